@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// A select may print aggregates of the rows it selects instead of the rows:
+//
+//	select count(*), count(email), min(username), max(id), avg(id)
+//	select sum(price), max(price) from products where name != pen
+//	select username, count(*) group by username having count(*) > 1
+//
+// count(*) counts the rows and count(<column>) those not null there; sum and
+// avg add up a column of numbers, and min and max find the least and
+// greatest value of any column. Each skips nulls, so all but count are null
+// over no values. The aggregates are computed as the scan goes, keeping no
+// rows, and print as one row, or one for each group when the select groups
+// the rows by the values of some of their columns. A having then keeps only
+// the groups it is true for; it compares aggregates, which need not be
+// printed, and the columns grouped by. A select of count(*) alone is the
+// older "select count", which prints "count: <n>". A sum of ints fails only
+// if the total is out of range, not if the sum along the way ever was.
+
+var errSumOverflow = errors.New("sum out of range")
+
+// Aggregate is "<function>(<column>)" in a select, or "count(*)" when Column
+// is "*". In a grouped select, an item with no function is a column grouped
+// by, whose value it takes from the records of each group.
+type Aggregate struct {
+	Function string
+	Column   string
+}
+
+func (a Aggregate) String() string {
+	if a.Function == "" {
+		return a.Column
+	}
+	return a.Function + "(" + a.Column + ")"
+}
+
+// aggregateFunctions are the functions an aggregate may apply. Count is a
+// keyword; the others are identifiers, so they stay free as names.
+var aggregateFunctions = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// isAggregate reports whether the next tokens start an aggregate rather
+// than, say, a column named max.
+func (p *parser) isAggregate() bool {
+	token := p.peek()
+	if token.Type != TOKEN_IDENTIFIER && token.Type != TOKEN_KEYWORD || !aggregateFunctions[token.Text] {
+		return false
+	}
+	next := p.tokens[p.next+1]
+	return next.Type == TOKEN_OPERATOR && next.Text == "("
+}
+
+// aggregate parses "<function>(<column>)" or "count(*)", returning the token
+// of its column as well.
+func (p *parser) aggregate() (Aggregate, Token, PrepareResult) {
+	aggregate := Aggregate{Function: p.advance().Text}
+	p.advance() // (
+	argument := p.peek()
+	if aggregate.Function == "count" && p.accept("*") {
+		aggregate.Column = "*"
+	} else {
+		name, result := p.columnName()
+		if result != PREPARE_SUCCESS {
+			return aggregate, argument, result
+		}
+		aggregate.Column = name
+	}
+	return aggregate, argument, p.expect(")")
+}
+
+// checkAggregate checks aggregate, whose column is argument, against the
+// columns of schema.
+func (p *parser) checkAggregate(aggregate Aggregate, argument Token, schema *Schema) PrepareResult {
+	_, err := newAggregator(schema, aggregate)
+	if err == nil {
+		return PREPARE_SUCCESS
+	}
+	_, message, _ := strings.Cut(err.Error(), ": ")
+	if errors.Is(err, errNoSuchColumn) {
+		return p.failAt(argument, PREPARE_NO_SUCH_COLUMN, message)
+	}
+	return p.failAt(argument, PREPARE_TYPE_MISMATCH, message)
+}
+
+// aggregator computes one aggregate over the records of a scan.
+type aggregator struct {
+	Aggregate
+	column  int  // the index of the column, -1 for count(*)
+	real    bool // the column holds reals, so sum does too
+	count   int64
+	sum     int64 // the sum of ints, wrapped around; see wraps
+	wraps   int64 // how many times 2^64 sum is short of the true sum
+	realSum float64
+	best    Value
+}
+
+// newAggregator starts computing aggregate over records with the columns of
+// schema.
+func newAggregator(schema *Schema, aggregate Aggregate) (*aggregator, error) {
+	a := &aggregator{Aggregate: aggregate, column: -1}
+	if aggregate.Column == "*" {
+		return a, nil
+	}
+	if a.column = columnIndex(schema, aggregate.Column); a.column == -1 {
+		return nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, aggregate.Column)
+	}
+	column := schema.Columns[a.column]
+	a.real = column.Type == COLUMN_REAL
+	if (aggregate.Function == "sum" || aggregate.Function == "avg") && column.Type != COLUMN_INT && !a.real {
+		return nil, fmt.Errorf("%w: %s adds up numbers, and %s holds %s values", errTypeMismatch, aggregate.Function, column.Name, columnTypeNames[column.Type])
+	}
+	return a, nil
+}
+
+// add takes the record values into the aggregate.
+func (a *aggregator) add(values []Value) {
+	if a.column == -1 {
+		a.count++
+		return
+	}
+	value := values[a.column]
+	if value == nil {
+		return
+	}
+	a.count++
+	switch a.Function {
+	case "":
+		a.best = value
+	case "sum", "avg":
+		if number, ok := value.(int64); ok {
+			sum := a.sum + number
+			switch {
+			case number > 0 && sum < a.sum:
+				a.wraps++
+			case number < 0 && sum > a.sum:
+				a.wraps--
+			}
+			a.sum = sum
+			a.realSum += float64(number)
+		} else {
+			a.realSum += value.(float64)
+		}
+	case "min":
+		if a.best == nil || compareValues(value, a.best) < 0 {
+			a.best = value
+		}
+	case "max":
+		if a.best == nil || compareValues(value, a.best) > 0 {
+			a.best = value
+		}
+	}
+}
+
+// result returns the aggregate of the values added.
+func (a *aggregator) result() (Value, error) {
+	switch {
+	case a.Function == "count":
+		return a.count, nil
+	case a.count == 0:
+		return nil, nil
+	case a.Function == "avg":
+		return a.realSum / float64(a.count), nil
+	case a.Function == "sum" && a.real:
+		if math.IsInf(a.realSum, 0) {
+			return nil, fmt.Errorf("%w: the sum of %s is too large for a real", errSumOverflow, a.Column)
+		}
+		return a.realSum, nil
+	case a.Function == "sum":
+		if a.wraps != 0 {
+			return nil, fmt.Errorf("%w: the sum of %s is too large for an int", errSumOverflow, a.Column)
+		}
+		return a.sum, nil
+	}
+	return a.best, nil
+}
+
+// aggregateColumn describes the column a prints in: named as it is written,
+// and of the type of its results.
+func (a *aggregator) aggregateColumn(schema *Schema) Column {
+	column := Column{Name: a.String(), Type: COLUMN_INT}
+	switch {
+	case a.Function == "avg":
+		column.Type = COLUMN_REAL
+	case a.Function != "count":
+		column.Type = schema.Columns[a.column].Type
+	}
+	return column
+}
+
+// grouping computes the aggregates of a select over the records it scans:
+// one set for each group of records holding the same values in the columns
+// grouped by, or a single set when there are none.
+type grouping struct {
+	schema   *Schema
+	result   *Schema // the columns the items print in
+	shown    []int   // those of them the select prints; nil for all
+	keys     []int   // the indexes of the columns grouped by
+	template []*aggregator
+	groups   map[string]*group
+	having   func(values []Value) bool
+}
+
+// group is the aggregates of the records holding key in the columns grouped
+// by.
+type group struct {
+	key         []Value
+	aggregators []*aggregator
+}
+
+// newGrouping starts computing the aggregates of statement over records with
+// the columns of schema, or returns nil if it has none.
+func newGrouping(schema *Schema, statement *SelectStmt) (*grouping, error) {
+	if statement.Aggregates == nil {
+		return nil, nil
+	}
+	g := &grouping{schema: schema, result: &Schema{Name: schema.Name}, groups: map[string]*group{}}
+	for _, name := range statement.GroupBy {
+		i := columnIndex(schema, name)
+		if i == -1 {
+			return nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, name)
+		}
+		g.keys = append(g.keys, i)
+	}
+
+	// the having may use aggregates and columns the select does not print,
+	// which are computed along with the rest
+	items := slices.Clone(statement.Aggregates)
+	var err error
+	havingItems(statement.Having, func(item Aggregate) {
+		if !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	})
+	if len(items) > len(statement.Aggregates) {
+		for i := range statement.Aggregates {
+			g.shown = append(g.shown, i)
+		}
+	}
+	for _, item := range items {
+		if item.Function == "" && !isGrouped(schema, statement, item.Column) {
+			return nil, fmt.Errorf("%w: column %s is not grouped by", errNoSuchColumn, item.Column)
+		}
+		a, err := newAggregator(schema, item)
+		if err != nil {
+			return nil, err
+		}
+		g.template = append(g.template, a)
+		g.result.Columns = append(g.result.Columns, a.aggregateColumn(schema))
+	}
+	if g.having, err = compileCondition(g.result, statement.Having); err != nil {
+		return nil, err
+	}
+	if statement.GroupBy == nil {
+		// the aggregates of no records are still printed
+		g.groups[""] = &group{aggregators: g.fresh()}
+	}
+	return g, nil
+}
+
+// havingItems calls visit with the aggregate or column each comparison of
+// having is on.
+func havingItems(having Condition, visit func(item Aggregate)) {
+	switch having := having.(type) {
+	case *Logical:
+		havingItems(having.Left, visit)
+		havingItems(having.Right, visit)
+	case *Comparison:
+		if having.Aggregate != nil {
+			visit(*having.Aggregate)
+		} else {
+			visit(Aggregate{Column: having.Column})
+		}
+	}
+}
+
+// fresh returns aggregators for a group no record has been added to.
+func (g *grouping) fresh() []*aggregator {
+	aggregators := make([]*aggregator, len(g.template))
+	for i, template := range g.template {
+		a := *template
+		aggregators[i] = &a
+	}
+	return aggregators
+}
+
+// add takes the record values into the aggregates of its group.
+func (g *grouping) add(values []Value) {
+	// the formatted values of the columns, each prefixed by its length
+	// and told apart from null, which no value formats to then
+	var key strings.Builder
+	for _, i := range g.keys {
+		if values[i] == nil {
+			key.WriteString("n")
+			continue
+		}
+		text := formatValue(g.schema.Columns[i], values[i])
+		fmt.Fprintf(&key, "v%d:%s", len(text), text)
+	}
+	entry := g.groups[key.String()]
+	if entry == nil {
+		entry = &group{aggregators: g.fresh()}
+		for _, i := range g.keys {
+			entry.key = append(entry.key, values[i])
+		}
+		g.groups[key.String()] = entry
+	}
+	for _, a := range entry.aggregators {
+		a.add(values)
+	}
+}
+
+// print prints the aggregates of each group its having keeps, ordered by
+// the values grouped by, as a row each, and returns how many it printed.
+func (g *grouping) print(asJSON bool, writer *bufio.Writer) (int, error) {
+	groups := slices.Collect(maps.Values(g.groups))
+	slices.SortFunc(groups, func(a, b *group) int {
+		for i := range a.key {
+			if order := compareNullable(a.key[i], b.key[i]); order != 0 {
+				return order
+			}
+		}
+		return 0
+	})
+	printed := 0
+	for _, group := range groups {
+		values := make([]Value, len(group.aggregators))
+		for i, a := range group.aggregators {
+			var err error
+			if values[i], err = a.result(); err != nil {
+				return printed, err
+			}
+		}
+		if g.having(values) {
+			printRecord(g.result, values, g.shown, asJSON, writer)
+			printed++
+		}
+	}
+	return printed, nil
+}
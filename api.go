@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// Errors returned by the Table methods.
+var (
+	ErrDuplicateKey  = errors.New("duplicate key")
+	ErrStringTooLong = errors.New("string is too long")
+	ErrReadOnly      = errors.New("database is read-only")
+
+	// ErrTableFull wraps every error caused by running out of room: rows or
+	// pages past what their 32-bit numbers can count, or a disk with no
+	// space left for the pages.
+	ErrTableFull = errors.New("table full")
+
+	// ErrCorrupt wraps every error caused by the file holding something it
+	// could not have written: a page failing its checksum, a truncated
+	// page, or a tree that does not hold together.
+	ErrCorrupt = errors.New("database is corrupt")
+
+	// ErrWrongPassphrase is returned when an encrypted database is opened
+	// with a passphrase other than its own.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+
+	// ErrSnapshotStale is returned by a Snapshot that the table was rolled
+	// back, vacuumed, compacted or closed under.
+	ErrSnapshotStale = errors.New("snapshot is out of date")
+)
+
+// Open opens the database in filename, creating it if needed. MEMORY_FILENAME
+// opens a table that is never written to disk.
+func Open(filename string) (*Table, error) {
+	return dbOpen(filename)
+}
+
+// Close writes the table back to its file and closes it.
+func (table *Table) Close() error {
+	return dbClose(table)
+}
+
+// Backup copies the table, with the changes not yet written back, to path
+// while other statements keep running. It fails inside a transaction.
+func (table *Table) Backup(path string) error {
+	return dbBackup(table, path)
+}
+
+// IntegrityCheck reads the whole table and returns the problems it finds in
+// it, none if it is sound. It changes nothing.
+func (table *Table) IntegrityCheck() ([]string, error) {
+	return dbIntegrityCheck(table)
+}
+
+// Insert adds a row. It fails with ErrStringTooLong if a field does not fit
+// its column, ErrDuplicateKey if the id is taken, ErrTableFull if the table
+// or the disk has no room left and ErrReadOnly on a read-only table; other
+// errors come from the file.
+func (table *Table) Insert(id uint32, username, email string) error {
+	row := Row{id: id, username: username, email: email}
+	if validateRow(&row) == PREPARE_STRING_TOO_LONG {
+		return ErrStringTooLong
+	}
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return ErrReadOnly
+	}
+	if table.numRows >= table.maxRows {
+		return ErrTableFull
+	}
+	if _, exists := table.ids[id]; exists {
+		return ErrDuplicateKey
+	}
+
+	if err := insertRow(table, &row); err != nil {
+		return err
+	}
+	recordMutation(table, mutation{kind: STATEMENT_INSERT, rows: []Row{row}})
+	return commitStatement(table)
+}
+
+// InsertWithNextID adds a row with the next id, which it returns; see
+// nextID. It fails like Insert, with ErrTableFull also once every id has
+// been used.
+func (table *Table) InsertWithNextID(username, email string) (uint32, error) {
+	row := Row{username: username, email: email}
+	if validateRow(&row) == PREPARE_STRING_TOO_LONG {
+		return 0, ErrStringTooLong
+	}
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return 0, ErrReadOnly
+	}
+	if table.numRows >= table.maxRows {
+		return 0, ErrTableFull
+	}
+	id, err := nextID(table)
+	if err != nil {
+		return 0, err
+	}
+	row.id = id
+
+	if err := ensureCatalog(table); err != nil {
+		return 0, err
+	}
+	if err := insertRow(table, &row); err != nil {
+		return 0, err
+	}
+	if err := saveCatalog(table); err != nil {
+		return 0, err
+	}
+	recordMutation(table, mutation{kind: STATEMENT_INSERT, rows: []Row{row}})
+	return id, commitStatement(table)
+}
+
+// SelectAll returns every row, ordered by id.
+func (table *Table) SelectAll() ([]Row, error) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	return collectRows(table)
+}
+
+// SelectRange returns the rows whose ids lie in [from, to], ordered by id.
+func (table *Table) SelectRange(from, to uint32) ([]Row, error) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	var rows []Row
+	cursor := tableSeek(table, from)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if rowID(slot) > to {
+			break
+		}
+		var row Row
+		if err := readRow(table.pager, slot, &row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// collectRows reads every row in id order. Callers hold table.mu.
+func collectRows(table *Table) ([]Row, error) {
+	rows := make([]Row, 0, table.numRows)
+	cursor := tableStart(table)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			return nil, err
+		}
+		var row Row
+		if err := readRow(table.pager, slot, &row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Snapshot takes a view of the table as it is now, which rows inserted,
+// updated or deleted later do not change. Reading through it does not keep
+// other statements waiting until it is done. Close it once done with it.
+func (table *Table) Snapshot() *Snapshot {
+	return snapshotOpen(table)
+}
+
+// NumRows returns how many rows the table held when the snapshot was taken.
+func (snapshot *Snapshot) NumRows() uint32 {
+	return snapshot.numRows
+}
+
+// SelectAll returns every row in the snapshot, ordered by id.
+func (snapshot *Snapshot) SelectAll() ([]Row, error) {
+	return snapshotRows(snapshot, 0, math.MaxUint32)
+}
+
+// SelectRange returns the rows in the snapshot whose ids lie in [from, to],
+// ordered by id.
+func (snapshot *Snapshot) SelectRange(from, to uint32) ([]Row, error) {
+	return snapshotRows(snapshot, from, to)
+}
+
+// Close releases the pages the snapshot keeps.
+func (snapshot *Snapshot) Close() {
+	snapshotClose(snapshot)
+}
+
+// ID returns the row's id.
+func (row Row) ID() uint32 { return row.id }
+
+// Username returns the row's username.
+func (row Row) Username() string { return row.username }
+
+// Email returns the row's email.
+func (row Row) Email() string { return row.email }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAPI_InsertSelectAllReopen(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	table, err := Open(fileName)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for id := uint32(1); id <= 3; id++ {
+		if err := table.Insert(id, "user", "person@example.com"); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+	if err := table.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	table, err = Open(fileName)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer table.Close()
+
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	var ids []uint32
+	for _, row := range rows {
+		ids = append(ids, row.ID())
+		if row.Username() != "user" || row.Email() != "person@example.com" {
+			t.Errorf("row %d = (%q, %q), want (\"user\", \"person@example.com\")", row.ID(), row.Username(), row.Email())
+		}
+	}
+	if want := []uint32{1, 2, 3}; !slices.Equal(ids, want) {
+		t.Errorf("SelectAll ids = %v, want %v", ids, want)
+	}
+}
+
+func TestAPI_SelectRange(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	for id := uint32(10); id <= 200; id += 10 {
+		if err := table.Insert(id, "user", "person@example.com"); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+
+	tests := []struct {
+		from, to uint32
+		want     []uint32
+	}{
+		{from: 0, to: 30, want: []uint32{10, 20, 30}},
+		{from: 15, to: 45, want: []uint32{20, 30, 40}},
+		{from: 190, to: 1000, want: []uint32{190, 200}},
+		{from: 41, to: 49, want: nil},
+		{from: 60, to: 50, want: nil},
+	}
+	for _, tt := range tests {
+		rows, err := table.SelectRange(tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("SelectRange(%d, %d): %v", tt.from, tt.to, err)
+		}
+		var ids []uint32
+		for _, row := range rows {
+			ids = append(ids, row.ID())
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("SelectRange(%d, %d) ids = %v, want %v", tt.from, tt.to, ids, tt.want)
+		}
+	}
+}
+
+func TestAPI_InsertErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(table *Table)
+		id       uint32
+		username string
+		wantErr  error
+	}{
+		{name: "duplicate key", id: 1, username: "again", wantErr: ErrDuplicateKey},
+		{name: "username too long", id: 2, username: strings.Repeat("a", COLUMN_USERNAME_SIZE+1), wantErr: ErrStringTooLong},
+		{name: "table full", setup: func(table *Table) { table.maxRows = 1 }, id: 2, username: "full", wantErr: ErrTableFull},
+		{name: "read-only", setup: func(table *Table) { table.readOnly = true }, id: 2, username: "ro", wantErr: ErrReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := Open(MEMORY_FILENAME)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer table.Close()
+			if err := table.Insert(1, "first", "first@example.com"); err != nil {
+				t.Fatalf("Insert: %v", err)
+			}
+			if tt.setup != nil {
+				tt.setup(table)
+			}
+
+			if err := table.Insert(tt.id, tt.username, "x@example.com"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Insert error = %v, want %v", err, tt.wantErr)
+			}
+			if table.numRows != 1 {
+				t.Errorf("table.numRows = %d, want 1", table.numRows)
+			}
+		})
+	}
+}
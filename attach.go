@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// +attach opens more databases next to the one the REPL started with, each
+// under a name. A statement prefixed with "in <name>" runs against the
+// attached database instead, and +copy moves rows between any two of them;
+// MAIN_DATABASE names the one the REPL started with. Attached databases are
+// opened with the default options and closed along with the main one.
+const MAIN_DATABASE = "main"
+
+// attachDatabase opens the database in path as name, next to table.
+func attachDatabase(table *Table, path, name string) error {
+	if !validDatabaseName(name) {
+		return fmt.Errorf("%q is not a usable database name", name)
+	}
+	if _, taken := table.attached[name]; taken || name == MAIN_DATABASE {
+		return fmt.Errorf("a database is already attached as %s", name)
+	}
+	// two pagers over one file would overwrite each other's pages
+	if info, err := os.Stat(path); err == nil {
+		for _, other := range append(slices.Collect(maps.Values(table.attached)), table) {
+			if other.pager.file == nil {
+				continue
+			}
+			if otherInfo, err := other.pager.file.Stat(); err == nil && os.SameFile(info, otherInfo) {
+				return fmt.Errorf("%s is already open", path)
+			}
+		}
+	}
+
+	attached, err := dbOpen(path)
+	if err != nil {
+		return err
+	}
+	if table.attached == nil {
+		table.attached = make(map[string]*Table)
+	}
+	table.attached[name] = attached
+	return nil
+}
+
+// detachDatabase closes the database attached as name.
+func detachDatabase(table *Table, name string) error {
+	attached, ok := table.attached[name]
+	if !ok {
+		return fmt.Errorf("no database is attached as %s", name)
+	}
+	delete(table.attached, name)
+	return dbClose(attached)
+}
+
+// closeAttached closes every database attached to table.
+func closeAttached(table *Table) error {
+	var errs []error
+	for name := range table.attached {
+		errs = append(errs, detachDatabase(table, name))
+	}
+	return errors.Join(errs...)
+}
+
+// lookupDatabase returns the database named name: table itself for
+// MAIN_DATABASE, or one attached to it.
+func lookupDatabase(table *Table, name string) (*Table, error) {
+	if name == MAIN_DATABASE {
+		return table, nil
+	}
+	attached, ok := table.attached[name]
+	if !ok {
+		return nil, fmt.Errorf("no database is attached as %s", name)
+	}
+	return attached, nil
+}
+
+// statementDatabase splits a statement prefixed with "in <name>" into the
+// database it addresses and the statement itself. Other statements address
+// table.
+func statementDatabase(table *Table, command string) (*Table, string, error) {
+	rest, ok := strings.CutPrefix(command, "in ")
+	if !ok {
+		return table, command, nil
+	}
+	name, statement, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	target, err := lookupDatabase(table, name)
+	if err != nil {
+		return nil, "", err
+	}
+	return target, strings.TrimSpace(statement), nil
+}
+
+// copyRows inserts the rows of source whose ids lie in [from, to] into
+// target, stopping at the first that cannot be inserted. It returns how many
+// were copied.
+func copyRows(source, target *Table, from, to uint32) (int, error) {
+	if source == target {
+		return 0, errors.New("cannot copy rows into the database they come from")
+	}
+	rows, err := source.SelectRange(from, to)
+	if err != nil {
+		return 0, err
+	}
+	for i, row := range rows {
+		if err := target.Insert(row.id, row.username, row.email); err != nil {
+			return i, fmt.Errorf("id %d: %w", row.id, err)
+		}
+	}
+	return len(rows), nil
+}
+
+func validDatabaseName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
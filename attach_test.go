@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttach_CopiesRowsBetweenDatabases(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	archiveName := filepath.Join(t.TempDir(), "archive.db")
+
+	input := insertRows(1, 10) +
+		"+attach " + archiveName + " as archive\n" +
+		"in archive insert 100 old old@example.com\n" +
+		"+copy main archive 3 5\n" +
+		"in archive select\n" +
+		"+databases\n" +
+		"select count\n"
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(input), &output, table, true)
+	want := "Copied 3 rows.\n" +
+		"(3, user3, person3@example.com)\n" +
+		"(4, user4, person4@example.com)\n" +
+		"(5, user5, person5@example.com)\n" +
+		"(100, old, old@example.com)\n" +
+		"main\narchive\n" +
+		"count: 10\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+
+	// the archive is closed with the main database and keeps its rows
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	archive := mustOpen(t, archiveName)
+	defer dbClose(archive)
+	if archive.numRows != 4 {
+		t.Errorf("archive holds %d rows, want 4", archive.numRows)
+	}
+}
+
+func TestAttach_Errors(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	defer dbClose(table)
+	archiveName := filepath.Join(t.TempDir(), "archive.db")
+	runREPL(strings.NewReader(insertRows(1, 3)+"+attach "+archiveName+" as archive\n+copy main archive\n"), io.Discard, table)
+
+	for _, test := range []struct{ input, want string }{
+		{"+attach " + fileName + " as again\n", "is already open"},
+		{"+attach other.db as archive\n", "already attached as archive"},
+		{"+attach other.db as main\n", "already attached as main"},
+		{"+attach other.db as two words\n", "not a usable database name"},
+		{"+attach other.db\n", "Usage: +attach"},
+		{"in nowhere select\n", "no database is attached as nowhere"},
+		{"+copy main archive\n", "duplicate key"},
+		{"+copy archive archive\n", "cannot copy rows into the database they come from"},
+		{"+detach nowhere\n", "no database is attached as nowhere"},
+	} {
+		var output bytes.Buffer
+		runREPLWith(strings.NewReader(test.input), &output, table, true)
+		if !strings.Contains(output.String(), test.want) {
+			t.Errorf("%q printed %q, want it to contain %q", test.input, output.String(), test.want)
+		}
+	}
+
+	// a copy that fails reports no count
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("+copy archive archive\n"), &output, table, true)
+	if strings.Contains(output.String(), "Copied") {
+		t.Errorf("a failed +copy printed %q", output.String())
+	}
+
+	output.Reset()
+	runREPLWith(strings.NewReader("+detach archive\n+databases\n"), &output, table, true)
+	if output.String() != "main\n" {
+		t.Errorf("+databases after +detach printed %q", output.String())
+	}
+}
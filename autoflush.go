@@ -0,0 +1,63 @@
+package main
+
+import "time"
+
+// Outside a transaction, changes stay in the page cache until something
+// writes them back, so a crash loses everything since the last +flush. With
+// OpenOptions.FlushEvery they are written back after that many statements
+// that change the table; with OpenOptions.FlushInterval a background
+// goroutine writes them back that often, skipping the flush when nothing
+// changed. A transaction in progress is never flushed early. A background
+// flush that fails is tried again on the next tick; if the failure lasts,
+// dbClose's own flush reports it.
+
+// autoFlushStatement counts a statement that changed the table and flushes
+// once FlushEvery of them have. Callers hold table.mu for writing.
+func autoFlushStatement(table *Table) error {
+	if table.flushEvery == 0 || table.inTransaction {
+		return nil
+	}
+	table.unflushedStatements++
+	if table.unflushedStatements < table.flushEvery {
+		return nil
+	}
+	table.unflushedStatements = 0
+	return flushAll(table)
+}
+
+// startAutoFlush starts the goroutine flushing table every interval, until
+// stopAutoFlush.
+func startAutoFlush(table *Table, interval time.Duration) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	table.autoFlushStop, table.autoFlushDone = stop, done
+	flushed := table.pager.changes
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			table.mu.Lock()
+			if table.pager.changes != flushed && !table.inTransaction && flushAll(table) == nil {
+				flushed = table.pager.changes
+			}
+			table.mu.Unlock()
+		}
+	}()
+}
+
+// stopAutoFlush stops the goroutine started by startAutoFlush, if any, and
+// waits for it to finish. Callers must not hold table.mu.
+func stopAutoFlush(table *Table) {
+	if table.autoFlushStop == nil {
+		return
+	}
+	close(table.autoFlushStop)
+	<-table.autoFlushDone
+	table.autoFlushStop, table.autoFlushDone = nil, nil
+}
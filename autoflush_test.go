@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flushedRows returns how many rows the file of fileName holds as it is on
+// disk.
+func flushedRows(t *testing.T, fileName string) uint32 {
+	t.Helper()
+	copied, err := dbOpenWith(crashCopyWithoutLog(t, fileName), OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(copied)
+	return copied.numRows
+}
+
+func TestAutoFlush_EveryNStatements(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{FlushEvery: 3})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+
+	// reads do not count, and neither does a transaction until it commits
+	runREPL(strings.NewReader(insertRows(1, 2)+"select\n"), io.Discard, table)
+	if rows := flushedRows(t, fileName); rows != 0 {
+		t.Errorf("file holds %d rows after 2 statements, want 0", rows)
+	}
+	runREPL(strings.NewReader(insertRows(3, 3)), io.Discard, table)
+	if rows := flushedRows(t, fileName); rows != 3 {
+		t.Errorf("file holds %d rows after 3 statements, want 3", rows)
+	}
+	runREPL(strings.NewReader("+begin\n"+insertRows(4, 10)), io.Discard, table)
+	if rows := flushedRows(t, fileName); rows != 3 {
+		t.Errorf("file holds %d rows inside a transaction, want 3", rows)
+	}
+}
+
+func TestAutoFlush_Interval(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	runREPL(strings.NewReader(insertRows(1, 5)), io.Discard, table)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for flushedRows(t, fileName) != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("rows never reached the file")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	if table.autoFlushStop != nil {
+		t.Errorf("dbClose left the flushing goroutine running")
+	}
+	if _, err := dbOpenWith(fileName, OpenOptions{FlushEvery: -1}); err == nil {
+		t.Errorf("dbOpenWith with a negative FlushEvery succeeded")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// A backup copies the pages of an open table to another file a few at a
+// time, holding the table only for reading and only during each step, so
+// statements keep running in between. The pages come from the pager, dirty
+// ones included, so nothing needs to be flushed first. Whenever a page may
+// have changed since the last step, the pass starts over; after
+// BACKUP_MAX_PASSES passes the rest is copied in one step.
+//
+// The copy keeps the page size and encryption of the table, but is always
+// stored uncompressed.
+const (
+	BACKUP_STEP_PAGES = 64
+	BACKUP_MAX_PASSES = 4
+)
+
+type backup struct {
+	table    *Table
+	file     *os.File
+	header   [HEADER_SIZE]byte // of the table when the pass started
+	changes  uint64            // pager.changes when the pass started
+	numPages uint32
+	next     uint32 // next page to copy; 0 until a pass has started
+	passes   int
+}
+
+// dbBackup copies a consistent image of table to path, replacing whatever is
+// there once the copy is complete.
+func dbBackup(table *Table, path string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create backup: %w", err)
+	}
+	tmpFileName := tmpFile.Name()
+	defer os.Remove(tmpFileName) // no-op once the rename succeeded
+
+	b := &backup{table: table, file: tmpFile}
+	for {
+		n := uint32(BACKUP_STEP_PAGES)
+		if b.passes >= BACKUP_MAX_PASSES {
+			n = math.MaxUint32
+		}
+		done, err := b.step(n)
+		if err != nil {
+			tmpFile.Close()
+			return fmt.Errorf("copy backup: %w", err)
+		}
+		if done {
+			break
+		}
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("sync backup: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("close backup: %w", err)
+	}
+	if err := os.Rename(tmpFileName, path); err != nil {
+		return fmt.Errorf("rename backup: %w", err)
+	}
+	return nil
+}
+
+// dbClone is dbBackup to a new file: it fails if path exists, and claims the
+// name before copying so nothing else can take it in the meantime.
+func dbClone(table *Table, path string) error {
+	placeholder, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("create clone: %w", err)
+	}
+	placeholder.Close()
+	if err := dbBackup(table, path); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// step copies up to n more pages, first starting a new pass if the table
+// changed since the last step. It reports whether the copy is complete.
+func (b *backup) step(n uint32) (bool, error) {
+	table := b.table
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	// the pages of an open transaction may still be rolled back
+	if table.inTransaction {
+		return false, errInsideTransaction
+	}
+	pager := table.pager
+	if b.next == 0 || pager.changes != b.changes {
+		if err := b.file.Truncate(0); err != nil {
+			return false, err
+		}
+		b.header = encodeHeader(table)
+		clear(b.header[HEADER_COMPRESSION_OFFSET:HEADER_SALT_OFFSET])
+		b.changes = pager.changes
+		b.numPages = pager.numPages
+		b.next = 0
+		b.passes++
+	}
+
+	for ; b.next < b.numPages && n > 0; b.next, n = b.next+1, n-1 {
+		page, err := getPage(pager, b.next)
+		if err != nil {
+			return false, err
+		}
+		// other readers share the cached page
+		page = slices.Clone(page)
+		if pager.checksums {
+			putPageChecksum(pager, page)
+		}
+		if pager.cipher != nil {
+			page = encryptPage(pager, b.next, page)
+		}
+		if _, err := b.file.WriteAt(page, HEADER_SIZE+int64(b.next)*int64(pager.pageSize)); err != nil {
+			return false, err
+		}
+	}
+	if b.next < b.numPages {
+		return false, nil
+	}
+	if _, err := b.file.WriteAt(b.header[:], 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
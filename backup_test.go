@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackup_IncludesUnflushedChanges(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	defer dbClose(table)
+	backupName := filepath.Join(t.TempDir(), "backup.db")
+
+	input := insertRows(1, rowsPerLeaf*3) + deleteRows(10, 20) + "+backup " + backupName + "\n"
+	runREPL(strings.NewReader(input), io.Discard, table)
+	if info, err := os.Stat(fileName); err != nil || info.Size() != HEADER_SIZE {
+		t.Fatalf("database file after the backup: %v, %v; want nothing flushed", info, err)
+	}
+	want, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+
+	copied := mustOpen(t, backupName)
+	defer dbClose(copied)
+	got, err := copied.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("backup holds %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	checkTree(t, copied)
+}
+
+func TestBackup_StartsOverWhenTheTableChanges(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*4)), io.Discard, table)
+	if table.pager.numPages < 3 {
+		t.Fatalf("table has only %d pages", table.pager.numPages)
+	}
+
+	backupName := filepath.Join(t.TempDir(), "backup.db")
+	file, err := os.Create(backupName)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer file.Close()
+	b := &backup{table: table, file: file}
+	if done, err := b.step(1); done || err != nil {
+		t.Fatalf("step = %v, %v; want more to copy", done, err)
+	}
+
+	// an insert between steps, then the rest copied at once
+	if err := table.Insert(uint32(rowsPerLeaf*4+1), "late", "late@example.com"); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if done, err := b.step(table.pager.numPages); !done || err != nil {
+		t.Fatalf("step = %v, %v; want the copy complete", done, err)
+	}
+	if b.passes != 2 {
+		t.Errorf("backup took %d passes, want 2", b.passes)
+	}
+
+	copied := mustOpen(t, backupName)
+	defer dbClose(copied)
+	if _, stored := checkTree(t, copied); stored != uint32(rowsPerLeaf*4+1) {
+		t.Errorf("backup holds %d rows, want %d", stored, rowsPerLeaf*4+1)
+	}
+}
+
+func TestBackup_RefusesAnOpenTransaction(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	backupName := filepath.Join(t.TempDir(), "backup.db")
+	runREPL(strings.NewReader(insertRows(1, 3)+"+begin\n"), io.Discard, table)
+	if err := table.Backup(backupName); !errors.Is(err, errInsideTransaction) {
+		t.Errorf("Backup inside a transaction: err = %v, want %v", err, errInsideTransaction)
+	}
+	if _, err := os.Stat(backupName); err == nil {
+		t.Errorf("a failed backup left %s behind", backupName)
+	}
+}
+
+func TestBackup_CloneMakesANewFile(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	cloneName := filepath.Join(t.TempDir(), "clone.db")
+
+	var output strings.Builder
+	input := insertRows(1, 10) + "+clone " + cloneName + "\n" + "+clone " + cloneName + "\n+clone\n"
+	runREPLWith(strings.NewReader(input), &output, table, true)
+	if !strings.Contains(output.String(), "Error: create clone:") || !strings.Contains(output.String(), "Usage: +clone") {
+		t.Errorf("output = %q, want the second clone refused and a usage line", output.String())
+	}
+
+	cloned := mustOpen(t, cloneName)
+	defer dbClose(cloned)
+	if cloned.numRows != 10 {
+		t.Errorf("clone holds %d rows, want 10", cloned.numRows)
+	}
+	checkTree(t, cloned)
+
+	// a failed clone leaves nothing behind
+	runREPL(strings.NewReader("+begin\n+clone "+cloneName+".2\n+rollback\n"), io.Discard, table)
+	if _, err := os.Stat(cloneName + ".2"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed clone left a file: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// benchInsert inserts n synthetic rows into throwaway tables, one in memory
+// and one in a temporary file opened with the default options, so synced as
+// SYNC_NORMAL says, timing each executeInsert call, and reports latency
+// percentiles and throughput for each. The table it is called from is never
+// touched.
+func benchInsert(n int, writer *bufio.Writer) error {
+	dir, err := os.MkdirTemp("", "simpledbgo-bench-*")
+	if err != nil {
+		return err
+	}
+	// the file may have a log, or a journal, beside it
+	defer os.RemoveAll(dir)
+
+	for _, bench := range []struct{ name, filename string }{
+		{"in memory", MEMORY_FILENAME},
+		{"on disk, sync " + SYNC_NORMAL.String(), filepath.Join(dir, "bench.db")},
+	} {
+		fmt.Fprintf(writer, "%s:\n", bench.name)
+		if err := benchInsertInto(bench.filename, n, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchInsertInto times n inserts into a new table opened from filename.
+func benchInsertInto(filename string, n int, writer *bufio.Writer) error {
+	table, err := dbOpen(filename)
+	if err != nil {
+		return err
+	}
+	defer dbClose(table)
+
+	latencies := make([]time.Duration, 0, n)
+	start := time.Now()
+	for i := range n {
+		statement := InsertStmt{
+			Row: Row{
+				id:       uint32(i),
+				username: fmt.Sprintf("bench%d", i),
+				email:    fmt.Sprintf("bench%d@example.com", i),
+			},
+		}
+
+		insertStart := time.Now()
+		result := executeInsert(&statement, table, writer)
+		elapsed := time.Since(insertStart)
+
+		if result != EXECUTE_SUCCESS {
+			fmt.Fprintf(writer, "stopped after %d rows\n", i)
+			break
+		}
+		latencies = append(latencies, elapsed)
+	}
+	total := time.Since(start)
+
+	if len(latencies) == 0 {
+		writer.WriteString("no rows inserted\n")
+		return nil
+	}
+
+	slices.Sort(latencies)
+	fmt.Fprintf(writer, "inserted %d rows in %v (%.0f rows/s)\n", len(latencies), total, float64(len(latencies))/total.Seconds())
+	for _, p := range []float64{50, 95, 99} {
+		fmt.Fprintf(writer, "p%.0f: %v\n", p, percentile(latencies, p))
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Starting with BTREE_FORMAT_VERSION, rows are stored in the leaf nodes of a
+// B+ tree keyed by id instead of packed one after another. Every page is a
+// node, and the first leaf is always page 0. Starting with
+// INTERNAL_NODE_FORMAT_VERSION, internal nodes index the leaves, and the
+// header records which page holds the root.
+const (
+	BTREE_FORMAT_VERSION         = 3
+	INTERNAL_NODE_FORMAT_VERSION = 4
+)
+
+type NodeType uint8
+
+const (
+	NODE_LEAF     NodeType = 0
+	NODE_INTERNAL NodeType = 1
+)
+
+// Common node header layout
+const (
+	NODE_TYPE_SIZE          = 1
+	NODE_TYPE_OFFSET        = 0
+	COMMON_NODE_HEADER_SIZE = NODE_TYPE_SIZE
+)
+
+// Leaf node layout: the header is followed by the offsets of its numCells
+// cells, in ascending key order, and then the cells themselves, each a key,
+// the length of the serialized row and the row. The cells are packed right
+// after the offsets, so a leaf is laid out anew whenever a cell is added or
+// removed. Leaves are chained in key order through nextLeaf; 0 marks the last
+// leaf, since page 0 is always the first.
+const (
+	LEAF_NODE_NUM_CELLS_SIZE   = 4
+	LEAF_NODE_NUM_CELLS_OFFSET = COMMON_NODE_HEADER_SIZE
+	LEAF_NODE_NEXT_LEAF_SIZE   = 4
+	LEAF_NODE_NEXT_LEAF_OFFSET = LEAF_NODE_NUM_CELLS_OFFSET + LEAF_NODE_NUM_CELLS_SIZE
+	LEAF_NODE_HEADER_SIZE      = LEAF_NODE_NEXT_LEAF_OFFSET + LEAF_NODE_NEXT_LEAF_SIZE
+
+	LEAF_NODE_CELL_POINTER_SIZE = 2
+	LEAF_NODE_KEY_SIZE          = ID_SIZE
+	LEAF_NODE_VALUE_LENGTH_SIZE = 2
+	LEAF_NODE_CELL_HEADER_SIZE  = LEAF_NODE_KEY_SIZE + LEAF_NODE_VALUE_LENGTH_SIZE
+	LEAF_NODE_MAX_CELL_SIZE     = LEAF_NODE_CELL_HEADER_SIZE + MAX_ROW_SIZE
+)
+
+// A page must have room for two of the largest cells, so a split always
+// leaves something in both leaves, and its offsets must fit in
+// LEAF_NODE_CELL_POINTER_SIZE bytes. Pages of an encrypted file need
+// ENCRYPTION_TRAILER_SIZE bytes more.
+const (
+	MIN_PAGE_SIZE = PAGE_CHECKSUM_SIZE + LEAF_NODE_HEADER_SIZE + 2*(LEAF_NODE_CELL_POINTER_SIZE+LEAF_NODE_MAX_CELL_SIZE)
+	MAX_PAGE_SIZE = 1 << 16
+)
+
+// Internal node layout: the header is followed by numKeys cells, each a child
+// page number and the largest key stored under that child, in ascending key
+// order. Keys larger than all of them are under rightChild.
+const (
+	INTERNAL_NODE_NUM_KEYS_SIZE      = 4
+	INTERNAL_NODE_NUM_KEYS_OFFSET    = COMMON_NODE_HEADER_SIZE
+	INTERNAL_NODE_RIGHT_CHILD_SIZE   = 4
+	INTERNAL_NODE_RIGHT_CHILD_OFFSET = INTERNAL_NODE_NUM_KEYS_OFFSET + INTERNAL_NODE_NUM_KEYS_SIZE
+	INTERNAL_NODE_HEADER_SIZE        = INTERNAL_NODE_RIGHT_CHILD_OFFSET + INTERNAL_NODE_RIGHT_CHILD_SIZE
+
+	INTERNAL_NODE_CHILD_SIZE = 4
+	INTERNAL_NODE_KEY_SIZE   = ID_SIZE
+	INTERNAL_NODE_CELL_SIZE  = INTERNAL_NODE_CHILD_SIZE + INTERNAL_NODE_KEY_SIZE
+)
+
+// setNodeCapacity sizes internal nodes to pager.pageSize.
+func setNodeCapacity(pager *Pager) {
+	usable := pageUsableSize(pager)
+	pager.internalMaxKeys = (usable - min(usable, INTERNAL_NODE_HEADER_SIZE)) / INTERNAL_NODE_CELL_SIZE
+}
+
+// leafNodeSpace returns how many bytes a leaf has for its cells and their
+// offsets.
+func leafNodeSpace(pager *Pager) uint32 {
+	return pageUsableSize(pager) - LEAF_NODE_HEADER_SIZE
+}
+
+func nodeType(node Page) NodeType {
+	return NodeType(node[NODE_TYPE_OFFSET])
+}
+
+func setNodeType(node Page, nodeType NodeType) {
+	node[NODE_TYPE_OFFSET] = byte(nodeType)
+}
+
+func leafNodeNumCells(node Page) uint32 {
+	return binary.LittleEndian.Uint32(node[LEAF_NODE_NUM_CELLS_OFFSET:])
+}
+
+func setLeafNodeNumCells(node Page, numCells uint32) {
+	binary.LittleEndian.PutUint32(node[LEAF_NODE_NUM_CELLS_OFFSET:], numCells)
+}
+
+func leafNodeNextLeaf(node Page) uint32 {
+	return binary.LittleEndian.Uint32(node[LEAF_NODE_NEXT_LEAF_OFFSET:])
+}
+
+func setLeafNodeNextLeaf(node Page, nextLeaf uint32) {
+	binary.LittleEndian.PutUint32(node[LEAF_NODE_NEXT_LEAF_OFFSET:], nextLeaf)
+}
+
+func leafNodeCellOffset(node Page, cellNum uint32) uint32 {
+	return uint32(binary.LittleEndian.Uint16(node[LEAF_NODE_HEADER_SIZE+cellNum*LEAF_NODE_CELL_POINTER_SIZE:]))
+}
+
+func leafNodeCell(node Page, cellNum uint32) []byte {
+	offset := leafNodeCellOffset(node, cellNum)
+	valueLength := uint32(binary.LittleEndian.Uint16(node[offset+LEAF_NODE_KEY_SIZE:]))
+	return node[offset : offset+LEAF_NODE_CELL_HEADER_SIZE+valueLength]
+}
+
+func leafNodeKey(node Page, cellNum uint32) uint32 {
+	return binary.LittleEndian.Uint32(node[leafNodeCellOffset(node, cellNum):])
+}
+
+// leafNodeValue returns the serialized row stored in a cell.
+func leafNodeValue(node Page, cellNum uint32) []byte {
+	return leafNodeCell(node, cellNum)[LEAF_NODE_CELL_HEADER_SIZE:]
+}
+
+// leafNodeCells returns the cells of a leaf in key order. They point into
+// the page.
+func leafNodeCells(node Page) [][]byte {
+	cells := make([][]byte, leafNodeNumCells(node))
+	for i := range cells {
+		cells[i] = leafNodeCell(node, uint32(i))
+	}
+	return cells
+}
+
+// leafNodeUsedSpace returns how much of leafNodeSpace a leaf's cells and
+// their offsets take up.
+func leafNodeUsedSpace(node Page) uint32 {
+	numCells := leafNodeNumCells(node)
+	if numCells == 0 {
+		return 0
+	}
+	// the last cell ends the packed ones
+	end := leafNodeCellOffset(node, numCells-1) + uint32(len(leafNodeCell(node, numCells-1)))
+	return end - LEAF_NODE_HEADER_SIZE
+}
+
+// leafCellsSize returns how much of leafNodeSpace cells would take up.
+func leafCellsSize(cells [][]byte) uint32 {
+	size := uint32(0)
+	for _, cell := range cells {
+		size += LEAF_NODE_CELL_POINTER_SIZE + uint32(len(cell))
+	}
+	return size
+}
+
+func makeLeafCell(key uint32, value []byte) []byte {
+	cell := make([]byte, LEAF_NODE_CELL_HEADER_SIZE, LEAF_NODE_CELL_HEADER_SIZE+len(value))
+	binary.LittleEndian.PutUint32(cell, key)
+	binary.LittleEndian.PutUint16(cell[LEAF_NODE_KEY_SIZE:], uint16(len(value)))
+	return append(cell, value...)
+}
+
+// encodeLeafNode lays out a leaf holding cells in a new page, so the cells
+// may point into the page it is copied over.
+func encodeLeafNode(pageSize uint32, nextLeaf uint32, cells [][]byte) Page {
+	node := make(Page, pageSize)
+	setNodeType(node, NODE_LEAF)
+	setLeafNodeNumCells(node, uint32(len(cells)))
+	setLeafNodeNextLeaf(node, nextLeaf)
+	offset := LEAF_NODE_HEADER_SIZE + uint32(len(cells))*LEAF_NODE_CELL_POINTER_SIZE
+	for i, cell := range cells {
+		binary.LittleEndian.PutUint16(node[LEAF_NODE_HEADER_SIZE+uint32(i)*LEAF_NODE_CELL_POINTER_SIZE:], uint16(offset))
+		offset += uint32(copy(node[offset:], cell))
+	}
+	return node
+}
+
+// splitLeafCells returns how many of cells go into the left of two leaves of
+// space bytes each, so both fit and hold about as many bytes. There are at
+// least two cells.
+func splitLeafCells(cells [][]byte, space uint32) int {
+	total := leafCellsSize(cells)
+	leftCount, leftSize := 0, uint32(0)
+	for leftCount < len(cells)-1 {
+		size := LEAF_NODE_CELL_POINTER_SIZE + uint32(len(cells[leftCount]))
+		if leftCount > 0 && leftSize+size > total/2 && total-leftSize <= space {
+			break
+		}
+		leftSize += size
+		leftCount++
+	}
+	return leftCount
+}
+
+func initializeLeafNode(node Page) {
+	clear(node)
+	setNodeType(node, NODE_LEAF)
+}
+
+func internalNodeNumKeys(node Page) uint32 {
+	return binary.LittleEndian.Uint32(node[INTERNAL_NODE_NUM_KEYS_OFFSET:])
+}
+
+func internalNodeRightChild(node Page) uint32 {
+	return binary.LittleEndian.Uint32(node[INTERNAL_NODE_RIGHT_CHILD_OFFSET:])
+}
+
+func internalNodeCellOffset(cellNum uint32) uint32 {
+	return INTERNAL_NODE_HEADER_SIZE + cellNum*INTERNAL_NODE_CELL_SIZE
+}
+
+func internalNodeKey(node Page, keyNum uint32) uint32 {
+	return binary.LittleEndian.Uint32(node[internalNodeCellOffset(keyNum)+INTERNAL_NODE_CHILD_SIZE:])
+}
+
+// internalNodeChild returns child childNum; childNum numKeys is the right
+// child.
+func internalNodeChild(node Page, childNum uint32) uint32 {
+	if childNum == internalNodeNumKeys(node) {
+		return internalNodeRightChild(node)
+	}
+	return binary.LittleEndian.Uint32(node[internalNodeCellOffset(childNum):])
+}
+
+// internalNodeFindChild returns the number of the child whose keys cover key.
+func internalNodeFindChild(node Page, key uint32) uint32 {
+	low, high := uint32(0), internalNodeNumKeys(node)
+	for low < high {
+		mid := low + (high-low)/2
+		if internalNodeKey(node, mid) < key {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// readInternalNode returns the children of an internal node and the keys
+// between them; the last child is the right child and has no key.
+func readInternalNode(node Page) (children []uint32, keys []uint32) {
+	numKeys := internalNodeNumKeys(node)
+	children = make([]uint32, numKeys+1)
+	keys = make([]uint32, numKeys)
+	for i := range numKeys {
+		children[i] = internalNodeChild(node, i)
+		keys[i] = internalNodeKey(node, i)
+	}
+	children[numKeys] = internalNodeRightChild(node)
+	return children, keys
+}
+
+// writeInternalNode makes node an internal node holding children and keys,
+// which has one fewer element.
+func writeInternalNode(node Page, children []uint32, keys []uint32) {
+	clear(node)
+	setNodeType(node, NODE_INTERNAL)
+	binary.LittleEndian.PutUint32(node[INTERNAL_NODE_NUM_KEYS_OFFSET:], uint32(len(keys)))
+	binary.LittleEndian.PutUint32(node[INTERNAL_NODE_RIGHT_CHILD_OFFSET:], children[len(keys)])
+	for i, key := range keys {
+		offset := internalNodeCellOffset(uint32(i))
+		binary.LittleEndian.PutUint32(node[offset:], children[i])
+		binary.LittleEndian.PutUint32(node[offset+INTERNAL_NODE_CHILD_SIZE:], key)
+	}
+}
+
+// pagerHoldPages stops getPage from evicting pages until pagerReleasePages,
+// for callers that keep several pages in hand while they restructure the
+// tree: a page evicted in the meantime would be read back as a fresh copy,
+// and writes to the old one lost.
+func pagerHoldPages(pager *Pager) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.holdPages = true
+}
+
+// pagerReleasePages ends pagerHoldPages, evicting whatever is over the limit.
+func pagerReleasePages(pager *Pager) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.holdPages = false
+	return pagerEvict(pager)
+}
+
+// pagerPin keeps pageNum in the cache until a matching pagerUnpin, for
+// callers that go on using one page while they fetch others, as a scan does
+// with the leaf under its cursor. Pins are counted, so readers sharing the
+// table can pin the same page. Unlike pagerHoldPages, every other page can
+// still be evicted. A pinned page is copied rather than changed in place
+// while readers may hold it; see pagerCopyOnWrite.
+func pagerPin(pager *Pager, pageNum uint32) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if pager.pins == nil {
+		pager.pins = make(map[uint32]int)
+	}
+	pager.pins[pageNum]++
+}
+
+// pagerUnpin releases a pin taken by pagerPin. The page is evicted by a later
+// getPage, if the cache is over its limit.
+func pagerUnpin(pager *Pager, pageNum uint32) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if pager.pins[pageNum]--; pager.pins[pageNum] <= 0 {
+		delete(pager.pins, pageNum)
+		delete(pager.shared, pageNum)
+	}
+}
+
+// pagerCopyOnWrite keeps a pinned page that readers may be holding from
+// changing under them: such a page is marked shared when it is fetched, and
+// the first getPageForWrite after that replaces the cached page with a copy
+// for the writer to change, leaving the readers the version they have.
+// Callers hold pager.mu.
+func pagerCopyOnWrite(pager *Pager, pageNum uint32, forWrite bool) {
+	if pager.pins[pageNum] == 0 {
+		return
+	}
+	if !forWrite {
+		if pager.shared == nil {
+			pager.shared = make(map[uint32]bool)
+		}
+		pager.shared[pageNum] = true
+	} else if pager.shared[pageNum] {
+		pager.pages[pageNum] = slices.Clone(pager.pages[pageNum])
+		delete(pager.shared, pageNum)
+	}
+}
+
+// findLeaf descends from the root to the leaf whose keys cover key. It also
+// returns the internal nodes passed on the way, root first.
+func findLeaf(table *Table, key uint32) (path []uint32, leafPageNum uint32, err error) {
+	pageNum := table.rootPageNum
+	for {
+		node, err := getPage(table.pager, pageNum)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch nodeType(node) {
+		case NODE_LEAF:
+			return path, pageNum, nil
+		case NODE_INTERNAL:
+			path = append(path, pageNum)
+			pageNum = internalNodeChild(node, internalNodeFindChild(node, key))
+		default:
+			return nil, 0, fmt.Errorf("%w: page %d has unknown node type %d", ErrCorrupt, pageNum, nodeType(node))
+		}
+		if len(path) > int(table.pager.numPages) {
+			return nil, 0, fmt.Errorf("%w: tree under root page %d has a cycle", ErrCorrupt, table.rootPageNum)
+		}
+	}
+}
+
+// leafNodeFind binary searches the leaf in page pageNum for key, returning a
+// cursor at its cell or at the cell it would be inserted at.
+func leafNodeFind(table *Table, pageNum uint32, key uint32) (*Cursor, bool, error) {
+	node, err := getPage(table.pager, pageNum)
+	if err != nil {
+		return nil, false, err
+	}
+	if nodeType(node) != NODE_LEAF {
+		return nil, false, fmt.Errorf("%w: page %d is not a leaf node", ErrCorrupt, pageNum)
+	}
+
+	numCells := leafNodeNumCells(node)
+	low, high := uint32(0), numCells
+	for low < high {
+		mid := low + (high-low)/2
+		if leafNodeKey(node, mid) < key {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	cursor := &Cursor{table: table, pageNum: pageNum, cellNum: low}
+	return cursor, low < numCells && leafNodeKey(node, low) == key, nil
+}
+
+// leafNodeInsert stores value, a serialized row, under key at the cursor. A
+// leaf it does not fit in is split. Callers hold table.mu for writing.
+func leafNodeInsert(cursor *Cursor, key uint32, value []byte) error {
+	pager := cursor.table.pager
+	node, err := getPageForWrite(pager, cursor.pageNum)
+	if err != nil {
+		return err
+	}
+
+	cells := slices.Insert(leafNodeCells(node), int(cursor.cellNum), makeLeafCell(key, value))
+	if leafCellsSize(cells) > leafNodeSpace(pager) {
+		return leafNodeSplitAndInsert(cursor, cells)
+	}
+	copy(node, encodeLeafNode(pager.pageSize, leafNodeNextLeaf(node), cells))
+	return nil
+}
+
+// leafNodeSplitAndInsert divides cells, the leaf's cells with the new one at
+// the cursor, between the leaf and a new leaf linked in after it, and adds
+// the new leaf to the parent. Both end up with about as many bytes, except
+// that a row appended past the end of the last leaf goes into the new leaf on
+// its own, so a table filled in id order ends up with full pages rather than
+// half-empty ones.
+func leafNodeSplitAndInsert(cursor *Cursor, cells [][]byte) error {
+	pager := cursor.table.pager
+	key := binary.LittleEndian.Uint32(cells[cursor.cellNum])
+	path, _, err := findLeaf(cursor.table, key)
+	if err != nil {
+		return err
+	}
+	oldNode, err := getPageForWrite(pager, cursor.pageNum)
+	if err != nil {
+		return err
+	}
+	newPageNum, err := pagerAllocatePage(pager)
+	if err != nil {
+		return err
+	}
+	newNode, err := getPageForWrite(pager, newPageNum)
+	if err != nil {
+		return err
+	}
+
+	leftCount := splitLeafCells(cells, leafNodeSpace(pager))
+	if int(cursor.cellNum) == len(cells)-1 && leafNodeNextLeaf(oldNode) == 0 {
+		leftCount = len(cells) - 1
+	}
+	// the cells point into oldNode, so lay out both halves before copying
+	left := encodeLeafNode(pager.pageSize, newPageNum, cells[:leftCount])
+	right := encodeLeafNode(pager.pageSize, leafNodeNextLeaf(oldNode), cells[leftCount:])
+	copy(oldNode, left)
+	copy(newNode, right)
+	return insertIntoParent(cursor.table, path, cursor.pageNum, leafNodeKey(oldNode, uint32(leftCount-1)), newPageNum)
+}
+
+// insertIntoParent records in the last node of path that its child left was
+// split: left now holds keys up to leftMax, and the rest moved to right. A
+// parent that overflows is split in turn, and a split root gets a new root
+// above it. Callers hold the pages with pagerHoldPages.
+func insertIntoParent(table *Table, path []uint32, left uint32, leftMax uint32, right uint32) error {
+	pager := table.pager
+	if len(path) == 0 {
+		rootPageNum, err := pagerAllocatePage(pager)
+		if err != nil {
+			return err
+		}
+		root, err := getPageForWrite(pager, rootPageNum)
+		if err != nil {
+			return err
+		}
+		writeInternalNode(root, []uint32{left, right}, []uint32{leftMax})
+		table.rootPageNum = rootPageNum
+		return nil
+	}
+
+	pageNum := path[len(path)-1]
+	node, err := getPageForWrite(pager, pageNum)
+	if err != nil {
+		return err
+	}
+	children, keys := readInternalNode(node)
+	i := slices.Index(children, left)
+	if i == -1 {
+		return fmt.Errorf("%w: page %d is missing from its parent, page %d", ErrCorrupt, left, pageNum)
+	}
+	// left's old key, if it had one, is still the bound for right
+	children = slices.Insert(children, i+1, right)
+	keys = slices.Insert(keys, i, leftMax)
+	if uint32(len(keys)) <= pager.internalMaxKeys {
+		writeInternalNode(node, children, keys)
+		return nil
+	}
+
+	// the middle key moves up to the parent as the bound of the left half
+	newPageNum, err := pagerAllocatePage(pager)
+	if err != nil {
+		return err
+	}
+	newNode, err := getPageForWrite(pager, newPageNum)
+	if err != nil {
+		return err
+	}
+	middle := len(keys) / 2
+	writeInternalNode(node, children[:middle+1], keys[:middle])
+	writeInternalNode(newNode, children[middle+1:], keys[middle+1:])
+	return insertIntoParent(table, path[:len(path)-1], pageNum, keys[middle], newPageNum)
+}
+
+// leafNodeDelete removes the cell under the cursor. A leaf left less than
+// half full is rebalanced. Callers hold table.mu for writing and the pages
+// with pagerHoldPages.
+func leafNodeDelete(cursor *Cursor) error {
+	pager := cursor.table.pager
+	node, err := getPageForWrite(pager, cursor.pageNum)
+	if err != nil {
+		return err
+	}
+
+	key := leafNodeKey(node, cursor.cellNum)
+	cells := slices.Delete(leafNodeCells(node), int(cursor.cellNum), int(cursor.cellNum)+1)
+	copy(node, encodeLeafNode(pager.pageSize, leafNodeNextLeaf(node), cells))
+	if !nodeUnderfull(pager, node) {
+		return nil
+	}
+
+	// the removed key still leads to this leaf: bounds in the parents are
+	// only ever loosened by a delete
+	path, _, err := findLeaf(cursor.table, key)
+	if err != nil {
+		return err
+	}
+	return rebalance(cursor.table, path, cursor.pageNum)
+}
+
+// nodeUnderfull reports whether a leaf fills less than half its space, or an
+// internal node holds fewer than half the keys it has room for.
+func nodeUnderfull(pager *Pager, node Page) bool {
+	if nodeType(node) == NODE_LEAF {
+		return leafNodeUsedSpace(node) < leafNodeSpace(pager)/2
+	}
+	return internalNodeNumKeys(node) < pager.internalMaxKeys/2
+}
+
+// rebalance restores the fill of the node in page pageNum, whose ancestors
+// are path, root first. An underfull node is paired with a sibling under the
+// same parent: if both fit in one node the right one is merged into the left
+// and freed, which takes a child from the parent, so the parent is rebalanced
+// next; otherwise their contents are split evenly between them. A root left
+// with a single child is replaced by that child. Callers hold the pages with
+// pagerHoldPages.
+func rebalance(table *Table, path []uint32, pageNum uint32) error {
+	pager := table.pager
+	node, err := getPage(pager, pageNum)
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		if nodeType(node) == NODE_INTERNAL && internalNodeNumKeys(node) == 0 {
+			table.rootPageNum = internalNodeRightChild(node)
+			return pagerFreePage(pager, pageNum)
+		}
+		return nil
+	}
+	if !nodeUnderfull(pager, node) {
+		return nil
+	}
+
+	parentPageNum := path[len(path)-1]
+	parent, err := getPageForWrite(pager, parentPageNum)
+	if err != nil {
+		return err
+	}
+	children, keys := readInternalNode(parent)
+	i := slices.Index(children, pageNum)
+	if i == -1 {
+		return fmt.Errorf("%w: page %d is missing from its parent, page %d", ErrCorrupt, pageNum, parentPageNum)
+	}
+	// pair with the left sibling when there is one; merging always keeps the
+	// left node, so page 0 stays the first leaf
+	left := max(i-1, 0)
+	leftNode, err := getPageForWrite(pager, children[left])
+	if err != nil {
+		return err
+	}
+	rightNode, err := getPageForWrite(pager, children[left+1])
+	if err != nil {
+		return err
+	}
+
+	var merged bool
+	var leftMax uint32
+	if nodeType(leftNode) == NODE_LEAF {
+		merged, leftMax = rebalanceLeaves(pager, leftNode, rightNode)
+	} else {
+		merged, leftMax = rebalanceInternalNodes(pager, leftNode, rightNode, keys[left])
+	}
+	if !merged {
+		keys[left] = leftMax
+		writeInternalNode(parent, children, keys)
+		return nil
+	}
+
+	// the merged node takes over the right one's bound, or becomes the
+	// parent's right child if that is what the right one was
+	rightPageNum := children[left+1]
+	children = slices.Delete(children, left+1, left+2)
+	keys = slices.Delete(keys, left, left+1)
+	writeInternalNode(parent, children, keys)
+	if err := pagerFreePage(pager, rightPageNum); err != nil {
+		return err
+	}
+	return rebalance(table, path[:len(path)-1], parentPageNum)
+}
+
+// rebalanceLeaves moves every cell of right into left if they fit, relinking
+// the chain around right, or else shares them out evenly. It reports whether
+// the leaves were merged and, if not, the largest key left in left.
+func rebalanceLeaves(pager *Pager, left, right Page) (merged bool, leftMax uint32) {
+	cells := slices.Concat(leafNodeCells(left), leafNodeCells(right))
+	space := leafNodeSpace(pager)
+	if leafCellsSize(cells) <= space {
+		copy(left, encodeLeafNode(pager.pageSize, leafNodeNextLeaf(right), cells))
+		return true, 0
+	}
+
+	leftCount := splitLeafCells(cells, space)
+	newLeft := encodeLeafNode(pager.pageSize, leafNodeNextLeaf(left), cells[:leftCount])
+	newRight := encodeLeafNode(pager.pageSize, leafNodeNextLeaf(right), cells[leftCount:])
+	copy(left, newLeft)
+	copy(right, newRight)
+	return false, leafNodeKey(left, uint32(leftCount-1))
+}
+
+// rebalanceInternalNodes moves every child of right into left if they fit,
+// or else shares them out evenly. separator is the parent's bound for left,
+// which lies between the two nodes' keys. It reports whether the nodes were
+// merged and, if not, the new bound for left.
+func rebalanceInternalNodes(pager *Pager, left, right Page, separator uint32) (merged bool, leftMax uint32) {
+	leftChildren, leftKeys := readInternalNode(left)
+	rightChildren, rightKeys := readInternalNode(right)
+	children := slices.Concat(leftChildren, rightChildren)
+	keys := slices.Concat(leftKeys, []uint32{separator}, rightKeys)
+	if uint32(len(keys)) <= pager.internalMaxKeys {
+		writeInternalNode(left, children, keys)
+		return true, 0
+	}
+
+	middle := len(keys) / 2
+	writeInternalNode(left, children[:middle+1], keys[:middle])
+	writeInternalNode(right, children[middle+1:], keys[middle+1:])
+	return false, keys[middle]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// smallPageSize is the smallest page size allowed, so a few hundred rows
+// build a tree several levels deep.
+const smallPageSize = MIN_PAGE_SIZE
+
+// checkTree walks the tree under the table's root, failing the test on any
+// node out of order, and returns its depth and number of rows.
+func checkTree(t *testing.T, table *Table) (depth int, numRows uint32) {
+	t.Helper()
+	var walk func(pageNum uint32, low, high uint64, level int)
+	walk = func(pageNum uint32, low, high uint64, level int) {
+		node, err := getPage(table.pager, pageNum)
+		if err != nil {
+			t.Fatalf("getPage(%d): %v", pageNum, err)
+		}
+		depth = max(depth, level)
+		if nodeType(node) == NODE_LEAF {
+			for i := range leafNodeNumCells(node) {
+				key := uint64(leafNodeKey(node, i))
+				if key < low || key > high {
+					t.Errorf("page %d cell %d: key %d outside [%d, %d]", pageNum, i, key, low, high)
+				}
+				if id := uint64(rowID(leafNodeValue(node, i))); id != key {
+					t.Errorf("page %d cell %d: row id %d stored under key %d", pageNum, i, id, key)
+				}
+				low = key + 1
+				numRows++
+			}
+			return
+		}
+		children, keys := readInternalNode(node)
+		for i, child := range children {
+			childHigh := high
+			if i < len(keys) {
+				childHigh = uint64(keys[i])
+				if childHigh < low || childHigh > high {
+					t.Errorf("page %d key %d: %d outside [%d, %d]", pageNum, i, childHigh, low, high)
+				}
+			}
+			walk(child, low, childHigh, level+1)
+			low = childHigh + 1
+		}
+	}
+	walk(table.rootPageNum, 0, 1<<32-1, 1)
+	return depth, numRows
+}
+
+func TestBTree_InternalNodeSplits(t *testing.T) {
+	table, err := dbOpenWith(MEMORY_FILENAME, OpenOptions{PageSize: smallPageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+	// a tiny fanout makes internal nodes split as often as leaves do
+	table.pager.internalMaxKeys = 3
+
+	rng := rand.New(rand.NewPCG(5, 6))
+	const numRows = 300
+	for _, id := range rng.Perm(numRows) {
+		if err := table.Insert(uint32(id), fmt.Sprintf("user%d", id), "x@example.com"); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+
+	depth, stored := checkTree(t, table)
+	if stored != numRows {
+		t.Errorf("tree holds %d rows, want %d", stored, numRows)
+	}
+	if depth < 4 {
+		t.Errorf("tree depth = %d, want at least 4", depth)
+	}
+	for id := range uint32(numRows) {
+		if _, found, err := tableFind(table, id); err != nil || !found {
+			t.Fatalf("tableFind(%d) = %v, %v", id, found, err)
+		}
+	}
+
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	for i, row := range rows {
+		if row.id != uint32(i) {
+			t.Fatalf("row %d has id %d", i, row.id)
+		}
+	}
+}
+
+func TestBTree_RootSurvivesReopen(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{PageSize: smallPageSize, MaxCachedPages: 2})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+
+	rng := rand.New(rand.NewPCG(7, 8))
+	const numRows = 200
+	for _, id := range rng.Perm(numRows) {
+		if err := table.Insert(uint32(id), fmt.Sprintf("user%d", id), "x@example.com"); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+	rootPageNum := table.rootPageNum
+	if rootPageNum == 0 {
+		t.Fatalf("root is still page 0 after %d inserts", numRows)
+	}
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.rootPageNum != rootPageNum {
+		t.Errorf("rootPageNum after reopen = %d, want %d", table.rootPageNum, rootPageNum)
+	}
+	if _, stored := checkTree(t, table); stored != numRows {
+		t.Errorf("tree holds %d rows after reopen, want %d", stored, numRows)
+	}
+	cursor, found, err := tableFind(table, numRows/2)
+	if err != nil || !found {
+		t.Fatalf("tableFind(%d) = %v, %v", numRows/2, found, err)
+	}
+	var row Row
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		t.Fatalf("cursorValue: %v", err)
+	}
+	if err := readRow(table.pager, slot, &row); err != nil {
+		t.Fatalf("readRow: %v", err)
+	}
+	if want := fmt.Sprintf("user%d", numRows/2); row.username != want {
+		t.Errorf("row %d has username %q, want %q", numRows/2, row.username, want)
+	}
+}
+
+func TestBTree_DeleteRebalances(t *testing.T) {
+	table, err := dbOpenWith(MEMORY_FILENAME, OpenOptions{PageSize: smallPageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+	table.pager.internalMaxKeys = 3
+
+	rng := rand.New(rand.NewPCG(9, 10))
+	const numRows = 300
+	for _, id := range rng.Perm(numRows) {
+		if err := table.Insert(uint32(id), fmt.Sprintf("user%d", id), "x@example.com"); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+	fullDepth, _ := checkTree(t, table)
+	fullPages := table.pager.numPages
+
+	remaining := numRows
+	var removed Row
+	for i, id := range rng.Perm(numRows) {
+		cursor, found, err := tableFind(table, uint32(id))
+		if err != nil || !found {
+			t.Fatalf("tableFind(%d) = %v, %v", id, found, err)
+		}
+		if err := removeRow(cursor, &removed); err != nil {
+			t.Fatalf("removeRow(%d): %v", id, err)
+		}
+		remaining--
+		if i%25 != 0 {
+			continue
+		}
+		if _, stored := checkTree(t, table); stored != uint32(remaining) {
+			t.Fatalf("tree holds %d rows after %d deletes, want %d", stored, i+1, remaining)
+		}
+	}
+
+	depth, stored := checkTree(t, table)
+	if stored != 0 {
+		t.Errorf("tree holds %d rows after deleting them all", stored)
+	}
+	if depth != 1 || table.rootPageNum != 0 {
+		t.Errorf("empty tree has depth %d and root page %d, want a single leaf in page 0", depth, table.rootPageNum)
+	}
+	if fullDepth < 4 {
+		t.Errorf("full tree depth = %d, want at least 4", fullDepth)
+	}
+	if table.pager.numPages >= fullPages {
+		t.Errorf("numPages = %d after deleting every row, want fewer than %d", table.pager.numPages, fullPages)
+	}
+}
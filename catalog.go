@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Starting with CATALOG_FORMAT_VERSION, the tables made by "create table"
+// are listed in a catalog on page 1. Each table has a B+ tree of its own,
+// sharing the file, the page cache and the freelist with the built-in table,
+// whose first leaf stays page 0. A database gets its catalog when its first
+// table is created: page 1 is taken for it, and whatever the page held is
+// moved to another page the way compaction moves pages, or when an insert
+// into the built-in table is first given its id, since the catalog also
+// keeps the largest id the built-in table has held; see nextID. Databases
+// with neither have no catalog. "drop table" gives the pages of a table back
+// to the freelist and takes it out of the catalog.
+const (
+	CATALOG_FORMAT_VERSION = 9
+
+	NODE_CATALOG NodeType = 4
+	CATALOG_PAGE          = 1
+)
+
+// Catalog layout: the header, the number of tables and the largest id of the
+// built-in table, is followed by every table in the order they
+// were created, each its root page, the number of rows it holds, its name and
+// its columns. The columns are a uvarint count followed by each column's
+// name, type, size and attributes: a uvarint of COLUMN_HAS_* flags, each
+// followed by what it says the column has. The checks follow, a uvarint count
+// and their texts. Names and texts are prefixed by their uvarint length and
+// sizes are uvarints. The root page comes first, so
+// compaction can rewrite it in place.
+const (
+	CATALOG_NUM_TABLES_SIZE   = 4
+	CATALOG_NUM_TABLES_OFFSET = COMMON_NODE_HEADER_SIZE
+	CATALOG_LAST_ID_SIZE      = 4
+	CATALOG_LAST_ID_OFFSET    = CATALOG_NUM_TABLES_OFFSET + CATALOG_NUM_TABLES_SIZE
+	CATALOG_HEADER_SIZE       = CATALOG_LAST_ID_OFFSET + CATALOG_LAST_ID_SIZE
+
+	CATALOG_ROOT_SIZE     = 4
+	CATALOG_NUM_ROWS_SIZE = 4
+
+	COLUMN_HAS_DEFAULT = 1 << 0 // followed by the default, as written
+	COLUMN_IS_UNIQUE   = 1 << 1
+	COLUMN_IS_NOT_NULL = 1 << 2
+
+	COLUMN_ATTRIBUTES = COLUMN_HAS_DEFAULT | COLUMN_IS_UNIQUE | COLUMN_IS_NOT_NULL
+)
+
+var (
+	errTableExists = errors.New("table already exists")
+	errNoSuchTable = errors.New("no such table")
+	errCatalogFull = errors.New("catalog is full; the definitions of the tables must fit in a page")
+)
+
+// catalogEntry is a table as the catalog lists it.
+type catalogEntry struct {
+	schema      *Schema
+	rootPageNum uint32
+	numRows     uint32
+	rootOffset  uint32 // where rootPageNum is stored in the catalog page
+}
+
+// readCatalog returns the tables the catalog lists, and whether the database
+// has a catalog at all.
+func readCatalog(pager *Pager) ([]catalogEntry, bool, error) {
+	if pager.version < CATALOG_FORMAT_VERSION || pager.numPages <= CATALOG_PAGE {
+		return nil, false, nil
+	}
+	page, err := getPage(pager, CATALOG_PAGE)
+	if err != nil {
+		return nil, false, err
+	}
+	if nodeType(page) != NODE_CATALOG {
+		return nil, false, nil
+	}
+	entries, err := decodeCatalog(page[:pageUsableSize(pager)])
+	if err != nil {
+		return nil, false, err
+	}
+	return entries, true, nil
+}
+
+func decodeCatalog(page Page) ([]catalogEntry, error) {
+	numTables := binary.LittleEndian.Uint32(page[CATALOG_NUM_TABLES_OFFSET:])
+	entries := make([]catalogEntry, 0, min(numTables, 64))
+	offset := uint32(CATALOG_HEADER_SIZE)
+	for tableNum := range numTables {
+		malformed := fmt.Errorf("%w: table %d of the catalog is malformed", ErrCorrupt, tableNum)
+		if uint64(offset)+CATALOG_ROOT_SIZE+CATALOG_NUM_ROWS_SIZE > uint64(len(page)) {
+			return nil, malformed
+		}
+		entry := catalogEntry{
+			schema:      &Schema{},
+			rootPageNum: binary.LittleEndian.Uint32(page[offset:]),
+			numRows:     binary.LittleEndian.Uint32(page[offset+CATALOG_ROOT_SIZE:]),
+			rootOffset:  offset,
+		}
+		rest := page[offset+CATALOG_ROOT_SIZE+CATALOG_NUM_ROWS_SIZE:]
+		name, rest, ok := cutLengthPrefixed(rest)
+		if !ok {
+			return nil, malformed
+		}
+		entry.schema.Name = string(name)
+		numColumns, n := binary.Uvarint(rest)
+		if n <= 0 || numColumns == 0 || numColumns > uint64(len(rest)) {
+			return nil, malformed
+		}
+		rest = rest[n:]
+		for range numColumns {
+			var column Column
+			name, rest, ok = cutLengthPrefixed(rest)
+			if !ok || len(rest) == 0 {
+				return nil, malformed
+			}
+			column.Name, column.Type = string(name), ColumnType(rest[0])
+			size, n := binary.Uvarint(rest[1:])
+			if n <= 0 || size > math.MaxUint32 || column.Type > COLUMN_TIMESTAMP {
+				return nil, malformed
+			}
+			column.Size = uint32(size)
+			rest = rest[1+n:]
+			attributes, n := binary.Uvarint(rest)
+			if n <= 0 || attributes&^COLUMN_ATTRIBUTES != 0 {
+				return nil, malformed
+			}
+			rest = rest[n:]
+			column.Unique = attributes&COLUMN_IS_UNIQUE != 0
+			column.NotNull = attributes&COLUMN_IS_NOT_NULL != 0
+			if attributes&COLUMN_HAS_DEFAULT != 0 {
+				var value []byte
+				if value, rest, ok = cutLengthPrefixed(rest); !ok {
+					return nil, malformed
+				}
+				column.Default = new(string)
+				*column.Default = string(value)
+			}
+			entry.schema.Columns = append(entry.schema.Columns, column)
+		}
+		numChecks, n := binary.Uvarint(rest)
+		if n <= 0 || numChecks > uint64(len(rest)) {
+			return nil, malformed
+		}
+		rest = rest[n:]
+		for range numChecks {
+			var check []byte
+			if check, rest, ok = cutLengthPrefixed(rest); !ok {
+				return nil, malformed
+			}
+			entry.schema.Checks = append(entry.schema.Checks, string(check))
+		}
+		entries = append(entries, entry)
+		offset = uint32(len(page) - len(rest))
+	}
+	return entries, nil
+}
+
+// encodeCatalog lays out the catalog page listing tables, along with the
+// largest id of the built-in table.
+func encodeCatalog(pager *Pager, tables []*Table, lastID uint32) ([]byte, error) {
+	page := make([]byte, CATALOG_HEADER_SIZE, pager.pageSize)
+	setNodeType(page, NODE_CATALOG)
+	binary.LittleEndian.PutUint32(page[CATALOG_NUM_TABLES_OFFSET:], uint32(len(tables)))
+	binary.LittleEndian.PutUint32(page[CATALOG_LAST_ID_OFFSET:], lastID)
+	for _, tree := range tables {
+		page = binary.LittleEndian.AppendUint32(page, tree.rootPageNum)
+		page = binary.LittleEndian.AppendUint32(page, tree.numRows)
+		page = binary.AppendUvarint(page, uint64(len(tree.schema.Name)))
+		page = append(page, tree.schema.Name...)
+		page = binary.AppendUvarint(page, uint64(len(tree.schema.Columns)))
+		for _, column := range tree.schema.Columns {
+			page = binary.AppendUvarint(page, uint64(len(column.Name)))
+			page = append(page, column.Name...)
+			page = append(page, byte(column.Type))
+			page = binary.AppendUvarint(page, uint64(column.Size))
+			var attributes uint64
+			if column.Default != nil {
+				attributes |= COLUMN_HAS_DEFAULT
+			}
+			if column.Unique {
+				attributes |= COLUMN_IS_UNIQUE
+			}
+			if column.NotNull {
+				attributes |= COLUMN_IS_NOT_NULL
+			}
+			page = binary.AppendUvarint(page, attributes)
+			if column.Default != nil {
+				page = binary.AppendUvarint(page, uint64(len(*column.Default)))
+				page = append(page, *column.Default...)
+			}
+		}
+		page = binary.AppendUvarint(page, uint64(len(tree.schema.Checks)))
+		for _, check := range tree.schema.Checks {
+			page = binary.AppendUvarint(page, uint64(len(check)))
+			page = append(page, check...)
+		}
+	}
+	if len(page) > int(pageUsableSize(pager)) {
+		return nil, errCatalogFull
+	}
+	return page, nil
+}
+
+// loadCatalog reads the tables the catalog lists into table.tables, and
+// raises table.lastID to the largest id it keeps. It runs after loadIDs.
+func loadCatalog(table *Table) error {
+	entries, exists, err := readCatalog(table.pager)
+	if err != nil {
+		return err
+	}
+	if exists {
+		page, err := getPage(table.pager, CATALOG_PAGE)
+		if err != nil {
+			return err
+		}
+		table.lastID = max(table.lastID, binary.LittleEndian.Uint32(page[CATALOG_LAST_ID_OFFSET:]))
+	}
+	table.tables = nil
+	for _, entry := range entries {
+		tree := &Table{
+			pager:       table.pager,
+			numRows:     entry.numRows,
+			rootPageNum: entry.rootPageNum,
+			maxRows:     math.MaxUint32,
+			schema:      entry.schema,
+		}
+		if err := loadIDs(tree); err != nil {
+			return fmt.Errorf("table %s: %w", entry.schema.Name, err)
+		}
+		table.tables = append(table.tables, tree)
+	}
+	return nil
+}
+
+// saveCatalog writes table.tables to the catalog page, which must exist.
+// Callers hold table.mu for writing, and call it after every change to the
+// root or the number of rows of one of the tables.
+func saveCatalog(table *Table) error {
+	encoded, err := encodeCatalog(table.pager, table.tables, table.lastID)
+	if err != nil {
+		return err
+	}
+	page, err := getPageForWrite(table.pager, CATALOG_PAGE)
+	if err != nil {
+		return err
+	}
+	clear(page)
+	copy(page, encoded)
+	return nil
+}
+
+// findTable returns the table made by create table named name, nil if there
+// is none.
+func findTable(table *Table, name string) *Table {
+	for _, tree := range table.tables {
+		if tree.schema.Name == name {
+			return tree
+		}
+	}
+	return nil
+}
+
+// createTable adds an empty table with the given schema to the database,
+// creating the catalog first if it has none. Callers hold table.mu for
+// writing.
+func createTable(table *Table, schema *Schema) error {
+	if findTable(table, schema.Name) != nil {
+		return fmt.Errorf("%w: %s", errTableExists, schema.Name)
+	}
+	pager := table.pager
+	tree := &Table{pager: pager, maxRows: math.MaxUint32, schema: schema, ids: make(map[uint32]struct{})}
+	tables := append(slices.Clip(table.tables), tree)
+	// the catalog page cannot grow, so check it has room before changing
+	// anything
+	if _, err := encodeCatalog(pager, tables, table.lastID); err != nil {
+		return err
+	}
+
+	if err := ensureCatalog(table); err != nil {
+		return err
+	}
+	rootPageNum, err := pagerAllocatePage(pager)
+	if err != nil {
+		return err
+	}
+	root, err := getPageForWrite(pager, rootPageNum)
+	if err != nil {
+		return err
+	}
+	initializeLeafNode(root)
+	tree.rootPageNum = rootPageNum
+	table.tables = tables
+	return saveCatalog(table)
+}
+
+// ensureCatalog gives the database a catalog if it has none. Callers hold
+// table.mu for writing, and save the catalog afterwards.
+func ensureCatalog(table *Table) error {
+	_, exists, err := readCatalog(table.pager)
+	if err != nil || exists {
+		return err
+	}
+	if err := reserveCatalogPage(table); err != nil {
+		return err
+	}
+	table.pager.version = max(table.pager.version, CATALOG_FORMAT_VERSION)
+	return nil
+}
+
+// dropTable removes the table made by create table named name, giving the
+// pages of its tree back to the freelist. Callers hold table.mu for writing.
+func dropTable(table *Table, name string) error {
+	i := slices.IndexFunc(table.tables, func(tree *Table) bool { return tree.schema.Name == name })
+	if i == -1 {
+		return fmt.Errorf("%w: %s", errNoSuchTable, name)
+	}
+	pages, err := treePages(table.tables[i])
+	if err != nil {
+		return err
+	}
+	table.tables = slices.Delete(slices.Clip(table.tables), i, i+1)
+	if err := saveCatalog(table); err != nil {
+		return err
+	}
+	// freed from the last page down, as many as can be are dropped from the
+	// end of the file rather than put on the freelist
+	slices.Sort(pages)
+	for _, pageNum := range slices.Backward(pages) {
+		if err := pagerFreePage(table.pager, pageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treePages returns the pages of the tree of a table made by create table,
+// whose records have no overflow pages.
+func treePages(tree *Table) ([]uint32, error) {
+	pager := tree.pager
+	var pages []uint32
+	var walk func(pageNum uint32) error
+	walk = func(pageNum uint32) error {
+		if pageNum == 0 || pageNum == CATALOG_PAGE || pageNum >= pager.numPages || uint32(len(pages)) == pager.numPages {
+			return fmt.Errorf("%w: page %d cannot be in the tree of table %s", ErrCorrupt, pageNum, tree.schema.Name)
+		}
+		pages = append(pages, pageNum)
+		node, err := getPage(pager, pageNum)
+		if err != nil {
+			return err
+		}
+		switch nodeType(node) {
+		case NODE_LEAF:
+			return nil
+		case NODE_INTERNAL:
+			children, _ := readInternalNode(node)
+			for _, child := range children {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("%w: page %d in the tree of table %s is neither a leaf nor an internal node", ErrCorrupt, pageNum, tree.schema.Name)
+	}
+	if err := walk(tree.rootPageNum); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// reserveCatalogPage makes page 1 free for the catalog. A database of a
+// single page grows a second one. Otherwise page 1 is taken off the freelist
+// if it is on it, or, if it is in use, its contents are moved to a newly
+// allocated page and every reference to it rewritten, as compaction does.
+func reserveCatalogPage(table *Table) error {
+	pager := table.pager
+	if pager.numPages <= CATALOG_PAGE {
+		_, err := pagerAllocatePage(pager)
+		return err
+	}
+	refs, err := collectPageRefs(table)
+	if err != nil {
+		return err
+	}
+	refsToPage, used := refs[CATALOG_PAGE]
+	if !used {
+		return pagerUnlinkFreePage(pager, CATALOG_PAGE)
+	}
+
+	to, err := pagerAllocatePage(pager)
+	if err != nil {
+		return err
+	}
+	// a snapshot would go on reading the moved page where it was
+	pager.mu.Lock()
+	invalidateSnapshots(pager)
+	pager.mu.Unlock()
+	source, err := getPage(pager, CATALOG_PAGE)
+	if err != nil {
+		return err
+	}
+	destination, err := getPageForWrite(pager, to)
+	if err != nil {
+		return err
+	}
+	copy(destination, source)
+	// references stored in page 1 itself moved along with it
+	for _, ref := range refsToPage {
+		page, err := getPageForWrite(pager, ref.page)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(page[ref.offset:], to)
+	}
+	if table.rootPageNum == CATALOG_PAGE {
+		table.rootPageNum = to
+	}
+	return nil
+}
+
+// copyTables creates the tables of source in target, a table just built by
+// buildTree, and copies their records over in key order, which keeps the
+// leaves full. The largest id of the built-in table is kept along with them.
+func copyTables(source, target *Table) error {
+	if _, exists, err := readCatalog(source.pager); err != nil || !exists {
+		return err
+	}
+	if err := ensureCatalog(target); err != nil {
+		return err
+	}
+	for _, tree := range source.tables {
+		if err := createTable(target, tree.schema); err != nil {
+			return err
+		}
+		copied := target.tables[len(target.tables)-1]
+		cursor := tableStart(tree)
+		for ; !cursor.endOfTable; cursorAdvance(cursor) {
+			record, err := cursorValue(cursor)
+			if err != nil {
+				cursorClose(cursor)
+				return fmt.Errorf("table %s: %w", tree.schema.Name, err)
+			}
+			if err := insertRecord(copied, record); err != nil {
+				cursorClose(cursor)
+				return err
+			}
+		}
+		cursorClose(cursor)
+	}
+	target.lastID = max(target.lastID, source.lastID)
+	return saveCatalog(target)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const createProducts = "create table products (id int, name text(64), price int)\n"
+
+// addProducts stores the records (id, product<id>, id*10) for ids in [from,
+// to] in the table products.
+func addProducts(t *testing.T, table *Table, from, to int) {
+	t.Helper()
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	products := findTable(table, "products")
+	for id := from; id <= to; id++ {
+		values := []Value{int64(id), fmt.Sprintf("product%d", id), int64(id * 10)}
+		if err := insertRecord(products, encodeRecord(products.schema, values)); err != nil {
+			t.Fatalf("insertRecord(%d): %v", id, err)
+		}
+	}
+	if err := saveCatalog(table); err != nil {
+		t.Fatalf("saveCatalog: %v", err)
+	}
+}
+
+// checkProducts checks that the table products holds the records addProducts
+// stored for ids in [from, to], and that the database is sound.
+func checkProducts(t *testing.T, table *Table, from, to int) {
+	t.Helper()
+	products := findTable(table, "products")
+	if products == nil {
+		t.Fatalf("no table products")
+	}
+	id := from
+	cursor := tableStart(products)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		record, err := cursorValue(cursor)
+		if err != nil {
+			t.Fatalf("cursorValue: %v", err)
+		}
+		values, err := decodeRecord(products.schema, record)
+		if err != nil {
+			t.Fatalf("decodeRecord: %v", err)
+		}
+		want := []Value{int64(id), fmt.Sprintf("product%d", id), int64(id * 10)}
+		if !reflect.DeepEqual(values, want) {
+			t.Fatalf("record = %v, want %v", values, want)
+		}
+		id++
+	}
+	if id != to+1 || products.numRows != uint32(to-from+1) {
+		t.Errorf("products holds records up to %d and counts %d, want up to %d", id-1, products.numRows, to)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(createProducts+
+		"create table tags (tag_id int, label text(16))\n"+
+		"create table products (id int)\n"+
+		"+tables\n+schema products\n+schema nothing\n"), &output, table, true)
+	want := "Error: table already exists.\n" +
+		"products (id int, name text(64), price int), 0 rows\n" +
+		"tags (tag_id int, label text(16)), 0 rows\n" +
+		"column     type       max size\n" +
+		"id         int (key)         4\n" +
+		"name       text(64)         64\n" +
+		"price      int              10\n" +
+		"max row size = 80\n" +
+		"Error: no table named nothing.\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	if table.pager.version != CATALOG_FORMAT_VERSION {
+		t.Errorf("format version = %d, want %d", table.pager.version, CATALOG_FORMAT_VERSION)
+	}
+	addProducts(t, table, 1, 300)
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+tables\n"), &output, table, true)
+	if want := "products (id int, name text(64), price int), 300 rows\ntags (tag_id int, label text(16)), 0 rows\n"; output.String() != want {
+		t.Errorf("+tables after reopening:\n%s\nwant:\n%s", output.String(), want)
+	}
+	checkProducts(t, table, 1, 300)
+}
+
+func TestCreateTable_MovesThePagesInTheWay(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, want := fragmentedTable(t, fileName, OpenOptions{})
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+	if _, exists, err := readCatalog(table.pager); !exists || err != nil {
+		t.Fatalf("no catalog after create table: %v", err)
+	}
+	checkRows(t, table, want)
+	addProducts(t, table, 1, 200)
+	checkProducts(t, table, 1, 200)
+
+	// the tables' pages are moved and compacted along with the rest
+	runREPL(strings.NewReader(deleteRows(1, 9)), io.Discard, table)
+	want = want[9:]
+	if _, _, err := dbCompact(table, 1000); err != nil {
+		t.Fatalf("dbCompact: %v", err)
+	}
+	checkRows(t, table, want)
+	checkProducts(t, table, 1, 200)
+	if _, err := dbVacuum(table); err != nil {
+		t.Fatalf("dbVacuum: %v", err)
+	}
+	checkRows(t, table, want)
+	checkProducts(t, table, 1, 200)
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	checkRows(t, table, want)
+	checkProducts(t, table, 1, 200)
+}
+
+func TestCreateTable_RollsBack(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	// the rows fill more than one page, so page 1 has to be moved out of
+	// the catalog's way
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(insertRows(1, 300)+"+begin\n"+createProducts+"+rollback\n+tables\nselect count\n"), &output, table, true)
+	if want := "(no tables)\ncount: 300\n"; !strings.HasSuffix(output.String(), want) {
+		t.Errorf("output:\n%s\nwant it to end with:\n%s", output.String(), want)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+
+	want, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+	checkRows(t, table, want)
+	addProducts(t, table, 1, 50)
+	checkProducts(t, table, 1, 50)
+}
+
+func TestDropTable(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(createProducts+"create table tags (tag_id int, label text(16))\n"), io.Discard, table)
+	addProducts(t, table, 1, 500)
+	tags := findTable(table, "tags")
+	numPages := table.pager.numPages
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("drop table products\ndrop table products\ndrop table if exists products\n+tables\n"), &output, table, true)
+	if want := "Error: no such table.\ntags (tag_id int, label text(16)), 0 rows\n"; output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	// the pages of products come before those of tags, whose root was
+	// allocated last, so they go onto the freelist
+	if freed := table.pager.numFreePages + numPages - table.pager.numPages; freed < 5 {
+		t.Errorf("%d pages freed by dropping a table of 500 rows", freed)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+
+	// freed pages are handed out to the tables left
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+	if root := findTable(table, "products").rootPageNum; root > tags.rootPageNum {
+		t.Errorf("new table's root is page %d, not one of the pages freed before page %d", root, tags.rootPageNum)
+	}
+	addProducts(t, table, 1, 20)
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+tables\n"), &output, table, true)
+	if want := "tags (tag_id int, label text(16)), 0 rows\nproducts (id int, name text(64), price int), 20 rows\n"; output.String() != want {
+		t.Errorf("+tables after reopening:\n%s\nwant:\n%s", output.String(), want)
+	}
+	checkProducts(t, table, 1, 20)
+}
+
+func TestPrepareStatement_CreateTable(t *testing.T) {
+	got, err := prepareStatement("create table t (k int, note text(10))")
+	if err != nil {
+		t.Fatalf("prepareStatement: %v", err)
+	}
+	want := &CreateTableStmt{Schema: Schema{Name: "t", Columns: []Column{{Name: "k", Type: COLUMN_INT}, {Name: "note", Type: COLUMN_TEXT, Size: 10}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prepareStatement = %+v, want %+v", got, want)
+	}
+	if got, err := prepareStatement("drop table if exists t"); err != nil || !reflect.DeepEqual(got, &DropTableStmt{Name: "t", IfExists: true}) {
+		t.Errorf("prepareStatement(drop table if exists t) = %+v, %v", got, err)
+	}
+
+	for input, message := range map[string]string{
+		"create t (k int)":                       `expected "table", found identifier "t"`,
+		"create table 1t (k int)":                `expected a table name, found identifier "1t"`,
+		"create table t ()":                      `expected a column name, found operator ")"`,
+		"create table t (k int, k int)":          "column k is defined twice",
+		"create table t (k text(5))":             "the first column is the key, and must be an int",
+		"create table t (k int, v float)":        `expected int, real, boolean, text(<size>), blob(<size>), date or timestamp, found identifier "float"`,
+		"create table t (k int, v blob)":         `expected "(", found operator ")"`,
+		"create table t (k int, v text(0))":      `expected a size of at least 1, found number "0"`,
+		"create table t (k int, v text(5)":       `expected ")", found end of statement`,
+		"create table t (k int, v text(300))":    "rows could take 307 bytes with v, more than the 294 a row may",
+		"create table t (k int) extra":           `expected the end of the statement, found identifier "extra"`,
+		"create table t (k int, select text(1))": `expected a column name, found keyword "select"`,
+		"drop t":                                 `expected "table", found identifier "t"`,
+		"drop table if t":                        `expected "exists", found identifier "t"`,
+		"drop table":                             "expected a table name, found end of statement",
+	} {
+		_, err := prepareStatement(input)
+		if prepareResult(err) != PREPARE_SYNTAX_ERROR || !strings.HasSuffix(err.Error(), message) {
+			t.Errorf("prepareStatement(%q): %v, want a syntax error ending in %q", input, err, message)
+		}
+	}
+}
+
+func TestRecord_RoundTrips(t *testing.T) {
+	schema := &Schema{Name: "t", Columns: []Column{
+		{Name: "k", Type: COLUMN_INT}, {Name: "n", Type: COLUMN_INT}, {Name: "s", Type: COLUMN_TEXT, Size: 8},
+		{Name: "r", Type: COLUMN_REAL}, {Name: "b", Type: COLUMN_BOOLEAN}, {Name: "x", Type: COLUMN_BLOB, Size: 4},
+	}}
+	values := []Value{int64(4294967295), int64(-300), "ünï", -2.5e-8, true, []byte{0, 0xff}}
+	record := encodeRecord(schema, values)
+	if uint64(len(record)) > maxRecordSize(schema.Columns) {
+		t.Errorf("record of %d bytes, more than the %d most", len(record), maxRecordSize(schema.Columns))
+	}
+	got, err := decodeRecord(schema, record)
+	if err != nil || !reflect.DeepEqual(got, values) {
+		t.Errorf("decodeRecord = %v, %v, want %v", got, err, values)
+	}
+	if _, err := decodeRecord(schema, record[:len(record)-1]); err == nil {
+		t.Errorf("decodeRecord of a truncated record succeeded")
+	}
+
+	values = []Value{int64(1), nil, "", nil, false, nil}
+	if got, err := decodeRecord(schema, encodeRecord(schema, values)); err != nil || !reflect.DeepEqual(got, values) {
+		t.Errorf("decodeRecord = %v, %v, want %v", got, err, values)
+	}
+}
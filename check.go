@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A table made by create table may have checks: conditions, written as in a
+// where clause, that no record may make false.
+//
+//	create table items (id int, price int check (price >= 0), stock int, check (stock > 0 or price = 0))
+//
+// A check written after a column is no different from one among the
+// columns, and either may name any column. The catalog keeps a check as it
+// was written, and it is parsed again whenever a record is inserted. A
+// comparison with null is neither true nor false, so a check on a column
+// left null passes, as in SQL.
+
+var errCheckViolation = errors.New("check constraint failed")
+
+// isCheck reports whether the next tokens start a check rather than, say, a
+// column named check.
+func (p *parser) isCheck() bool {
+	token := p.peek()
+	if token.Type != TOKEN_IDENTIFIER || token.Text != "check" {
+		return false
+	}
+	next := p.tokens[p.next+1]
+	return next.Type == TOKEN_OPERATOR && next.Text == "("
+}
+
+// check parses "check (<condition>)" into schema.Checks. The condition is
+// checked against the columns once they are all known; see checkChecks.
+func (p *parser) check(schema *Schema) PrepareResult {
+	keyword := p.advance()
+	if result := p.expect("("); result != PREPARE_SUCCESS {
+		return result
+	}
+	start := p.peek().Pos
+	p.anyColumn = true
+	if _, result := p.where(); result != PREPARE_SUCCESS {
+		return result
+	}
+	end := p.tokens[p.next-1].End
+	if result := p.expect(")"); result != PREPARE_SUCCESS {
+		return result
+	}
+	schema.Checks = append(schema.Checks, p.input[start:end])
+	p.checks = append(p.checks, keyword)
+	return PREPARE_SUCCESS
+}
+
+// checkChecks points at the first check of schema that names a column it
+// does not have, or compares one with a value of another type.
+func (p *parser) checkChecks(schema *Schema) PrepareResult {
+	for i, text := range schema.Checks {
+		condition, err := parseCondition(text)
+		if err == nil {
+			_, err = compileCondition(schema, condition)
+		}
+		if err == nil {
+			continue
+		}
+		_, message, _ := strings.Cut(err.Error(), ": ")
+		if errors.Is(err, errNoSuchColumn) {
+			return p.failAt(p.checks[i], PREPARE_NO_SUCH_COLUMN, message)
+		}
+		return p.failAt(p.checks[i], PREPARE_TYPE_MISMATCH, message)
+	}
+	return PREPARE_SUCCESS
+}
+
+// parseCondition parses text, a condition on the columns of a table made by
+// create table.
+func parseCondition(text string) (Condition, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: text, tokens: tokens, anyColumn: true}
+	condition, result := p.where()
+	if result == PREPARE_SUCCESS {
+		result = p.end()
+	}
+	if result != PREPARE_SUCCESS {
+		return nil, p.err
+	}
+	return condition, nil
+}
+
+// checkRecord fails if values, a record of a table with the columns of
+// schema, make one of its checks false.
+func checkRecord(schema *Schema, values []Value) error {
+	for _, text := range schema.Checks {
+		condition, err := parseCondition(text)
+		if err != nil {
+			return fmt.Errorf("%w: table %s has a check (%s) that does not parse: %v", ErrCorrupt, schema.Name, text, err)
+		}
+		evaluate, err := compileTruth(schema, condition)
+		if err != nil {
+			return fmt.Errorf("%w: table %s has a check (%s) that does not fit it: %v", ErrCorrupt, schema.Name, text, err)
+		}
+		if evaluate(values) == TRUTH_FALSE {
+			return fmt.Errorf("%w: %s: (%s)", errCheckViolation, schema.Name, text)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Starting with CHECKSUM_FORMAT_VERSION, every page ends in a CRC32 of the
+// rest of the page, and pages are always written whole. Files of older
+// versions keep their layout and are not checked. In an encrypted file the
+// checksum comes before the encryption trailer; see encrypt.go.
+const (
+	CHECKSUM_FORMAT_VERSION = 2
+	PAGE_CHECKSUM_SIZE      = 4
+)
+
+// pageUsableSize returns how many bytes of a page its node can use: all of
+// them but the checksum and the encryption trailer.
+func pageUsableSize(pager *Pager) uint32 {
+	return pager.pageSize - min(pager.pageSize, pager.reserved+PAGE_CHECKSUM_SIZE)
+}
+
+func computePageChecksum(pager *Pager, page Page) uint32 {
+	return crc32.ChecksumIEEE(page[:pageUsableSize(pager)])
+}
+
+// putPageChecksum stores the page's checksum in its trailer.
+func putPageChecksum(pager *Pager, page Page) {
+	binary.LittleEndian.PutUint32(page[pageUsableSize(pager):], computePageChecksum(pager, page))
+}
+
+// verifyPageChecksum checks a page just read from the file against the
+// checksum in its trailer.
+func verifyPageChecksum(pager *Pager, pageNum uint32, page Page) error {
+	stored := binary.LittleEndian.Uint32(page[pageUsableSize(pager):])
+	if computed := computePageChecksum(pager, page); stored != computed {
+		return fmt.Errorf("%w: page %d checksum mismatch: stored %08x, computed %08x", ErrCorrupt, pageNum, stored, computed)
+	}
+	return nil
+}
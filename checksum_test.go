@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChecksum_DetectsCorruptPage(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf+2)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	// flip a bit in the email of the first row on page 1
+	cell := leafNodeCell(Page(contents[HEADER_SIZE+DEFAULT_PAGE_SIZE:]), 0)
+	cell[len(cell)-1] ^= 0x01
+	if err := os.WriteFile(fileName, contents, 0666); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	_, err = dbOpen(fileName)
+	if !errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), "page 1 checksum mismatch") {
+		t.Fatalf("dbOpen error = %v, want a page 1 checksum mismatch", err)
+	}
+}
+
+func TestChecksum_DetectsTruncatedPage(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, 2)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	if err := os.Truncate(fileName, HEADER_SIZE+DEFAULT_PAGE_SIZE/2); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	_, err := dbOpen(fileName)
+	if !errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), "page 0 truncated") {
+		t.Fatalf("dbOpen error = %v, want a truncated page error", err)
+	}
+}
+
+func TestChecksum_ValidPageWithBrokenTreeIsCorrupt(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf+2)), io.Discard, table)
+	rootPageNum := table.rootPageNum
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	// give the root a node type that does not exist, with a checksum to
+	// match, so only the tree itself is wrong
+	root := Page(contents[HEADER_SIZE+rootPageNum*DEFAULT_PAGE_SIZE:][:DEFAULT_PAGE_SIZE])
+	setNodeType(root, 7)
+	putPageChecksum(&Pager{pageSize: DEFAULT_PAGE_SIZE}, root)
+	if err := os.WriteFile(fileName, contents, 0666); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	_, err = dbOpen(fileName)
+	if !errors.Is(err, ErrCorrupt) || !strings.Contains(err.Error(), "unknown node type 7") {
+		t.Fatalf("dbOpen error = %v, want an unknown node type", err)
+	}
+}
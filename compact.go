@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Compaction moves the pages in use at the end of the file into the free
+// pages nearer the front, rewriting every reference to them, so the file can
+// be trimmed after the last page still in use. Unlike +vacuum it works in
+// place, a bounded number of pages at a time, and leaves the leaves as full
+// as they are. Pages neither in use nor on the freelist are reclaimed too.
+// With OpenOptions.CompactOnClose, dbClose compacts the whole file when more
+// than that percentage of its pages are free.
+
+// pageRef is where a page number is stored: offset bytes into page.
+type pageRef struct {
+	page   uint32
+	offset uint32
+}
+
+// dbCompact moves up to limit pages toward the front of the file and trims
+// the pages left free at its end. It returns how many pages were moved and
+// how many the file shrank by.
+func dbCompact(table *Table, limit uint32) (moved, reclaimed uint32, err error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.inTransaction {
+		return 0, 0, errInsideTransaction
+	}
+	if table.readOnly {
+		return 0, 0, ErrReadOnly
+	}
+	moved, reclaimed, err = compactPages(table, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	return moved, reclaimed, commitStatement(table)
+}
+
+// compactPages is dbCompact for callers holding table.mu for writing.
+func compactPages(table *Table, limit uint32) (moved, reclaimed uint32, err error) {
+	pager := table.pager
+	refs, err := collectPageRefs(table)
+	if err != nil {
+		return 0, 0, err
+	}
+	var holes, used []uint32
+	for pageNum := range pager.numPages {
+		if _, ok := refs[pageNum]; ok {
+			used = append(used, pageNum)
+		} else {
+			holes = append(holes, pageNum)
+		}
+	}
+
+	// the last pages in use go to the first holes
+	moves := make(map[uint32]uint32)
+	for _, pageNum := range slices.Backward(used) {
+		if moved == limit || len(holes) == 0 || holes[0] > pageNum {
+			break
+		}
+		moves[pageNum] = holes[0]
+		holes = holes[1:]
+		moved++
+	}
+	// a snapshot would go on reading the moved pages where they were
+	if len(moves) > 0 {
+		pager.mu.Lock()
+		invalidateSnapshots(pager)
+		pager.mu.Unlock()
+	}
+	for from, to := range moves {
+		source, err := getPage(pager, from)
+		if err != nil {
+			return 0, 0, err
+		}
+		destination, err := getPageForWrite(pager, to)
+		if err != nil {
+			return 0, 0, err
+		}
+		copy(destination, source)
+	}
+	newPlace := func(pageNum uint32) uint32 {
+		if to, ok := moves[pageNum]; ok {
+			return to
+		}
+		return pageNum
+	}
+	for from, to := range moves {
+		for _, ref := range refs[from] {
+			page, err := getPageForWrite(pager, newPlace(ref.page))
+			if err != nil {
+				return 0, 0, err
+			}
+			binary.LittleEndian.PutUint32(page[ref.offset:], to)
+		}
+	}
+	table.rootPageNum = newPlace(table.rootPageNum)
+	for _, tree := range table.tables {
+		tree.rootPageNum = newPlace(tree.rootPageNum)
+	}
+
+	numPages := uint32(1)
+	for _, pageNum := range used {
+		numPages = max(numPages, newPlace(pageNum)+1)
+	}
+	reclaimed = pager.numPages - numPages
+	pager.numPages = numPages
+
+	// the holes left are linked into a new freelist, lowest first
+	holes = slices.DeleteFunc(holes, func(pageNum uint32) bool { return pageNum >= numPages })
+	pager.freePage, pager.numFreePages = 0, 0
+	for _, pageNum := range slices.Backward(holes) {
+		if err := pagerFreePage(pager, pageNum); err != nil {
+			return 0, 0, err
+		}
+	}
+	return moved, reclaimed, nil
+}
+
+// collectPageRefs walks the trees and returns, for every page they use,
+// where the number of the page is stored. The root of the built-in table is
+// referenced from the header and the catalog from nowhere, so neither has
+// entries of its own; the roots of the other tables are referenced from the
+// catalog.
+func collectPageRefs(table *Table) (map[uint32][]pageRef, error) {
+	pager := table.pager
+	refs := map[uint32][]pageRef{table.rootPageNum: nil}
+	addRef := func(pageNum uint32, ref pageRef) error {
+		if _, seen := refs[pageNum]; seen || pageNum == 0 || pageNum >= pager.numPages {
+			return fmt.Errorf("%w: page %d is referenced by page %d but cannot be", ErrCorrupt, pageNum, ref.page)
+		}
+		refs[pageNum] = []pageRef{ref}
+		return nil
+	}
+
+	// only the built-in table has rows with overflow pages, and page 0 as
+	// its first leaf
+	var walk func(pageNum uint32, builtIn bool) error
+	walk = func(pageNum uint32, builtIn bool) error {
+		node, err := getPage(pager, pageNum)
+		if err != nil {
+			return err
+		}
+		switch nodeType(node) {
+		case NODE_INTERNAL:
+			numKeys := internalNodeNumKeys(node)
+			for childNum := range numKeys + 1 {
+				offset := internalNodeCellOffset(childNum)
+				if childNum == numKeys {
+					offset = INTERNAL_NODE_RIGHT_CHILD_OFFSET
+				}
+				child := internalNodeChild(node, childNum)
+				// page 0 is the first leaf and appears under the tree
+				// only once, as the leftmost child
+				if child == 0 && builtIn {
+					refs[0] = append(refs[0], pageRef{pageNum, offset})
+				} else if err := addRef(child, pageRef{pageNum, offset}); err != nil {
+					return err
+				}
+				if err := walk(child, builtIn); err != nil {
+					return err
+				}
+			}
+		case NODE_LEAF:
+			if !builtIn {
+				break // records never overflow
+			}
+			for cellNum := range leafNodeNumCells(node) {
+				value := leafNodeValue(node, cellNum)
+				var row Row
+				overflow, err := deserializeRow(value, &row)
+				if err != nil {
+					return err
+				}
+				if overflow.pageNum == 0 {
+					continue
+				}
+				offset := leafNodeCellOffset(node, cellNum) + LEAF_NODE_CELL_HEADER_SIZE + uint32(len(value)) - ROW_OVERFLOW_REF_SIZE
+				if err := walkOverflowRefs(pager, overflow.pageNum, pageRef{pageNum, offset}, addRef); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%w: page %d in the tree is neither a leaf nor an internal node", ErrCorrupt, pageNum)
+		}
+		return nil
+	}
+
+	// every leaf but the last is referenced by the one before it as well
+	chainLeaves := func(pageNum uint32) error {
+		for leaves := uint32(0); ; leaves++ {
+			if leaves == pager.numPages {
+				return fmt.Errorf("%w: the chain of leaves has a cycle", ErrCorrupt)
+			}
+			node, err := getPage(pager, pageNum)
+			if err != nil {
+				return err
+			}
+			next := leafNodeNextLeaf(node)
+			if next == 0 {
+				return nil
+			}
+			if _, ok := refs[next]; !ok {
+				return fmt.Errorf("%w: leaf %d links to page %d outside the tree", ErrCorrupt, pageNum, next)
+			}
+			refs[next] = append(refs[next], pageRef{pageNum, LEAF_NODE_NEXT_LEAF_OFFSET})
+			pageNum = next
+		}
+	}
+
+	if err := walk(table.rootPageNum, true); err != nil {
+		return nil, err
+	}
+	if err := chainLeaves(0); err != nil {
+		return nil, err
+	}
+
+	entries, exists, err := readCatalog(pager)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if _, seen := refs[CATALOG_PAGE]; seen {
+			return nil, fmt.Errorf("%w: the catalog, page %d, is in the tree", ErrCorrupt, CATALOG_PAGE)
+		}
+		refs[CATALOG_PAGE] = nil
+	}
+	for _, entry := range entries {
+		if err := addRef(entry.rootPageNum, pageRef{CATALOG_PAGE, entry.rootOffset}); err != nil {
+			return nil, err
+		}
+		if err := walk(entry.rootPageNum, false); err != nil {
+			return nil, err
+		}
+		_, firstLeaf, err := findLeaf(&Table{pager: pager, rootPageNum: entry.rootPageNum}, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := chainLeaves(firstLeaf); err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// walkOverflowRefs adds the references to the overflow chain starting at
+// pageNum, which ref points to.
+func walkOverflowRefs(pager *Pager, pageNum uint32, ref pageRef, addRef func(uint32, pageRef) error) error {
+	for pageNum != 0 {
+		if err := addRef(pageNum, ref); err != nil {
+			return err
+		}
+		page, err := getPage(pager, pageNum)
+		if err != nil {
+			return err
+		}
+		if nodeType(page) != NODE_OVERFLOW {
+			return fmt.Errorf("%w: page %d is not an overflow page", ErrCorrupt, pageNum)
+		}
+		ref = pageRef{pageNum, OVERFLOW_NODE_NEXT_PAGE_OFFSET}
+		pageNum = binary.LittleEndian.Uint32(page[OVERFLOW_NODE_NEXT_PAGE_OFFSET:])
+	}
+	return nil
+}
+
+// compactOnClose compacts the whole file if enough of it is free. Callers
+// hold table.mu for writing.
+func compactOnClose(table *Table) error {
+	pager := table.pager
+	if pager.file == nil || table.readOnly || table.compactOnClose == 0 ||
+		uint64(pager.numFreePages)*100 <= uint64(pager.numPages)*uint64(table.compactOnClose) {
+		return nil
+	}
+	_, _, err := compactPages(table, math.MaxUint32)
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fragmentedTable fills a table with small pages, some rows with emails on
+// overflow pages, then deletes most of the early rows so the free pages are
+// at the front and the pages in use at the end.
+func fragmentedTable(t *testing.T, fileName string, options OpenOptions) (*Table, []Row) {
+	t.Helper()
+	options.PageSize = smallPageSize
+	table, err := dbOpenWith(fileName, options)
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	var input strings.Builder
+	input.WriteString(insertRows(1, 150))
+	for id := 160; id <= 170; id++ {
+		fmt.Fprintf(&input, "insert %d user%d %s@example.com\n", id, id, strings.Repeat("x", COLUMN_EMAIL_SIZE*2))
+	}
+	input.WriteString(deleteRows(10, 140))
+	runREPL(strings.NewReader(input.String()), io.Discard, table)
+	if table.pager.numFreePages == 0 {
+		t.Fatalf("no pages on the freelist after deleting most rows")
+	}
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	return table, rows
+}
+
+func checkRows(t *testing.T, table *Table, want []Row) {
+	t.Helper()
+	got, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	checkTree(t, table)
+}
+
+func TestCompact_MovesPagesIntoTheHoles(t *testing.T) {
+	fileName := tempDBFile(t)
+	// the small cache makes the moves write pages back as they go
+	table, want := fragmentedTable(t, fileName, OpenOptions{MaxCachedPages: 2})
+	numPages, numFree := table.pager.numPages, table.pager.numFreePages
+
+	// one page at a time leaves a table that holds together
+	moved, reclaimed, err := dbCompact(table, 1)
+	if err != nil {
+		t.Fatalf("dbCompact: %v", err)
+	}
+	if moved != 1 || reclaimed == 0 {
+		t.Errorf("dbCompact(1) moved %d pages and reclaimed %d", moved, reclaimed)
+	}
+	checkRows(t, table, want)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("+compact\n"), &output, table, true)
+	if !strings.HasPrefix(output.String(), "Moved ") {
+		t.Fatalf("+compact printed %q", output.String())
+	}
+	if table.pager.numFreePages != 0 || table.pager.numPages != numPages-numFree {
+		t.Errorf("after +compact numPages = %d and free pages = %d, want %d and 0", table.pager.numPages, table.pager.numFreePages, numPages-numFree)
+	}
+	checkRows(t, table, want)
+
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	checkRows(t, table, want)
+	if table.pager.fileLength != HEADER_SIZE+int64(numPages-numFree)*smallPageSize {
+		t.Errorf("file is %d bytes, want %d pages", table.pager.fileLength, numPages-numFree)
+	}
+
+	// the tree keeps growing normally afterwards
+	runREPL(strings.NewReader(insertRows(10, 140)), io.Discard, table)
+	if _, stored := checkTree(t, table); stored != uint32(len(want)+131) {
+		t.Errorf("tree holds %d rows, want %d", stored, len(want)+131)
+	}
+}
+
+func TestCompact_OnClose(t *testing.T) {
+	for _, threshold := range []uint32{0, 5} {
+		fileName := tempDBFile(t)
+		table, want := fragmentedTable(t, fileName, OpenOptions{CompactOnClose: threshold})
+		numFree := table.pager.numFreePages
+		if err := dbClose(table); err != nil {
+			t.Fatalf("dbClose: %v", err)
+		}
+
+		table = mustOpen(t, fileName)
+		checkRows(t, table, want)
+		if compacted := table.pager.numFreePages == 0; compacted != (threshold != 0) {
+			t.Errorf("CompactOnClose %d left %d of %d free pages", threshold, table.pager.numFreePages, numFree)
+		}
+		dbClose(table)
+	}
+
+	if _, err := dbOpenWith(tempDBFile(t), OpenOptions{CompactOnClose: 101}); err == nil {
+		t.Errorf("dbOpenWith with a threshold over 100%% succeeded")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Starting with COMPRESSION_FORMAT_VERSION the header says how the pages of
+// the file are stored. With COMPRESSION_NONE, page n is at HEADER_SIZE +
+// n*pageSize as always.
+//
+// With COMPRESSION_FLATE, each page is deflated and stored in a slot of its
+// own, anywhere after the header; a page that does not shrink is stored as
+// it is, which its length of a whole page tells apart. The page directory
+// lists the slot of every page, HEADER_DIRECTORY_PAGES entries of an offset,
+// the length of the stored page and the room in its slot; the header holds
+// its offset. A page rewritten in place keeps its slot while it fits and
+// moves to a new one at the end of the file when it does not; the directory
+// is rewritten the same way by every flush. +vacuum packs the slots again.
+// Only the pager's own reads and writes understand the layout, so compressed
+// files cannot be mapped or used with a write-ahead log or rollback journal.
+const (
+	COMPRESSION_FORMAT_VERSION = 7
+
+	COMPRESSION_NONE  uint32 = 0
+	COMPRESSION_FLATE uint32 = 1
+
+	DIRECTORY_ENTRY_OFFSET_OFFSET   = 0
+	DIRECTORY_ENTRY_LENGTH_OFFSET   = DIRECTORY_ENTRY_OFFSET_OFFSET + 8
+	DIRECTORY_ENTRY_CAPACITY_OFFSET = DIRECTORY_ENTRY_LENGTH_OFFSET + 4
+	DIRECTORY_ENTRY_SIZE            = DIRECTORY_ENTRY_CAPACITY_OFFSET + 4
+
+	// slots are rounded up to a multiple of this, so a page that grows a
+	// little can stay where it is
+	COMPRESSED_SLOT_ALIGNMENT = 64
+)
+
+var compressionNames = [...]string{COMPRESSION_NONE: "none", COMPRESSION_FLATE: "flate"}
+
+// pageSlot locates a stored page, or the directory, in a compressed file. A
+// zero length means the page was never written.
+type pageSlot struct {
+	offset   int64
+	length   uint32
+	capacity uint32
+}
+
+func (slot pageSlot) end() int64 {
+	return slot.offset + int64(slot.capacity)
+}
+
+// compressPage deflates page, or returns it as it is if that does not make
+// it any shorter.
+func compressPage(page Page) []byte {
+	var compressed bytes.Buffer
+	writer, _ := flate.NewWriter(&compressed, flate.BestSpeed) // only fails on a bad level
+	writer.Write(page)
+	writer.Close()
+	if compressed.Len() >= len(page) {
+		return page
+	}
+	return compressed.Bytes()
+}
+
+// decompressPage fills page from what compressPage stored for it.
+func decompressPage(stored []byte, page Page) error {
+	if len(stored) == len(page) {
+		copy(page, stored)
+		return nil
+	}
+	reader := flate.NewReader(bytes.NewReader(stored))
+	defer reader.Close()
+	if _, err := io.ReadFull(reader, page); err != nil {
+		return err
+	}
+	if n, _ := reader.Read(make([]byte, 1)); n != 0 {
+		return errors.New("inflates to more than a page")
+	}
+	return nil
+}
+
+// allocateSlot reserves room for length bytes at the end of a compressed
+// file.
+func allocateSlot(pager *Pager, length int) pageSlot {
+	capacity := (length + COMPRESSED_SLOT_ALIGNMENT - 1) / COMPRESSED_SLOT_ALIGNMENT * COMPRESSED_SLOT_ALIGNMENT
+	slot := pageSlot{offset: pager.dataEnd, capacity: uint32(capacity)}
+	pager.dataEnd += int64(capacity)
+	return slot
+}
+
+// writeSlot stores data in slot, moving it to a new slot at the end of the
+// file if it does not fit.
+func writeSlot(pager *Pager, slot pageSlot, data []byte) (pageSlot, error) {
+	if len(data) > int(slot.capacity) {
+		slot = allocateSlot(pager, len(data))
+	}
+	slot.length = uint32(len(data))
+	_, err := pager.file.WriteAt(data, slot.offset)
+	if errors.Is(err, syscall.ENOSPC) {
+		return slot, fmt.Errorf("write failed: %w: %w", ErrTableFull, err)
+	}
+	if err != nil {
+		return slot, fmt.Errorf("write failed: %w", err)
+	}
+	pager.fileLength = max(pager.fileLength, slot.offset+int64(slot.length))
+	return slot, nil
+}
+
+// compressedWritePage stores page as page pageNum of a compressed file.
+func compressedWritePage(pager *Pager, pageNum uint32, page Page) error {
+	if pageNum >= uint32(len(pager.directory)) {
+		pager.directory = append(pager.directory, make([]pageSlot, int(pageNum)+1-len(pager.directory))...)
+	}
+	slot, err := writeSlot(pager, pager.directory[pageNum], compressPage(page))
+	if err != nil {
+		return err
+	}
+	pager.directory[pageNum] = slot
+	return nil
+}
+
+// compressedReadPage reads page pageNum of a compressed file, or returns a
+// zeroed page for one never written.
+func compressedReadPage(pager *Pager, pageNum uint32) (Page, error) {
+	page := make(Page, pager.pageSize)
+	if pageNum >= uint32(len(pager.directory)) || pager.directory[pageNum].length == 0 {
+		return page, nil
+	}
+	slot := pager.directory[pageNum]
+	stored := make([]byte, slot.length)
+	if _, err := pager.file.ReadAt(stored, slot.offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: page %d truncated", ErrCorrupt, pageNum)
+		}
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	if err := decompressPage(stored, page); err != nil {
+		return nil, fmt.Errorf("%w: page %d does not decompress: %w", ErrCorrupt, pageNum, err)
+	}
+	if pager.checksums {
+		if err := verifyPageChecksum(pager, pageNum, page); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// compressedFlush finishes flushAll for a compressed file: it writes the
+// directory of the pages still in use and the header, and trims the file
+// after the last slot in use.
+func compressedFlush(table *Table) error {
+	pager := table.pager
+	if uint32(len(pager.directory)) > pager.numPages {
+		pager.directory = pager.directory[:pager.numPages]
+	}
+
+	encoded := make([]byte, len(pager.directory)*DIRECTORY_ENTRY_SIZE)
+	for i, slot := range pager.directory {
+		entry := encoded[i*DIRECTORY_ENTRY_SIZE:]
+		binary.LittleEndian.PutUint64(entry[DIRECTORY_ENTRY_OFFSET_OFFSET:], uint64(slot.offset))
+		binary.LittleEndian.PutUint32(entry[DIRECTORY_ENTRY_LENGTH_OFFSET:], slot.length)
+		binary.LittleEndian.PutUint32(entry[DIRECTORY_ENTRY_CAPACITY_OFFSET:], slot.capacity)
+	}
+	slot, err := writeSlot(pager, pager.directorySlot, encoded)
+	if err != nil {
+		return err
+	}
+	pager.directorySlot = slot
+
+	if err := writeHeader(table); err != nil {
+		return err
+	}
+	dataEnd := max(int64(HEADER_SIZE), pager.directorySlot.end())
+	for _, slot := range pager.directory {
+		dataEnd = max(dataEnd, slot.end())
+	}
+	if err := pager.file.Truncate(dataEnd); err != nil {
+		return fmt.Errorf("truncate failed: %w", err)
+	}
+	pager.dataEnd = dataEnd
+	pager.fileLength = dataEnd
+	return pagerSync(pager)
+}
+
+// loadDirectory reads the page directory of a compressed file from where
+// its header says it is.
+func loadDirectory(pager *Pager, header fileHeader) error {
+	pager.dataEnd = max(int64(HEADER_SIZE), pager.fileLength)
+	if header.directoryPages == 0 {
+		return nil
+	}
+	length := int64(header.directoryPages) * DIRECTORY_ENTRY_SIZE
+	if header.directoryOffset < HEADER_SIZE || header.directoryOffset+length > pager.fileLength {
+		return fmt.Errorf("%w: page directory lies outside the file", ErrCorrupt)
+	}
+	encoded := make([]byte, length)
+	if _, err := pager.file.ReadAt(encoded, header.directoryOffset); err != nil {
+		return fmt.Errorf("error reading page directory: %w", err)
+	}
+	pager.directorySlot = pageSlot{offset: header.directoryOffset, length: uint32(length), capacity: uint32(length)}
+	pager.directory = make([]pageSlot, header.directoryPages)
+	for i := range pager.directory {
+		entry := encoded[i*DIRECTORY_ENTRY_SIZE:]
+		slot := pageSlot{
+			offset:   int64(binary.LittleEndian.Uint64(entry[DIRECTORY_ENTRY_OFFSET_OFFSET:])),
+			length:   binary.LittleEndian.Uint32(entry[DIRECTORY_ENTRY_LENGTH_OFFSET:]),
+			capacity: binary.LittleEndian.Uint32(entry[DIRECTORY_ENTRY_CAPACITY_OFFSET:]),
+		}
+		if slot.length > slot.capacity || slot.length > pager.pageSize || (slot.length != 0 && (slot.offset < HEADER_SIZE || slot.end() > pager.fileLength)) {
+			return fmt.Errorf("%w: page %d has a bad directory entry", ErrCorrupt, i)
+		}
+		pager.directory[i] = slot
+	}
+	return nil
+}
+
+// writeCompressedImage writes the table to file in the compressed layout,
+// with every slot packed after the one before. file is not synced.
+func writeCompressedImage(table *Table, file *os.File) error {
+	pager := newPager(file, 0)
+	pager.pageSize = table.pager.pageSize
+	pager.version = table.pager.version
+	pager.checksums = table.pager.checksums
+	pager.numPages = table.pager.numPages
+	pager.freePage = table.pager.freePage
+	pager.numFreePages = table.pager.numFreePages
+	pager.compression = COMPRESSION_FLATE
+	pager.syncMode = SYNC_OFF
+	pager.dataEnd = HEADER_SIZE
+	image := &Table{pager: pager, numRows: table.numRows, rootPageNum: table.rootPageNum}
+
+	for pageNum := range table.pager.numPages {
+		page, err := getPage(table.pager, pageNum)
+		if err != nil {
+			return err
+		}
+		if pager.checksums {
+			putPageChecksum(pager, page)
+		}
+		if err := compressedWritePage(pager, pageNum, page); err != nil {
+			return err
+		}
+	}
+	return compressedFlush(image)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompression_RoundTrip(t *testing.T) {
+	input := insertRows(1, rowsPerLeaf*4) + deleteRows(50, 150) +
+		"update 3 set email = " + strings.Repeat("x", COLUMN_EMAIL_SIZE*3) + "@example.com\n"
+
+	plainName := tempDBFile(t)
+	plain := mustOpen(t, plainName)
+	runREPL(strings.NewReader(input), io.Discard, plain)
+	want, err := plain.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if err := dbClose(plain); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	// the small cache writes pages back, and rewrites them, before close
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{Compress: true, MaxCachedPages: 2})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	runREPL(strings.NewReader(input), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	plainInfo, err := os.Stat(plainName)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size() >= plainInfo.Size()/2 {
+		t.Errorf("compressed file is %d bytes, want well under the %d of the uncompressed one", info.Size(), plainInfo.Size())
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.pager.compression != COMPRESSION_FLATE {
+		t.Fatalf("reopened table uses compression %d", table.pager.compression)
+	}
+	got, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	checkTree(t, table)
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("+stats\n"), &output, table)
+	if !strings.Contains(output.String(), "compression = flate") {
+		t.Errorf("+stats does not report the compression:\n%s", output.String())
+	}
+}
+
+func TestCompression_StoresPagesThatDoNotShrinkAsTheyAre(t *testing.T) {
+	page := make(Page, DEFAULT_PAGE_SIZE)
+	if stored := compressPage(page); len(stored) >= len(page)/10 {
+		t.Errorf("an empty page compresses to %d bytes", len(stored))
+	}
+
+	rand.New(rand.NewSource(1)).Read(page)
+	stored := compressPage(page)
+	if len(stored) != len(page) {
+		t.Fatalf("a random page is stored in %d bytes, want it as it is", len(stored))
+	}
+	got := make(Page, len(page))
+	if err := decompressPage(stored, got); err != nil || !bytes.Equal(got, page) {
+		t.Errorf("decompressPage = %v, or the page changed", err)
+	}
+}
+
+func TestCompression_VacuumPacksTheSlots(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+
+	// rows with random emails make the pages grow out of their slots
+	random := rand.New(rand.NewSource(1))
+	var input strings.Builder
+	for id := 1; id <= rowsPerLeaf*3; id++ {
+		input.WriteString(insertRows(id, id))
+		if id%10 == 0 {
+			input.WriteString("+flush\n")
+		}
+	}
+	for id := 1; id <= rowsPerLeaf*3; id += 2 {
+		email := make([]byte, 40)
+		for i := range email {
+			email[i] = 'a' + byte(random.Intn(26))
+		}
+		fmt.Fprintf(&input, "update %d set email = %s\n", id, email)
+	}
+	input.WriteString("+flush\n")
+	runREPL(strings.NewReader(input.String()), io.Discard, table)
+	before := table.pager.fileLength
+
+	if _, err := dbVacuum(table); err != nil {
+		t.Fatalf("dbVacuum: %v", err)
+	}
+	if table.pager.compression != COMPRESSION_FLATE {
+		t.Fatalf("vacuum dropped the compression")
+	}
+	if table.pager.fileLength >= before {
+		t.Errorf("file is %d bytes after vacuum, want less than %d", table.pager.fileLength, before)
+	}
+	if _, stored := checkTree(t, table); stored != uint32(rowsPerLeaf*3) {
+		t.Errorf("tree holds %d rows, want %d", stored, rowsPerLeaf*3)
+	}
+}
+
+func TestCompression_RejectsWhatItCannotDo(t *testing.T) {
+	plainName := tempDBFile(t)
+	dbClose(mustOpen(t, plainName))
+	if _, err := dbOpenWith(plainName, OpenOptions{Compress: true}); err == nil {
+		t.Errorf("dbOpenWith compressing an existing uncompressed file succeeded")
+	}
+
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	runREPL(strings.NewReader(insertRows(1, 10)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	for _, options := range []OpenOptions{{WAL: true}, {Journal: true}, {ReadOnly: true, Mmap: true}} {
+		if table, err := dbOpenWith(fileName, options); err == nil {
+			dbClose(table)
+			t.Errorf("dbOpenWith(%+v) on a compressed file succeeded", options)
+		}
+	}
+
+	// a directory entry pointing past the end of the file
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	directory := binary.LittleEndian.Uint64(contents[HEADER_DIRECTORY_OFFSET_OFFSET:])
+	binary.LittleEndian.PutUint64(contents[directory+DIRECTORY_ENTRY_OFFSET_OFFSET:], uint64(len(contents)))
+	if err := os.WriteFile(fileName, contents, 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := dbOpen(fileName); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("dbOpen with a bad directory entry: err = %v, want %v", err, ErrCorrupt)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// exportCSV writes every row to path as id,username,email records and
+// returns the number of rows written.
+func exportCSV(table *Table, path string) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	csvWriter := csv.NewWriter(file)
+	exported := 0
+	var row Row
+	cursor := tableStart(table)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			return exported, err
+		}
+		if err := readRow(table.pager, slot, &row); err != nil {
+			return exported, err
+		}
+		record := []string{strconv.FormatUint(uint64(row.id), 10), row.username, row.email}
+		if err := csvWriter.Write(record); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return exported, err
+	}
+	return exported, file.Close()
+}
+
+// importCSV inserts the id,username,email records in path. Records that are
+// malformed or fail the same checks as insert are reported with their line
+// number and skipped; only I/O errors abort the import.
+func importCSV(table *Table, path string, writer *bufio.Writer) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	csvReader := csv.NewReader(file)
+	csvReader.FieldsPerRecord = -1 // field count is checked per record below
+
+	imported := 0
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			fmt.Fprintf(writer, "line %d: %v, skipped\n", parseErr.StartLine, parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return imported, err
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		if len(record) != 3 {
+			fmt.Fprintf(writer, "line %d: expected 3 fields, got %d, skipped\n", line, len(record))
+			continue
+		}
+		id, err := strconv.ParseUint(record[0], 10, 32)
+		if err != nil {
+			fmt.Fprintf(writer, "line %d: invalid id %q, skipped\n", line, record[0])
+			continue
+		}
+
+		statement := InsertStmt{Row: Row{id: uint32(id), username: record[1], email: record[2]}}
+		if validateRow(&statement.Row) == PREPARE_STRING_TOO_LONG {
+			fmt.Fprintf(writer, "line %d: string is too long, skipped\n", line)
+			continue
+		}
+
+		switch executeInsert(&statement, table, writer) {
+		case EXECUTE_SUCCESS:
+			imported++
+		case EXECUTE_DUPLICATE_KEY:
+			fmt.Fprintf(writer, "line %d: duplicate key %d, skipped\n", line, id)
+		case EXECUTE_TABLE_FULL:
+			return imported, fmt.Errorf("line %d: table full", line)
+		case EXECUTE_READ_ONLY:
+			return imported, ErrReadOnly
+		default:
+			return imported, fmt.Errorf("line %d: insert failed", line)
+		}
+	}
+	return imported, nil
+}
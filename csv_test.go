@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSV_RoundTrip(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "users.csv")
+	input := insertRows(1, rowsPerLeaf+2) + "insert 1000 last,first \"quoted\"@example.com\n"
+
+	source := mustOpen(t, tempDBFile(t))
+	defer dbClose(source)
+	var sourceOutput bytes.Buffer
+	runREPL(strings.NewReader(input+"+export "+csvPath+"\nselect\n"), &sourceOutput, source)
+	if !strings.Contains(sourceOutput.String(), fmt.Sprintf("Exported %d rows.", rowsPerLeaf+3)) {
+		t.Fatalf("export summary missing\ngot:\n%s", sourceOutput.String())
+	}
+
+	destination := mustOpen(t, tempDBFile(t))
+	defer dbClose(destination)
+	var destinationOutput bytes.Buffer
+	runREPL(strings.NewReader("+import "+csvPath+"\nselect\n"), &destinationOutput, destination)
+	if !strings.Contains(destinationOutput.String(), fmt.Sprintf("Imported %d rows.", rowsPerLeaf+3)) {
+		t.Fatalf("import summary missing\ngot:\n%s", destinationOutput.String())
+	}
+
+	if got, want := selectOutput(destinationOutput.String()), selectOutput(sourceOutput.String()); got != want {
+		t.Errorf("imported rows differ from the exported table\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCSV_ImportSkipsBadLines(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "users.csv")
+	contents := strings.Join([]string{
+		"1,user1,person1@example.com",
+		"2,missing-email",
+		"x,user3,person3@example.com",
+		"1,dup,dup@example.com",
+		"4," + strings.Repeat("a", COLUMN_USERNAME_SIZE+1) + ",long@example.com",
+		`5,"unterminated,person5@example.com`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(csvPath, []byte(contents), 0666); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	var output bytes.Buffer
+	runREPL(strings.NewReader("+import "+csvPath+"\n"), &output, table)
+	got := output.String()
+
+	for _, want := range []string{
+		"line 2: expected 3 fields, got 2, skipped",
+		`line 3: invalid id "x", skipped`,
+		"line 4: duplicate key 1, skipped",
+		"line 5: string is too long, skipped",
+		"line 6: ",
+		"Imported 1 rows.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, got)
+		}
+	}
+	if table.numRows != 1 {
+		t.Errorf("table.numRows = %d, want 1", table.numRows)
+	}
+}
+
+// selectOutput returns the row lines printed by a REPL session.
+func selectOutput(output string) string {
+	var rows []string
+	for line := range strings.Lines(strings.ReplaceAll(output, "simpledbgo > ", "")) {
+		if strings.HasPrefix(line, "(") {
+			rows = append(rows, line)
+		}
+	}
+	return strings.Join(rows, "")
+}
@@ -0,0 +1,151 @@
+package main
+
+import "math"
+
+// Cursor points at a cell in a leaf node. Executors walk the table with a
+// cursor instead of reading pages themselves, visiting the rows in id order.
+//
+// A cursor from tableStart or tableSeek pins the leaf it is on and keeps the
+// version of it that it fetched, so the slot cursorValue returns stays the
+// same while the row is read, even if reading its overflow pages evicts
+// others or a writer changes the leaf; see pagerCopyOnWrite. The pin moves
+// with the cursor and is released at the end of the table; scans that stop
+// before then call cursorClose. Cursors for a single lookup pin nothing and
+// fetch the leaf anew for every value.
+type Cursor struct {
+	table      *Table
+	pageNum    uint32
+	cellNum    uint32
+	endOfTable bool   // one past the last row; nothing to read here
+	numCells   uint32 // cells in the current leaf, saving cursorAdvance a page fetch per row
+	pinned     bool   // the cursor holds a pin on pageNum; see cursorClose
+	page       Page   // the version of the pinned leaf the cursor reads
+
+	// err is set when moving the cursor failed to read a page, and is
+	// returned by the next cursorValue, so scan loops need only one error
+	// check.
+	err error
+}
+
+func tableStart(table *Table) *Cursor {
+	cursor := &Cursor{table: table}
+	_, cursor.pageNum, cursor.err = findLeaf(table, 0)
+	if cursor.err == nil {
+		cursorSkipEmpty(cursor)
+	}
+	return cursor
+}
+
+// tableSeek returns a cursor at the first row whose id is at least id, so a
+// range scan starts there instead of walking every leaf before it.
+func tableSeek(table *Table, id uint32) *Cursor {
+	cursor, _, err := tableFind(table, id)
+	if err != nil {
+		return &Cursor{table: table, endOfTable: true, err: err}
+	}
+	// the row may be past the last cell of its leaf, or the leaf may be empty
+	cursorSkipEmpty(cursor)
+	return cursor
+}
+
+// tableEnd returns a cursor one past the last row, where a row with a larger
+// id than any stored would go.
+func tableEnd(table *Table) *Cursor {
+	cursor := &Cursor{table: table, endOfTable: true}
+	_, cursor.pageNum, cursor.err = findLeaf(table, math.MaxUint32)
+	for cursor.err == nil {
+		// empty leaves may follow the one holding the largest keys
+		var node Page
+		node, cursor.err = getPage(table.pager, cursor.pageNum)
+		if cursor.err != nil {
+			break
+		}
+		next := leafNodeNextLeaf(node)
+		if next == 0 {
+			cursor.cellNum = leafNodeNumCells(node)
+			return cursor
+		}
+		cursor.pageNum = next
+	}
+	cursor.endOfTable = false
+	return cursor
+}
+
+// cursorValue returns the serialized row under the cursor.
+func cursorValue(cursor *Cursor) ([]byte, error) {
+	if cursor.err != nil {
+		return nil, cursor.err
+	}
+	if cursor.page != nil {
+		return leafNodeValue(cursor.page, cursor.cellNum), nil
+	}
+	page, err := getPage(cursor.table.pager, cursor.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	return leafNodeValue(page, cursor.cellNum), nil
+}
+
+// cursorValueForWrite returns the row under the cursor for modification.
+func cursorValueForWrite(cursor *Cursor) ([]byte, error) {
+	if cursor.err != nil {
+		return nil, cursor.err
+	}
+	page, err := getPageForWrite(cursor.table.pager, cursor.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	return leafNodeValue(page, cursor.cellNum), nil
+}
+
+func cursorAdvance(cursor *Cursor) {
+	if cursor.err != nil {
+		cursor.endOfTable = true
+		cursorClose(cursor)
+		return
+	}
+	cursor.cellNum++
+	if cursor.cellNum < cursor.numCells {
+		return
+	}
+	cursorSkipEmpty(cursor)
+}
+
+// cursorSkipEmpty moves a cursor that is past the last cell of its leaf on to
+// the first cell of the next leaf that has one, or marks the end of the
+// table.
+func cursorSkipEmpty(cursor *Cursor) {
+	for {
+		if !cursor.pinned {
+			pagerPin(cursor.table.pager, cursor.pageNum)
+			cursor.pinned = true
+		}
+		node, err := getPage(cursor.table.pager, cursor.pageNum)
+		if err != nil {
+			cursor.err = err
+			return
+		}
+		cursor.page = node
+		cursor.numCells = leafNodeNumCells(node)
+		if cursor.cellNum < cursor.numCells {
+			return
+		}
+		next := leafNodeNextLeaf(node)
+		if next == 0 {
+			cursor.endOfTable = true
+			cursorClose(cursor)
+			return
+		}
+		cursorClose(cursor)
+		cursor.pageNum, cursor.cellNum = next, 0
+	}
+}
+
+// cursorClose releases the leaf the cursor has pinned, if any. It is safe to
+// call more than once.
+func cursorClose(cursor *Cursor) {
+	if cursor.pinned {
+		pagerUnpin(cursor.table.pager, cursor.pageNum)
+		cursor.pinned, cursor.page = false, nil
+	}
+}
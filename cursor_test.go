@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCursor_EmptyTable(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	if cursor := tableStart(table); !cursor.endOfTable {
+		t.Errorf("tableStart on an empty table: endOfTable = false, want true")
+	}
+	if cursor := tableEnd(table); !cursor.endOfTable || cursor.pageNum != 0 || cursor.cellNum != 0 {
+		t.Errorf("tableEnd on an empty table = {pageNum: %d, cellNum: %d, endOfTable: %v}, want {0, 0, true}", cursor.pageNum, cursor.cellNum, cursor.endOfTable)
+	}
+}
+
+func TestCursor_AdvancesAcrossLeafBoundary(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	numRows := rowsPerLeaf + 2
+	runREPL(strings.NewReader(insertRows(1, numRows)), io.Discard, table)
+
+	cursor := tableStart(table)
+	var row Row
+	for want := 1; want <= numRows; want++ {
+		if cursor.endOfTable {
+			t.Fatalf("cursor reached the end after %d rows, want %d", want-1, numRows)
+		}
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			t.Fatalf("cursorValue at page %d cell %d: %v", cursor.pageNum, cursor.cellNum, err)
+		}
+		if err := readRow(table.pager, slot, &row); err != nil {
+			t.Fatalf("readRow: %v", err)
+		}
+		if row.id != uint32(want) {
+			t.Errorf("page %d cell %d: id = %d, want %d", cursor.pageNum, cursor.cellNum, row.id, want)
+		}
+		cursorAdvance(cursor)
+	}
+	if !cursor.endOfTable {
+		t.Errorf("cursor not at end after reading every row (page %d, cell %d)", cursor.pageNum, cursor.cellNum)
+	}
+
+	end := tableEnd(table)
+	if end.pageNum != cursor.pageNum || end.cellNum != cursor.cellNum || !end.endOfTable {
+		t.Errorf("tableEnd = {pageNum: %d, cellNum: %d, endOfTable: %v}, want {%d, %d, true}", end.pageNum, end.cellNum, end.endOfTable, cursor.pageNum, cursor.cellNum)
+	}
+	if end.pageNum == 0 {
+		t.Errorf("%d rows fit in the first leaf, want them to span two", numRows)
+	}
+}
+
+func TestCursor_SkipsEmptyLeaves(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	// empty the first leaf entirely, leaving the second one to hold the rows
+	var input strings.Builder
+	input.WriteString(insertRows(1, rowsPerLeaf+2))
+	for id := 1; id <= rowsPerLeaf; id++ {
+		fmt.Fprintf(&input, "delete %d\n", id)
+	}
+	runREPL(strings.NewReader(input.String()), io.Discard, table)
+
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(rows) != 2 || rows[0].id != uint32(rowsPerLeaf+1) || rows[1].id != uint32(rowsPerLeaf+2) {
+		t.Errorf("SelectAll = %+v, want rows %d and %d", rows, rowsPerLeaf+1, rowsPerLeaf+2)
+	}
+}
+
+func TestCursor_SeekStartsAtFirstIDInRange(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	// even ids only, across several leaves
+	var input strings.Builder
+	for id := 2; id <= rowsPerLeaf*6; id += 2 {
+		fmt.Fprintf(&input, "insert %d user%d person%d@example.com\n", id, id, id)
+	}
+	runREPL(strings.NewReader(input.String()), io.Discard, table)
+
+	tests := []struct {
+		seek int
+		want int
+	}{
+		{seek: 0, want: 2},
+		{seek: 2, want: 2},
+		{seek: 3, want: 4},
+		{seek: rowsPerLeaf*4 + 1, want: rowsPerLeaf*4 + 2},
+		{seek: rowsPerLeaf * 6, want: rowsPerLeaf * 6},
+	}
+	for _, tt := range tests {
+		cursor := tableSeek(table, uint32(tt.seek))
+		if cursor.endOfTable {
+			t.Errorf("tableSeek(%d) is at the end, want id %d", tt.seek, tt.want)
+			continue
+		}
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			t.Fatalf("cursorValue: %v", err)
+		}
+		if got := rowID(slot); got != uint32(tt.want) {
+			t.Errorf("tableSeek(%d) at id %d, want %d", tt.seek, got, tt.want)
+		}
+		cursorClose(cursor)
+	}
+
+	if cursor := tableSeek(table, uint32(rowsPerLeaf*6+1)); !cursor.endOfTable {
+		t.Errorf("tableSeek past the largest id: endOfTable = false, want true")
+	}
+}
+
+func TestCursor_PinsTheLeafItIsOn(t *testing.T) {
+	table, err := dbOpenWith(tempDBFile(t), OpenOptions{MaxCachedPages: 2})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*4)), io.Discard, table)
+
+	cursor := tableStart(table)
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		t.Fatalf("cursorValue: %v", err)
+	}
+	// reading every other page through the small cache leaves the pinned
+	// leaf where it was
+	for pageNum := range table.pager.numPages {
+		if _, err := getPage(table.pager, pageNum); err != nil {
+			t.Fatalf("getPage(%d): %v", pageNum, err)
+		}
+	}
+	if table.pager.pages[cursor.pageNum] == nil {
+		t.Fatalf("leaf %d under the cursor was evicted", cursor.pageNum)
+	}
+	if got := rowID(slot); got != 1 {
+		t.Errorf("slot holds id %d after the other pages were read, want 1", got)
+	}
+
+	// the pin follows the cursor and is gone at the end of the table
+	for !cursor.endOfTable {
+		if len(table.pager.pins) != 1 || table.pager.pins[cursor.pageNum] != 1 {
+			t.Fatalf("pins = %v with the cursor on page %d", table.pager.pins, cursor.pageNum)
+		}
+		cursorAdvance(cursor)
+	}
+	if len(table.pager.pins) != 0 {
+		t.Errorf("pins = %v after the scan, want none", table.pager.pins)
+	}
+
+	// a scan that stops early releases its pin when closed
+	if _, err := table.SelectRange(2, 3); err != nil {
+		t.Fatalf("SelectRange: %v", err)
+	}
+	if len(table.pager.pins) != 0 {
+		t.Errorf("pins = %v after SelectRange, want none", table.pager.pins)
+	}
+}
+
+func TestCursor_KeepsItsVersionOfTheLeaf(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 3)), io.Discard, table)
+
+	cursor := tableStart(table)
+	// a writer changing the pinned leaf gets a copy of its own
+	page, err := getPageForWrite(table.pager, cursor.pageNum)
+	if err != nil {
+		t.Fatalf("getPageForWrite: %v", err)
+	}
+	leafNodeValue(page, 0)[0] = 99
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		t.Fatalf("cursorValue: %v", err)
+	}
+	if got := rowID(slot); got != 1 {
+		t.Errorf("cursor reads id %d after the leaf changed, want 1", got)
+	}
+	if cached, _ := getPage(table.pager, cursor.pageNum); rowID(leafNodeValue(cached, 0)) != 99 {
+		t.Errorf("the cache lost the writer's change")
+	}
+
+	// with no reader left the writer changes the page in place
+	cursorClose(cursor)
+	again, err := getPageForWrite(table.pager, 0)
+	if err != nil {
+		t.Fatalf("getPageForWrite: %v", err)
+	}
+	if &again[0] != &page[0] {
+		t.Errorf("an unpinned page was copied for writing")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Starting with ENCRYPTION_FORMAT_VERSION a database can be encrypted with a
+// passphrase, chosen when it is created. The key is derived from the
+// passphrase with PBKDF2 and the random salt the header holds; a zero salt
+// means the file is not encrypted. The header itself stays readable.
+//
+// Every page is sealed with AES-GCM on its way to the file or the write-ahead
+// log and opened again when it is read, so pages in the cache are plain. The
+// last ENCRYPTION_TRAILER_SIZE bytes of a page are kept free for the GCM tag
+// and a nonce drawn afresh for every write; the page number is authenticated
+// along with the page, so pages cannot be swapped around unnoticed. Only the
+// pager's own reads and writes understand the layout, so encrypted files
+// cannot be mapped or compressed.
+const (
+	ENCRYPTION_FORMAT_VERSION = 8
+
+	ENCRYPTION_KEY_SIZE       = 32 // AES-256
+	ENCRYPTION_KDF_ITERATIONS = 600_000
+	ENCRYPTION_NONCE_SIZE     = 12
+	ENCRYPTION_TAG_SIZE       = 16
+	ENCRYPTION_TRAILER_SIZE   = ENCRYPTION_TAG_SIZE + ENCRYPTION_NONCE_SIZE
+)
+
+// encrypted reports whether a header salt belongs to an encrypted file.
+func encrypted(salt [HEADER_SALT_SIZE]byte) bool {
+	return salt != [HEADER_SALT_SIZE]byte{}
+}
+
+// newSalt draws the salt of a new encrypted file.
+func newSalt() [HEADER_SALT_SIZE]byte {
+	var salt [HEADER_SALT_SIZE]byte
+	rand.Read(salt[:]) // never fails
+	return salt
+}
+
+// encryptionStart derives the key of the pager's file from passphrase and
+// its salt. If page 0 is in the file, it must open with the key, which tells
+// a wrong passphrase apart before anything else is read.
+func encryptionStart(pager *Pager, passphrase string) error {
+	key, err := pbkdf2.Key(sha256.New, passphrase, pager.salt[:], ENCRYPTION_KDF_ITERATIONS, ENCRYPTION_KEY_SIZE)
+	if err != nil {
+		return fmt.Errorf("derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	pager.cipher, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	if pager.fileLength < HEADER_SIZE+int64(pager.pageSize) {
+		return nil
+	}
+	page := make(Page, pager.pageSize)
+	if _, err := pager.file.ReadAt(page, HEADER_SIZE); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	if decryptPage(pager, 0, page) != nil {
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+// encryptPage returns page sealed for the file as page pageNum. page itself
+// is left as it is.
+func encryptPage(pager *Pager, pageNum uint32, page Page) Page {
+	sealed := make(Page, pager.pageSize)
+	nonce := sealed[pager.pageSize-ENCRYPTION_NONCE_SIZE:]
+	rand.Read(nonce)
+	pager.cipher.Seal(sealed[:0], nonce, page[:pager.pageSize-ENCRYPTION_TRAILER_SIZE], pageNumber(pageNum))
+	return sealed
+}
+
+// decryptPage opens page pageNum, as read from the file, in place.
+func decryptPage(pager *Pager, pageNum uint32, page Page) error {
+	var nonce [ENCRYPTION_NONCE_SIZE]byte
+	copy(nonce[:], page[pager.pageSize-ENCRYPTION_NONCE_SIZE:])
+	sealed := page[:pager.pageSize-ENCRYPTION_NONCE_SIZE]
+	if _, err := pager.cipher.Open(sealed[:0], nonce[:], sealed, pageNumber(pageNum)); err != nil {
+		return fmt.Errorf("%w: page %d fails authentication", ErrCorrupt, pageNum)
+	}
+	return nil
+}
+
+func pageNumber(pageNum uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, pageNum)
+}
+
+// checkPage turns a page just read from the file or the write-ahead log into
+// the one that was written, decrypting it and checking its checksum as the
+// file needs.
+func checkPage(pager *Pager, pageNum uint32, page Page) error {
+	if pager.cipher != nil {
+		if err := decryptPage(pager, pageNum, page); err != nil {
+			return err
+		}
+	}
+	if pager.checksums {
+		return verifyPageChecksum(pager, pageNum, page)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+func openEncrypted(t *testing.T, fileName string, options OpenOptions) *Table {
+	t.Helper()
+	options.Passphrase = testPassphrase
+	table, err := dbOpenWith(fileName, options)
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	return table
+}
+
+func TestEncryption_RoundTrip(t *testing.T) {
+	fileName := tempDBFile(t)
+	input := insertRows(1, rowsPerLeaf*3) + deleteRows(10, 20) +
+		"update 5 set email = " + strings.Repeat("x", COLUMN_EMAIL_SIZE*3) + "@example.com\n"
+
+	// the small cache writes pages back, and reads them again, before close
+	table := openEncrypted(t, fileName, OpenOptions{MaxCachedPages: 2})
+	runREPL(strings.NewReader(input), io.Discard, table)
+	want, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(contents, []byte("person1@example.com")) {
+		t.Errorf("file holds an email in the clear")
+	}
+
+	table = openEncrypted(t, fileName, OpenOptions{})
+	defer dbClose(table)
+	got, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	checkTree(t, table)
+
+	// vacuum writes a new file, which must keep the key
+	if _, err := dbVacuum(table); err != nil {
+		t.Fatalf("dbVacuum: %v", err)
+	}
+	if _, stored := checkTree(t, table); stored != uint32(len(want)) {
+		t.Errorf("tree holds %d rows after vacuum, want %d", stored, len(want))
+	}
+	var output bytes.Buffer
+	runREPL(strings.NewReader("+stats\n"), &output, table)
+	if !strings.Contains(output.String(), "encrypted = true") {
+		t.Errorf("+stats does not report the encryption:\n%s", output.String())
+	}
+}
+
+func TestEncryption_WALRecovery(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "wal.db")
+	table := openEncrypted(t, fileName, OpenOptions{WAL: true})
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*2)), io.Discard, table)
+
+	copyName := crashCopy(t, fileName)
+	recovered := openEncrypted(t, copyName, OpenOptions{})
+	defer dbClose(recovered)
+	if _, stored := checkTree(t, recovered); stored != uint32(rowsPerLeaf*2) {
+		t.Errorf("recovered %d rows, want %d", stored, rowsPerLeaf*2)
+	}
+}
+
+func TestEncryption_RejectsTheWrongKeyAndTampering(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := openEncrypted(t, fileName, OpenOptions{})
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*2)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	if _, err := dbOpenWith(fileName, OpenOptions{Passphrase: "wrong"}); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("dbOpenWith with the wrong passphrase: err = %v, want %v", err, ErrWrongPassphrase)
+	}
+	if _, err := dbOpen(fileName); err == nil {
+		t.Errorf("dbOpen without a passphrase succeeded")
+	}
+	for _, options := range []OpenOptions{{Compress: true}, {ReadOnly: true, Mmap: true}} {
+		options.Passphrase = testPassphrase
+		if table, err := dbOpenWith(fileName, options); err == nil {
+			dbClose(table)
+			t.Errorf("dbOpenWith(%+v) on an encrypted file succeeded", options)
+		}
+	}
+	plainName := tempDBFile(t)
+	dbClose(mustOpen(t, plainName))
+	if _, err := dbOpenWith(plainName, OpenOptions{Passphrase: testPassphrase}); err == nil {
+		t.Errorf("dbOpenWith encrypting an existing plain file succeeded")
+	}
+
+	// page 1 swapped with page 0, which still decrypts, just not as page 1
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	copy(contents[HEADER_SIZE+DEFAULT_PAGE_SIZE:], contents[HEADER_SIZE:HEADER_SIZE+DEFAULT_PAGE_SIZE])
+	if err := os.WriteFile(fileName, contents, 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	table, err = dbOpenWith(fileName, OpenOptions{Passphrase: testPassphrase})
+	if err == nil {
+		_, err = table.SelectAll()
+		dbClose(table)
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("reading a swapped page: err = %v, want %v", err, ErrCorrupt)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Pages the tree no longer uses are kept on a freelist, linked through their
+// first bytes, and handed out again before the file grows. The header records
+// the first free page and how many there are. Headers written before the
+// fields existed hold 0, an empty list, so older files need no upgrade.
+const (
+	NODE_FREE NodeType = 3
+
+	FREE_NODE_NEXT_PAGE_SIZE   = 4
+	FREE_NODE_NEXT_PAGE_OFFSET = COMMON_NODE_HEADER_SIZE
+)
+
+// pagerAllocatePage returns the number of a page for a new node: the first
+// one on the freelist, or else a new page past the last one in use, which is
+// created by the first getPage. Callers initialize the page themselves.
+func pagerAllocatePage(pager *Pager) (uint32, error) {
+	if pager.freePage != 0 {
+		pageNum := pager.freePage
+		page, err := getPage(pager, pageNum)
+		if err != nil {
+			return 0, err
+		}
+		if nodeType(page) != NODE_FREE {
+			return 0, fmt.Errorf("%w: page %d is on the freelist but is not free", ErrCorrupt, pageNum)
+		}
+		pager.freePage = binary.LittleEndian.Uint32(page[FREE_NODE_NEXT_PAGE_OFFSET:])
+		pager.numFreePages--
+		return pageNum, nil
+	}
+
+	// page numbers are uint32, and numPages counts one past the last
+	if pager.numPages == math.MaxUint32 {
+		return 0, ErrTableFull
+	}
+	pageNum := pager.numPages
+	pager.numPages++
+	return pageNum, nil
+}
+
+// pagerFreePage gives back a page the tree no longer uses. The last page is
+// dropped from the file; any other goes onto the freelist.
+func pagerFreePage(pager *Pager, pageNum uint32) error {
+	page, err := getPageForWrite(pager, pageNum)
+	if err != nil {
+		return err
+	}
+	clear(page)
+	if pageNum == pager.numPages-1 {
+		pager.numPages--
+		return nil
+	}
+	setNodeType(page, NODE_FREE)
+	binary.LittleEndian.PutUint32(page[FREE_NODE_NEXT_PAGE_OFFSET:], pager.freePage)
+	pager.freePage = pageNum
+	pager.numFreePages++
+	return nil
+}
+
+// pagerUnlinkFreePage takes pageNum off the freelist, if it is on it, so it
+// can be put to a use of its own.
+func pagerUnlinkFreePage(pager *Pager, pageNum uint32) error {
+	previous := uint32(0)
+	for next, seen := pager.freePage, uint32(0); next != 0; seen++ {
+		if seen == pager.numPages {
+			return fmt.Errorf("%w: the freelist has a cycle", ErrCorrupt)
+		}
+		page, err := getPage(pager, next)
+		if err != nil {
+			return err
+		}
+		after := binary.LittleEndian.Uint32(page[FREE_NODE_NEXT_PAGE_OFFSET:])
+		if next != pageNum {
+			previous, next = next, after
+			continue
+		}
+		if previous == 0 {
+			pager.freePage = after
+		} else {
+			previousPage, err := getPageForWrite(pager, previous)
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint32(previousPage[FREE_NODE_NEXT_PAGE_OFFSET:], after)
+		}
+		pager.numFreePages--
+		return nil
+	}
+	return nil
+}
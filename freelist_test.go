@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFreelist_ReusesFreedPages(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{PageSize: smallPageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+
+	// emptying the middle of the table merges leaves there, away from the
+	// end of the file
+	runREPL(strings.NewReader(insertRows(1, 100)+deleteRows(20, 80)), io.Discard, table)
+	if table.pager.numFreePages == 0 {
+		t.Fatalf("no pages on the freelist after deleting most rows")
+	}
+	numPages, numFree := table.pager.numPages, table.pager.numFreePages
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.pager.numPages != numPages || table.pager.numFreePages != numFree {
+		t.Errorf("after reopen numPages = %d and free pages = %d, want %d and %d", table.pager.numPages, table.pager.numFreePages, numPages, numFree)
+	}
+
+	// putting the rows back fills the freed pages before the file grows
+	for id := 20; id <= 80; id++ {
+		runREPL(strings.NewReader(insertRows(id, id)), io.Discard, table)
+		if table.pager.numFreePages != 0 && table.pager.numPages != numPages {
+			t.Fatalf("file grew to %d pages after inserting row %d with %d free pages to reuse", table.pager.numPages, id, table.pager.numFreePages)
+		}
+	}
+	if table.pager.numFreePages != 0 {
+		t.Errorf("%d pages left on the freelist after reinserting the rows", table.pager.numFreePages)
+	}
+	if _, stored := checkTree(t, table); stored != 100 {
+		t.Errorf("tree holds %d rows, want 100", stored)
+	}
+}
+
+func TestFreelist_RollbackRestoresFreelist(t *testing.T) {
+	table, err := dbOpenWith(tempDBFile(t), OpenOptions{PageSize: smallPageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+
+	runREPL(strings.NewReader(insertRows(1, 60)+deleteRows(10, 30)), io.Discard, table)
+	freePage, numFree := table.pager.freePage, table.pager.numFreePages
+
+	runREPL(strings.NewReader("+begin\n"+deleteRows(31, 50)+insertRows(10, 20)+"+rollback\n"), io.Discard, table)
+	if table.pager.freePage != freePage || table.pager.numFreePages != numFree {
+		t.Errorf("after rollback the freelist starts at page %d and holds %d pages, want %d and %d", table.pager.freePage, table.pager.numFreePages, freePage, numFree)
+	}
+	runREPL(strings.NewReader(insertRows(10, 30)), io.Discard, table)
+	if _, stored := checkTree(t, table); stored != 60 {
+		t.Errorf("tree holds %d rows, want 60", stored)
+	}
+}
+
+func TestFreelist_AllocationStopsWhenPageNumbersRunOut(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	pager := table.pager
+	pager.numPages = math.MaxUint32
+	if _, err := pagerAllocatePage(pager); !errors.Is(err, ErrTableFull) {
+		t.Fatalf("pagerAllocatePage with every page number taken = %v, want %v", err, ErrTableFull)
+	}
+
+	// a free page is still handed out
+	pager.numPages = 3
+	if err := pagerFreePage(pager, 1); err != nil {
+		t.Fatalf("pagerFreePage: %v", err)
+	}
+	pager.numPages = math.MaxUint32
+	if pageNum, err := pagerAllocatePage(pager); err != nil || pageNum != 1 {
+		t.Errorf("pagerAllocatePage = %d, %v, want the free page 1", pageNum, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// "<column> in (<value>, ...)" is true where the column holds one of the
+// values listed, and unknown where it holds null. The values are checked
+// against the column as those of any comparison are, and made into a set
+// once, before the scan, so that each row costs one lookup however long the
+// list. On id, or the key of a created table, the select reads only the
+// keys from the least listed to the greatest.
+
+// inList parses "(<value>, ...)", the list of comparison, an in of a column
+// of type operand when the table is known.
+func (p *parser) inList(comparison *Comparison, operand *Column) PrepareResult {
+	if result := p.expect("("); result != PREPARE_SUCCESS {
+		return result
+	}
+	builtIn := !p.anyColumn
+	if builtIn {
+		comparison.in = map[Value]bool{}
+	}
+	for {
+		value := p.peek()
+		id, result := p.operandValue(comparison.Column, operand)
+		if result != PREPARE_SUCCESS {
+			return result
+		}
+		p.advance()
+		comparison.Values = append(comparison.Values, value.Text)
+		switch {
+		case !builtIn:
+		case comparison.Column == "id":
+			comparison.in[int64(id)] = true
+		default:
+			comparison.in[value.Text] = true
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	comparison.Value = ""
+	return p.expect(")")
+}
+
+// inIDs returns the ids from the least of values to the greatest.
+func inIDs(values []string) idRange {
+	ids := idRange{math.MaxUint32 + 1, -1}
+	for _, value := range values {
+		id, _ := parseID(value)
+		ids.low, ids.high = min(ids.low, int64(id)), max(ids.high, int64(id))
+	}
+	return ids
+}
+
+// setKey returns value as a key of a map: the values of most types are
+// keys already, while blobs become strings and times instants.
+func setKey(value Value) Value {
+	switch value := value.(type) {
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.UnixNano()
+	}
+	return value
+}
+
+// compileIn compiles comparison, an in of the column at i in schema.
+func compileIn(schema *Schema, i int, comparison *Comparison) (func(values []Value) truth, error) {
+	set := make(map[Value]bool, len(comparison.Values))
+	for _, text := range comparison.Values {
+		value, err := columnValue(Column{Name: comparison.Column, Type: schema.Columns[i].Type, Size: math.MaxUint32}, text)
+		if err != nil {
+			return nil, err
+		}
+		set[setKey(value)] = true
+	}
+	return func(values []Value) truth {
+		switch {
+		case values[i] == nil:
+			return TRUTH_UNKNOWN
+		case set[setKey(values[i])]:
+			return TRUTH_TRUE
+		}
+		return TRUTH_FALSE
+	}, nil
+}
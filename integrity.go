@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// An integrity check reads every page the way a select would, but reports
+// what it finds wrong instead of stopping at the first error: stored pages
+// that fail their checksum or cannot be decrypted, nodes out of bounds or
+// out of key order, rows that do not decode, broken or shared overflow
+// chains, and a freelist, header or catalog that disagrees with the trees.
+// It changes nothing, and reports at most INTEGRITY_MAX_PROBLEMS problems.
+const INTEGRITY_MAX_PROBLEMS = 100
+
+// integrityCheck is the state of one dbIntegrityCheck.
+type integrityCheck struct {
+	table      *Table
+	problems   []string
+	users      map[uint32]string // what each page reached so far belongs to
+	unreadable map[uint32]bool   // pages whose stored copy failed to check
+
+	// the tree being walked, the built-in table's or that of a table made
+	// by create table, and what was found in it so far
+	tree      *Table
+	treeName  string
+	leaves    []uint32 // in key order, as the tree has them
+	nextLeaf  map[uint32]uint32
+	leafDepth int
+	numRows   uint32
+}
+
+// dbIntegrityCheck checks the whole table and returns the problems found,
+// none if it is sound. The error is for failures to read the file at all.
+func dbIntegrityCheck(table *Table) ([]string, error) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	pager := table.pager
+	check := &integrityCheck{
+		table:      table,
+		users:      make(map[uint32]string),
+		unreadable: make(map[uint32]bool),
+	}
+	for pageNum := range pager.numPages {
+		err := checkStoredPage(pager, pageNum)
+		if err != nil && !errors.Is(err, ErrCorrupt) {
+			return nil, err
+		}
+		if err != nil {
+			check.unreadable[pageNum] = true
+			check.report("%v", err)
+		}
+	}
+	if err := check.checkTree(table, "the tree"); err != nil {
+		return nil, err
+	}
+	if check.numRows != table.numRows {
+		check.report("the header counts %d rows, the tree holds %d", table.numRows, check.numRows)
+	}
+	entries, exists, err := readCatalog(pager)
+	if errors.Is(err, ErrCorrupt) {
+		check.report("%v", err)
+	} else if err != nil {
+		return nil, err
+	}
+	if exists {
+		check.use(CATALOG_PAGE, "the catalog")
+	}
+	for _, entry := range entries {
+		tree := &Table{pager: pager, rootPageNum: entry.rootPageNum, schema: entry.schema}
+		if err := check.checkTree(tree, "the tree of table "+entry.schema.Name); err != nil {
+			return nil, err
+		}
+		if check.numRows != entry.numRows {
+			check.report("the catalog counts %d rows in table %s, its tree holds %d", entry.numRows, entry.schema.Name, check.numRows)
+		}
+	}
+	if err := check.checkFreelist(); err != nil {
+		return nil, err
+	}
+
+	for pageNum := range pager.numPages {
+		if _, used := check.users[pageNum]; !used {
+			check.report("page %d is neither in the tree nor on the freelist", pageNum)
+		}
+	}
+	if len(check.problems) > INTEGRITY_MAX_PROBLEMS {
+		check.problems = append(check.problems[:INTEGRITY_MAX_PROBLEMS], "too many problems; stopped listing them")
+	}
+	return check.problems, nil
+}
+
+// checkTree checks the tree of table, a table made by create table or the
+// built-in one, which name describes in problems.
+func (check *integrityCheck) checkTree(tree *Table, name string) error {
+	check.tree, check.treeName = tree, name
+	check.leaves, check.nextLeaf = nil, make(map[uint32]uint32)
+	check.leafDepth, check.numRows = 0, 0
+	if err := check.walk(tree.rootPageNum, 0, 1<<32-1, 1); err != nil {
+		return err
+	}
+	check.checkLeafChain()
+	return nil
+}
+
+// checkStoredPage reads the stored copy of a page again to check it, even if
+// the page is cached. Dirty pages are skipped: their stored copy is about to
+// be replaced, and the new one is checksummed as it is written.
+func checkStoredPage(pager *Pager, pageNum uint32) error {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	if pager.file == nil || int(pageNum) < len(pager.dirty) && pager.dirty[pageNum] {
+		return nil
+	}
+	_, err := loadPage(pager, pageNum, pagerFilePages(pager))
+	return err
+}
+
+func (check *integrityCheck) report(format string, args ...any) {
+	check.problems = append(check.problems, fmt.Sprintf(format, args...))
+}
+
+// use records that pageNum belongs to user, reporting false if it cannot:
+// it is past the last page or belongs to something else already.
+func (check *integrityCheck) use(pageNum uint32, user string) bool {
+	if pageNum >= check.table.pager.numPages {
+		check.report("page %d, used by %s, is past the last page", pageNum, user)
+		return false
+	}
+	if other, used := check.users[pageNum]; used {
+		if other == user {
+			check.report("%s reaches page %d twice", user, pageNum)
+		} else {
+			check.report("page %d is used by both %s and %s", pageNum, other, user)
+		}
+		return false
+	}
+	check.users[pageNum] = user
+	return true
+}
+
+// page fetches a page, reporting false if it is damaged; the error is for
+// failures to read it at all.
+func (check *integrityCheck) page(pageNum uint32) (Page, bool, error) {
+	if check.unreadable[pageNum] {
+		return nil, false, nil
+	}
+	page, err := getPage(check.table.pager, pageNum)
+	if errors.Is(err, ErrCorrupt) {
+		check.report("%v", err)
+		return nil, false, nil
+	}
+	return page, err == nil, err
+}
+
+// walk checks the subtree under pageNum, whose keys must be in [low, high].
+func (check *integrityCheck) walk(pageNum uint32, low, high uint64, depth int) error {
+	if !check.use(pageNum, check.treeName) {
+		return nil
+	}
+	node, ok, err := check.page(pageNum)
+	if !ok {
+		return err
+	}
+	usable := uint64(pageUsableSize(check.table.pager))
+	switch nodeType(node) {
+	case NODE_LEAF:
+		return check.checkLeaf(pageNum, node, low, high, depth)
+	case NODE_INTERNAL:
+		numKeys := internalNodeNumKeys(node)
+		if uint64(INTERNAL_NODE_HEADER_SIZE)+uint64(numKeys)*INTERNAL_NODE_CELL_SIZE > usable {
+			check.report("internal node %d has %d keys, more than fit", pageNum, numKeys)
+			return nil
+		}
+		children, keys := readInternalNode(node)
+		for i, child := range children {
+			childHigh := high
+			if i < len(keys) {
+				childHigh = uint64(keys[i])
+				if childHigh < low || childHigh > high {
+					check.report("internal node %d key %d: %d is out of order, want it in [%d, %d]", pageNum, i, childHigh, low, high)
+				}
+			}
+			if err := check.walk(child, low, childHigh, depth+1); err != nil {
+				return err
+			}
+			low = childHigh + 1
+		}
+	default:
+		check.report("page %d in %s is neither a leaf nor an internal node", pageNum, check.treeName)
+	}
+	return nil
+}
+
+func (check *integrityCheck) checkLeaf(pageNum uint32, node Page, low, high uint64, depth int) error {
+	if check.leafDepth == 0 {
+		check.leafDepth = depth
+	} else if depth != check.leafDepth {
+		check.report("leaf %d is at depth %d, the first leaf at depth %d", pageNum, depth, check.leafDepth)
+	}
+	check.leaves = append(check.leaves, pageNum)
+	check.nextLeaf[pageNum] = leafNodeNextLeaf(node)
+
+	usable := pageUsableSize(check.table.pager)
+	numCells := leafNodeNumCells(node)
+	if uint64(LEAF_NODE_HEADER_SIZE)+uint64(numCells)*LEAF_NODE_CELL_POINTER_SIZE > uint64(usable) {
+		check.report("leaf %d has %d cells, more than fit", pageNum, numCells)
+		return nil
+	}
+	cellsStart := LEAF_NODE_HEADER_SIZE + numCells*LEAF_NODE_CELL_POINTER_SIZE
+	for cellNum := range numCells {
+		offset := leafNodeCellOffset(node, cellNum)
+		if offset < cellsStart || offset+LEAF_NODE_CELL_HEADER_SIZE > usable {
+			check.report("leaf %d cell %d: offset %d is outside the cells", pageNum, cellNum, offset)
+			continue
+		}
+		valueLength := uint32(binary.LittleEndian.Uint16(node[offset+LEAF_NODE_KEY_SIZE:]))
+		if offset+LEAF_NODE_CELL_HEADER_SIZE+valueLength > usable {
+			check.report("leaf %d cell %d: row of %d bytes runs past the end of the page", pageNum, cellNum, valueLength)
+			continue
+		}
+		key := uint64(leafNodeKey(node, cellNum))
+		if key < low || key > high {
+			check.report("leaf %d cell %d: key %d is out of order, want it in [%d, %d]", pageNum, cellNum, key, low, high)
+		} else {
+			low = key + 1
+		}
+		check.numRows++
+		if err := check.checkRow(pageNum, cellNum, uint32(key), leafNodeValue(node, cellNum)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRow checks a serialized row and the overflow pages it refers to, or a
+// record of a table made by create table.
+func (check *integrityCheck) checkRow(pageNum, cellNum, key uint32, value []byte) error {
+	if check.tree.schema != nil {
+		values, err := decodeRecord(check.tree.schema, value)
+		if err != nil {
+			check.report("%s, leaf %d cell %d: %v", check.treeName, pageNum, cellNum, err)
+		} else if id := uint32(values[0].(int64)); id != key {
+			check.report("%s, leaf %d cell %d: record %d stored under key %d", check.treeName, pageNum, cellNum, id, key)
+		}
+		return nil
+	}
+	var row Row
+	ref, err := deserializeRow(value, &row)
+	if err != nil {
+		check.report("leaf %d cell %d: %v", pageNum, cellNum, err)
+		return nil
+	}
+	if row.id != key {
+		check.report("leaf %d cell %d: row id %d stored under key %d", pageNum, cellNum, row.id, key)
+	}
+	if len(row.username) > COLUMN_USERNAME_SIZE {
+		check.report("row %d: username of %d bytes is longer than %d", row.id, len(row.username), COLUMN_USERNAME_SIZE)
+	}
+	if len(row.email) > COLUMN_EMAIL_SIZE {
+		check.report("row %d: email of %d bytes is stored inline, longer than %d", row.id, len(row.email), COLUMN_EMAIL_SIZE)
+	}
+	if ref.pageNum == 0 {
+		return nil
+	}
+
+	// the chain has just enough pages for the email
+	user := fmt.Sprintf("row %d", row.id)
+	capacity := overflowPageCapacity(check.table.pager)
+	next := ref.pageNum
+	for stored := uint32(0); stored < ref.length; stored += capacity {
+		if next == 0 {
+			check.report("row %d: overflow chain ends after %d of %d bytes", row.id, stored, ref.length)
+			return nil
+		}
+		if !check.use(next, user) {
+			return nil
+		}
+		page, ok, err := check.page(next)
+		if !ok {
+			return err
+		}
+		if nodeType(page) != NODE_OVERFLOW {
+			check.report("row %d: page %d in its overflow chain is not an overflow page", row.id, next)
+			return nil
+		}
+		next = binary.LittleEndian.Uint32(page[OVERFLOW_NODE_NEXT_PAGE_OFFSET:])
+	}
+	if next != 0 {
+		check.report("row %d: overflow chain goes on to page %d past the end of the email", row.id, next)
+	}
+	return nil
+}
+
+// checkLeafChain checks that the leaves link to each other in the order the
+// tree has them, starting from page 0 in the built-in table.
+func (check *integrityCheck) checkLeafChain() {
+	if check.tree == check.table && len(check.leaves) > 0 && check.leaves[0] != 0 {
+		check.report("the first leaf is page %d, not page 0", check.leaves[0])
+	}
+	for i, pageNum := range check.leaves {
+		want := uint32(0)
+		if i+1 < len(check.leaves) {
+			want = check.leaves[i+1]
+		}
+		if next := check.nextLeaf[pageNum]; next != want {
+			check.report("leaf %d links to page %d, want %d", pageNum, next, want)
+		}
+	}
+}
+
+// checkFreelist checks that the freelist holds free pages only, as many as
+// the header counts.
+func (check *integrityCheck) checkFreelist() error {
+	pager := check.table.pager
+	var numFree uint32
+	for pageNum := pager.freePage; pageNum != 0; numFree++ {
+		if !check.use(pageNum, "the freelist") {
+			break
+		}
+		page, ok, err := check.page(pageNum)
+		if !ok {
+			if err != nil {
+				return err
+			}
+			break
+		}
+		if nodeType(page) != NODE_FREE {
+			check.report("page %d is on the freelist but is not free", pageNum)
+		}
+		pageNum = binary.LittleEndian.Uint32(page[FREE_NODE_NEXT_PAGE_OFFSET:])
+	}
+	if numFree != pager.numFreePages {
+		check.report("the header counts %d free pages, the freelist holds %d", pager.numFreePages, numFree)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIntegrityCheck_SoundTable(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, _ := fragmentedTable(t, fileName, OpenOptions{})
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("+integrity_check\n"), &output, table, true)
+	if output.String() != "ok\n" {
+		t.Errorf("+integrity_check printed %q, want ok", output.String())
+	}
+}
+
+func TestIntegrityCheck_ReportsDamage(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, _ := fragmentedTable(t, fileName, OpenOptions{})
+	freePage := table.pager.freePage
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	// a stored page that fails its checksum, which opening does not read
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, HEADER_SIZE+int64(freePage)*smallPageSize+20); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	file.Close()
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	// a leaf key out of order, and a row count the tree does not hold
+	leaf, err := getPageForWrite(table.pager, 0)
+	if err != nil {
+		t.Fatalf("getPageForWrite: %v", err)
+	}
+	binary.LittleEndian.PutUint32(leaf[leafNodeCellOffset(leaf, 0):], 1_000_000)
+	table.numRows++
+
+	problems, err := table.IntegrityCheck()
+	if err != nil {
+		t.Fatalf("IntegrityCheck: %v", err)
+	}
+	for _, want := range []string{
+		"checksum mismatch",
+		"leaf 0 cell 0: key 1000000 is out of order",
+		"leaf 0 cell 0: row id 1 stored under key 1000000",
+		"the header counts",
+	} {
+		found := false
+		for _, problem := range problems {
+			found = found || strings.Contains(problem, want)
+		}
+		if !found {
+			t.Errorf("no problem mentions %q in %q", want, problems)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Joins. "select ... from a join b on <condition>" pairs each record of a
+// with each of b, and keeps the pairs that meet the condition: a nested
+// loop, with b read once into memory and a scanned in key order, so the
+// result comes in the order of a's keys and, for each, of b's. The columns
+// of the pairs are those of a then those of b, named a.<column> and
+// b.<column>; a column only one of the tables has may go by its name alone.
+// The condition compares columns with columns, "a.id = b.user_id", joined
+// by and and or. A left join also keeps each record of a that pairs with
+// none of b, once, with nulls for b's columns.
+
+// joinSchema returns the columns of the records joining left and right,
+// qualified by the names of their tables.
+func joinSchema(left, right *Schema) *Schema {
+	joined := &Schema{Name: left.Name + " join " + right.Name}
+	for _, schema := range []*Schema{left, right} {
+		for _, column := range schema.Columns {
+			column.Name = schema.Name + "." + column.Name
+			joined.Columns = append(joined.Columns, column)
+		}
+	}
+	return joined
+}
+
+// join parses "[inner | left [outer]] join <table> on <condition>" into
+// statement, after the table it joins with. The columns named from then on
+// are those of both tables.
+func (p *parser) join(statement *SelectStmt) PrepareResult {
+	switch token := p.peek(); {
+	case token.Type != TOKEN_IDENTIFIER:
+	case token.Text == "inner":
+		p.advance()
+	case token.Text == "left":
+		p.advance()
+		statement.LeftJoin = true
+		if token := p.peek(); token.Type == TOKEN_IDENTIFIER && token.Text == "outer" {
+			p.advance()
+		}
+	}
+	if token := p.peek(); token.Type != TOKEN_IDENTIFIER || token.Text != "join" {
+		return p.fail(`"join"`)
+	}
+	p.advance()
+	left := p.schema
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	if name == statement.Table {
+		return p.failAt(p.tokens[p.next-1], PREPARE_SYNTAX_ERROR, "a table cannot be joined with itself")
+	}
+	statement.Join = name
+	if result := p.lookupTable(); result != PREPARE_SUCCESS {
+		return result
+	}
+	if p.schema != nil {
+		p.schema = joinSchema(left, p.schema)
+	}
+	if token := p.peek(); token.Type != TOKEN_IDENTIFIER || token.Text != "on" {
+		return p.fail(`"on"`)
+	}
+	p.advance()
+	p.anyColumn, p.joining = true, true
+	on, result := p.where()
+	p.joining = false
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.On = on
+	return PREPARE_SUCCESS
+}
+
+// columnComparison parses the column after operator in a comparison of two
+// columns, the first of which is column, named name, of type operand when
+// the tables are known.
+func (p *parser) columnComparison(column Token, name, operator string, operand *Column) (Condition, PrepareResult) {
+	other := p.peek()
+	if _, result := p.columnName(); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	if p.schema != nil {
+		index := columnIndex(p.schema, other.Text)
+		if index == -1 {
+			return nil, p.failAt(other, PREPARE_NO_SUCH_COLUMN, fmt.Sprintf("table %s has no column %s", p.schema.Name, other.Text))
+		}
+		if p.schema.Columns[index].Type != operand.Type {
+			return nil, p.failAt(column, PREPARE_TYPE_MISMATCH, fmt.Sprintf("%s and %s are of different types", name, other.Text))
+		}
+	}
+	return &Comparison{Column: name, Operator: operator, Other: other.Text}, PREPARE_SUCCESS
+}
+
+// joinSource returns the columns of the records statement joins, left with
+// the table it names, and a function scanning those that meet its on
+// condition until visit returns false.
+func joinSource(table *Table, left *Table, statement *SelectStmt) (*Schema, func(visit func(values []Value) bool) error, error) {
+	right := findTable(table, statement.Join)
+	if right == nil {
+		return nil, nil, fmt.Errorf("%w: %s", errNoSuchTable, statement.Join)
+	}
+	schema := joinSchema(left.schema, right.schema)
+	on, err := compileCondition(schema, statement.On)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schema, func(visit func(values []Value) bool) error {
+		var records [][]Value
+		err := scanRecords(right, allIDs, func(values []Value) bool {
+			records = append(records, values)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return scanRecords(left, allIDs, func(values []Value) bool {
+			matched := false
+			for _, other := range records {
+				joined := append(append(make([]Value, 0, len(schema.Columns)), values...), other...)
+				if !on(joined) {
+					continue
+				}
+				matched = true
+				if !visit(joined) {
+					return false
+				}
+			}
+			if !matched && statement.LeftJoin {
+				return visit(append(values, make([]Value, len(right.schema.Columns))...))
+			}
+			return true
+		})
+	}, nil
+}
+
+// compileColumns compiles comparison, of the column at i in schema with
+// another, which is unknown where either is null.
+func compileColumns(schema *Schema, i int, comparison *Comparison) (func(values []Value) truth, error) {
+	j := columnIndex(schema, comparison.Other)
+	if j == -1 {
+		return nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, comparison.Other)
+	}
+	if schema.Columns[i].Type != schema.Columns[j].Type {
+		return nil, fmt.Errorf("%w: %s and %s are of different types", errTypeMismatch, comparison.Column, comparison.Other)
+	}
+	operator := comparison.Operator
+	return func(values []Value) truth {
+		switch {
+		case values[i] == nil || values[j] == nil:
+			return TRUTH_UNKNOWN
+		case compareOrder(compareValues(values[i], values[j]), operator):
+			return TRUTH_TRUE
+		}
+		return TRUTH_FALSE
+	}, nil
+}
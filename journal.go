@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// With OpenOptions.Journal, every statement outside a transaction, and every
+// transaction, reaches the database file all at once or not at all. Before a
+// page of the file is first overwritten, its original contents are appended
+// to a rollback journal next to the file, which is synced before the file is
+// written. Once the new pages and header are in the file and it is synced,
+// the journal is deleted; that is the moment the statement commits. A journal
+// found when a database is opened belongs to a statement that never finished,
+// and the pages saved in it are copied back.
+//
+// The journal starts with JOURNAL_MAGIC, the page size, the number of pages
+// in the file and its header before the statement, and a CRC32 of all that.
+// Each record is a page number and a CRC32 of the record, followed by the
+// page as it was.
+const (
+	JOURNAL_SUFFIX = "-journal"
+	JOURNAL_MAGIC  = "SIMPLJNL"
+
+	JOURNAL_PAGE_SIZE_OFFSET = len(JOURNAL_MAGIC)
+	JOURNAL_NUM_PAGES_OFFSET = JOURNAL_PAGE_SIZE_OFFSET + 4
+	JOURNAL_DB_HEADER_OFFSET = JOURNAL_NUM_PAGES_OFFSET + 4
+	JOURNAL_CHECKSUM_OFFSET  = JOURNAL_DB_HEADER_OFFSET + HEADER_SIZE
+	JOURNAL_HEADER_SIZE      = JOURNAL_CHECKSUM_OFFSET + 4
+
+	JOURNAL_RECORD_PAGE_NUM_OFFSET = 0
+	JOURNAL_RECORD_CHECKSUM_OFFSET = JOURNAL_RECORD_PAGE_NUM_OFFSET + 4
+	JOURNAL_RECORD_HEADER_SIZE     = JOURNAL_RECORD_CHECKSUM_OFFSET + 4
+)
+
+func journalPath(filename string) string {
+	return filename + JOURNAL_SUFFIX
+}
+
+// journalBegin starts the journal of the statement in progress, saving the
+// size and header of the file, unless it is already started.
+func journalBegin(pager *Pager) error {
+	if pager.journal != nil {
+		return nil
+	}
+	numPages := uint32(0)
+	if pager.fileLength > HEADER_SIZE {
+		pageSize := int64(pager.pageSize)
+		numPages = uint32((pager.fileLength - HEADER_SIZE + pageSize - 1) / pageSize)
+	}
+
+	var header [JOURNAL_HEADER_SIZE]byte
+	copy(header[:], JOURNAL_MAGIC)
+	binary.LittleEndian.PutUint32(header[JOURNAL_PAGE_SIZE_OFFSET:], pager.pageSize)
+	binary.LittleEndian.PutUint32(header[JOURNAL_NUM_PAGES_OFFSET:], numPages)
+	if _, err := pager.file.ReadAt(header[JOURNAL_DB_HEADER_OFFSET:JOURNAL_CHECKSUM_OFFSET], 0); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read header failed: %w", err)
+	}
+	binary.LittleEndian.PutUint32(header[JOURNAL_CHECKSUM_OFFSET:], crc32.ChecksumIEEE(header[:JOURNAL_CHECKSUM_OFFSET]))
+
+	journal, err := os.OpenFile(journalPath(pager.file.Name()), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("open rollback journal: %w", err)
+	}
+	if _, err := journal.WriteAt(header[:], 0); err != nil {
+		journal.Close()
+		return fmt.Errorf("write rollback journal header: %w", err)
+	}
+	pager.journal = journal
+	pager.journalLength = int64(JOURNAL_HEADER_SIZE)
+	pager.journalPages = numPages
+	pager.journaled = make(map[uint32]bool)
+	pager.journalSynced = false
+	return nil
+}
+
+// journalSavePage appends the contents pageNum has in the file to the
+// journal, unless they are saved already or the page was not in the file when
+// the journal was started. The journal must be synced by journalSync before
+// the page is overwritten.
+func journalSavePage(pager *Pager, pageNum uint32) error {
+	if err := journalBegin(pager); err != nil {
+		return err
+	}
+	if pageNum >= pager.journalPages || pager.journaled[pageNum] {
+		return nil
+	}
+
+	record := make([]byte, JOURNAL_RECORD_HEADER_SIZE+pager.pageSize)
+	binary.LittleEndian.PutUint32(record[JOURNAL_RECORD_PAGE_NUM_OFFSET:], pageNum)
+	offset := HEADER_SIZE + int64(pageNum)*int64(pager.pageSize)
+	if _, err := pager.file.ReadAt(record[JOURNAL_RECORD_HEADER_SIZE:], offset); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read failed: %w", err)
+	}
+	binary.LittleEndian.PutUint32(record[JOURNAL_RECORD_CHECKSUM_OFFSET:], journalRecordChecksum(record))
+	if _, err := pager.journal.WriteAt(record, pager.journalLength); err != nil {
+		return fmt.Errorf("write to rollback journal: %w", err)
+	}
+	pager.journalLength += int64(len(record))
+	pager.journaled[pageNum] = true
+	pager.journalSynced = false
+	return nil
+}
+
+// journalRecordChecksum covers a whole record but its checksum field.
+func journalRecordChecksum(record []byte) uint32 {
+	checksum := crc32.ChecksumIEEE(record[:JOURNAL_RECORD_CHECKSUM_OFFSET])
+	return crc32.Update(checksum, crc32.IEEETable, record[JOURNAL_RECORD_HEADER_SIZE:])
+}
+
+// journalSync syncs the journal if anything was added since it last was.
+func journalSync(pager *Pager) error {
+	if pager.journalSynced || pager.syncMode == SYNC_OFF {
+		return nil
+	}
+	if err := pager.journal.Sync(); err != nil {
+		return fmt.Errorf("sync rollback journal: %w", err)
+	}
+	pager.journalSynced = true
+	return nil
+}
+
+// journalSaveChanges saves every page flushAll is about to overwrite or trim
+// off the file and syncs the journal, once for all of them. Without dirty
+// pages nothing may have changed since the last commit, and if nothing
+// reached the file either no journal is started.
+func journalSaveChanges(pager *Pager) error {
+	for pageNum, dirty := range pager.dirty {
+		if !dirty || uint32(pageNum) >= pager.numPages {
+			continue
+		}
+		if err := journalSavePage(pager, uint32(pageNum)); err != nil {
+			return err
+		}
+	}
+	if pager.journal == nil {
+		return nil
+	}
+	for pageNum := pager.numPages; pageNum < pager.journalPages; pageNum++ {
+		if err := journalSavePage(pager, pageNum); err != nil {
+			return err
+		}
+	}
+	return journalSync(pager)
+}
+
+// journalCommit syncs the file, then deletes the journal, committing what
+// was written since it was started.
+func journalCommit(pager *Pager) error {
+	if pager.journal == nil {
+		return nil
+	}
+	if err := pagerSync(pager); err != nil {
+		return err
+	}
+	if err := pager.journal.Close(); err != nil {
+		return err
+	}
+	pager.journal = nil
+	pager.journaled = nil
+	return os.Remove(journalPath(pager.file.Name()))
+}
+
+// journalRecover copies the pages saved in a journal left next to the pager's
+// file back into it and removes the journal. Records from the first damaged
+// one on never made it to disk, so neither did the pages they would have
+// saved; a journal without a whole header means the file was never written.
+// A read-only open cannot roll anything back and fails if there is something
+// to roll back.
+func journalRecover(pager *Pager, readOnly bool) error {
+	path := journalPath(pager.file.Name())
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read rollback journal: %w", err)
+	}
+
+	if len(contents) < JOURNAL_HEADER_SIZE || string(contents[:len(JOURNAL_MAGIC)]) != JOURNAL_MAGIC ||
+		binary.LittleEndian.Uint32(contents[JOURNAL_CHECKSUM_OFFSET:]) != crc32.ChecksumIEEE(contents[:JOURNAL_CHECKSUM_OFFSET]) {
+		if readOnly {
+			return nil
+		}
+		return os.Remove(path)
+	}
+	if readOnly {
+		return fmt.Errorf("rollback journal %s holds an unfinished statement; open the database for writing once to roll it back", path)
+	}
+
+	pageSize := binary.LittleEndian.Uint32(contents[JOURNAL_PAGE_SIZE_OFFSET:])
+	numPages := binary.LittleEndian.Uint32(contents[JOURNAL_NUM_PAGES_OFFSET:])
+	header := contents[JOURNAL_DB_HEADER_OFFSET:JOURNAL_CHECKSUM_OFFSET]
+	pages := make(map[uint32][]byte)
+	recordSize := JOURNAL_RECORD_HEADER_SIZE + int(pageSize)
+	for rest := contents[JOURNAL_HEADER_SIZE:]; len(rest) >= recordSize; rest = rest[recordSize:] {
+		record := rest[:recordSize]
+		if binary.LittleEndian.Uint32(record[JOURNAL_RECORD_CHECKSUM_OFFSET:]) != journalRecordChecksum(record) {
+			break
+		}
+		pages[binary.LittleEndian.Uint32(record[JOURNAL_RECORD_PAGE_NUM_OFFSET:])] = record[JOURNAL_RECORD_HEADER_SIZE:]
+	}
+	if err := applyPages(pager, pageSize, pages, header, numPages); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
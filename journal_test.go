@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openJournaled(t *testing.T, fileName string) *Table {
+	t.Helper()
+	table, err := dbOpenWith(fileName, OpenOptions{Journal: true})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	return table
+}
+
+func TestJournal_RollsBackAnUnfinishedStatement(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "journal.db")
+	table := openJournaled(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*3)), io.Discard, table)
+	if _, err := os.Stat(fileName + JOURNAL_SUFFIX); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("journal still there after the statements committed: %v", err)
+	}
+	want, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	// the process dies after writing the pages and header of a transaction
+	// that shrinks the file, but before deleting the journal
+	runREPL(strings.NewReader("+begin\n"+deleteRows(rowsPerLeaf, rowsPerLeaf*3)), io.Discard, table)
+	pager := table.pager
+	if err := journalSaveChanges(pager); err != nil {
+		t.Fatalf("journalSaveChanges: %v", err)
+	}
+	for pageNum, dirty := range pager.dirty {
+		if dirty && uint32(pageNum) < pager.numPages {
+			if err := pagerFlush(pager, uint32(pageNum)); err != nil {
+				t.Fatalf("pagerFlush: %v", err)
+			}
+		}
+	}
+	if err := writeHeader(table); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+	if err := pager.file.Truncate(HEADER_SIZE + int64(pager.numPages)*int64(pager.pageSize)); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	copyName := crashCopy(t, fileName)
+	pagerClose(pager)
+
+	recovered := mustOpen(t, copyName)
+	defer dbClose(recovered)
+	if _, err := os.Stat(copyName + JOURNAL_SUFFIX); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal still there after recovery: %v", err)
+	}
+	if recovered.pager.fileLength != info.Size() {
+		t.Errorf("recovered file holds %d bytes, want %d", recovered.pager.fileLength, info.Size())
+	}
+	got, err := recovered.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recovered %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	checkTree(t, recovered)
+}
+
+func TestJournal_IgnoresWhatNeverReachedTheDisk(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "journal.db")
+	table := openJournaled(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*2)), io.Discard, table)
+
+	// a journal whose header never made it to disk
+	unsynced := crashCopy(t, fileName)
+	if err := os.WriteFile(unsynced+JOURNAL_SUFFIX, []byte(JOURNAL_MAGIC), 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// page 0 is saved and overwritten; the record saving page 1 is torn, so
+	// page 1 cannot have been written yet
+	pager := table.pager
+	for _, pageNum := range []uint32{0, 1} {
+		if err := journalSavePage(pager, pageNum); err != nil {
+			t.Fatalf("journalSavePage: %v", err)
+		}
+	}
+	if err := journalSync(pager); err != nil {
+		t.Fatalf("journalSync: %v", err)
+	}
+	if _, err := pager.file.WriteAt(make([]byte, pager.pageSize), HEADER_SIZE); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	torn := crashCopy(t, fileName)
+	contents, err := os.ReadFile(torn + JOURNAL_SUFFIX)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	contents[len(contents)-1] ^= 0xFF
+	if err := os.WriteFile(torn+JOURNAL_SUFFIX, contents, 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	pagerClose(pager)
+
+	for _, copyName := range []string{torn, unsynced} {
+		recovered := mustOpen(t, copyName)
+		if _, err := os.Stat(copyName + JOURNAL_SUFFIX); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("journal still there after recovery: %v", err)
+		}
+		if _, stored := checkTree(t, recovered); stored != uint32(rowsPerLeaf*2) {
+			t.Errorf("recovered %d rows, want %d", stored, rowsPerLeaf*2)
+		}
+		dbClose(recovered)
+	}
+}
+
+func TestJournal_ReadOnlyOpenRefusesAPendingJournal(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "journal.db")
+	for _, options := range []OpenOptions{{Journal: true, ReadOnly: true}, {Journal: true, WAL: true}} {
+		if _, err := dbOpenWith(fileName, options); err == nil {
+			t.Errorf("dbOpenWith(%+v) succeeded", options)
+		}
+	}
+
+	table := openJournaled(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, 3)), io.Discard, table)
+	if err := journalSavePage(table.pager, 0); err != nil {
+		t.Fatalf("journalSavePage: %v", err)
+	}
+	copyName := crashCopy(t, fileName)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	if _, err := dbOpenWith(copyName, OpenOptions{ReadOnly: true}); err == nil || !strings.Contains(err.Error(), "rollback journal") {
+		t.Errorf("dbOpenWith read-only over a pending journal: err = %v", err)
+	}
+	recovered := mustOpen(t, copyName)
+	defer dbClose(recovered)
+	if recovered.numRows != 3 {
+		t.Errorf("numRows = %d, want 3", recovered.numRows)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Before RECORD_FORMAT_VERSION every row took FIXED_ROW_SIZE bytes: the id,
+// then the username and the email, each padded with NULs to the width of its
+// column. Starting with OVERFLOW_FORMAT_VERSION, an email field starting with
+// a NUL and FIXED_OVERFLOW_MARKER instead holds the length and first page of
+// an email stored on overflow pages.
+const (
+	FIXED_USERNAME_OFFSET     = ID_SIZE
+	FIXED_EMAIL_OFFSET        = FIXED_USERNAME_OFFSET + COLUMN_USERNAME_SIZE
+	FIXED_ROW_SIZE            = FIXED_EMAIL_OFFSET + COLUMN_EMAIL_SIZE
+	FIXED_LEAF_NODE_CELL_SIZE = LEAF_NODE_KEY_SIZE + FIXED_ROW_SIZE
+
+	FIXED_OVERFLOW_MARKER        = 1
+	FIXED_OVERFLOW_LENGTH_OFFSET = 2
+	FIXED_OVERFLOW_PAGE_OFFSET   = FIXED_OVERFLOW_LENGTH_OFFSET + 4
+)
+
+// upgradeLegacyTable converts a table opened from a file written before
+// RECORD_FORMAT_VERSION into a B+ tree of variable-length rows. Files older
+// than BTREE_FORMAT_VERSION store their rows packed one after another; the
+// ones since keep them in fixed-size leaf cells, chained from page 0. The
+// tree replaces the file the same way +vacuum does; a read-only table keeps
+// it in memory instead and leaves the file as it was.
+func upgradeLegacyTable(table *Table) error {
+	var rows []Row
+	var err error
+	if table.pager.version < BTREE_FORMAT_VERSION {
+		rows, err = readLegacyRows(table)
+	} else {
+		rows, err = readFixedLeafRows(table)
+	}
+	if err != nil {
+		return err
+	}
+	tree, err := buildTree(table.pager, rows)
+	if err != nil {
+		return fmt.Errorf("upgrade from format version %d: %w", table.pager.version, err)
+	}
+
+	table.rootPageNum = tree.rootPageNum
+	if table.readOnly {
+		pagerClose(table.pager)
+		table.pager = tree.pager
+		return nil
+	}
+	return replaceFile(table.pager, tree, "upgrade")
+}
+
+// readLegacyRows reads the rows of a file in the packed layout, where row n
+// is slot n%rowsPerPage of page n/rowsPerPage.
+func readLegacyRows(table *Table) ([]Row, error) {
+	pager := table.pager
+	usable := pager.pageSize
+	if pager.checksums {
+		usable -= PAGE_CHECKSUM_SIZE
+	}
+	rowsPerPage := usable / FIXED_ROW_SIZE
+
+	rows := make([]Row, table.numRows)
+	for rowNum := range table.numRows {
+		page, err := getPage(pager, rowNum/rowsPerPage)
+		if err != nil {
+			return nil, err
+		}
+		byteOffset := rowNum % rowsPerPage * FIXED_ROW_SIZE
+		if err := readFixedRow(pager, page[byteOffset:byteOffset+FIXED_ROW_SIZE], &rows[rowNum]); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// readFixedLeafRows reads the rows of a file whose leaves hold fixed-size
+// cells, walking the leaf chain from page 0; internal nodes are not needed.
+func readFixedLeafRows(table *Table) ([]Row, error) {
+	pager := table.pager
+	filePages := uint32(0)
+	if pager.fileLength > HEADER_SIZE {
+		filePages = uint32((pager.fileLength - HEADER_SIZE) / int64(pager.pageSize))
+	}
+	maxCells := (pager.pageSize - PAGE_CHECKSUM_SIZE - LEAF_NODE_HEADER_SIZE) / FIXED_LEAF_NODE_CELL_SIZE
+
+	rows := make([]Row, 0, table.numRows)
+	pageNum := uint32(0)
+	for numLeaves := uint32(1); ; numLeaves++ {
+		if numLeaves > max(filePages, 1) {
+			return nil, fmt.Errorf("%w: leaf chain has a cycle", ErrCorrupt)
+		}
+		page, err := getPage(pager, pageNum)
+		if err != nil {
+			return nil, err
+		}
+		if nodeType(page) != NODE_LEAF {
+			return nil, fmt.Errorf("%w: page %d is not a leaf node", ErrCorrupt, pageNum)
+		}
+		numCells := leafNodeNumCells(page)
+		if numCells > maxCells {
+			return nil, fmt.Errorf("%w: leaf %d holds %d cells, more than fit", ErrCorrupt, pageNum, numCells)
+		}
+		for cellNum := range numCells {
+			offset := LEAF_NODE_HEADER_SIZE + cellNum*FIXED_LEAF_NODE_CELL_SIZE + LEAF_NODE_KEY_SIZE
+			var row Row
+			if err := readFixedRow(pager, page[offset:offset+FIXED_ROW_SIZE], &row); err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+		pageNum = leafNodeNextLeaf(page)
+		if pageNum == 0 {
+			return rows, nil
+		}
+	}
+}
+
+// readFixedRow decodes a fixed-size row, reading an email stored on overflow
+// pages back from them.
+func readFixedRow(pager *Pager, source []byte, destination *Row) error {
+	destination.id = rowID(source)
+	destination.username = fixedString(source[FIXED_USERNAME_OFFSET:FIXED_EMAIL_OFFSET])
+	email := source[FIXED_EMAIL_OFFSET:FIXED_ROW_SIZE]
+	if pager.version < OVERFLOW_FORMAT_VERSION || email[0] != 0 || email[1] != FIXED_OVERFLOW_MARKER {
+		destination.email = fixedString(email)
+		return nil
+	}
+	length := binary.LittleEndian.Uint32(email[FIXED_OVERFLOW_LENGTH_OFFSET:])
+	pageNum := binary.LittleEndian.Uint32(email[FIXED_OVERFLOW_PAGE_OFFSET:])
+	value, err := readOverflow(pager, pageNum, length)
+	if err != nil {
+		return fmt.Errorf("row %d: %w", destination.id, err)
+	}
+	destination.email = value
+	return nil
+}
+
+// fixedString reads a NUL-padded fixed-width field. A field filled to the
+// last byte has no terminator.
+func fixedString(field []byte) string {
+	if nullIndex := bytes.IndexByte(field, 0); nullIndex != -1 {
+		field = field[:nullIndex]
+	}
+	return string(field)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Statements are split into tokens before they are parsed. A token is a
+// keyword, a number, a string in double or single quotes, an operator, or
+// any other run of characters up to whitespace or an operator: a column name
+// or a value written without quotes, such as an email. Quotes only delimit a
+// string when they surround a whole token, so a value like "x"@example.com
+// is kept as written. A double-quoted string has no escapes and cannot
+// contain a double quote; in a single-quoted one, as in SQL, two single
+// quotes stand for one. Keywords are matched as written, in lower case.
+type TokenType int
+
+const (
+	TOKEN_END TokenType = iota // past the last token
+	TOKEN_KEYWORD
+	TOKEN_IDENTIFIER
+	TOKEN_NUMBER
+	TOKEN_STRING
+	TOKEN_OPERATOR
+)
+
+var tokenTypeNames = [...]string{
+	TOKEN_END:        "end of statement",
+	TOKEN_KEYWORD:    "keyword",
+	TOKEN_IDENTIFIER: "identifier",
+	TOKEN_NUMBER:     "number",
+	TOKEN_STRING:     "string",
+	TOKEN_OPERATOR:   "operator",
+}
+
+func (tokenType TokenType) String() string {
+	return tokenTypeNames[tokenType]
+}
+
+var keywords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "select": true,
+	"set": true, "count": true, "where": true, "order": true, "by": true,
+	"asc": true, "desc": true, "limit": true, "and": true, "or": true, "from": true,
+	"create": true, "table": true, "drop": true, "if": true, "exists": true,
+	"into": true, "values": true,
+}
+
+// operators, longest first so "<=" is not read as "<"
+var operators = []string{"<=", ">=", "!=", "=", "<", ">", "(", ")", ",", "*"}
+
+// Token is one token of a statement. Text is the token as written, without
+// the quotes of a string, and Pos and End the byte offsets it starts and ends
+// at.
+type Token struct {
+	Type     TokenType
+	Text     string
+	Pos, End int
+}
+
+// String describes the token for an error message.
+func (token Token) String() string {
+	if token.Type == TOKEN_END {
+		return token.Type.String()
+	}
+	return fmt.Sprintf("%s %q", token.Type, token.Text)
+}
+
+// prepareError is a statement that cannot be prepared: why, as a
+// PrepareResult, and the byte offset of the token it went wrong at.
+type prepareError struct {
+	result  PrepareResult
+	pos     int
+	message string
+}
+
+func (err *prepareError) Error() string {
+	return fmt.Sprintf("at position %d: %s", err.pos+1, err.message)
+}
+
+// tokenize splits input into tokens, ending with a TOKEN_END.
+func tokenize(input string) ([]Token, error) {
+	var tokens []Token
+	pos := 0
+	for {
+		for pos < len(input) {
+			r, size := utf8.DecodeRuneInString(input[pos:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			pos += size
+		}
+		if pos == len(input) {
+			return append(tokens, Token{Type: TOKEN_END, Pos: pos, End: pos}), nil
+		}
+
+		rest := input[pos:]
+		if operator := operatorAt(rest); operator != "" {
+			tokens = append(tokens, Token{Type: TOKEN_OPERATOR, Text: operator, Pos: pos, End: pos + len(operator)})
+			pos += len(operator)
+			continue
+		}
+
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing == -1 {
+				return nil, &prepareError{PREPARE_SYNTAX_ERROR, pos, "string is never closed"}
+			}
+			if end := closing + 2; end == len(rest) || endsWord(rest[end:]) {
+				tokens = append(tokens, Token{Type: TOKEN_STRING, Text: rest[1 : end-1], Pos: pos, End: pos + end})
+				pos += end
+				continue
+			}
+		}
+
+		if rest[0] == '\'' {
+			text, end, closed := singleQuoted(rest)
+			if !closed {
+				return nil, &prepareError{PREPARE_SYNTAX_ERROR, pos, "string is never closed"}
+			}
+			if end == len(rest) || endsWord(rest[end:]) {
+				tokens = append(tokens, Token{Type: TOKEN_STRING, Text: text, Pos: pos, End: pos + end})
+				pos += end
+				continue
+			}
+		}
+
+		end := 0
+		for end < len(rest) && !endsWord(rest[end:]) {
+			_, size := utf8.DecodeRuneInString(rest[end:])
+			end += size
+		}
+		word := rest[:end]
+		tokenType := TOKEN_IDENTIFIER
+		if keywords[word] {
+			tokenType = TOKEN_KEYWORD
+		} else if isNumber(word) {
+			tokenType = TOKEN_NUMBER
+		}
+		tokens = append(tokens, Token{Type: tokenType, Text: word, Pos: pos, End: pos + end})
+		pos += end
+	}
+}
+
+// operatorAt returns the operator input starts with, if any.
+func operatorAt(input string) string {
+	for _, operator := range operators {
+		if strings.HasPrefix(input, operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+// singleQuoted reads the single-quoted string input starts with, returning
+// its text, with each pair of quotes made one, and the length of input it
+// takes up.
+func singleQuoted(input string) (text string, end int, closed bool) {
+	var builder strings.Builder
+	for end = 1; end < len(input); end++ {
+		if input[end] != '\'' {
+			builder.WriteByte(input[end])
+			continue
+		}
+		if end+1 < len(input) && input[end+1] == '\'' {
+			builder.WriteByte('\'')
+			end++
+			continue
+		}
+		return builder.String(), end + 1, true
+	}
+	return "", 0, false
+}
+
+// endsWord reports whether a word stops where input starts: at whitespace
+// or an operator.
+func endsWord(input string) bool {
+	r, _ := utf8.DecodeRuneInString(input)
+	return unicode.IsSpace(r) || operatorAt(input) != ""
+}
+
+// isNumber reports whether word is an integer, optionally signed.
+func isNumber(word string) bool {
+	digits := strings.TrimLeft(word, "+-")
+	if len(word)-len(digits) > 1 || digits == "" {
+		return false
+	}
+	return strings.TrimLeft(digits, "0123456789") == ""
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Token
+	}{
+		{
+			input: `select count(*)`,
+			want: []Token{
+				{TOKEN_KEYWORD, "select", 0, 6}, {TOKEN_KEYWORD, "count", 7, 12},
+				{TOKEN_OPERATOR, "(", 12, 13}, {TOKEN_OPERATOR, "*", 13, 14}, {TOKEN_OPERATOR, ")", 14, 15},
+				{TOKEN_END, "", 15, 15},
+			},
+		},
+		{
+			input: `insert -3 "John Doe"  "x"@example.com`,
+			want: []Token{
+				{TOKEN_KEYWORD, "insert", 0, 6}, {TOKEN_NUMBER, "-3", 7, 9},
+				{TOKEN_STRING, "John Doe", 10, 20}, {TOKEN_IDENTIFIER, `"x"@example.com`, 22, 37},
+				{TOKEN_END, "", 37, 37},
+			},
+		},
+		{
+			input: `values ('a@example.com', 'it''s', '', x'00ff', 'a'b)`,
+			want: []Token{
+				{TOKEN_KEYWORD, "values", 0, 6}, {TOKEN_OPERATOR, "(", 7, 8},
+				{TOKEN_STRING, "a@example.com", 8, 23}, {TOKEN_OPERATOR, ",", 23, 24},
+				{TOKEN_STRING, "it's", 25, 32}, {TOKEN_OPERATOR, ",", 32, 33},
+				{TOKEN_STRING, "", 34, 36}, {TOKEN_OPERATOR, ",", 36, 37},
+				{TOKEN_IDENTIFIER, "x'00ff'", 38, 45}, {TOKEN_OPERATOR, ",", 45, 46},
+				{TOKEN_IDENTIFIER, "'a'b", 47, 51}, {TOKEN_OPERATOR, ")", 51, 52},
+				{TOKEN_END, "", 52, 52},
+			},
+		},
+		{
+			input: `set email<=x>=1!=`,
+			want: []Token{
+				{TOKEN_KEYWORD, "set", 0, 3}, {TOKEN_IDENTIFIER, "email", 4, 9},
+				{TOKEN_OPERATOR, "<=", 9, 11}, {TOKEN_IDENTIFIER, "x", 11, 12},
+				{TOKEN_OPERATOR, ">=", 12, 14}, {TOKEN_NUMBER, "1", 14, 15}, {TOKEN_OPERATOR, "!=", 15, 17},
+				{TOKEN_END, "", 17, 17},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.input)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.input, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) =\n%v\nwant\n%v", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := tokenize(`insert 1 "open`); err == nil || err.Error() != "at position 10: string is never closed" {
+		t.Errorf("tokenize with an unclosed string: %v", err)
+	}
+	if _, err := tokenize(`insert 1 'it''s`); err == nil || err.Error() != "at position 10: string is never closed" {
+		t.Errorf("tokenize with an unclosed single-quoted string: %v", err)
+	}
+}
+
+func TestPrepareStatement_ShowsWhereTheSyntaxErrorIs(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("select order by name\nupdate 1 set username=a username=b\ninsert -1 a b\n"), &output, table, true)
+	want := "Syntax error. Could not parse statement.\n" +
+		"  select order by name\n" +
+		"                  ^ expected id, username or email, found identifier \"name\"\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  update 1 set username=a username=b\n" +
+		"                          ^ expected username or email, each at most once, found identifier \"username\"\n" +
+		"Error: id must be between 0 and 4294967295.\n" +
+		"  insert -1 a b\n" +
+		"         ^ id -1 is out of range\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestPrepareStatement_BuildsTheSyntaxTree(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Statement
+	}{
+		{"insert 1 user1 person1@example.com", &InsertStmt{Row: Row{id: 1, username: "user1", email: "person1@example.com"}}},
+		{"delete 7", &DeleteStmt{ID: 7}},
+		{"delete from t where k in (1, 2)", &DeleteStmt{From: true, Table: "t", Where: &Comparison{Column: "k", Operator: "in", Values: []string{"1", "2"}}}},
+		{"delete from t", &DeleteStmt{From: true, Table: "t"}},
+		{"update 2 set email = b@example.com", &UpdateStmt{ID: 2, Set: []Assignment{{"email", "b@example.com"}}}},
+		{"update 2 a b", &UpdateStmt{ID: 2, Set: []Assignment{{"username", "a"}, {"email", "b"}}}},
+		{"update t set a = 1, b = null where k > 2", &UpdateStmt{Table: "t", Columns: []string{"a", "b"}, Values: []Literal{{Text: "1"}, {Text: "null", Null: true}}, Where: &Comparison{Column: "k", Operator: ">", Value: "2"}}},
+		{"select", &SelectStmt{}},
+		{"select count(*) 3", &SelectStmt{Count: true, Where: &Comparison{Column: "id", Operator: "=", Value: "3", ID: 3}}},
+		{`select where email "a b"`, &SelectStmt{Where: &Comparison{Column: "email", Operator: "=", Value: "a b"}}},
+		{"select order by id desc limit 2", &SelectStmt{OrderBy: "id", OrderDesc: true, HasLimit: true, Limit: 2}},
+	}
+	for _, tt := range tests {
+		got, err := prepareStatement(tt.input)
+		if err != nil {
+			t.Errorf("prepareStatement(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("prepareStatement(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A comparison may match a text column against a pattern instead:
+//
+//	select where email like %@example.com
+//	select username from users where username ilike a_n%
+//
+// In the pattern, % stands for any run of characters, none included, and _
+// for any one character; the rest matches itself. like tells case apart and
+// ilike does not. A pattern is read once, when the statement is prepared or
+// run, and matched by walking the text against it, going back only to the
+// last % when the rest does not fit, so no row compiles anything.
+
+// likeOperators are the words a comparison may use as operators, with
+// whether each ignores case.
+var likeOperators = map[string]bool{"like": false, "ilike": true}
+
+// isLike reports whether operator is one of likeOperators.
+func isLike(operator string) bool {
+	_, like := likeOperators[operator]
+	return like
+}
+
+// likePattern is the pattern of a like or ilike, ready to match texts.
+type likePattern struct {
+	runes []rune
+	fold  bool // case is ignored
+}
+
+func compileLike(pattern, operator string) *likePattern {
+	return &likePattern{runes: []rune(pattern), fold: likeOperators[operator]}
+}
+
+// match reports whether text matches the pattern, all of it.
+func (pattern *likePattern) match(text string) bool {
+	p, t := 0, 0
+	star, resume := -1, 0 // the last % met, and where in text it ends for now
+	for t < len(text) {
+		r, size := utf8.DecodeRuneInString(text[t:])
+		switch {
+		case p < len(pattern.runes) && pattern.runes[p] == '%':
+			star, resume = p, t
+			p++
+		case p < len(pattern.runes) && (pattern.runes[p] == '_' || pattern.equal(pattern.runes[p], r)):
+			p, t = p+1, t+size
+		case star != -1:
+			// the rest did not fit: the last % takes one more character
+			_, size := utf8.DecodeRuneInString(text[resume:])
+			resume += size
+			p, t = star+1, resume
+		default:
+			return false
+		}
+	}
+	for p < len(pattern.runes) && pattern.runes[p] == '%' {
+		p++
+	}
+	return p == len(pattern.runes)
+}
+
+func (pattern *likePattern) equal(a, b rune) bool {
+	return a == b || pattern.fold && unicode.ToLower(a) == unicode.ToLower(b)
+}
+
+// compileLikeTruth compiles comparison, a like or ilike of the column at i
+// in schema, which is unknown where the column is null.
+func compileLikeTruth(schema *Schema, i int, comparison *Comparison) (func(values []Value) truth, error) {
+	if schema.Columns[i].Type != COLUMN_TEXT {
+		return nil, fmt.Errorf("%w: %s matches text, and %s does not hold text", errTypeMismatch, comparison.Operator, comparison.Column)
+	}
+	pattern := compileLike(comparison.Value, comparison.Operator)
+	return func(values []Value) truth {
+		switch {
+		case values[i] == nil:
+			return TRUTH_UNKNOWN
+		case pattern.match(values[i].(string)):
+			return TRUTH_TRUE
+		}
+		return TRUTH_FALSE
+	}, nil
+}
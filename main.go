@@ -2,19 +2,45 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"cmp"
+	"container/list"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 type ExecuteResult uint8
 
 const (
-	EXECUTE_SUCCESS    ExecuteResult = 0
-	EXECUTE_TABLE_FULL ExecuteResult = 1
+	EXECUTE_SUCCESS           ExecuteResult = 0
+	EXECUTE_TABLE_FULL        ExecuteResult = 1
+	EXECUTE_ID_NOT_FOUND      ExecuteResult = 2
+	EXECUTE_DUPLICATE_KEY     ExecuteResult = 3
+	EXECUTE_IO_ERROR          ExecuteResult = 4
+	EXECUTE_READ_ONLY         ExecuteResult = 5
+	EXECUTE_UNKNOWN_STATEMENT ExecuteResult = 6
+	EXECUTE_TABLE_EXISTS      ExecuteResult = 7
+	EXECUTE_NO_SUCH_TABLE     ExecuteResult = 8
+	EXECUTE_NO_SUCH_COLUMN    ExecuteResult = 9
+	EXECUTE_TYPE_MISMATCH     ExecuteResult = 10
+	EXECUTE_VALUE_COUNT       ExecuteResult = 11
+	EXECUTE_STRING_TOO_LONG   ExecuteResult = 12
+	EXECUTE_CONSTRAINT_FAILED ExecuteResult = 13
+	EXECUTE_SUM_OVERFLOW      ExecuteResult = 14
 )
 
 type MetaCommandResult uint8
@@ -32,6 +58,10 @@ const (
 	PREPARE_UNRECOGNIZED_STATEMENT PrepareResult = 1
 	PREPARE_SYNTAX_ERROR           PrepareResult = 2
 	PREPARE_STRING_TOO_LONG        PrepareResult = 3
+	PREPARE_NEGATIVE_ID            PrepareResult = 4 // id outside the uint32 range, negative or too large
+	PREPARE_NO_SUCH_TABLE          PrepareResult = 5
+	PREPARE_NO_SUCH_COLUMN         PrepareResult = 6
+	PREPARE_TYPE_MISMATCH          PrepareResult = 7 // a value the column's type cannot hold
 )
 
 type StatementType uint8
@@ -39,329 +69,2871 @@ type StatementType uint8
 const (
 	STATEMENT_INSERT StatementType = 0
 	STATEMENT_SELECT StatementType = 1
+	STATEMENT_DELETE StatementType = 2
+	STATEMENT_UPDATE StatementType = 3
 )
 
 const (
-	COLUMN_USERNAME_SIZE = 32
-	COLUMN_EMAIL_SIZE    = 255
+	COLUMN_USERNAME_SIZE  = 32
+	COLUMN_EMAIL_SIZE     = 255 // longer emails are stored on overflow pages
+	COLUMN_EMAIL_MAX_SIZE = 64 * 1024
 )
 
+const ID_SIZE = 4 // uint32 -> 4 bytes
+
+type Row struct {
+	id       uint32
+	username string
+	email    string
+}
+
+// Statement is a parsed statement, the root of its syntax tree: one of
+// *InsertStmt, *SelectStmt, *UpdateStmt, *DeleteStmt, *CreateTableStmt or
+// *DropTableStmt.
+type Statement interface {
+	statementNode()
+}
+
+// InsertStmt is "insert <id> <username> <email>", "insert <username>
+// <email>" when AutoID is set, or "insert into <table> [(<column>, ...)]
+// values (<value>, ...)" when Table is set.
+type InsertStmt struct {
+	Row     Row
+	AutoID  bool // the row is given the next id; see nextID
+	Table   string
+	Columns []string // the columns Values are for, in order; nil for all of them
+	Values  []Literal
+}
+
+// Literal is a value as written in a statement on a table made by create
+// table: its text, without quotes, or null, written unquoted.
+type Literal struct {
+	Text string
+	Null bool
+}
+
+// SelectStmt is "select [count | <item>, ...] [<id> | where <condition>]
+// [<group> | <order>] [<limit>]" or, when Table is set, "select [count] [* |
+// <item>, ...] from <table> [<join>] [where <condition>] [<group> | <order>]
+// [<limit>]". A join is "[inner | left [outer]] join <table> on
+// <condition>" and an item a column or an aggregate; a group is "group by
+// <column>, ... [having <condition>]", an order "order [by] <column>
+// [asc|desc]" and a limit "limit <n> [offset <m>]". Columns may be named
+// <table>.<column>. Only a group may follow count or aggregates, and only a
+// grouped select mixes columns with aggregates.
+type SelectStmt struct {
+	Table      string
+	Join       string      // the table joined with Table, if any; see join.go
+	LeftJoin   bool        // keep the records of Table that join with none
+	On         Condition   // the condition the records joined meet
+	Columns    []string    // the columns printed, in order; nil for all of them
+	Aggregates []Aggregate // printed instead of the rows when not nil; see aggregate.go
+	Count      bool
+	Where      Condition // nil selects every row
+	GroupBy    []string  // the columns whose values group the rows aggregated
+	Having     Condition // nil prints every group
+	OrderBy    string    // the column rows are printed in the order of; "" for none
+	OrderDesc  bool
+	HasLimit   bool
+	Limit      uint32
+	Offset     uint32 // how many of the rows selected are skipped
+}
+
+// UpdateStmt assigns new values to columns of the row with id ID, or of
+// every row that meets Where when it is not nil. When Table is set, it is
+// "update <table> set <column> = <value>, ... where <condition>", which
+// assigns Values to Columns instead of Set.
+type UpdateStmt struct {
+	ID      uint32
+	Where   Condition
+	Set     []Assignment // at most one per column
+	Table   string
+	Columns []string // the columns Values are for, in order
+	Values  []Literal
+}
+
+// DeleteStmt is "delete <id>", or "delete from [where <condition>]", which
+// deletes every row that meets Where, or every row when Where is nil. When
+// Table is set, it is "delete from <table> [where <condition>]", which
+// deletes the records of that table instead.
+type DeleteStmt struct {
+	ID    uint32
+	From  bool
+	Where Condition
+	Table string
+}
+
+// Assignment is "<column> = <value>" in an update.
+type Assignment struct {
+	Column string
+	Value  string
+}
+
+// CreateTableStmt is "create table <name> (<column> <type>, ...)".
+type CreateTableStmt struct {
+	Schema Schema
+}
+
+// DropTableStmt is "drop table [if exists] <name>".
+type DropTableStmt struct {
+	Name     string
+	IfExists bool
+}
+
+func (*InsertStmt) statementNode()      {}
+func (*SelectStmt) statementNode()      {}
+func (*UpdateStmt) statementNode()      {}
+func (*DeleteStmt) statementNode()      {}
+func (*CreateTableStmt) statementNode() {}
+func (*DropTableStmt) statementNode()   {}
+
+// The database file starts with a fixed-size header; page n is stored at
+// HEADER_SIZE + n*pageSize, unless the pages are compressed (see compress.go).
+// Every integer in the file, its write-ahead log and its journal is
+// little-endian, whatever the machine, and written through encoding/binary.
+const (
+	HEADER_MAGIC            = "SIMPLEDB"
+	HEADER_MAGIC_SIZE       = len(HEADER_MAGIC)
+	HEADER_NUM_ROWS_OFFSET  = HEADER_MAGIC_SIZE
+	HEADER_NUM_ROWS_SIZE    = 4
+	HEADER_PAGE_SIZE_OFFSET = HEADER_NUM_ROWS_OFFSET + HEADER_NUM_ROWS_SIZE
+	HEADER_PAGE_SIZE_SIZE   = 4
+	HEADER_VERSION_OFFSET   = HEADER_PAGE_SIZE_OFFSET + HEADER_PAGE_SIZE_SIZE
+	HEADER_VERSION_SIZE     = 4
+	HEADER_ROOT_PAGE_OFFSET = HEADER_VERSION_OFFSET + HEADER_VERSION_SIZE
+	HEADER_ROOT_PAGE_SIZE   = 4
+	HEADER_FREE_PAGE_OFFSET = HEADER_ROOT_PAGE_OFFSET + HEADER_ROOT_PAGE_SIZE
+	HEADER_FREE_PAGE_SIZE   = 4
+	HEADER_NUM_FREE_OFFSET  = HEADER_FREE_PAGE_OFFSET + HEADER_FREE_PAGE_SIZE
+	HEADER_NUM_FREE_SIZE    = 4
+
+	HEADER_COMPRESSION_OFFSET      = HEADER_NUM_FREE_OFFSET + HEADER_NUM_FREE_SIZE
+	HEADER_COMPRESSION_SIZE        = 4
+	HEADER_DIRECTORY_OFFSET_OFFSET = HEADER_COMPRESSION_OFFSET + HEADER_COMPRESSION_SIZE
+	HEADER_DIRECTORY_OFFSET_SIZE   = 8
+	HEADER_DIRECTORY_PAGES_OFFSET  = HEADER_DIRECTORY_OFFSET_OFFSET + HEADER_DIRECTORY_OFFSET_SIZE
+	HEADER_DIRECTORY_PAGES_SIZE    = 4
+	HEADER_SALT_OFFSET             = HEADER_DIRECTORY_PAGES_OFFSET + HEADER_DIRECTORY_PAGES_SIZE
+	HEADER_SALT_SIZE               = 16
+
+	HEADER_SIZE = 64
+)
+
+// FORMAT_VERSION is bumped whenever the file layout changes in a way older
+// builds cannot read; new files are created with it. Headers written before
+// the field existed hold 0, which reads as version 1. Files of versions before
+// RECORD_FORMAT_VERSION are upgraded when opened.
+const FORMAT_VERSION = CATALOG_FORMAT_VERSION
+
+// The page size is chosen when a database is created and recorded in its
+// header. Headers written before the field existed hold 0, meaning the
+// default.
+const DEFAULT_PAGE_SIZE = 4096
+
+type Page []byte
+
+// MEMORY_FILENAME opens a table that lives only in the page cache.
+const MEMORY_FILENAME = ":memory:"
+
+type Pager struct {
+	mu         sync.Mutex // guards the page cache and the file offset in getPage
+	file       *os.File   // nil for an in-memory database
+	fileLength int64
+	mapped     []byte // the file mapped into memory, if OpenOptions.Mmap asked for it
+	pages      []Page // indexed by page number, grown on demand; nil until loaded
+	dirty      []bool // parallel to pages; set for pages changed since they were last written
+	changes    uint64 // bumped whenever a page may change, so a backup can tell; see backup.go
+
+	// The write-ahead log, if OpenOptions.WAL asked for one; see wal.go.
+	// walIndex locates the latest frame of every page in the log.
+	wal       *os.File
+	walLength int64
+	walIndex  map[uint32]int64
+	walFrames int // page frames in the log since the last checkpoint
+
+	// The rollback journal, if OpenOptions.Journal asked for one; see
+	// journal.go. journal stays nil until the statement in progress first
+	// writes to the file.
+	journaling    bool
+	journal       *os.File
+	journalLength int64
+	journalPages  uint32          // pages in the file when the journal was started
+	journaled     map[uint32]bool // pages whose original contents are in the journal
+	journalSynced bool
+
+	// Once more than maxCachedPages pages are resident, getPage evicts the
+	// least recently used ones, writing them back first if they are dirty.
+	// 0 means no limit. In-memory databases have nowhere to write evicted
+	// pages and never evict.
+	maxCachedPages int
+	lru            *list.List // resident page numbers, most recently used first
+	lruElements    map[uint32]*list.Element
+	keepDirty      bool // set during a transaction, when dirty pages must not reach the file
+	syncMode       SyncMode
+	holdPages      bool            // set while the tree is being restructured; see pagerHoldPages
+	pins           map[uint32]int  // pages that must stay cached, with how many pins each; see pagerPin
+	shared         map[uint32]bool // pinned pages readers may hold, which writers must copy; see pagerCopyOnWrite
+	snapshots      []*Snapshot     // open snapshots, which pages are saved for before they change
+
+	pageSize        uint32
+	internalMaxKeys uint32
+	numPages        uint32 // pages in use by the tree, whether or not they reached the file yet
+	freePage        uint32 // first page on the freelist, 0 if it is empty
+	numFreePages    uint32
+	version         uint32 // format version of the file, which decides the page layout
+	checksums       bool   // pages end in a checksum; see CHECKSUM_FORMAT_VERSION
+
+	// How the pages are stored in the file; see compress.go. The directory
+	// is only used with compression.
+	compression   uint32
+	directory     []pageSlot // where each page is stored, indexed by page number
+	directorySlot pageSlot   // where the directory itself is stored
+	dataEnd       int64      // end of the last slot, where new ones go
+
+	// The key of an encrypted file, nil if it is not; see encrypt.go.
+	salt     [HEADER_SALT_SIZE]byte
+	cipher   cipher.AEAD
+	reserved uint32 // bytes at the end of every page kept for the encryption trailer
+}
+
+// Table is safe for concurrent use: executors that modify rows take mu for
+// writing, and readers take it for reading.
+type Table struct {
+	mu          sync.RWMutex
+	numRows     uint32
+	pager       *Pager
+	rootPageNum uint32 // moves to a new page whenever the root splits
+	maxRows     uint32
+	ids         map[uint32]struct{} // ids of every stored row, for duplicate key checks
+	lastID      uint32              // the largest id the table has held; see nextID
+
+	readOnly       bool
+	compactOnClose uint32 // see OpenOptions.CompactOnClose
+
+	// see autoflush.go
+	flushEvery          int
+	unflushedStatements int
+	autoFlushStop       chan struct{} // closed to stop the goroutine flushing every FlushInterval
+	autoFlushDone       chan struct{} // closed by that goroutine once it stopped
+
+	// state saved by +begin for +rollback
+	inTransaction bool
+	savedNumRows  uint32
+	savedNumPages uint32
+	savedRootPage uint32
+	savedFreePage uint32
+	savedNumFree  uint32
+	savedPages    []Page // in-memory tables only; file-backed ones reload from disk
+
+	jsonOutput bool // print rows as JSON objects, toggled by +json
+
+	undo []mutation // the latest changes, newest last, for +undo
+
+	attached map[string]*Table // databases opened by +attach, by name; see attach.go
+
+	// The tables made by create table, listed in the catalog; see
+	// catalog.go. They share the pager and mu of the built-in table and use
+	// only its tree fields of their own: numRows, rootPageNum, maxRows and
+	// ids, along with schema, which is nil for the built-in table.
+	tables []*Table
+	schema *Schema
+}
+
+// SyncMode says when writes to the file are synced to disk, trading speed
+// for how much a crash of the machine, rather than of the process, can lose.
+type SyncMode int
+
 const (
-	ID_SIZE         = 4 // uint32 -> 4 bytes
-	USERNAME_SIZE   = COLUMN_USERNAME_SIZE
-	EMAIL_SIZE      = COLUMN_EMAIL_SIZE
-	ID_OFFSET       = 0
-	USERNAME_OFFSET = ID_OFFSET + ID_SIZE
-	EMAIL_OFFSET    = USERNAME_OFFSET + USERNAME_SIZE
-	ROW_SIZE        = ID_SIZE + USERNAME_SIZE + EMAIL_SIZE
+	// SYNC_NORMAL syncs the file whenever every change has been written back
+	// to it: on +flush, +commit and close, and when the write-ahead log is
+	// checkpointed.
+	SYNC_NORMAL SyncMode = iota
+
+	// SYNC_FULL also writes back and syncs every statement outside a
+	// transaction as soon as it finishes, or the write-ahead log it went to.
+	SYNC_FULL
+
+	// SYNC_OFF never syncs, leaving it to the operating system. A rollback
+	// journal then only protects against the process dying.
+	SYNC_OFF
 )
 
-type Row struct {
-	id       uint32
-	username string
-	email    string
+var syncModeNames = [...]string{SYNC_NORMAL: "normal", SYNC_FULL: "full", SYNC_OFF: "off"}
+
+func (mode SyncMode) String() string {
+	return syncModeNames[mode]
+}
+
+// parseSyncMode reads the name of a sync mode.
+func parseSyncMode(name string) (SyncMode, error) {
+	for mode, modeName := range syncModeNames {
+		if name == modeName {
+			return SyncMode(mode), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync mode %q; want full, normal or off", name)
+}
+
+// OpenOptions tune how dbOpenWith opens a database. The zero value gives the
+// defaults used by dbOpen.
+type OpenOptions struct {
+	// PageSize is the page size for a new database. Existing databases keep
+	// the page size they were created with; asking for a different one is an
+	// error.
+	PageSize uint32
+
+	// ReadOnly opens the file without write access. Statements that would
+	// modify the table are rejected and nothing is written back on close.
+	ReadOnly bool
+
+	// MaxCachedPages bounds how many pages stay in memory at once; 0 means
+	// no limit. A limit must be at least 2, since splitting a leaf needs both
+	// halves resident.
+	MaxCachedPages int
+
+	// Mmap maps the file into memory and reads pages straight out of the
+	// mapping instead of copying them in. Only read-only tables can map
+	// their file.
+	Mmap bool
+
+	// WAL sends every change through a write-ahead log next to the file,
+	// which a statement reaches whole or not at all. With SYNC_FULL a crash
+	// loses at most the statement in progress. See wal.go.
+	WAL bool
+
+	// Journal makes every statement atomic with a rollback journal next to
+	// the file, holding the pages it overwrites until it commits. See
+	// journal.go.
+	Journal bool
+
+	// Sync says when writes are synced to disk; see SyncMode.
+	Sync SyncMode
+
+	// Compress stores the pages of a new database compressed. Existing
+	// databases keep the layout they were created with; asking for
+	// compression of an uncompressed one is an error. See compress.go.
+	Compress bool
+
+	// Passphrase encrypts a new database, and must be given again to open
+	// it; a wrong one fails with ErrWrongPassphrase. Existing databases keep
+	// the choice they were created with. See encrypt.go.
+	Passphrase string
+
+	// CompactOnClose makes closing the database compact it, as +compact
+	// does, when more than this percentage of its pages are free. 0 never
+	// compacts. See compact.go.
+	CompactOnClose uint32
+
+	// FlushEvery writes the changes back to the file after every this many
+	// statements that change the table, and FlushInterval at least this
+	// often, from a background goroutine. 0 leaves it to +flush and close.
+	// See autoflush.go.
+	FlushEvery    int
+	FlushInterval time.Duration
+}
+
+func dbOpen(filename string) (*Table, error) {
+	return dbOpenWith(filename, OpenOptions{})
+}
+
+func dbOpenWith(filename string, options OpenOptions) (*Table, error) {
+	if options.MaxCachedPages < 0 || options.MaxCachedPages == 1 {
+		return nil, fmt.Errorf("page cache must hold at least 2 pages, not %d", options.MaxCachedPages)
+	}
+	if options.FlushEvery < 0 || options.FlushInterval < 0 {
+		return nil, errors.New("flushing can only be asked for every positive number of statements or interval")
+	}
+	if options.CompactOnClose > 100 {
+		return nil, fmt.Errorf("compaction threshold must be a percentage, not %d", options.CompactOnClose)
+	}
+	if options.Mmap && !options.ReadOnly {
+		return nil, errors.New("memory mapping needs a read-only database")
+	}
+	if options.WAL && options.ReadOnly {
+		return nil, errors.New("a write-ahead log needs a writable database")
+	}
+	if options.Journal && options.ReadOnly {
+		return nil, errors.New("a rollback journal needs a writable database")
+	}
+	if options.Journal && options.WAL {
+		return nil, errors.New("a rollback journal and a write-ahead log cannot be used together")
+	}
+
+	pager, err := pagerOpen(filename, options.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	pager.maxCachedPages = options.MaxCachedPages
+	pager.syncMode = options.Sync
+	if pager.file != nil {
+		if err := walRecover(pager, options.ReadOnly); err != nil {
+			pagerClose(pager)
+			return nil, err
+		}
+		if err := journalRecover(pager, options.ReadOnly); err != nil {
+			pagerClose(pager)
+			return nil, err
+		}
+	}
+	if options.Mmap {
+		if err := pagerMap(pager); err != nil {
+			pagerClose(pager)
+			return nil, err
+		}
+	}
+
+	header, err := readHeader(pager)
+	if err != nil {
+		pagerClose(pager)
+		return nil, err
+	}
+
+	pageSize := header.pageSize
+	if pageSize == 0 {
+		pageSize = options.PageSize
+		if pageSize == 0 {
+			pageSize = DEFAULT_PAGE_SIZE
+		}
+	} else if options.PageSize != 0 && options.PageSize != pageSize {
+		pagerClose(pager)
+		return nil, fmt.Errorf("database uses a page size of %d bytes, not %d", pageSize, options.PageSize)
+	}
+	version := header.version
+	if version == 0 {
+		version = FORMAT_VERSION
+	}
+	pager.salt = header.salt
+	if options.Passphrase != "" {
+		if pager.file == nil {
+			pagerClose(pager)
+			return nil, errors.New("in-memory databases cannot be encrypted")
+		}
+		if pager.fileLength == 0 {
+			pager.salt = newSalt()
+		} else if !encrypted(pager.salt) {
+			pagerClose(pager)
+			return nil, errors.New("database is not encrypted; encryption can only be chosen for a new database")
+		}
+	} else if encrypted(pager.salt) {
+		pagerClose(pager)
+		return nil, errors.New("database is encrypted; open it with its passphrase")
+	}
+	minPageSize := uint32(MIN_PAGE_SIZE)
+	if encrypted(pager.salt) {
+		pager.reserved = ENCRYPTION_TRAILER_SIZE
+		minPageSize += pager.reserved
+	}
+	// legacy files are upgraded to pages of the same size, so it must suit
+	// the tree whatever the file's version
+	if pageSize < minPageSize || pageSize > MAX_PAGE_SIZE {
+		pagerClose(pager)
+		return nil, fmt.Errorf("page size %d is outside the supported range of %d to %d bytes", pageSize, minPageSize, MAX_PAGE_SIZE)
+	}
+	pager.pageSize = pageSize
+	setNodeCapacity(pager)
+	pager.version = version
+	pager.checksums = version >= CHECKSUM_FORMAT_VERSION
+	pager.freePage = header.freePage
+	pager.numFreePages = header.numFreePages
+	pager.compression = header.compression
+	if options.Compress && pager.file != nil {
+		if pager.fileLength == 0 {
+			pager.compression = COMPRESSION_FLATE
+		} else if pager.compression == COMPRESSION_NONE {
+			pagerClose(pager)
+			return nil, errors.New("database is stored uncompressed; compression can only be chosen for a new database")
+		}
+	}
+	if pager.compression != COMPRESSION_NONE && (options.Mmap || options.WAL || options.Journal) {
+		pagerClose(pager)
+		return nil, errors.New("compressed databases cannot be mapped or used with a write-ahead log or rollback journal")
+	}
+	if encrypted(pager.salt) {
+		if options.Mmap || pager.compression != COMPRESSION_NONE {
+			pagerClose(pager)
+			return nil, errors.New("encrypted databases cannot be mapped or compressed")
+		}
+		if err := encryptionStart(pager, options.Passphrase); err != nil {
+			pagerClose(pager)
+			return nil, err
+		}
+	}
+
+	table := &Table{
+		pager:          pager,
+		numRows:        header.numRows,
+		rootPageNum:    header.rootPageNum,
+		maxRows:        math.MaxUint32,
+		readOnly:       options.ReadOnly,
+		compactOnClose: options.CompactOnClose,
+		flushEvery:     options.FlushEvery,
+	}
+
+	// stamp a brand-new file right away, so it is recognizable as a database
+	// even if the process dies before the first flush
+	if pager.file != nil && pager.fileLength == 0 && !options.ReadOnly {
+		if err := writeHeader(table); err != nil {
+			pagerClose(pager)
+			return nil, err
+		}
+		pager.fileLength = HEADER_SIZE
+	}
+	if pager.compression != COMPRESSION_NONE {
+		if err := loadDirectory(pager, header); err != nil {
+			pagerClose(pager)
+			return nil, err
+		}
+	}
+
+	if version < RECORD_FORMAT_VERSION {
+		err = upgradeLegacyTable(table)
+	} else {
+		err = openTree(table)
+	}
+	if err == nil {
+		err = loadIDs(table)
+	}
+	if err == nil {
+		err = loadCatalog(table)
+	}
+	if err == nil && options.WAL && pager.file != nil {
+		err = walOpen(pager)
+	}
+	table.pager.journaling = options.Journal && table.pager.file != nil
+	if err != nil {
+		pagerClose(table.pager)
+		return nil, err
+	}
+	if options.FlushInterval > 0 && table.pager.file != nil && !options.ReadOnly {
+		startAutoFlush(table, options.FlushInterval)
+	}
+
+	return table, nil
+}
+
+// openTree counts the pages of the tree in the table's file, creating the
+// root, a single empty leaf, if there is none yet.
+func openTree(table *Table) error {
+	pager := table.pager
+	if pager.compression != COMPRESSION_NONE {
+		pager.numPages = uint32(len(pager.directory))
+	} else if pager.fileLength > HEADER_SIZE {
+		pageSize := int64(pager.pageSize)
+		pager.numPages = uint32((pager.fileLength - HEADER_SIZE + pageSize - 1) / pageSize)
+	}
+	if pager.numPages > 0 {
+		return nil
+	}
+
+	pageNum, err := pagerAllocatePage(pager)
+	if err != nil {
+		return err
+	}
+	root, err := getPageForWrite(pager, pageNum)
+	if err != nil {
+		return err
+	}
+	initializeLeafNode(root)
+	table.rootPageNum = pageNum
+	return nil
+}
+
+// loadIDs rebuilds table.ids from the stored rows.
+func loadIDs(table *Table) error {
+	table.ids = make(map[uint32]struct{}, table.numRows)
+	table.lastID = 0
+	cursor := tableStart(table)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			return err
+		}
+		table.ids[rowID(slot)] = struct{}{}
+		table.lastID = rowID(slot)
+	}
+	return nil
+}
+
+type fileHeader struct {
+	numRows         uint32
+	pageSize        uint32 // 0 for a brand-new file
+	version         uint32 // 0 for a brand-new file
+	rootPageNum     uint32
+	freePage        uint32
+	numFreePages    uint32
+	compression     uint32
+	directoryOffset int64
+	directoryPages  uint32
+	salt            [HEADER_SALT_SIZE]byte
+}
+
+// readHeader validates the file header and returns the fields stored in it.
+// A brand-new empty file has no header yet and holds zero rows.
+func readHeader(pager *Pager) (fileHeader, error) {
+	if pager.fileLength == 0 {
+		return fileHeader{}, nil
+	}
+
+	var header [HEADER_SIZE]byte
+	n, err := pager.file.ReadAt(header[:], 0)
+	if err != nil && err != io.EOF {
+		return fileHeader{}, fmt.Errorf("error reading header: %w", err)
+	}
+	if n < HEADER_MAGIC_SIZE || string(header[:HEADER_MAGIC_SIZE]) != HEADER_MAGIC {
+		return fileHeader{}, fmt.Errorf("missing database header: not a simpledbgo database, or written by a version without headers")
+	}
+	if err == io.EOF {
+		return fileHeader{}, fmt.Errorf("database header truncated: file is only %d bytes", pager.fileLength)
+	}
+
+	version := binary.LittleEndian.Uint32(header[HEADER_VERSION_OFFSET : HEADER_VERSION_OFFSET+HEADER_VERSION_SIZE])
+	if version == 0 {
+		version = 1
+	}
+	if version > FORMAT_VERSION {
+		return fileHeader{}, fmt.Errorf("database format version %d is newer than the supported version %d", version, FORMAT_VERSION)
+	}
+
+	numRows := header[HEADER_NUM_ROWS_OFFSET : HEADER_NUM_ROWS_OFFSET+HEADER_NUM_ROWS_SIZE]
+	pageSize := header[HEADER_PAGE_SIZE_OFFSET : HEADER_PAGE_SIZE_OFFSET+HEADER_PAGE_SIZE_SIZE]
+	rootPageNum := header[HEADER_ROOT_PAGE_OFFSET : HEADER_ROOT_PAGE_OFFSET+HEADER_ROOT_PAGE_SIZE]
+	freePage := header[HEADER_FREE_PAGE_OFFSET : HEADER_FREE_PAGE_OFFSET+HEADER_FREE_PAGE_SIZE]
+	numFreePages := header[HEADER_NUM_FREE_OFFSET : HEADER_NUM_FREE_OFFSET+HEADER_NUM_FREE_SIZE]
+	compression := header[HEADER_COMPRESSION_OFFSET : HEADER_COMPRESSION_OFFSET+HEADER_COMPRESSION_SIZE]
+	directoryOffset := header[HEADER_DIRECTORY_OFFSET_OFFSET : HEADER_DIRECTORY_OFFSET_OFFSET+HEADER_DIRECTORY_OFFSET_SIZE]
+	directoryPages := header[HEADER_DIRECTORY_PAGES_OFFSET : HEADER_DIRECTORY_PAGES_OFFSET+HEADER_DIRECTORY_PAGES_SIZE]
+	decoded := fileHeader{
+		numRows:         binary.LittleEndian.Uint32(numRows),
+		pageSize:        binary.LittleEndian.Uint32(pageSize),
+		version:         version,
+		rootPageNum:     binary.LittleEndian.Uint32(rootPageNum),
+		freePage:        binary.LittleEndian.Uint32(freePage),
+		numFreePages:    binary.LittleEndian.Uint32(numFreePages),
+		compression:     binary.LittleEndian.Uint32(compression),
+		directoryOffset: int64(binary.LittleEndian.Uint64(directoryOffset)),
+		directoryPages:  binary.LittleEndian.Uint32(directoryPages),
+	}
+	copy(decoded.salt[:], header[HEADER_SALT_OFFSET:HEADER_SALT_OFFSET+HEADER_SALT_SIZE])
+	if decoded.compression >= uint32(len(compressionNames)) {
+		return fileHeader{}, fmt.Errorf("database uses unknown compression %d", decoded.compression)
+	}
+	if decoded.pageSize == 0 {
+		decoded.pageSize = DEFAULT_PAGE_SIZE
+	}
+	return decoded, nil
+}
+
+func encodeHeader(table *Table) [HEADER_SIZE]byte {
+	pager := table.pager
+	var header [HEADER_SIZE]byte
+	copy(header[:], HEADER_MAGIC)
+	binary.LittleEndian.PutUint32(header[HEADER_NUM_ROWS_OFFSET:HEADER_NUM_ROWS_OFFSET+HEADER_NUM_ROWS_SIZE], table.numRows)
+	binary.LittleEndian.PutUint32(header[HEADER_PAGE_SIZE_OFFSET:HEADER_PAGE_SIZE_OFFSET+HEADER_PAGE_SIZE_SIZE], pager.pageSize)
+	binary.LittleEndian.PutUint32(header[HEADER_VERSION_OFFSET:HEADER_VERSION_OFFSET+HEADER_VERSION_SIZE], pager.version)
+	binary.LittleEndian.PutUint32(header[HEADER_ROOT_PAGE_OFFSET:HEADER_ROOT_PAGE_OFFSET+HEADER_ROOT_PAGE_SIZE], table.rootPageNum)
+	binary.LittleEndian.PutUint32(header[HEADER_FREE_PAGE_OFFSET:HEADER_FREE_PAGE_OFFSET+HEADER_FREE_PAGE_SIZE], pager.freePage)
+	binary.LittleEndian.PutUint32(header[HEADER_NUM_FREE_OFFSET:HEADER_NUM_FREE_OFFSET+HEADER_NUM_FREE_SIZE], pager.numFreePages)
+	binary.LittleEndian.PutUint32(header[HEADER_COMPRESSION_OFFSET:HEADER_COMPRESSION_OFFSET+HEADER_COMPRESSION_SIZE], pager.compression)
+	binary.LittleEndian.PutUint64(header[HEADER_DIRECTORY_OFFSET_OFFSET:HEADER_DIRECTORY_OFFSET_OFFSET+HEADER_DIRECTORY_OFFSET_SIZE], uint64(pager.directorySlot.offset))
+	binary.LittleEndian.PutUint32(header[HEADER_DIRECTORY_PAGES_OFFSET:HEADER_DIRECTORY_PAGES_OFFSET+HEADER_DIRECTORY_PAGES_SIZE], uint32(len(pager.directory)))
+	copy(header[HEADER_SALT_OFFSET:HEADER_SALT_OFFSET+HEADER_SALT_SIZE], pager.salt[:])
+	return header
+}
+
+func writeHeader(table *Table) error {
+	header := encodeHeader(table)
+	if _, err := table.pager.file.WriteAt(header[:], 0); err != nil {
+		return fmt.Errorf("write header failed: %w", err)
+	}
+	return nil
+}
+
+func pagerFlush(pager *Pager, pageNum uint32) error {
+	if pager.file == nil || pageNum >= uint32(len(pager.pages)) || pager.pages[pageNum] == nil {
+		return nil
+	}
+	page := pager.pages[pageNum]
+	if pager.checksums {
+		putPageChecksum(pager, page)
+	}
+	if pager.cipher != nil {
+		page = encryptPage(pager, pageNum, page)
+	}
+	if pager.wal != nil {
+		return walWritePage(pager, pageNum, page)
+	}
+	if pager.compression != COMPRESSION_NONE {
+		if err := compressedWritePage(pager, pageNum, page); err != nil {
+			return err
+		}
+		pager.dirty[pageNum] = false
+		return nil
+	}
+	if pager.journaling {
+		if err := journalSavePage(pager, pageNum); err != nil {
+			return err
+		}
+		if err := journalSync(pager); err != nil {
+			return err
+		}
+	}
+	offset := HEADER_SIZE + int64(pageNum)*int64(pager.pageSize)
+	_, err := pager.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("seek failed: %w", err)
+	}
+	_, err = pager.file.Write(page)
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("write failed: %w: %w", ErrTableFull, err)
+	}
+	if err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+
+	pager.dirty[pageNum] = false
+	// an evicted page may be read back before the next flushAll trims the
+	// file, so getPage must know it is there
+	pager.fileLength = max(pager.fileLength, offset+int64(pager.pageSize))
+	return nil
+}
+
+// pagerEvict drops least recently used pages until at most maxCachedPages are
+// resident, writing dirty ones back first. While keepDirty is set dirty pages
+// are skipped instead, so the cache may stay over the limit. The most recently
+// used page is never evicted. Callers hold pager.mu.
+func pagerEvict(pager *Pager) error {
+	if pager.file == nil || pager.maxCachedPages == 0 || pager.holdPages {
+		return nil
+	}
+
+	element := pager.lru.Back()
+	for pager.lru.Len() > pager.maxCachedPages && element != pager.lru.Front() {
+		previous := element.Prev()
+		pageNum := element.Value.(uint32)
+		if pager.pins[pageNum] > 0 {
+			element = previous
+			continue
+		}
+		if pager.dirty[pageNum] {
+			if pager.keepDirty {
+				element = previous
+				continue
+			}
+			if err := pagerFlush(pager, pageNum); err != nil {
+				return err
+			}
+		}
+		pager.lru.Remove(element)
+		delete(pager.lruElements, pageNum)
+		pager.pages[pageNum] = nil
+		element = previous
+	}
+	return nil
+}
+
+// pagerReset replaces the page cache with pages, all of them clean. nil empties
+// the cache, so every page is read back from the file on next use.
+func pagerReset(pager *Pager, pages []Page) {
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+
+	pager.pages = pages
+	pager.dirty = make([]bool, len(pages))
+	pager.changes++
+	pager.shared = nil
+	invalidateSnapshots(pager)
+	pager.lru = list.New()
+	pager.lruElements = make(map[uint32]*list.Element)
+	for pageNum, page := range pages {
+		if page != nil {
+			pager.lruElements[uint32(pageNum)] = pager.lru.PushFront(uint32(pageNum))
+		}
+	}
+}
+
+func pagerOpen(filename string, readOnly bool) (*Pager, error) {
+	if filename == MEMORY_FILENAME {
+		return newPager(nil, 0), nil
+	}
+
+	flags := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flags = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	fileLength, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error seeking file: %w", err)
+	}
+
+	return newPager(file, fileLength), nil
+}
+
+// pagerMap maps the pager's file into memory. An empty file or an in-memory
+// database has nothing to map.
+func pagerMap(pager *Pager) error {
+	if pager.file == nil || pager.fileLength == 0 {
+		return nil
+	}
+	if pager.fileLength > math.MaxInt {
+		return fmt.Errorf("file of %d bytes is too large to map", pager.fileLength)
+	}
+	mapped, err := mmapFile(pager.file, int(pager.fileLength))
+	if err != nil {
+		return fmt.Errorf("map file: %w", err)
+	}
+	pager.mapped = mapped
+	return nil
+}
+
+// pagerClose closes the pager's file, unmapping it first if it is mapped.
+// No page from the mapping may be used afterwards.
+func pagerClose(pager *Pager) error {
+	if pager.mapped != nil {
+		if err := munmapFile(pager.mapped); err != nil {
+			pager.file.Close()
+			return fmt.Errorf("unmap file: %w", err)
+		}
+		pager.mapped = nil
+	}
+	return pager.file.Close()
+}
+
+func newPager(file *os.File, fileLength int64) *Pager {
+	return &Pager{
+		file:        file,
+		fileLength:  fileLength,
+		lru:         list.New(),
+		lruElements: make(map[uint32]*list.Element),
+	}
+}
+
+// flushAll writes every dirty page back to the file, keeping the pages cached,
+// and trims the file to the pages in use.
+func flushAll(table *Table) error {
+	pager := table.pager
+	if pager.file == nil || table.readOnly {
+		return nil
+	}
+	if pager.wal != nil {
+		return walCommit(table)
+	}
+	if pager.journaling {
+		if err := journalSaveChanges(pager); err != nil {
+			return err
+		}
+		// nothing changed since the last commit
+		if pager.journal == nil {
+			return nil
+		}
+	}
+
+	for pageNum, dirty := range pager.dirty {
+		// pages past the end of the tree are truncated below
+		if !dirty || uint32(pageNum) >= pager.numPages {
+			continue
+		}
+		if err := pagerFlush(pager, uint32(pageNum)); err != nil {
+			return err
+		}
+	}
+	if pager.compression != COMPRESSION_NONE {
+		return compressedFlush(table)
+	}
+
+	if err := writeHeader(table); err != nil {
+		return err
+	}
+
+	fileLength := HEADER_SIZE + int64(pager.numPages)*int64(pager.pageSize)
+	if err := pager.file.Truncate(fileLength); err != nil {
+		return fmt.Errorf("truncate failed: %w", err)
+	}
+	pager.fileLength = fileLength
+
+	if pager.journaling {
+		return journalCommit(pager)
+	}
+	return pagerSync(pager)
+}
+
+// pagerSync syncs the file, unless the sync mode is SYNC_OFF.
+func pagerSync(pager *Pager) error {
+	if pager.syncMode == SYNC_OFF {
+		return nil
+	}
+	if err := pager.file.Sync(); err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	return nil
+}
+
+func dbClose(table *Table) error {
+	stopAutoFlush(table)
+	if err := closeAttached(table); err != nil {
+		return err
+	}
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	pager := table.pager
+
+	// closing without +commit abandons the transaction
+	if table.inTransaction {
+		if err := discardTransaction(table); err != nil {
+			return err
+		}
+	}
+
+	if err := compactOnClose(table); err != nil {
+		return err
+	}
+	if err := flushAll(table); err != nil {
+		return err
+	}
+	if err := walClose(table); err != nil {
+		return err
+	}
+
+	// the cache may point into the mapping, so let go of it first
+	pagerReset(pager, nil)
+
+	if pager.file != nil {
+		if err := pagerClose(pager); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dbFlush writes every change so far to the file, leaving the table open.
+func dbFlush(table *Table) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	// flushing mid-transaction would make the changes impossible to roll back
+	if table.inTransaction {
+		return errInsideTransaction
+	}
+	return flushAll(table)
+}
+
+// dbSnapshot flushes the table and copies the database file byte for byte to
+// path. The copy is written to a temporary file next to path and renamed into
+// place, so path never holds a half-written snapshot.
+func dbSnapshot(table *Table, path string) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	// the snapshot is a flushed copy of the file, and flushing would make
+	// uncommitted changes impossible to roll back
+	if table.inTransaction {
+		return errInsideTransaction
+	}
+	if err := flushAll(table); err != nil {
+		return err
+	}
+	if err := walCheckpoint(table); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	tmpFileName := tmpFile.Name()
+	defer os.Remove(tmpFileName) // no-op once the rename succeeded
+
+	if table.pager.file != nil {
+		source := io.NewSectionReader(table.pager.file, 0, table.pager.fileLength)
+		_, err = io.Copy(tmpFile, source)
+	} else {
+		err = writeImage(table, tmpFile)
+	}
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("copy snapshot: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmpFileName, path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	return nil
+}
+
+// dbVacuum rebuilds the tree from the live rows, and those of the tables made
+// by create table from their records, into as few pages as they fit in,
+// leaving out the freelist and the room deletes left in the nodes, and
+// returns how many pages that saved. The new tree is written to a temporary
+// file that is renamed over the original, so an interrupted vacuum leaves
+// the old file untouched.
+func dbVacuum(table *Table) (reclaimed uint32, err error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.inTransaction {
+		return 0, errInsideTransaction
+	}
+	if table.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	pager := table.pager
+	rows, err := collectRows(table)
+	if err != nil {
+		return 0, err
+	}
+	rebuilt, err := buildTree(pager, rows)
+	if err != nil {
+		return 0, err
+	}
+	if err := copyTables(table, rebuilt); err != nil {
+		return 0, err
+	}
+
+	// the log or journal must not outlive the file it was written against
+	if pager.wal != nil || pager.journaling {
+		if err := flushAll(table); err != nil {
+			return 0, err
+		}
+		if err := walCheckpoint(table); err != nil {
+			return 0, err
+		}
+	}
+
+	reclaimed = pager.numPages - min(pager.numPages, rebuilt.pager.numPages)
+	table.rootPageNum = rebuilt.rootPageNum
+	if pager.file == nil {
+		pagerReset(pager, rebuilt.pager.pages)
+		pager.numPages = rebuilt.pager.numPages
+		pager.freePage, pager.numFreePages = rebuilt.pager.freePage, rebuilt.pager.numFreePages
+		pager.version = rebuilt.pager.version
+		return reclaimed, loadCatalog(table)
+	}
+	if err := replaceFile(pager, rebuilt, "vacuum"); err != nil {
+		return 0, err
+	}
+	return reclaimed, loadCatalog(table)
+}
+
+// buildTree returns an in-memory table holding rows, which must have distinct
+// ids, in pages of the size and encryption of like. Inserting them in id order
+// keeps the leaves full.
+func buildTree(like *Pager, rows []Row) (*Table, error) {
+	pager := newPager(nil, 0)
+	pager.pageSize = like.pageSize
+	pager.salt, pager.cipher, pager.reserved = like.salt, like.cipher, like.reserved
+	setNodeCapacity(pager)
+	pager.version = FORMAT_VERSION
+	pager.checksums = true
+	tree := &Table{pager: pager, ids: make(map[uint32]struct{}, len(rows))}
+	if err := openTree(tree); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(rows, func(a, b Row) int { return cmp.Compare(a.id, b.id) })
+	for i := range rows {
+		if _, exists := tree.ids[rows[i].id]; exists {
+			return nil, fmt.Errorf("%w: id %d is stored twice", ErrCorrupt, rows[i].id)
+		}
+		if err := insertRow(tree, &rows[i]); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// replaceFile replaces the file behind pager with the pages of source, and
+// starts over with an empty cache on the new file. source is written to a
+// temporary file named after purpose, which is then renamed over the
+// original.
+func replaceFile(pager *Pager, source *Table, purpose string) error {
+	path := pager.file.Name()
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"."+purpose+"-*")
+	if err != nil {
+		return fmt.Errorf("create %s file: %w", purpose, err)
+	}
+	tmpFileName := tmpFile.Name()
+	defer os.Remove(tmpFileName) // no-op once the rename succeeded
+
+	if pager.compression != COMPRESSION_NONE {
+		err = writeCompressedImage(source, tmpFile)
+	} else {
+		err = writeImage(source, tmpFile)
+	}
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("write %s file: %w", purpose, err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("sync %s file: %w", purpose, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("close %s file: %w", purpose, err)
+	}
+	if err := os.Rename(tmpFileName, path); err != nil {
+		return fmt.Errorf("rename %s file: %w", purpose, err)
+	}
+
+	// the old handle still points at the replaced file
+	replaced, err := pagerOpen(path, false)
+	if err != nil {
+		return err
+	}
+	pager.file.Close()
+	pager.file = replaced.file
+	pager.fileLength = replaced.fileLength
+	pager.numPages = source.pager.numPages
+	pager.freePage = source.pager.freePage
+	pager.numFreePages = source.pager.numFreePages
+	pager.version = source.pager.version
+	pager.checksums = source.pager.checksums
+	pagerReset(pager, nil)
+	if pager.compression != COMPRESSION_NONE {
+		header, err := readHeader(pager)
+		if err != nil {
+			return err
+		}
+		return loadDirectory(pager, header)
+	}
+	return nil
+}
+
+// writeImage writes the table to w in the on-disk file format, reading the
+// pages through the page cache.
+func writeImage(table *Table, w io.Writer) error {
+	pager := table.pager
+	header := encodeHeader(table)
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	for pageNum := range pager.numPages {
+		page, err := getPage(pager, pageNum)
+		if err != nil {
+			return err
+		}
+		if pager.checksums {
+			putPageChecksum(pager, page)
+		}
+		if pager.cipher != nil {
+			page = encryptPage(pager, pageNum, page)
+		}
+		if _, err := w.Write(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getPage(pager *Pager, pageNum uint32) (Page, error) {
+	return fetchPage(pager, pageNum, false)
+}
+
+// getPageForWrite is getPage for a caller about to modify the page, which is
+// marked dirty.
+func getPageForWrite(pager *Pager, pageNum uint32) (Page, error) {
+	return fetchPage(pager, pageNum, true)
+}
+
+func fetchPage(pager *Pager, pageNum uint32, forWrite bool) (Page, error) {
+	// concurrent readers may all miss the cache at once
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+
+	filePages := pagerFilePages(pager)
+	// the cache grows to the largest page number fetched, so one read from a
+	// damaged node must not get past the pages there are
+	if int64(pageNum) >= max(int64(pager.numPages), filePages) {
+		return nil, fmt.Errorf("%w: page %d is past the last page", ErrCorrupt, pageNum)
+	}
+
+	if pageNum >= uint32(len(pager.pages)) {
+		pager.pages = append(pager.pages, make([]Page, int(pageNum)+1-len(pager.pages))...)
+		pager.dirty = append(pager.dirty, make([]bool, len(pager.pages)-len(pager.dirty))...)
+	}
+
+	if forWrite {
+		pager.dirty[pageNum] = true
+		pager.changes++
+	}
+
+	if pager.pages[pageNum] != nil {
+		pager.lru.MoveToFront(pager.lruElements[pageNum])
+	} else {
+		// cache miss. alocate memory and load from file
+		page, err := loadPage(pager, pageNum, filePages)
+		if err != nil {
+			return nil, err
+		}
+		pager.pages[pageNum] = page
+		pager.lruElements[pageNum] = pager.lru.PushFront(pageNum)
+		if err := pagerEvict(pager); err != nil {
+			return nil, err
+		}
+	}
+	pagerCopyOnWrite(pager, pageNum, forWrite)
+	if forWrite && len(pager.snapshots) > 0 {
+		snapshotSavePage(pager, pageNum)
+	}
+	return pager.pages[pageNum], nil
+}
+
+// pagerFilePages returns how many pages the file holds, counting a last page
+// cut short. Callers hold pager.mu.
+func pagerFilePages(pager *Pager) int64 {
+	if pager.compression != COMPRESSION_NONE {
+		return int64(len(pager.directory))
+	}
+	if pager.fileLength <= HEADER_SIZE {
+		return 0
+	}
+	pageSize := int64(pager.pageSize)
+	dataLength := pager.fileLength - HEADER_SIZE
+	filePages := dataLength / pageSize
+	if dataLength%pageSize != 0 {
+		filePages++
+	}
+	return filePages
+}
+
+// loadPage reads page pageNum from the file, of which there are filePages
+// pages, or returns a zeroed page past its end. Pages of a mapped file are
+// used in place; only read-only tables map their file, so they are never
+// written to. Callers hold pager.mu.
+func loadPage(pager *Pager, pageNum uint32, filePages int64) (Page, error) {
+	if pager.compression != COMPRESSION_NONE {
+		return compressedReadPage(pager, pageNum)
+	}
+	pageSize := int64(pager.pageSize)
+	offset := HEADER_SIZE + int64(pageNum)*pageSize
+	if end := offset + pageSize; end <= int64(len(pager.mapped)) {
+		page := Page(pager.mapped[offset:end:end])
+		if pager.checksums {
+			if err := verifyPageChecksum(pager, pageNum, page); err != nil {
+				return nil, err
+			}
+		}
+		return page, nil
+	}
+
+	page := make(Page, pager.pageSize)
+	if offset, ok := pager.walIndex[pageNum]; ok {
+		if _, err := pager.wal.ReadAt(page, offset); err != nil {
+			return nil, fmt.Errorf("error reading write-ahead log: %w", err)
+		}
+		if err := checkPage(pager, pageNum, page); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	if pager.file == nil || int64(pageNum) >= filePages {
+		return page, nil
+	}
+	_, err := pager.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking file: %w", err)
+	}
+
+	// version 1 files store their last page only up to its last row,
+	// so running out of file before the page is full is expected
+	bytesRead, err := io.ReadFull(pager.file, page)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	clear(page[bytesRead:])
+	if pager.checksums && bytesRead < len(page) {
+		return nil, fmt.Errorf("%w: page %d truncated: %d of %d bytes", ErrCorrupt, pageNum, bytesRead, len(page))
+	}
+	if err := checkPage(pager, pageNum, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// jsonRow is the shape of a row printed in JSON output mode.
+type jsonRow struct {
+	ID       uint32 `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// rowSchema describes the columns of the built-in table, so its rows print
+// as the records of a table made by create table do.
+var rowSchema = &Schema{Columns: []Column{
+	{Name: "id", Type: COLUMN_INT},
+	{Name: "username", Type: COLUMN_TEXT, Size: COLUMN_USERNAME_SIZE},
+	{Name: "email", Type: COLUMN_TEXT, Size: COLUMN_EMAIL_MAX_SIZE},
+}}
+
+// printRow prints the given columns of row, the indexes of those of
+// rowSchema, or all of them when columns is nil; see printRecord.
+func printRow(row *Row, columns []int, asJSON bool, writer *bufio.Writer) {
+	printRecord(rowSchema, rowValues(row), columns, asJSON, writer)
+}
+
+// rowValues returns the values of row in the columns of rowSchema.
+func rowValues(row *Row) []Value {
+	return []Value{int64(row.id), row.username, row.email}
+}
+
+func printStats(table *Table, writer *bufio.Writer) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	allocatedPages := 0
+	for _, page := range table.pager.pages {
+		if page != nil {
+			allocatedPages++
+		}
+	}
+
+	fmt.Fprintf(writer, "numRows = %d\n", table.numRows)
+	fmt.Fprintf(writer, "allocated pages = %d\n", allocatedPages)
+	fmt.Fprintf(writer, "PAGE_SIZE = %d\n", table.pager.pageSize)
+	fmt.Fprintf(writer, "leaf space = %d bytes\n", leafNodeSpace(table.pager))
+	fmt.Fprintf(writer, "INTERNAL_NODE_MAX_KEYS = %d\n", table.pager.internalMaxKeys)
+	fmt.Fprintf(writer, "root page = %d\n", table.rootPageNum)
+	fmt.Fprintf(writer, "free pages = %d\n", table.pager.numFreePages)
+	fmt.Fprintf(writer, "MAX_ROW_SIZE = %d\n", MAX_ROW_SIZE)
+	fmt.Fprintf(writer, "file length = %d bytes\n", table.pager.fileLength)
+	fmt.Fprintf(writer, "compression = %s\n", compressionNames[table.pager.compression])
+	fmt.Fprintf(writer, "encrypted = %t\n", table.pager.cipher != nil)
+}
+
+// printSchema describes the columns. Rows take only as many bytes as their
+// values need, up to MAX_ROW_SIZE with the email inline.
+func printSchema(writer *bufio.Writer) {
+	fmt.Fprintf(writer, "%-10s %-10s %8s\n", "column", "type", "max size")
+	fmt.Fprintf(writer, "%-10s %-10s %8d\n", "id", "uint32", ID_SIZE)
+	fmt.Fprintf(writer, "%-10s %-10s %8d\n", "username", fmt.Sprintf("text(%d)", COLUMN_USERNAME_SIZE), COLUMN_USERNAME_SIZE)
+	fmt.Fprintf(writer, "%-10s %-10s %8d\n", "email", fmt.Sprintf("text(%d)", COLUMN_EMAIL_MAX_SIZE), COLUMN_EMAIL_MAX_SIZE)
+	fmt.Fprintf(writer, "MAX_ROW_SIZE = %d\n", MAX_ROW_SIZE)
+}
+
+// printTableSchema describes the columns of a table made by create table, the
+// way printSchema does those of the built-in table.
+func printTableSchema(schema *Schema, writer *bufio.Writer) {
+	fmt.Fprintf(writer, "%-10s %-10s %8s\n", "column", "type", "max size")
+	for i, column := range schema.Columns {
+		columnType, size := column.typeName(), maxValueSize(column)
+		switch {
+		case i == 0:
+			columnType, size = "int (key)", ID_SIZE
+		case column.Type.sized():
+			size = uint64(column.Size)
+		}
+		fmt.Fprintf(writer, "%-10s %-10s %8d\n", column.Name, columnType, size)
+	}
+	fmt.Fprintf(writer, "max row size = %d\n", maxRecordSize(schema.Columns))
+}
+
+// metaArg matches a meta command that takes an argument, returning the
+// trimmed argument (empty if none was given).
+func metaArg(input string, command string) (string, bool) {
+	if input != command && !strings.HasPrefix(input, command+" ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(input, command)), true
+}
+
+func doMetaCommand(input string, table *Table, writer *bufio.Writer) MetaCommandResult {
+	if input == "+quit" {
+		return META_COMMAND_EXIT
+	}
+
+	if input == "+begin" || input == "+commit" || input == "+rollback" {
+		var err error
+		switch input {
+		case "+begin":
+			err = beginTransaction(table)
+		case "+commit":
+			err = commitTransaction(table)
+		case "+rollback":
+			err = rollbackTransaction(table)
+		}
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+undo" {
+		err := undoLastMutation(table)
+		if err == errNothingToUndo {
+			writer.WriteString("Nothing to undo.\n")
+		} else if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+stats" {
+		printStats(table, writer)
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+flush" {
+		if err := dbFlush(table); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+vacuum" {
+		reclaimed, err := dbVacuum(table)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		fmt.Fprintf(writer, "Reclaimed %d pages.\n", reclaimed)
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+compact"); ok {
+		limit := uint64(math.MaxUint32)
+		if arg != "" {
+			var err error
+			limit, err = strconv.ParseUint(arg, 10, 32)
+			if err != nil || limit == 0 {
+				writer.WriteString("Usage: +compact [max pages to move]\n")
+				return META_COMMAND_SUCCESS
+			}
+		}
+		moved, reclaimed, err := dbCompact(table, uint32(limit))
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		fmt.Fprintf(writer, "Moved %d pages, reclaimed %d pages.\n", moved, reclaimed)
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+integrity_check" {
+		problems, err := dbIntegrityCheck(table)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		if len(problems) == 0 {
+			writer.WriteString("ok\n")
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(writer, problem)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+schema"); ok {
+		if arg == "" {
+			printSchema(writer)
+			return META_COMMAND_SUCCESS
+		}
+		table.mu.RLock()
+		tree := findTable(table, arg)
+		table.mu.RUnlock()
+		if tree == nil {
+			fmt.Fprintf(writer, "Error: no table named %s.\n", arg)
+			return META_COMMAND_SUCCESS
+		}
+		printTableSchema(tree.schema, writer)
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+tables" {
+		table.mu.RLock()
+		defer table.mu.RUnlock()
+		if len(table.tables) == 0 {
+			writer.WriteString("(no tables)\n")
+		}
+		for _, tree := range table.tables {
+			fmt.Fprintf(writer, "%s, %d rows\n", tree.schema, tree.numRows)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+sync"); ok {
+		if arg == "" {
+			fmt.Fprintf(writer, "sync = %s\n", table.pager.syncMode)
+			return META_COMMAND_SUCCESS
+		}
+		mode, err := parseSyncMode(arg)
+		if err != nil {
+			writer.WriteString("Usage: +sync [full|normal|off]\n")
+			return META_COMMAND_SUCCESS
+		}
+		table.mu.Lock()
+		table.pager.syncMode = mode
+		table.mu.Unlock()
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+json"); ok {
+		if arg != "on" && arg != "off" {
+			writer.WriteString("Usage: +json on|off\n")
+			return META_COMMAND_SUCCESS
+		}
+		table.mu.Lock()
+		table.jsonOutput = arg == "on"
+		table.mu.Unlock()
+		return META_COMMAND_SUCCESS
+	}
+
+	if path, ok := metaArg(input, "+snapshot"); ok {
+		if path == "" {
+			writer.WriteString("Usage: +snapshot <path>\n")
+			return META_COMMAND_SUCCESS
+		}
+		if err := dbSnapshot(table, path); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if path, ok := metaArg(input, "+backup"); ok {
+		if path == "" {
+			writer.WriteString("Usage: +backup <path>\n")
+			return META_COMMAND_SUCCESS
+		}
+		if err := dbBackup(table, path); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if path, ok := metaArg(input, "+clone"); ok {
+		if path == "" {
+			writer.WriteString("Usage: +clone <newfile>\n")
+			return META_COMMAND_SUCCESS
+		}
+		if err := dbClone(table, path); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+attach"); ok {
+		path, name, ok := strings.Cut(arg, " as ")
+		if !ok || strings.TrimSpace(path) == "" {
+			writer.WriteString("Usage: +attach <path> as <name>\n")
+			return META_COMMAND_SUCCESS
+		}
+		if err := attachDatabase(table, strings.TrimSpace(path), strings.TrimSpace(name)); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if name, ok := metaArg(input, "+detach"); ok {
+		if name == "" {
+			writer.WriteString("Usage: +detach <name>\n")
+			return META_COMMAND_SUCCESS
+		}
+		if err := detachDatabase(table, name); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if input == "+databases" {
+		fmt.Fprintf(writer, "%s\n", MAIN_DATABASE)
+		for _, name := range slices.Sorted(maps.Keys(table.attached)) {
+			fmt.Fprintf(writer, "%s\n", name)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+copy"); ok {
+		args := strings.Fields(arg)
+		from, to := uint64(0), uint64(math.MaxUint32)
+		var err error
+		if len(args) == 4 {
+			from, err = strconv.ParseUint(args[2], 10, 32)
+			if err == nil {
+				to, err = strconv.ParseUint(args[3], 10, 32)
+			}
+		}
+		if (len(args) != 2 && len(args) != 4) || err != nil {
+			writer.WriteString("Usage: +copy <from> <to> [<first id> <last id>]\n")
+			return META_COMMAND_SUCCESS
+		}
+		source, err := lookupDatabase(table, args[0])
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		target, err := lookupDatabase(table, args[1])
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		copied, err := copyRows(source, target, uint32(from), uint32(to))
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		fmt.Fprintf(writer, "Copied %d rows.\n", copied)
+		return META_COMMAND_SUCCESS
+	}
+
+	if arg, ok := metaArg(input, "+benchinsert"); ok {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			writer.WriteString("Usage: +benchinsert <n>\n")
+			return META_COMMAND_SUCCESS
+		}
+		if err := benchInsert(n, writer); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		return META_COMMAND_SUCCESS
+	}
+
+	if path, ok := metaArg(input, "+export"); ok {
+		if path == "" {
+			writer.WriteString("Usage: +export <path>\n")
+			return META_COMMAND_SUCCESS
+		}
+		exported, err := exportCSV(table, path)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return META_COMMAND_SUCCESS
+		}
+		fmt.Fprintf(writer, "Exported %d rows.\n", exported)
+		return META_COMMAND_SUCCESS
+	}
+
+	if path, ok := metaArg(input, "+import"); ok {
+		if path == "" {
+			writer.WriteString("Usage: +import <path>\n")
+			return META_COMMAND_SUCCESS
+		}
+		imported, err := importCSV(table, path, writer)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		}
+		fmt.Fprintf(writer, "Imported %d rows.\n", imported)
+		return META_COMMAND_SUCCESS
+	}
+
+	return META_COMMAND_UNRECOGNIZED_COMMAND
+}
+
+// prepareStatement parses input into the syntax tree of a statement. When it
+// cannot, the error is a *prepareError saying why and where.
+func prepareStatement(input string) (Statement, error) {
+	return prepareStatementIn(input, nil)
+}
+
+// prepareStatementIn parses input like prepareStatement, and checks the
+// tables it names, their columns and the values given for them against the
+// catalog of table.
+func prepareStatementIn(input string, table *Table) (Statement, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens, catalog: table}
+	statement, result := p.statement()
+	if result != PREPARE_SUCCESS {
+		return nil, p.err
+	}
+	return statement, nil
+}
+
+// prepareResult returns the kind of failure an error from prepareStatement
+// is, PREPARE_SUCCESS for none.
+func prepareResult(err error) PrepareResult {
+	var prepareErr *prepareError
+	switch {
+	case err == nil:
+		return PREPARE_SUCCESS
+	case errors.As(err, &prepareErr):
+		return prepareErr.result
+	}
+	return PREPARE_SYNTAX_ERROR
+}
+
+// parser reads the tokens of a statement in order. Whatever stops it is kept
+// in err, along with the token it stopped at.
+type parser struct {
+	input     string
+	tokens    []Token
+	next      int
+	err       *prepareError
+	anyColumn bool        // conditions name columns of a table made by create table
+	catalog   *Table      // to look up the tables named in; nil skips the checks
+	schema    *Schema     // the table named, once looked up in the catalog
+	checks    []Token     // where each check of a create table starts
+	having    *SelectStmt // the select whose having is being parsed, if any
+	joining   bool        // comparisons are of two columns, as in the on of a join
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.next]
+}
+
+// advance returns the next token and moves past it, unless it is the end.
+func (p *parser) advance() Token {
+	token := p.tokens[p.next]
+	if token.Type != TOKEN_END {
+		p.next++
+	}
+	return token
+}
+
+// accept moves past the next token if it is the keyword or operator text.
+func (p *parser) accept(text string) bool {
+	token := p.peek()
+	if (token.Type == TOKEN_KEYWORD || token.Type == TOKEN_OPERATOR) && token.Text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// failAt records that parsing stopped at token, and returns result.
+func (p *parser) failAt(token Token, result PrepareResult, message string) PrepareResult {
+	p.err = &prepareError{result, token.Pos, message}
+	return result
+}
+
+// fail records a syntax error at the next token: what was expected there.
+func (p *parser) fail(expected string) PrepareResult {
+	token := p.peek()
+	return p.failAt(token, PREPARE_SYNTAX_ERROR, fmt.Sprintf("expected %s, found %s", expected, token))
+}
+
+func (p *parser) expect(text string) PrepareResult {
+	if !p.accept(text) {
+		return p.fail(strconv.Quote(text))
+	}
+	return PREPARE_SUCCESS
+}
+
+func (p *parser) end() PrepareResult {
+	if p.peek().Type != TOKEN_END {
+		return p.fail("the end of the statement")
+	}
+	return PREPARE_SUCCESS
+}
+
+// adjacent reports whether the next token follows the one before it with no
+// whitespace in between.
+func (p *parser) adjacent() bool {
+	return p.next > 0 && p.peek().Type != TOKEN_END && p.peek().Pos == p.tokens[p.next-1].End
+}
+
+// id reads a row id, which must stand on its own.
+func (p *parser) id() (uint32, PrepareResult) {
+	if p.peek().Type != TOKEN_NUMBER {
+		return 0, p.fail("an id")
+	}
+	token := p.advance()
+	if p.adjacent() {
+		p.next--
+		return 0, p.fail("an id")
+	}
+	id, result := parseID(token.Text)
+	switch result {
+	case PREPARE_SUCCESS:
+		return id, result
+	case PREPARE_NEGATIVE_ID:
+		return 0, p.failAt(token, result, fmt.Sprintf("id %s is out of range", token.Text))
+	}
+	p.next--
+	return 0, p.fail("an id")
+}
+
+// value reads a value for column: a string, or everything up to the next
+// whitespace, operators included, so values need quotes only to hold
+// whitespace. A value too long for the column stops the parse there.
+func (p *parser) value(column string) (string, PrepareResult) {
+	first := p.peek()
+	if first.Type == TOKEN_END {
+		return "", p.fail("a value for " + column)
+	}
+	p.advance()
+	value := first.Text
+	if first.Type != TOKEN_STRING || p.adjacent() {
+		for p.adjacent() {
+			p.advance()
+		}
+		value = p.input[first.Pos:p.tokens[p.next-1].End]
+	}
+
+	limit := COLUMN_EMAIL_MAX_SIZE
+	if column == "username" {
+		limit = COLUMN_USERNAME_SIZE
+	}
+	if len(value) > limit {
+		return "", p.failAt(first, PREPARE_STRING_TOO_LONG, fmt.Sprintf("%s of %d bytes is longer than %d", column, len(value), limit))
+	}
+	return value, PREPARE_SUCCESS
+}
+
+func (p *parser) statement() (Statement, PrepareResult) {
+	keyword := p.advance()
+	if keyword.Type == TOKEN_KEYWORD {
+		switch keyword.Text {
+		case "insert":
+			if p.accept("into") {
+				return p.insertInto()
+			}
+			if p.peek().Type != TOKEN_NUMBER {
+				row, result := p.rowWithoutID()
+				return &InsertStmt{Row: row, AutoID: true}, result
+			}
+			row, result := p.row()
+			return &InsertStmt{Row: row}, result
+		case "update":
+			return p.update()
+		case "delete":
+			return p.deleteStatement()
+		case "select":
+			return p.selectStatement()
+		case "create":
+			return p.createTable()
+		case "drop":
+			return p.dropTable()
+		}
+	}
+	return nil, p.failAt(keyword, PREPARE_UNRECOGNIZED_STATEMENT, fmt.Sprintf("expected a statement, found %s", keyword))
+}
+
+// row parses "<id> <username> <email>".
+func (p *parser) row() (Row, PrepareResult) {
+	id, result := p.id()
+	if result != PREPARE_SUCCESS {
+		return Row{}, result
+	}
+	username, result := p.value("username")
+	if result != PREPARE_SUCCESS {
+		return Row{}, result
+	}
+	email, result := p.value("email")
+	if result != PREPARE_SUCCESS {
+		return Row{}, result
+	}
+	return Row{id: id, username: username, email: email}, p.end()
+}
+
+// rowWithoutID parses "<username> <email>", for a row to be given the next
+// id.
+func (p *parser) rowWithoutID() (Row, PrepareResult) {
+	username, result := p.value("username")
+	if result != PREPARE_SUCCESS {
+		return Row{}, result
+	}
+	email, result := p.value("email")
+	if result != PREPARE_SUCCESS {
+		return Row{}, result
+	}
+	return Row{username: username, email: email}, p.end()
+}
+
+// update parses "<id> <username> <email>", which replaces both columns,
+// "<id> set <column> = <value> ...", which assigns only the columns named,
+// or "set <column> = <value> ... where <condition>", which assigns them in
+// every row that meets the condition. The where clause cannot be left out,
+// so that no slip rewrites the whole table. A table name first makes it an
+// update of a table made by create table; see updateTable.
+func (p *parser) update() (Statement, PrepareResult) {
+	statement := &UpdateStmt{}
+	if p.peek().Type == TOKEN_IDENTIFIER {
+		if result := p.updateTable(statement); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		return statement, p.end()
+	}
+	if !p.accept("set") {
+		if set := p.tokens[min(p.next+1, len(p.tokens)-1)]; set.Type != TOKEN_KEYWORD || set.Text != "set" {
+			row, result := p.row()
+			return &UpdateStmt{ID: row.id, Set: []Assignment{{"username", row.username}, {"email", row.email}}}, result
+		}
+		id, result := p.id()
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		p.advance() // set
+		statement.ID = id
+		return statement, p.assignments(statement, false)
+	}
+
+	if result := p.assignments(statement, true); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	if result := p.expect("where"); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	where, result := p.where()
+	if result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	statement.Where = where
+	return statement, p.end()
+}
+
+// assignments parses "<column> = <value> ..." into statement, up to the end
+// of the statement or, if beforeWhere, a where.
+func (p *parser) assignments(statement *UpdateStmt, beforeWhere bool) PrepareResult {
+	for first := true; first || p.peek().Type != TOKEN_END && !(beforeWhere && p.peek().Type == TOKEN_KEYWORD && p.peek().Text == "where"); first = false {
+		column := p.peek()
+		if column.Type != TOKEN_IDENTIFIER {
+			return p.fail("a column")
+		}
+		assigned := slices.ContainsFunc(statement.Set, func(assignment Assignment) bool { return assignment.Column == column.Text })
+		if column.Text != "username" && column.Text != "email" || assigned {
+			// an unknown column, one assigned twice, or the id, which is
+			// the row's key and cannot change
+			return p.fail("username or email, each at most once")
+		}
+		p.advance()
+		if result := p.expect("="); result != PREPARE_SUCCESS {
+			return result
+		}
+		value, result := p.value(column.Text)
+		if result != PREPARE_SUCCESS {
+			return result
+		}
+		statement.Set = append(statement.Set, Assignment{column.Text, value})
+	}
+	return PREPARE_SUCCESS
+}
+
+// deleteStatement parses what follows delete: "<id>" or
+// "from [where <condition>]".
+func (p *parser) deleteStatement() (Statement, PrepareResult) {
+	if !p.accept("from") {
+		id, result := p.id()
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		return &DeleteStmt{ID: id}, p.end()
+	}
+
+	statement := &DeleteStmt{From: true}
+	if p.peek().Type == TOKEN_IDENTIFIER {
+		if result := p.deleteTable(statement); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		return statement, p.end()
+	}
+	if p.accept("where") {
+		where, result := p.where()
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		statement.Where = where
+	}
+	return statement, p.end()
+}
+
+// selection is the list of a select as written: the tokens naming the
+// columns it prints and those of its aggregates, to point at once the table
+// they are in is known.
+type selection struct {
+	columns   []Token
+	arguments []Token
+}
+
+// selectStatement parses what follows select: "[count] [<id>]",
+// "[count] where <condition>" or an order clause, any of them after the
+// columns or aggregates to print instead of count, and the first two
+// followed by an order clause when there is no count.
+func (p *parser) selectStatement() (Statement, PrepareResult) {
+	statement := &SelectStmt{}
+	var selected selection
+	if p.peek().Type == TOKEN_IDENTIFIER || p.isAggregate() {
+		var result PrepareResult
+		if selected, result = p.selectList(statement); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+	} else if p.accept("count") {
+		statement.Count = true
+		if p.accept("(") {
+			if result := p.expect("*"); result != PREPARE_SUCCESS {
+				return nil, result
+			}
+			if result := p.expect(")"); result != PREPARE_SUCCESS {
+				return nil, result
+			}
+		}
+	}
+
+	if selected.columns == nil && selected.arguments == nil && p.accept("*") || p.peek().Type == TOKEN_KEYWORD && p.peek().Text == "from" {
+		if result := p.expect("from"); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		return statement, p.selectFrom(statement, selected)
+	}
+	for _, column := range append(selected.columns, selected.arguments...) {
+		if column.Text != "id" && column.Text != "username" && column.Text != "email" && column.Text != "*" {
+			return nil, p.failAt(column, PREPARE_SYNTAX_ERROR, fmt.Sprintf("expected id, username or email, found %s", column))
+		}
+	}
+	for i, aggregate := range statement.Aggregates {
+		if result := p.checkAggregate(aggregate, selected.arguments[i], rowSchema); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+	}
+
+	switch token := p.peek(); {
+	case token.Type == TOKEN_END:
+		return statement, p.selectEnd(statement, selected)
+	case p.accept("where"):
+		column, next := p.peek(), p.tokens[min(p.next+1, len(p.tokens)-1)]
+		operand := p.tokens[min(p.next+2, len(p.tokens)-1)]
+		operator := next.Type == TOKEN_IDENTIFIER && (isLike(next.Text) || next.Text == "in" || next.Text == "between") && operand.Type != TOKEN_END
+		if column.Type == TOKEN_IDENTIFIER && column.Text == "email" && next.Type != TOKEN_OPERATOR && next.Type != TOKEN_END && !operator {
+			// "where email <email>", from before conditions had operators
+			p.advance()
+			email, result := p.value("email")
+			if result != PREPARE_SUCCESS {
+				return nil, result
+			}
+			statement.Where = &Comparison{Column: "email", Operator: "=", Value: email}
+			return statement, p.selectEnd(statement, selected)
+		}
+		where, result := p.where()
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		statement.Where = where
+		return statement, p.selectEnd(statement, selected)
+	case token.Type == TOKEN_NUMBER:
+		id, err := strconv.ParseUint(token.Text, 10, 32)
+		if err != nil {
+			return nil, p.fail("an id")
+		}
+		p.advance()
+		statement.Where = &Comparison{Column: "id", Operator: "=", Value: token.Text, ID: uint32(id)}
+		return statement, p.selectEnd(statement, selected)
+	case statement.Count:
+		return nil, p.fail("an id, where or the end of the statement")
+	}
+	return statement, p.selectEnd(statement, selected)
+}
+
+// selectList parses the columns or aggregates a select prints into
+// statement. With any aggregate, the columns go among them as items with no
+// function, which only a select with a group by may have.
+func (p *parser) selectList(statement *SelectStmt) (selection, PrepareResult) {
+	var selected selection
+	var items []Aggregate
+	var tokens []Token
+	hasAggregate := false
+	for {
+		if p.isAggregate() {
+			aggregate, argument, result := p.aggregate()
+			if result != PREPARE_SUCCESS {
+				return selected, result
+			}
+			items, tokens = append(items, aggregate), append(tokens, argument)
+			hasAggregate = true
+		} else {
+			column := p.peek()
+			if _, result := p.columnName(); result != PREPARE_SUCCESS {
+				return selected, result
+			}
+			items, tokens = append(items, Aggregate{Column: column.Text}), append(tokens, column)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	if hasAggregate {
+		statement.Aggregates, selected.arguments = items, tokens
+		return selected, PREPARE_SUCCESS
+	}
+	for _, item := range items {
+		statement.Columns = append(statement.Columns, item.Column)
+	}
+	selected.columns = tokens
+	return selected, PREPARE_SUCCESS
 }
 
-type Statement struct {
-	Type        StatementType
-	RowToInsert Row
+// selectEnd parses the group by, order and limit clauses that may end a
+// select, then the end of the statement. Grouped selects and those of
+// aggregates or count have no order or limit. A select of count(*) alone is
+// the older select count.
+func (p *parser) selectEnd(statement *SelectStmt, selected selection) PrepareResult {
+	if token := p.peek(); token.Type == TOKEN_IDENTIFIER && token.Text == "group" {
+		if result := p.selectGroup(statement, selected); result != PREPARE_SUCCESS {
+			return result
+		}
+		return p.end()
+	}
+	for i, item := range statement.Aggregates {
+		if item.Function == "" {
+			return p.failAt(selected.arguments[i], PREPARE_SYNTAX_ERROR, "a column cannot be selected along with aggregates without a group by")
+		}
+	}
+	if len(statement.Aggregates) == 1 && statement.Aggregates[0] == (Aggregate{Function: "count", Column: "*"}) {
+		statement.Aggregates = nil
+		statement.Count = true
+	}
+	switch token := p.peek(); {
+	case statement.Count || statement.Aggregates != nil:
+		return p.end()
+	case token.Type == TOKEN_KEYWORD && token.Text == "order":
+		return p.selectOrder(statement)
+	}
+	return p.selectLimit(statement)
 }
 
-func serializeRow(source *Row, destination []byte) {
-	destination[0] = byte(source.id)
-	destination[1] = byte(source.id >> 8)
-	destination[2] = byte(source.id >> 16)
-	destination[3] = byte(source.id >> 24)
+// selectGroup parses "group by <column>, ... [having <condition>]" into
+// statement, whose columns must each be grouped by. The having may compare
+// aggregates, and the columns grouped by, with values.
+func (p *parser) selectGroup(statement *SelectStmt, selected selection) PrepareResult {
+	p.advance() // group
+	if result := p.expect("by"); result != PREPARE_SUCCESS {
+		return result
+	}
+	for {
+		column, result := p.selectColumn(statement)
+		if result != PREPARE_SUCCESS {
+			return result
+		}
+		statement.GroupBy = append(statement.GroupBy, column.Text)
+		if !p.accept(",") {
+			break
+		}
+	}
 
-	usernameBytes := []byte(source.username)
-	bytesToCopy := min(len(usernameBytes), USERNAME_SIZE)
-	copy(destination[USERNAME_OFFSET:USERNAME_OFFSET+bytesToCopy], usernameBytes[:bytesToCopy])
-	for i := USERNAME_OFFSET + bytesToCopy; i < USERNAME_OFFSET+USERNAME_SIZE; i++ {
-		destination[i] = 0
+	switch {
+	case statement.Count:
+		statement.Count = false
+		statement.Aggregates = []Aggregate{{Function: "count", Column: "*"}}
+	case statement.Columns != nil:
+		for _, name := range statement.Columns {
+			statement.Aggregates = append(statement.Aggregates, Aggregate{Column: name})
+		}
+		statement.Columns, selected.arguments = nil, selected.columns
+	case statement.Aggregates == nil:
+		return p.failAt(p.tokens[0], PREPARE_SYNTAX_ERROR, "a grouped select names the columns or aggregates it prints")
+	}
+	for i, item := range statement.Aggregates {
+		if item.Function == "" && !isGrouped(p.schema, statement, item.Column) {
+			return p.failAt(selected.arguments[i], PREPARE_SYNTAX_ERROR, fmt.Sprintf("column %s is not grouped by", item.Column))
+		}
 	}
 
-	emailBytes := []byte(source.email)
-	bytesToCopy = min(len(emailBytes), EMAIL_SIZE)
-	copy(destination[EMAIL_OFFSET:EMAIL_OFFSET+EMAIL_SIZE], emailBytes[:bytesToCopy])
-	for i := EMAIL_OFFSET + bytesToCopy; i < EMAIL_OFFSET+EMAIL_SIZE; i++ {
-		destination[i] = 0
+	if token := p.peek(); token.Type != TOKEN_IDENTIFIER || token.Text != "having" {
+		return PREPARE_SUCCESS
+	}
+	p.advance()
+	p.having, p.anyColumn = statement, true
+	if statement.Table == "" {
+		p.schema = rowSchema
 	}
+	having, result := p.where()
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.Having = having
+	return PREPARE_SUCCESS
 }
 
-func deserializeRow(source []byte, destination *Row) {
-	destination.id = uint32(source[0]) | uint32(source[1])<<8 | uint32(source[2])<<16 | uint32(source[3])<<24
-
-	usernameBytes := source[USERNAME_OFFSET : USERNAME_OFFSET+USERNAME_SIZE]
-	nullIndex := bytes.IndexByte(usernameBytes, 0)
-	if nullIndex == -1 {
-		destination.username = string(usernameBytes)
-	} else {
-		destination.username = string(usernameBytes[:nullIndex])
+// selectColumn parses a column of the table a select names: id, username
+// or email, or any of the columns of a table made by create table.
+func (p *parser) selectColumn(statement *SelectStmt) (Token, PrepareResult) {
+	column := p.peek()
+	if statement.Table != "" {
+		if _, result := p.columnName(); result != PREPARE_SUCCESS {
+			return column, result
+		}
+		if p.schema != nil && columnIndex(p.schema, column.Text) == -1 {
+			return column, p.failAt(column, PREPARE_NO_SUCH_COLUMN, fmt.Sprintf("table %s has no column %s", p.schema.Name, column.Text))
+		}
+		return column, PREPARE_SUCCESS
 	}
-
-	emailBytes := source[EMAIL_OFFSET : EMAIL_OFFSET+EMAIL_SIZE]
-	nullIndex = bytes.IndexByte(emailBytes, 0)
-	if nullIndex == -1 {
-		destination.email = string(emailBytes)
-	} else {
-		destination.email = string(emailBytes[:nullIndex])
+	if column.Type != TOKEN_IDENTIFIER || column.Text != "id" && column.Text != "username" && column.Text != "email" {
+		return column, p.fail("id, username or email")
 	}
+	p.advance()
+	return column, PREPARE_SUCCESS
 }
 
-const PAGE_SIZE = 4096
-const TABLE_MAX_PAGES = 100
-const ROWS_PER_PAGE = PAGE_SIZE / ROW_SIZE
-const TABLE_MAX_ROWS = ROWS_PER_PAGE * TABLE_MAX_PAGES
+// selectOrder parses "order [by] <column> [asc|desc]" into statement, and
+// the limit clause that may follow.
+func (p *parser) selectOrder(statement *SelectStmt) PrepareResult {
+	if result := p.expect("order"); result != PREPARE_SUCCESS {
+		return result
+	}
+	p.accept("by")
+	column, result := p.selectColumn(statement)
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.OrderBy = column.Text
 
-type Page [PAGE_SIZE]byte
+	if !p.accept("asc") && p.accept("desc") {
+		statement.OrderDesc = true
+	}
 
-type Pager struct {
-	file       *os.File
-	fileLength uint32
-	pages      [TABLE_MAX_PAGES]*Page
+	return p.selectLimit(statement)
 }
 
-type Table struct {
-	numRows uint32
-	pager   *Pager
+// selectLimit parses "[limit <n> [offset <m>]]" into statement, then the end
+// of the statement. Offset is matched as an identifier, so it stays free for
+// usernames.
+func (p *parser) selectLimit(statement *SelectStmt) PrepareResult {
+	if p.accept("limit") {
+		limit, err := strconv.ParseUint(p.peek().Text, 10, 32)
+		if p.peek().Type != TOKEN_NUMBER || err != nil {
+			return p.fail("a number of rows")
+		}
+		p.advance()
+		statement.HasLimit = true
+		statement.Limit = uint32(limit)
+
+		if token := p.peek(); token.Type == TOKEN_IDENTIFIER && token.Text == "offset" {
+			p.advance()
+			offset, err := strconv.ParseUint(p.peek().Text, 10, 32)
+			if p.peek().Type != TOKEN_NUMBER || err != nil {
+				return p.fail("a number of rows")
+			}
+			p.advance()
+			statement.Offset = uint32(offset)
+		}
+	}
+	return p.end()
 }
 
-func rowSlot(table *Table, rowNum uint32) ([]byte, error) {
-	pageNum := rowNum / ROWS_PER_PAGE
-	page, err := getPage(table.pager, pageNum)
+// parseID parses a row id. It goes through an int64 so negative and
+// oversized ids can be told apart from garbage instead of wrapping around.
+func parseID(field string) (uint32, PrepareResult) {
+	id, err := strconv.ParseInt(field, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, PREPARE_NEGATIVE_ID
+	}
 	if err != nil {
-		return nil, err
+		return 0, PREPARE_SYNTAX_ERROR
 	}
-	rowOffset := rowNum % ROWS_PER_PAGE
-	byteOffset := rowOffset * ROW_SIZE
-	return page[byteOffset : byteOffset+ROW_SIZE], nil
+	if id < 0 || id > math.MaxUint32 {
+		return 0, PREPARE_NEGATIVE_ID
+	}
+	return uint32(id), PREPARE_SUCCESS
 }
 
-func dbOpen(filename string) *Table {
-	pager, _ := pagerOpen(filename)
-	numRows := pager.fileLength / ROW_SIZE
+// validateRow checks that the row's fields fit their columns.
+func validateRow(row *Row) PrepareResult {
+	if len(row.username) > COLUMN_USERNAME_SIZE {
+		return PREPARE_STRING_TOO_LONG
+	}
 
-	table := &Table{
-		pager:   pager,
-		numRows: numRows,
+	if len(row.email) > COLUMN_EMAIL_MAX_SIZE {
+		return PREPARE_STRING_TOO_LONG
 	}
 
-	return table
+	return PREPARE_SUCCESS
 }
 
-func pagerFlush(pager *Pager, pageNum uint32, size int) error {
-	if pager.pages[pageNum] == nil {
-		return nil
+// executeInsert runs an insert through Table.Insert, translating its errors
+// into results for the REPL.
+func executeInsert(statement *InsertStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	if statement.Table != "" {
+		return executeInsertInto(statement, table, writer)
 	}
-	offset := int64(pageNum) * int64(PAGE_SIZE)
-	_, err := pager.file.Seek(offset, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("seek failed: %w", err)
+	row := &statement.Row
+	var err error
+	if statement.AutoID {
+		_, err = table.InsertWithNextID(row.username, row.email)
+	} else {
+		err = table.Insert(row.id, row.username, row.email)
 	}
-	_, err = pager.file.Write(pager.pages[pageNum][:size])
-	if err != nil {
-		return fmt.Errorf("write failed: %w", err)
+	switch {
+	case err == nil:
+		return EXECUTE_SUCCESS
+	case errors.Is(err, ErrReadOnly):
+		return EXECUTE_READ_ONLY
+	case errors.Is(err, ErrTableFull):
+		return EXECUTE_TABLE_FULL
+	case errors.Is(err, ErrDuplicateKey):
+		return EXECUTE_DUPLICATE_KEY
+	default:
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
 	}
+}
 
-	return nil
+// rowWindow lets through the rows a select prints, in the order they are
+// selected: none of the first statement.Offset, and no more than its limit.
+type rowWindow struct {
+	skip    uint32
+	left    uint32
+	limited bool
 }
 
-func pagerOpen(filename string) (*Pager, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil {
-		return nil, err
+func newRowWindow(statement *SelectStmt) *rowWindow {
+	return &rowWindow{skip: statement.Offset, left: statement.Limit, limited: statement.HasLimit}
+}
+
+// admit reports whether the next row selected is printed.
+func (w *rowWindow) admit() bool {
+	switch {
+	case w.skip > 0:
+		w.skip--
+		return false
+	case !w.limited:
+		return true
+	case w.left == 0:
+		return false
 	}
+	w.left--
+	return true
+}
 
-	fileLength, err := file.Seek(0, io.SeekEnd)
+// done reports whether no later row will be printed, so the scan may stop.
+func (w *rowWindow) done() bool {
+	return w.limited && w.left == 0
+}
 
-	pager := &Pager{
-		file:       file,
-		fileLength: uint32(fileLength),
+func executeSelect(statement *SelectStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	if statement.Table != "" {
+		return executeSelectFrom(statement, table, writer)
 	}
+	table.mu.RLock()
+	defer table.mu.RUnlock()
 
-	for i := range TABLE_MAX_PAGES {
-		pager.pages[i] = nil
+	if statement.Count {
+		return executeSelectCount(statement, table, writer)
+	}
+	if statement.Aggregates != nil {
+		return executeSelectAggregates(statement, table, writer)
+	}
+	columns, err := projection(rowSchema, statement.Columns)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	// the rows are stored in id order, so ordering all of them by id needs
+	// no sort
+	if statement.OrderBy == "id" && statement.Where == nil && statement.HasLimit {
+		return executeSelectTopN(statement, columns, table, writer)
+	}
+	if statement.OrderBy == "id" && statement.Where == nil {
+		return executeSelectSorted(statement, columns, table, writer)
+	}
+	if statement.OrderBy != "" {
+		return executeSelectOrdered(statement, columns, table, writer)
 	}
 
-	return pager, nil
+	matched := false
+	window := newRowWindow(statement)
+	err = scanRows(table, statement.Where, func(row *Row) bool {
+		if window.admit() {
+			printRow(row, columns, table.jsonOutput, writer)
+			matched = true
+		}
+		return !window.done()
+	})
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	if statement.Where != nil && !matched {
+		writer.WriteString("(no rows)\n")
+	}
+	return EXECUTE_SUCCESS
 }
 
-func dbClose(table *Table) error {
-	pager := table.pager
-	numFullPages := table.numRows / ROWS_PER_PAGE
-
-	for i := range numFullPages {
-		if pager.pages[i] == nil {
-			continue
+// executeSelectCount prints the number of matching rows. Every stored id is
+// in table.ids, so counting every row or the row with one id reads none.
+func executeSelectCount(statement *SelectStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	var count uint32
+	comparison, isComparison := statement.Where.(*Comparison)
+	switch {
+	case statement.Where == nil:
+		count = table.numRows
+	case isComparison && comparison.Column == "id" && comparison.Operator == "=":
+		if _, exists := table.ids[comparison.ID]; exists {
+			count = 1
 		}
-		if err := pagerFlush(pager, i, PAGE_SIZE); err != nil {
-			return err
+	default:
+		if err := scanRows(table, statement.Where, func(*Row) bool { count++; return true }); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return EXECUTE_IO_ERROR
 		}
-		pager.pages[i] = nil
 	}
+	fmt.Fprintf(writer, "count: %d\n", count)
+	return EXECUTE_SUCCESS
+}
 
-	numAditionalRows := table.numRows % ROWS_PER_PAGE
-	if numAditionalRows > 0 {
-		pageNum := numFullPages
-		if pager.pages[pageNum] != nil {
-			size := int(numAditionalRows) * ROW_SIZE
-			if err := pagerFlush(pager, pageNum, size); err != nil {
-				return err
-			}
-			pager.pages[pageNum] = nil
+// executeSelectSorted prints every row ordered by id. The cursor already
+// visits rows in id order, so ascending output streams straight from the
+// scan; descending output is collected and printed backwards.
+func executeSelectSorted(statement *SelectStmt, columns []int, table *Table, writer *bufio.Writer) ExecuteResult {
+	var rows []Row
+	cursor := tableStart(table)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			fmt.Fprintf(writer, "Error reading page %d: %v\n", cursor.pageNum, err)
+			return EXECUTE_IO_ERROR
 		}
+		var row Row
+		if err := readRow(table.pager, slot, &row); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return EXECUTE_IO_ERROR
+		}
+		if !statement.OrderDesc {
+			printRow(&row, columns, table.jsonOutput, writer)
+			continue
+		}
+		rows = append(rows, row)
 	}
 
-	err := pager.file.Close()
-	if err != nil {
-		return err
-	}
-
-	for i := range pager.pages {
-		pager.pages[i] = nil
+	for i := len(rows) - 1; i >= 0; i-- {
+		printRow(&rows[i], columns, table.jsonOutput, writer)
 	}
 
-	return nil
+	return EXECUTE_SUCCESS
 }
 
-func getPage(pager *Pager, pageNum uint32) (*Page, error) {
-	if pageNum > TABLE_MAX_PAGES {
-		return nil, fmt.Errorf("tried to fetch page number out of bounds: %d > %d", pageNum, TABLE_MAX_PAGES)
+// executeSelectAggregates prints the aggregates of the rows that meet the
+// condition, for each group of them if the select groups them.
+func executeSelectAggregates(statement *SelectStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	grouping, err := newGrouping(rowSchema, statement)
+	if err != nil {
+		return tableResult(err, writer)
 	}
-
-	if pager.pages[pageNum] == nil {
-		// cache miss. alocate memory and load from file
-		page := new(Page)
-		numPages := pager.fileLength / PAGE_SIZE
-
-		if pager.fileLength%PAGE_SIZE != 0 {
-			numPages++
+	err = scanRows(table, statement.Where, func(row *Row) bool {
+		grouping.add(rowValues(row))
+		return true
+	})
+	if err == nil {
+		var printed int
+		if printed, err = grouping.print(table.jsonOutput, writer); err == nil && printed == 0 {
+			writer.WriteString("(no rows)\n")
 		}
+	}
+	return tableResult(err, writer)
+}
 
-		if pageNum <= numPages {
-			offset := int64(pageNum) * int64(PAGE_SIZE)
-			_, err := pager.file.Seek(offset, io.SeekStart)
-			if err != nil {
-				return nil, fmt.Errorf("error seeking file: %w", err)
-			}
+// executeSelectOrdered prints the rows that meet the condition ordered by
+// any column, sorting them in memory.
+func executeSelectOrdered(statement *SelectStmt, columns []int, table *Table, writer *bufio.Writer) ExecuteResult {
+	var records [][]Value
+	err := scanRows(table, statement.Where, func(row *Row) bool {
+		records = append(records, rowValues(row))
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	records = orderRecords(records, columnIndex(rowSchema, statement.OrderBy), statement)
+	for _, values := range records {
+		printRecord(rowSchema, values, columns, table.jsonOutput, writer)
+	}
+	if statement.Where != nil && len(records) == 0 {
+		writer.WriteString("(no rows)\n")
+	}
+	return EXECUTE_SUCCESS
+}
 
-			_, err = pager.file.Read(page[:])
-			if err != nil && err != io.EOF {
-				return nil, fmt.Errorf("error reading file: %w", err)
-			}
+// executeSelectTopN prints the first statement.Limit rows ordered by id,
+// after its offset, in a single scan, keeping only the current candidates in
+// memory.
+func executeSelectTopN(statement *SelectStmt, columns []int, table *Table, writer *bufio.Writer) ExecuteResult {
+	kept := min(uint64(statement.Offset)+uint64(statement.Limit), uint64(table.numRows))
+	best := newTopN(uint32(kept), statement.OrderDesc)
 
-			/*if bytesRead < PAGE_SIZE {
-				for i := bytesRead; i < PAGE_SIZE; i++ {
-					page[i] = 0
-				}
-			}*/
+	var row Row
+	cursor := tableStart(table)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		// the scan visits ids in ascending order, so once the candidates
+		// are full no later row can beat them
+		if !statement.OrderDesc && best.full() {
+			break
 		}
-		pager.pages[pageNum] = page
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			fmt.Fprintf(writer, "Error reading page %d: %v\n", cursor.pageNum, err)
+			return EXECUTE_IO_ERROR
+		}
+		// most rows lose against the current candidates, so check the id
+		// before paying for the string fields
+		if !best.accepts(rowID(slot)) {
+			continue
+		}
+		if err := readRow(table.pager, slot, &row); err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			return EXECUTE_IO_ERROR
+		}
+		best.add(row)
+	}
+
+	rows := best.sorted()
+	for _, row := range rows[min(int(statement.Offset), len(rows)):] {
+		printRow(&row, columns, table.jsonOutput, writer)
 	}
-	return pager.pages[pageNum], nil
-}
 
-func printRow(row *Row, writer *bufio.Writer) {
-	writer.WriteString("(" + strconv.FormatUint(uint64(row.id), 10) + ", " + row.username + ", " + row.email + ")\n")
+	return EXECUTE_SUCCESS
 }
 
-func doMetaCommand(input string) MetaCommandResult {
-	if input == "+quit" {
-		return META_COMMAND_EXIT
+// executeDelete removes the row whose id matches, or the rows a delete from
+// selects.
+func executeDelete(statement *DeleteStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return EXECUTE_READ_ONLY
+	}
+	if statement.Table != "" {
+		return executeDeleteTable(statement, table, writer)
+	}
+	if statement.From {
+		return executeDeleteWhere(statement, table, writer)
 	}
-	return META_COMMAND_UNRECOGNIZED_COMMAND
-}
 
-func prepareStatement(input string, statement *Statement) PrepareResult {
-	if strings.HasPrefix(input, "insert") {
-		statement.Type = STATEMENT_INSERT
+	cursor, found, err := tableFind(table, statement.ID)
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	if !found {
+		return EXECUTE_ID_NOT_FOUND
+	}
 
-		var id uint32
-		var username, email string
+	var row Row
+	if err := removeRow(cursor, &row); err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	recordMutation(table, mutation{kind: STATEMENT_DELETE, rows: []Row{row}})
+	if err := keepLastID(table); err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	if err := commitStatement(table); err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
 
-		argsAssigned, err := fmt.Sscanf(input, "insert %d %s %s", &id, &username, &email)
+	return EXECUTE_SUCCESS
+}
 
-		if err != nil || argsAssigned < 3 {
-			return PREPARE_SYNTAX_ERROR
-		}
+// executeDeleteWhere removes every row that meets the statement's where
+// clause, or every row if it has none, and prints how many it removed. +undo
+// puts them all back at once. Callers hold table.mu for writing.
+func executeDeleteWhere(statement *DeleteStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	// removing a row rebalances leaves, so the rows are found before any
+	// of them goes
+	var ids []uint32
+	if err := scanRows(table, statement.Where, func(row *Row) bool { ids = append(ids, row.id); return true }); err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
 
-		if len(username) > COLUMN_USERNAME_SIZE {
-			return PREPARE_STRING_TOO_LONG
+	var removed []Row
+	var err error
+	for _, id := range ids {
+		var cursor *Cursor
+		if cursor, _, err = tableFind(table, id); err != nil {
+			break
 		}
-
-		if len(email) > COLUMN_EMAIL_SIZE {
-			return PREPARE_STRING_TOO_LONG
+		var row Row
+		if err = removeRow(cursor, &row); err != nil {
+			break
 		}
+		removed = append(removed, row)
+	}
+	// as with update ... where, the rows removed before an error stay
+	// removed and can be put back
+	if len(removed) > 0 {
+		recordMutation(table, mutation{kind: STATEMENT_DELETE, rows: removed})
+	}
+	if err == nil {
+		err = keepLastID(table)
+	}
+	if err == nil {
+		err = commitStatement(table)
+	}
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	fmt.Fprintf(writer, "Deleted %d rows.\n", len(removed))
+	return EXECUTE_SUCCESS
+}
 
-		statement.RowToInsert = Row{
-			id:       id,
-			username: username,
-			email:    email,
+// removeRow deletes the row under the cursor, storing it in removed. Callers
+// hold table.mu for writing.
+func removeRow(cursor *Cursor, removed *Row) (err error) {
+	table := cursor.table
+	// rebalancing may touch every node on the way up from the leaf
+	pagerHoldPages(table.pager)
+	defer func() {
+		if releaseErr := pagerReleasePages(table.pager); err == nil {
+			err = releaseErr
 		}
-
-		return PREPARE_SUCCESS
+	}()
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		return err
+	}
+	if err := readRow(table.pager, slot, removed); err != nil {
+		return err
+	}
+	if err := freeRowOverflow(table.pager, slot); err != nil {
+		return err
+	}
+	if err := leafNodeDelete(cursor); err != nil {
+		return err
 	}
+	table.numRows--
+	delete(table.ids, removed.id)
+	return nil
+}
 
-	if strings.HasPrefix(input, "select") {
-		statement.Type = STATEMENT_SELECT
-		return PREPARE_SUCCESS
+// executeUpdate rewrites the columns the statement assigns in the row whose
+// id matches, or in every row that meets its where clause, in place.
+func executeUpdate(statement *UpdateStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return EXECUTE_READ_ONLY
+	}
+	if statement.Table != "" {
+		return executeUpdateTable(statement, table, writer)
+	}
+	if statement.Where != nil {
+		return executeUpdateWhere(statement, table, writer)
 	}
 
-	return PREPARE_UNRECOGNIZED_STATEMENT
+	previous, found, err := updateRow(table, statement.ID, statement.Set)
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	if !found {
+		return EXECUTE_ID_NOT_FOUND
+	}
+	recordMutation(table, mutation{kind: STATEMENT_UPDATE, rows: []Row{previous}})
+	if err := commitStatement(table); err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+	return EXECUTE_SUCCESS
 }
 
-func executeInsert(statement *Statement, table *Table, writer *bufio.Writer) ExecuteResult {
-	if table.numRows >= TABLE_MAX_ROWS {
-		return EXECUTE_TABLE_FULL
+// executeUpdateWhere rewrites every row that meets the statement's where
+// clause and prints how many it rewrote. +undo reverts them all at once.
+// Callers hold table.mu for writing.
+func executeUpdateWhere(statement *UpdateStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	// a rewritten row can move to another leaf, so the rows are found
+	// before any of them changes
+	var ids []uint32
+	if err := scanRows(table, statement.Where, func(row *Row) bool { ids = append(ids, row.id); return true }); err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
 	}
 
-	rowToInsert := &statement.RowToInsert
-
-	slot, err := rowSlot(table, table.numRows)
+	var previous []Row
+	var err error
+	for _, id := range ids {
+		var row Row
+		if row, _, err = updateRow(table, id, statement.Set); err != nil {
+			break
+		}
+		previous = append(previous, row)
+	}
+	// the rows rewritten before an error stay rewritten, like those of
+	// any statement that fails part way, and can be undone
+	if len(previous) > 0 {
+		recordMutation(table, mutation{kind: STATEMENT_UPDATE, rows: previous})
+	}
+	if err == nil {
+		err = commitStatement(table)
+	}
 	if err != nil {
 		fmt.Fprintf(writer, "Error: %v\n", err)
-		return EXECUTE_SUCCESS // !
+		return EXECUTE_IO_ERROR
 	}
-	serializeRow(rowToInsert, slot)
-	table.numRows++
-
+	fmt.Fprintf(writer, "Updated %d rows.\n", len(previous))
 	return EXECUTE_SUCCESS
 }
 
-func executeSelect(table *Table, writer *bufio.Writer) ExecuteResult {
-	var row Row
-	for i := 0; i < int(table.numRows); i++ {
-		slot, err := rowSlot(table, uint32(i))
-		if err != nil {
-			fmt.Fprintf(writer, "Error reading row %d: %v\n", i, err)
-			continue
+// updateRow applies set to the row with id, returning the row as it was.
+// Callers hold table.mu for writing.
+func updateRow(table *Table, id uint32, set []Assignment) (previous Row, found bool, err error) {
+	cursor, found, err := tableFind(table, id)
+	if err != nil || !found {
+		return Row{}, found, err
+	}
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		return Row{}, true, err
+	}
+	if err := readRow(table.pager, slot, &previous); err != nil {
+		return Row{}, true, err
+	}
+	updated := previous
+	for _, assignment := range set {
+		switch assignment.Column {
+		case "username":
+			updated.username = assignment.Value
+		case "email":
+			updated.email = assignment.Value
 		}
-		deserializeRow(slot, &row)
-		printRow(&row, writer)
 	}
+	if err := rewriteRow(cursor, &updated); err != nil {
+		return Row{}, true, err
+	}
+	return previous, true, nil
+}
+
+// executeCreateTable creates an empty table. Undo does not reach tables made
+// by create table.
+func executeCreateTable(statement *CreateTableStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return EXECUTE_READ_ONLY
+	}
+	schema := statement.Schema
+	err := createTable(table, &schema)
+	if err == nil {
+		err = commitStatement(table)
+	}
+	switch {
+	case err == nil:
+		return EXECUTE_SUCCESS
+	case errors.Is(err, errTableExists):
+		return EXECUTE_TABLE_EXISTS
+	case errors.Is(err, ErrTableFull):
+		return EXECUTE_TABLE_FULL
+	default:
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+}
 
-	return EXECUTE_SUCCESS
+// executeDropTable removes a table and its rows, and with if exists does
+// nothing if there is no such table.
+func executeDropTable(statement *DropTableStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return EXECUTE_READ_ONLY
+	}
+	if statement.IfExists && findTable(table, statement.Name) == nil {
+		return EXECUTE_SUCCESS
+	}
+	err := dropTable(table, statement.Name)
+	if err == nil {
+		err = commitStatement(table)
+	}
+	switch {
+	case err == nil:
+		return EXECUTE_SUCCESS
+	case errors.Is(err, errNoSuchTable):
+		return EXECUTE_NO_SUCH_TABLE
+	default:
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
 }
 
-func executeStatement(statement *Statement, table *Table, writer *bufio.Writer) ExecuteResult {
-	switch statement.Type {
-	case STATEMENT_INSERT:
+// executeStatement runs the statement whose syntax tree is statement.
+func executeStatement(statement Statement, table *Table, writer *bufio.Writer) ExecuteResult {
+	switch statement := statement.(type) {
+	case *InsertStmt:
 		return executeInsert(statement, table, writer)
-	case STATEMENT_SELECT:
-		return executeSelect(table, writer)
+	case *SelectStmt:
+		return executeSelect(statement, table, writer)
+	case *DeleteStmt:
+		return executeDelete(statement, table, writer)
+	case *UpdateStmt:
+		return executeUpdate(statement, table, writer)
+	case *CreateTableStmt:
+		return executeCreateTable(statement, table, writer)
+	case *DropTableStmt:
+		return executeDropTable(statement, table, writer)
+	default:
+		return EXECUTE_UNKNOWN_STATEMENT
+	}
+}
+
+// executeResultMessage is what the REPL prints after a statement finishes
+// with result.
+func executeResultMessage(result ExecuteResult) string {
+	switch result {
+	case EXECUTE_SUCCESS:
+		return "Executed."
+	case EXECUTE_TABLE_FULL:
+		return "Error: Table full."
+	case EXECUTE_ID_NOT_FOUND:
+		return "Error: id not found."
+	case EXECUTE_DUPLICATE_KEY:
+		return "Error: Duplicate key."
+	case EXECUTE_IO_ERROR:
+		return "Error: I/O failure."
+	case EXECUTE_READ_ONLY:
+		return "Error: database is read-only."
+	case EXECUTE_UNKNOWN_STATEMENT:
+		return "Error: unknown statement type."
+	case EXECUTE_TABLE_EXISTS:
+		return "Error: table already exists."
+	case EXECUTE_NO_SUCH_TABLE:
+		return "Error: no such table."
+	case EXECUTE_NO_SUCH_COLUMN:
+		return "Error: no such column."
+	case EXECUTE_TYPE_MISMATCH:
+		return "Error: value does not match the column's type."
+	case EXECUTE_VALUE_COUNT:
+		return "Error: wrong number of values."
+	case EXECUTE_STRING_TOO_LONG:
+		return "Error: String is too long."
+	case EXECUTE_CONSTRAINT_FAILED:
+		return "Error: constraint failed."
+	case EXECUTE_SUM_OVERFLOW:
+		return "Error: sum out of range."
 	default:
-		return EXECUTE_SUCCESS // change
+		return fmt.Sprintf("Error: unexpected result %d.", result)
 	}
 }
 
 func runREPL(input io.Reader, output io.Writer, table *Table) {
+	runREPLWith(input, output, table, false)
+}
+
+// runREPLWith is runREPL with batch mode optionally on. Batch mode is for
+// scripts: it prints no prompt and no "Executed." lines, only query results
+// and errors.
+func runREPLWith(input io.Reader, output io.Writer, table *Table, batch bool) {
 	reader := bufio.NewReader(input)
 	writer := bufio.NewWriter(output)
 	defer writer.Flush()
 
 	for {
-		writer.WriteString("simpledbgo > ")
-		writer.Flush()
+		if !batch {
+			writer.WriteString("simpledbgo > ")
+			writer.Flush()
+		}
 		input, err := reader.ReadString('\n')
 
 		if err != nil {
@@ -379,7 +2951,7 @@ func runREPL(input io.Reader, output io.Writer, table *Table) {
 
 		// meta commands
 		if command[0] == '+' {
-			switch doMetaCommand(command) {
+			switch doMetaCommand(command, table, writer) {
 			case META_COMMAND_SUCCESS:
 				continue
 			case META_COMMAND_UNRECOGNIZED_COMMAND:
@@ -390,37 +2962,137 @@ func runREPL(input io.Reader, output io.Writer, table *Table) {
 			}
 		}
 
+		target, command, err := statementDatabase(table, command)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+			continue
+		}
+		if target != table {
+			// the output format belongs to the session, not to a database
+			target.mu.Lock()
+			target.jsonOutput = table.jsonOutput
+			target.mu.Unlock()
+		}
+
 		// prepare SQL statements
-		var statement Statement
-		switch prepareStatement(command, &statement) {
+		statement, err := prepareStatementIn(command, target)
+		switch prepareResult(err) {
 		case PREPARE_SUCCESS:
 			// exec SQL statements
-			switch executeStatement(&statement, table, writer) {
-			case (EXECUTE_SUCCESS):
-				writer.WriteString("Executed.\n")
-			case (EXECUTE_TABLE_FULL):
-				writer.WriteString("Error: Table full.\n")
+			switch result := executeStatement(statement, target, writer); {
+			case result == EXECUTE_CONSTRAINT_FAILED, result == EXECUTE_SUM_OVERFLOW:
+				// described, in full, as it failed
+			case result != EXECUTE_SUCCESS || !batch:
+				writer.WriteString(executeResultMessage(result) + "\n")
 			}
 		case PREPARE_UNRECOGNIZED_STATEMENT:
 			writer.WriteString("Unrecognized keyword at start of " + command + ".\n")
-		case (PREPARE_SYNTAX_ERROR):
+		case PREPARE_SYNTAX_ERROR:
 			writer.WriteString("Syntax error. Could not parse statement.\n")
+			printSyntaxError(writer, command, err)
 		case PREPARE_STRING_TOO_LONG:
 			writer.WriteString("String is too long.\n")
+			printSyntaxError(writer, command, err)
+		case PREPARE_NEGATIVE_ID:
+			fmt.Fprintf(writer, "Error: id must be between 0 and %d.\n", uint32(math.MaxUint32))
+			printSyntaxError(writer, command, err)
+		case PREPARE_NO_SUCH_TABLE:
+			writer.WriteString(executeResultMessage(EXECUTE_NO_SUCH_TABLE) + "\n")
+			printSyntaxError(writer, command, err)
+		case PREPARE_NO_SUCH_COLUMN:
+			writer.WriteString(executeResultMessage(EXECUTE_NO_SUCH_COLUMN) + "\n")
+			printSyntaxError(writer, command, err)
+		case PREPARE_TYPE_MISMATCH:
+			writer.WriteString(executeResultMessage(EXECUTE_TYPE_MISMATCH) + "\n")
+			printSyntaxError(writer, command, err)
 		}
 	}
 }
 
+// printSyntaxError shows where in command parsing stopped, under it.
+func printSyntaxError(writer *bufio.Writer, command string, err error) {
+	var prepareErr *prepareError
+	if !errors.As(err, &prepareErr) {
+		return
+	}
+	column := utf8.RuneCountInString(command[:prepareErr.pos])
+	fmt.Fprintf(writer, "  %s\n  %s^ %s\n", command, strings.Repeat(" ", column), prepareErr.message)
+}
+
+// isTerminal reports whether file is an interactive terminal rather than a
+// pipe or a regular file.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: simpledbgo <database_file>")
+	pageSize := flag.Uint("pagesize", 0, fmt.Sprintf("page size in bytes for a new database (default %d)", DEFAULT_PAGE_SIZE))
+	readOnly := flag.Bool("readonly", false, "open the database without modifying it")
+	cachePages := flag.Int("cachepages", 0, "maximum number of pages kept in memory (0 for no limit)")
+	mmap := flag.Bool("mmap", false, "read pages straight from the file mapped into memory (needs -readonly)")
+	wal := flag.Bool("wal", false, "send every statement through a write-ahead log, so a crash never leaves one half written")
+	journal := flag.Bool("journal", false, "keep a rollback journal, so a crash never leaves a statement half written")
+	compress := flag.Bool("compress", false, "store the pages of a new database compressed")
+	passphraseFile := flag.String("passphrase-file", "", "encrypt a new database, or open an encrypted one, with the passphrase on the first line of this file")
+	compactOnClose := flag.Uint("autocompact", 0, "compact the database on close when more than this percentage of its pages are free (0 never does)")
+	flushEvery := flag.Int("flushevery", 0, "write changes back to the file after every n statements that make them (0 for only on +flush and close)")
+	flushInterval := flag.Duration("flushinterval", 0, "write changes back to the file this often, e.g. 30s (0 for only on +flush and close)")
+	syncName := flag.String("sync", SYNC_NORMAL.String(), "when to sync writes to disk: full (after every statement), normal (on flush, commit, close and checkpoints) or off")
+	batch := flag.Bool("batch", false, "print no prompt or \"Executed.\" lines (the default when input is not a terminal)")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: simpledbgo [-pagesize n] [-readonly] [-cachepages n] [-mmap] [-wal] [-journal] [-sync full|normal|off] [-compress] [-passphrase-file path] [-autocompact percent] [-flushevery n] [-flushinterval d] [-batch] <database_file>")
+		os.Exit(1)
+	}
+
+	if *pageSize > math.MaxUint32 {
+		fmt.Fprintf(os.Stderr, "Error: page size %d is too large\n", *pageSize)
+		os.Exit(1)
+	}
+	syncMode, err := parseSyncMode(*syncName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	filename := os.Args[1]
-	table := dbOpen(filename)
+	passphrase := ""
+	if *passphraseFile != "" {
+		contents, err := os.ReadFile(*passphraseFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		passphrase, _, _ = strings.Cut(string(contents), "\n")
+		passphrase = strings.TrimSuffix(passphrase, "\r")
+		if passphrase == "" {
+			fmt.Fprintf(os.Stderr, "Error: %s holds no passphrase\n", *passphraseFile)
+			os.Exit(1)
+		}
+	}
+
+	filename := flag.Arg(0)
+	table, err := dbOpenWith(filename, OpenOptions{
+		PageSize:       uint32(*pageSize),
+		ReadOnly:       *readOnly,
+		MaxCachedPages: *cachePages,
+		Mmap:           *mmap,
+		WAL:            *wal,
+		Journal:        *journal,
+		Sync:           syncMode,
+		Compress:       *compress,
+		Passphrase:     passphrase,
+		CompactOnClose: uint32(min(*compactOnClose, math.MaxUint32)),
+		FlushEvery:     *flushEvery,
+		FlushInterval:  *flushInterval,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
 
-	runREPL(os.Stdin, os.Stdout, table)
+	runREPLWith(os.Stdin, os.Stdout, table, *batch || !isTerminal(os.Stdin))
 
 	if err := dbClose(table); err != nil {
 		fmt.Fprintf(os.Stderr, "Error closing database: %v\n", err)
@@ -1,22 +1,103 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
-func TestIntegration_InsertAndSelect(t *testing.T) {
-	var tableFull strings.Builder
-	for i := 1; i <= TABLE_MAX_ROWS+1; i++ {
-		tableFull.WriteString(fmt.Sprintf("insert %d user%d person%d@example.com\n", i, i, i))
+// tempDBFile returns the path of an empty database file that is removed when
+// the test finishes.
+func tempDBFile(t *testing.T) string {
+	t.Helper()
+	return newTempDBFile(t)
+}
+
+// tempDBFileB is tempDBFile for benchmarks.
+func tempDBFileB(b *testing.B) string {
+	b.Helper()
+	return newTempDBFile(b)
+}
+
+func newTempDBFile(tb testing.TB) string {
+	tmpFile, err := os.CreateTemp("", "test_db_*.db")
+	if err != nil {
+		tb.Fatalf("failed to create temp file: %v", err)
+	}
+	tmpFileName := tmpFile.Name()
+	tmpFile.Close()
+
+	tb.Cleanup(func() { os.Remove(tmpFileName) })
+	return tmpFileName
+}
+
+// mustOpen opens filename or fails the test.
+func mustOpen(tb testing.TB, filename string) *Table {
+	tb.Helper()
+	table, err := dbOpen(filename)
+	if err != nil {
+		tb.Fatalf("dbOpen(%q): %v", filename, err)
+	}
+	return table
+}
+
+// insertRows returns REPL input inserting ids from..to with the usual
+// userN / personN@example.com fields.
+func insertRows(from, to int) string {
+	var sb strings.Builder
+	for i := from; i <= to; i++ {
+		sb.WriteString(fmt.Sprintf("insert %d user%d person%d@example.com\n", i, i, i))
+	}
+	return sb.String()
+}
+
+// putFixedRow stores row in the fixed-size layout of files written before
+// RECORD_FORMAT_VERSION.
+func putFixedRow(destination []byte, row Row) {
+	clear(destination[:FIXED_ROW_SIZE])
+	binary.LittleEndian.PutUint32(destination, row.id)
+	copy(destination[FIXED_USERNAME_OFFSET:FIXED_EMAIL_OFFSET], row.username)
+	copy(destination[FIXED_EMAIL_OFFSET:FIXED_ROW_SIZE], row.email)
+}
+
+// rowsPerLeaf is how many rows from insertRows, counting from 1, fill the
+// first leaf of a table with the default page size.
+var rowsPerLeaf = func() int {
+	used := uint32(0)
+	for id := 1; ; id++ {
+		row := Row{id: uint32(id), username: fmt.Sprintf("user%d", id), email: fmt.Sprintf("person%d@example.com", id)}
+		used += LEAF_NODE_CELL_POINTER_SIZE + LEAF_NODE_CELL_HEADER_SIZE + uint32(len(serializeRow(&row, overflowRef{})))
+		if used > DEFAULT_PAGE_SIZE-PAGE_CHECKSUM_SIZE-LEAF_NODE_HEADER_SIZE {
+			return id - 1
+		}
+	}
+}()
+
+// deleteRows returns REPL input deleting ids from..to.
+func deleteRows(from, to int) string {
+	var sb strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&sb, "delete %d\n", i)
 	}
-	tableFull.WriteString("+quit\n")
+	return sb.String()
+}
 
+func TestIntegration_InsertAndSelect(t *testing.T) {
 	tests := []struct {
 		name         string
+		startRows    uint32 // rows the table pretends to hold before input runs
 		input        string
 		wantContains []string
 		wantRows     int
@@ -34,12 +115,13 @@ func TestIntegration_InsertAndSelect(t *testing.T) {
 			wantRows: 1,
 		},
 		{
-			name:  "prints error message when table is full",
-			input: tableFull.String(),
+			name:      "prints error message when table is full",
+			startRows: math.MaxUint32 - 1,
+			input:     insertRows(1, 2) + "+quit\n",
 			wantContains: []string{
 				"Error: Table full.",
 			},
-			wantRows: TABLE_MAX_ROWS,
+			wantRows: math.MaxUint32,
 		},
 		{
 			name: "allows inserting strings that are the maximun length",
@@ -57,26 +139,1849 @@ func TestIntegration_InsertAndSelect(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			var output bytes.Buffer
 
-			tmpFile, err := os.CreateTemp("", "test_db_*.db")
-			if err != nil {
-				t.Fatalf("failed to create temp file: %v", err)
+			table := mustOpen(t, tempDBFile(t))
+			table.numRows = tt.startRows
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			for _, want := range tt.wantContains {
+				if !strings.Contains(got, want) {
+					t.Errorf("%s: output missing expected part %q\ngot:\n%s", tt.name, want, got)
+				}
+			}
+			if table.numRows != uint32(tt.wantRows) {
+				t.Errorf("%s: table.numRows = %d, want %d", tt.name, table.numRows, tt.wantRows)
 			}
-			tmpFileName := tmpFile.Name()
-			tmpFile.Close()
+		})
+	}
+}
 
-			defer os.Remove(tmpFileName)
+func TestIntegration_Delete(t *testing.T) {
+	pageBoundary := rowsPerLeaf + 1 // first row stored on the second page
 
-			table := dbOpen(tmpFileName)
+	tests := []struct {
+		name            string
+		input           string
+		wantContains    []string
+		wantNotContains []string
+		wantRows        int
+	}{
+		{
+			name:  "deletes the first row",
+			input: insertRows(1, 3) + "delete 1\nselect\n",
+			wantContains: []string{
+				"(2, user2, person2@example.com)",
+				"(3, user3, person3@example.com)",
+			},
+			wantNotContains: []string{"(1, user1, person1@example.com)"},
+			wantRows:        2,
+		},
+		{
+			name:  "deletes the last row",
+			input: insertRows(1, 3) + "delete 3\nselect\n",
+			wantContains: []string{
+				"(1, user1, person1@example.com)",
+				"(2, user2, person2@example.com)",
+			},
+			wantNotContains: []string{"(3, user3, person3@example.com)"},
+			wantRows:        2,
+		},
+		{
+			name:  "shifts rows across a page boundary",
+			input: insertRows(1, pageBoundary+1) + "delete 2\nselect\n",
+			wantContains: []string{
+				fmt.Sprintf("(%d, user%d, person%d@example.com)", pageBoundary, pageBoundary, pageBoundary),
+				fmt.Sprintf("(%d, user%d, person%d@example.com)", pageBoundary+1, pageBoundary+1, pageBoundary+1),
+			},
+			wantNotContains: []string{"(2, user2, person2@example.com)"},
+			wantRows:        pageBoundary,
+		},
+		{
+			name:         "reports a missing id and leaves the table unchanged",
+			input:        insertRows(1, 2) + "delete 7\n",
+			wantContains: []string{"Error: id not found."},
+			wantRows:     2,
+		},
+		{
+			name:         "rejects a delete without an id",
+			input:        "delete\n",
+			wantContains: []string{"Syntax error. Could not parse statement."},
+			wantRows:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
 
 			runREPL(strings.NewReader(tt.input), &output, table)
 			got := output.String()
 			for _, want := range tt.wantContains {
 				if !strings.Contains(got, want) {
-					t.Errorf("%s: output missing expected part %q\ngot:\n%s", tt.name, want, got)
+					t.Errorf("output missing expected part %q\ngot:\n%s", want, got)
+				}
+			}
+			for _, unwanted := range tt.wantNotContains {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("output contains unexpected part %q\ngot:\n%s", unwanted, got)
 				}
 			}
 			if table.numRows != uint32(tt.wantRows) {
-				t.Errorf("%s: table.numRows = %d, want %d", tt.name, table.numRows, tt.wantRows)
+				t.Errorf("table.numRows = %d, want %d", table.numRows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestIntegration_DeletePersists(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf+1)+"delete 1\n"), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.numRows != uint32(rowsPerLeaf) {
+		t.Errorf("table.numRows after reopen = %d, want %d", table.numRows, rowsPerLeaf)
+	}
+}
+
+func TestIntegration_Snapshot(t *testing.T) {
+	fileName := tempDBFile(t)
+	snapshotName := filepath.Join(t.TempDir(), "snapshot.db")
+
+	table := mustOpen(t, fileName)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	input := insertRows(1, rowsPerLeaf+3) + "delete 2\n+snapshot " + snapshotName + "\ninsert 1000 late late@example.com\n"
+	runREPL(strings.NewReader(input), &output, table)
+	if strings.Contains(output.String(), "Error") {
+		t.Fatalf("unexpected error in output:\n%s", output.String())
+	}
+
+	original, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read original: %v", err)
+	}
+	snapshotBytes, err := os.ReadFile(snapshotName)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	// the late insert only lives in the original's page cache
+	if !bytes.Equal(original, snapshotBytes) {
+		t.Errorf("snapshot is not a byte-for-byte copy of the flushed database")
+	}
+
+	snapshot := mustOpen(t, snapshotName)
+	defer dbClose(snapshot)
+	if snapshot.numRows != uint32(rowsPerLeaf+2) {
+		t.Errorf("snapshot.numRows = %d, want %d", snapshot.numRows, rowsPerLeaf+2)
+	}
+
+	var snapshotOutput bytes.Buffer
+	runREPL(strings.NewReader("select\n"), &snapshotOutput, snapshot)
+	got := snapshotOutput.String()
+	if strings.Contains(got, "(2, user2, person2@example.com)") {
+		t.Errorf("snapshot still contains the deleted row:\n%s", got)
+	}
+	if strings.Contains(got, "late@example.com") {
+		t.Errorf("snapshot contains a row inserted after it was taken:\n%s", got)
+	}
+	last := rowsPerLeaf + 3
+	if want := fmt.Sprintf("(%d, user%d, person%d@example.com)", last, last, last); !strings.Contains(got, want) {
+		t.Errorf("snapshot output missing %q\ngot:\n%s", want, got)
+	}
+}
+
+func TestIntegration_SelectOrderByLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{
+			name:       "returns the three largest ids in descending order",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\ninsert 1 a a@x.com\ninsert 7 g g@x.com\ninsert 5 e e@x.com\nselect order by id desc limit 3\n",
+			wantOutput: "(9, i, i@x.com)\n(7, g, g@x.com)\n(5, e, e@x.com)\n",
+		},
+		{
+			name:       "returns the smallest ids in ascending order",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\ninsert 1 a a@x.com\nselect order by id asc limit 2\n",
+			wantOutput: "(1, a, a@x.com)\n(4, d, d@x.com)\n",
+		},
+		{
+			name:       "rejects a limit without a count",
+			input:      "select order by id desc limit\n",
+			wantOutput: "Syntax error. Could not parse statement.\n",
+		},
+		{
+			name:       "skips the offset before the limit",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\ninsert 1 a a@x.com\ninsert 7 g g@x.com\ninsert 5 e e@x.com\nselect order by id desc limit 2 offset 1\n",
+			wantOutput: "> (7, g, g@x.com)\n(5, e, e@x.com)\nExecuted.\n",
+		},
+		{
+			name:       "an offset past the end prints nothing",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\nselect order by id limit 5 offset 2\n",
+			wantOutput: "Executed.\nsimpledbgo > Executed.\nsimpledbgo > Executed.\nsimpledbgo > ",
+		},
+		{
+			name:       "limits without an order",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\ninsert 1 a a@x.com\nselect username limit 2 offset 1\n",
+			wantOutput: "> (d)\n(i)\nExecuted.\n",
+		},
+		{
+			name:       "limits after a where",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\ninsert 1 a a@x.com\nselect where id > 1 limit 1\n",
+			wantOutput: "> (4, d, d@x.com)\nExecuted.\n",
+		},
+		{
+			name:       "orders by username with an offset",
+			input:      "insert 4 d d@x.com\ninsert 9 i i@x.com\ninsert 1 a a@x.com\nselect id order by username desc limit 5 offset 1\n",
+			wantOutput: "> (4)\n(1)\nExecuted.\n",
+		},
+		{
+			name:       "rejects an offset without a limit",
+			input:      "select offset 1\n",
+			wantOutput: "Syntax error. Could not parse statement.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			if got := output.String(); !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+		})
+	}
+}
+
+func TestIntegration_SelectByID(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{
+			name:       "prints only the matching row",
+			input:      insertRows(1, 5) + "select 3\n",
+			wantOutput: "simpledbgo > (3, user3, person3@example.com)\nExecuted.\n",
+		},
+		{
+			name:       "reports a missing id",
+			input:      insertRows(1, 5) + "select 42\n",
+			wantOutput: "simpledbgo > (no rows)\nExecuted.\n",
+		},
+		{
+			name:       "rejects a non-numeric id",
+			input:      "select three\n",
+			wantOutput: "Syntax error. Could not parse statement.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			if !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+			if n := strings.Count(got, "@example.com)"); n > 1 {
+				t.Errorf("got %d rows, want at most 1\ngot:\n%s", n, got)
+			}
+		})
+	}
+}
+
+func TestIntegration_SelectWhereEmail(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{
+			name:       "prints only the matching row",
+			input:      insertRows(1, 5) + "select where email person2@example.com\n",
+			wantOutput: "simpledbgo > (2, user2, person2@example.com)\nExecuted.\n",
+		},
+		{
+			name:       "is case-sensitive",
+			input:      insertRows(1, 5) + "select where email PERSON2@example.com\n",
+			wantOutput: "simpledbgo > (no rows)\nExecuted.\n",
+		},
+		{
+			name:       "reports no match",
+			input:      insertRows(1, 5) + "select where email nobody@example.com\n",
+			wantOutput: "simpledbgo > (no rows)\nExecuted.\n",
+		},
+		{
+			name:       "counts matches",
+			input:      insertRows(1, 5) + "insert 6 other person2@example.com\nselect count where email person2@example.com\n",
+			wantOutput: "simpledbgo > count: 2\nExecuted.\n",
+		},
+		{
+			name:       "rejects other columns",
+			input:      "select where username user1\n",
+			wantOutput: "Syntax error. Could not parse statement.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+			defer dbClose(table)
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			if !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+			if n := strings.Count(got, "@example.com)"); n > 1 {
+				t.Errorf("got %d rows, want at most 1\ngot:\n%s", n, got)
+			}
+		})
+	}
+}
+
+func TestHeader_NumRowsRoundTrip(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	// enough rows that the old fileLength / ROW_SIZE estimate would be wrong
+	numRows := rowsPerLeaf*14 + 3
+
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, numRows)+"delete 5\n"), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.numRows != uint32(numRows-1) {
+		t.Errorf("table.numRows after reopen = %d, want %d", table.numRows, numRows-1)
+	}
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader(fmt.Sprintf("select %d\n", numRows)), &output, table)
+	if want := fmt.Sprintf("(%d, user%d, person%d@example.com)", numRows, numRows, numRows); !strings.Contains(output.String(), want) {
+		t.Errorf("output missing %q\ngot:\n%s", want, output.String())
+	}
+}
+
+func TestHeader_RejectsHeaderlessFile(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	// a database written before the header existed: rows start at offset 0
+	legacy := make([]byte, FIXED_ROW_SIZE)
+	putFixedRow(legacy, Row{id: 1, username: "cstack", email: "foo@bar.com"})
+	if err := os.WriteFile(fileName, legacy, 0666); err != nil {
+		t.Fatalf("write legacy file: %v", err)
+	}
+
+	_, err := dbOpen(fileName)
+	if err == nil || !strings.Contains(err.Error(), "missing database header") {
+		t.Fatalf("dbOpen error = %v, want a missing header error", err)
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if !bytes.Equal(contents, legacy) {
+		t.Errorf("dbOpen modified a file it refused to open")
+	}
+}
+
+func TestHeader_InitializesEmptyFile(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	table := mustOpen(t, fileName)
+	defer dbClose(table)
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if len(contents) != HEADER_SIZE {
+		t.Fatalf("new file is %d bytes, want a %d byte header", len(contents), HEADER_SIZE)
+	}
+	if !bytes.HasPrefix(contents, []byte(HEADER_MAGIC)) {
+		t.Errorf("new file does not start with %q", HEADER_MAGIC)
+	}
+	if version := binary.LittleEndian.Uint32(contents[HEADER_VERSION_OFFSET:]); version != FORMAT_VERSION {
+		t.Errorf("format version = %d, want %d", version, FORMAT_VERSION)
+	}
+}
+
+func TestHeader_RejectsForeignFiles(t *testing.T) {
+	newer := encodeHeader(&Table{pager: &Pager{pageSize: DEFAULT_PAGE_SIZE}})
+	binary.LittleEndian.PutUint32(newer[HEADER_VERSION_OFFSET:], FORMAT_VERSION+1)
+
+	tests := []struct {
+		name     string
+		contents []byte
+		wantErr  string
+	}{
+		{name: "junk", contents: bytes.Repeat([]byte("junk"), 200), wantErr: "not a simpledbgo database"},
+		{name: "short junk", contents: []byte("hi"), wantErr: "not a simpledbgo database"},
+		{name: "truncated header", contents: []byte(HEADER_MAGIC + "\x00\x00"), wantErr: "header truncated"},
+		{name: "newer version", contents: newer[:], wantErr: "format version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := tempDBFile(t)
+			if err := os.WriteFile(fileName, tt.contents, 0666); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			_, err := dbOpen(fileName)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("dbOpen error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIntegration_BenchInsert(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	// the on-disk run makes its file here
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader(insertRows(1, 2)+"+benchinsert 50\nselect\n"), &output, table)
+	got := output.String()
+
+	for _, bench := range strings.SplitAfter(got, "on disk, sync normal:\n") {
+		for _, want := range []string{"inserted 50 rows in", "rows/s)", "p50: ", "p95: ", "p99: "} {
+			if !strings.Contains(bench, want) {
+				t.Errorf("output missing %q\ngot:\n%s", want, got)
+			}
+		}
+	}
+	if !strings.Contains(got, "in memory:\n") || !strings.Contains(got, "on disk, sync normal:\n") {
+		t.Errorf("output does not name both runs\ngot:\n%s", got)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+		t.Errorf("the on-disk run left %d files behind", len(entries))
+	}
+	if strings.Contains(got, "bench") {
+		t.Errorf("benchmark rows leaked into the real table:\n%s", got)
+	}
+	if table.numRows != 2 {
+		t.Errorf("table.numRows = %d, want 2", table.numRows)
+	}
+}
+
+func TestSerializeRow_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		row  Row
+	}{
+		{name: "short fields", row: Row{id: 1, username: "cstack", email: "foo@bar.com"}},
+		{
+			name: "fields filling the whole column",
+			row: Row{
+				id:       0xDEADBEEF,
+				username: strings.Repeat("u", COLUMN_USERNAME_SIZE),
+				email:    strings.Repeat("e", COLUMN_EMAIL_SIZE),
+			},
+		},
+		{name: "empty fields", row: Row{id: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := serializeRow(&tt.row, overflowRef{})
+			if len(buf) > MAX_ROW_SIZE {
+				t.Errorf("serialized row takes %d bytes, more than MAX_ROW_SIZE (%d)", len(buf), MAX_ROW_SIZE)
+			}
+
+			// start from garbage so stale fields would show up in the result
+			got := Row{id: 7, username: "stale", email: "stale@example.com"}
+			ref, err := deserializeRow(buf, &got)
+			if err != nil {
+				t.Fatalf("deserializeRow: %v", err)
+			}
+			if got != tt.row || ref != (overflowRef{}) {
+				t.Errorf("round trip = %+v with reference %+v, want %+v inline", got, ref, tt.row)
+			}
+		})
+	}
+}
+
+func TestSerializeRow_Layout(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow overflowRef
+		want     []byte
+	}{
+		{
+			name: "inline email",
+			want: []byte{0x01, 0x02, 0x03, 0x04, 2, 'a', 'b', 2 * 1, 'c'},
+		},
+		{
+			name:     "overflowed email",
+			overflow: overflowRef{length: 300, pageNum: 5},
+			// 300*2+1 = 601 as a uvarint
+			want: []byte{0x01, 0x02, 0x03, 0x04, 2, 'a', 'b', 0xD9, 0x04, 0x05, 0x00, 0x00, 0x00},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serializeRow(&Row{id: 0x04030201, username: "ab", email: "c"}, tt.overflow)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("serialized layout changed\ngot:  %x\nwant: %x", got, tt.want)
+			}
+			var row Row
+			ref, err := deserializeRow(got, &row)
+			if err != nil || ref != tt.overflow {
+				t.Errorf("deserializeRow = %+v, %v, want %+v", ref, err, tt.overflow)
+			}
+		})
+	}
+}
+
+func TestDeserializeRow_RejectsMalformedRows(t *testing.T) {
+	valid := serializeRow(&Row{id: 1, username: "ab", email: "cd"}, overflowRef{})
+	tests := []struct {
+		name  string
+		value []byte
+	}{
+		{name: "too short for an id", value: valid[:3]},
+		{name: "username cut off", value: valid[:6]},
+		{name: "email cut off", value: valid[:len(valid)-1]},
+		{name: "trailing bytes", value: append(slices.Clone(valid), 0)},
+		{name: "overflow reference cut off", value: serializeRow(&Row{id: 1}, overflowRef{length: 300, pageNum: 5})[:8]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var row Row
+			if _, err := deserializeRow(tt.value, &row); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("deserializeRow(%x) error = %v, want %v", tt.value, err, ErrCorrupt)
+			}
+		})
+	}
+}
+
+func TestIntegration_DuplicateKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantContains []string
+		wantRows     int
+	}{
+		{
+			name:         "rejects inserting the same id twice",
+			input:        "insert 1 user1 person1@example.com\ninsert 1 other other@example.com\nselect\n",
+			wantContains: []string{"Error: Duplicate key.", "(1, user1, person1@example.com)"},
+			wantRows:     1,
+		},
+		{
+			name:         "allows reusing an id after it was deleted",
+			input:        "insert 1 user1 person1@example.com\ndelete 1\ninsert 1 other other@example.com\nselect\n",
+			wantContains: []string{"(1, other, other@example.com)"},
+			wantRows:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			for _, want := range tt.wantContains {
+				if !strings.Contains(got, want) {
+					t.Errorf("output missing expected part %q\ngot:\n%s", want, got)
+				}
+			}
+			if table.numRows != uint32(tt.wantRows) {
+				t.Errorf("table.numRows = %d, want %d", table.numRows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestIntegration_DuplicateKeyAfterReopen(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, 3)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("insert 2 again again@example.com\n"), &output, table)
+	if !strings.Contains(output.String(), "Error: Duplicate key.") {
+		t.Errorf("output missing duplicate key error\ngot:\n%s", output.String())
+	}
+}
+
+func TestIntegration_Update(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		wantContains    []string
+		wantNotContains []string
+		wantRows        int
+	}{
+		{
+			name:            "rewrites the matching row",
+			input:           insertRows(1, 3) + "update 2 renamed new@example.com\nselect\n",
+			wantContains:    []string{"(2, renamed, new@example.com)", "(1, user1, person1@example.com)"},
+			wantNotContains: []string{"(2, user2, person2@example.com)"},
+			wantRows:        3,
+		},
+		{
+			name:         "reports a missing id",
+			input:        insertRows(1, 2) + "update 9 nobody nobody@example.com\n",
+			wantContains: []string{"Error: id not found."},
+			wantRows:     2,
+		},
+		{
+			name:         "rejects a username that is too long",
+			input:        insertRows(1, 1) + fmt.Sprintf("update 1 %s a@b.com\nselect\n", strings.Repeat("a", COLUMN_USERNAME_SIZE+1)),
+			wantContains: []string{"String is too long.", "(1, user1, person1@example.com)"},
+			wantRows:     1,
+		},
+		{
+			name:            "set assigns only the named column",
+			input:           insertRows(1, 2) + "update 2 set email=moved@example.com\nselect\n",
+			wantContains:    []string{"(2, user2, moved@example.com)", "(1, user1, person1@example.com)"},
+			wantNotContains: []string{"person2@example.com"},
+			wantRows:        2,
+		},
+		{
+			name:         "set assigns both columns",
+			input:        insertRows(1, 1) + "update 1 set username=renamed email=new@example.com\nselect\n",
+			wantContains: []string{"(1, renamed, new@example.com)"},
+			wantRows:     1,
+		},
+		{
+			name:         "set rejects the id and unknown columns",
+			input:        insertRows(1, 1) + "update 1 set id=5\nupdate 1 set name=x\nupdate 1 set\nselect\n",
+			wantContains: []string{"Syntax error. Could not parse statement.", "(1, user1, person1@example.com)"},
+			wantRows:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			for _, want := range tt.wantContains {
+				if !strings.Contains(got, want) {
+					t.Errorf("output missing expected part %q\ngot:\n%s", want, got)
+				}
+			}
+			for _, unwanted := range tt.wantNotContains {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("output contains unexpected part %q\ngot:\n%s", unwanted, got)
+				}
+			}
+			if table.numRows != uint32(tt.wantRows) {
+				t.Errorf("table.numRows = %d, want %d", table.numRows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestPager_FlushesSparselyTouchedPages(t *testing.T) {
+	fileName := tempDBFile(t)
+	numRows := rowsPerLeaf*3 + 1
+
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, numRows)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	// only load the tail page, leaving the earlier ones unread
+	table = mustOpen(t, fileName)
+	pagerReset(table.pager, nil)
+	cursor, found, err := tableFind(table, uint32(numRows))
+	if err != nil || !found {
+		t.Fatalf("tableFind(%d) = %v, %v", numRows, found, err)
+	}
+	if err := rewriteRow(cursor, &Row{id: uint32(numRows), username: "tail", email: "tail@example.com"}); err != nil {
+		t.Fatalf("rewriteRow: %v", err)
+	}
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	var output bytes.Buffer
+	runREPL(strings.NewReader("select 1\nselect "+strconv.Itoa(numRows)+"\n"), &output, table)
+	got := output.String()
+	for _, want := range []string{"(1, user1, person1@example.com)", fmt.Sprintf("(%d, tail, tail@example.com)", numRows)} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, got)
+		}
+	}
+}
+
+func TestPager_RejectsPagesPastTheEnd(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf+1)), io.Discard, table)
+
+	for _, pageNum := range []uint32{table.pager.numPages, math.MaxUint32} {
+		if _, err := getPage(table.pager, pageNum); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("getPage(%d) with %d pages = %v, want %v", pageNum, table.pager.numPages, err, ErrCorrupt)
+		}
+	}
+}
+
+func TestPager_LRUEviction(t *testing.T) {
+	const maxCachedPages = 2
+	fileName := tempDBFile(t)
+	numRows := rowsPerLeaf*6 + 1
+
+	table, err := dbOpenWith(fileName, OpenOptions{MaxCachedPages: maxCachedPages})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	runREPL(strings.NewReader(insertRows(1, numRows)+"delete 1\nupdate 2 changed changed@example.com\n"), io.Discard, table)
+
+	if resident := table.pager.lru.Len(); resident > maxCachedPages {
+		t.Errorf("%d pages resident, want at most %d", resident, maxCachedPages)
+	}
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("select\n"), &output, table)
+	got := output.String()
+	if resident := table.pager.lru.Len(); resident > maxCachedPages {
+		t.Errorf("%d pages resident after select, want at most %d", resident, maxCachedPages)
+	}
+	for _, want := range []string{"(2, changed, changed@example.com)", fmt.Sprintf("(%d, user%d, person%d@example.com)", numRows, numRows, numRows)} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q", want)
+		}
+	}
+	if strings.Contains(got, "(1, ") {
+		t.Errorf("deleted row still visible")
+	}
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.numRows != uint32(numRows-1) {
+		t.Fatalf("table.numRows = %d, want %d", table.numRows, numRows-1)
+	}
+	want := uint32(2)
+	for cursor := tableStart(table); !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			t.Fatalf("cursorValue: %v", err)
+		}
+		if id := rowID(slot); id != want {
+			t.Fatalf("page %d cell %d has id %d, want %d", cursor.pageNum, cursor.cellNum, id, want)
+		}
+		want++
+	}
+}
+
+func TestOpen_InvalidCacheSize(t *testing.T) {
+	for _, maxCachedPages := range []int{-1, 1} {
+		if _, err := dbOpenWith(tempDBFile(t), OpenOptions{MaxCachedPages: maxCachedPages}); err == nil {
+			t.Errorf("MaxCachedPages %d: dbOpenWith succeeded, want error", maxCachedPages)
+		}
+	}
+}
+
+func TestIntegration_Flush(t *testing.T) {
+	fileName := tempDBFile(t)
+	numRows := rowsPerLeaf + 2
+
+	table := mustOpen(t, fileName)
+	defer dbClose(table)
+	var output bytes.Buffer
+	runREPL(strings.NewReader(insertRows(1, numRows)+"+flush\n"), &output, table)
+	if strings.Contains(output.String(), "Error") {
+		t.Fatalf("+flush failed\ngot:\n%s", output.String())
+	}
+
+	// a second, read-only handle sees the flushed rows while the first one is
+	// still open
+	reader, err := dbOpenWith(fileName, OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(reader)
+	if reader.numRows != uint32(numRows) {
+		t.Errorf("reader.numRows = %d, want %d", reader.numRows, numRows)
+	}
+	output.Reset()
+	runREPL(strings.NewReader(fmt.Sprintf("select %d\n", numRows)), &output, reader)
+	if want := fmt.Sprintf("(%d, user%d, person%d@example.com)", numRows, numRows, numRows); !strings.Contains(output.String(), want) {
+		t.Errorf("output missing %q\ngot:\n%s", want, output.String())
+	}
+
+	output.Reset()
+	runREPL(strings.NewReader("+begin\n+flush\n+rollback\n"), &output, table)
+	if !strings.Contains(output.String(), "Error: not allowed inside a transaction") {
+		t.Errorf("+flush inside a transaction was not rejected\ngot:\n%s", output.String())
+	}
+}
+
+func TestIntegration_Vacuum(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	defer dbClose(table)
+
+	// flush before deleting, so the file still holds the deleted rows
+	input := insertRows(1, 10) + "+flush\ndelete 2\ndelete 4\ndelete 6\ndelete 8\ndelete 10\n+vacuum\n"
+	var output bytes.Buffer
+	runREPL(strings.NewReader(input), &output, table)
+	if strings.Contains(output.String(), "Error") {
+		t.Fatalf("+vacuum failed\ngot:\n%s", output.String())
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	// the five remaining rows fit in one page, stored whole with its
+	// checksum, after the catalog keeping 10, the largest id deleted
+	if want := int64(HEADER_SIZE + 2*DEFAULT_PAGE_SIZE); info.Size() != want {
+		t.Errorf("file is %d bytes after vacuum, want %d", info.Size(), want)
+	}
+	if table.pager.fileLength != info.Size() {
+		t.Errorf("pager.fileLength = %d, want %d", table.pager.fileLength, info.Size())
+	}
+
+	// the table keeps working on the rewritten file
+	output.Reset()
+	runREPL(strings.NewReader("insert 11 user11 person11@example.com\nselect\n"), &output, table)
+	for _, id := range []int{1, 3, 5, 7, 9, 11} {
+		if want := fmt.Sprintf("(%d, user%d, person%d@example.com)", id, id, id); !strings.Contains(output.String(), want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "user2,") {
+		t.Errorf("deleted row came back\ngot:\n%s", output.String())
+	}
+
+	if matches, _ := filepath.Glob(fileName + ".vacuum-*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestIntegration_VacuumReclaimsFreePages(t *testing.T) {
+	table, err := dbOpenWith(tempDBFile(t), OpenOptions{PageSize: smallPageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+
+	runREPL(strings.NewReader(insertRows(1, 100)+deleteRows(11, 90)), io.Discard, table)
+	if table.pager.numFreePages == 0 {
+		t.Fatalf("no pages on the freelist after deleting most rows")
+	}
+	numPages := table.pager.numPages
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("+vacuum\n"), &output, table)
+	if table.pager.numFreePages != 0 || table.pager.freePage != 0 {
+		t.Errorf("freelist holds %d pages from page %d after vacuum, want none", table.pager.numFreePages, table.pager.freePage)
+	}
+	want := fmt.Sprintf("Reclaimed %d pages.\n", numPages-table.pager.numPages)
+	if numPages <= table.pager.numPages || !strings.Contains(output.String(), want) {
+		t.Errorf("vacuum went from %d to %d pages; output %q, want %q", numPages, table.pager.numPages, output.String(), want)
+	}
+	if _, stored := checkTree(t, table); stored != 20 {
+		t.Errorf("tree holds %d rows after vacuum, want 20", stored)
+	}
+}
+
+func TestIntegration_Stats(t *testing.T) {
+	var output bytes.Buffer
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	runREPL(strings.NewReader(insertRows(1, 3)+"+stats\n"), &output, table)
+	got := output.String()
+	for _, want := range []string{
+		"numRows = 3\n",
+		"allocated pages = 1\n",
+		fmt.Sprintf("leaf space = %d bytes\n", DEFAULT_PAGE_SIZE-PAGE_CHECKSUM_SIZE-LEAF_NODE_HEADER_SIZE),
+		fmt.Sprintf("MAX_ROW_SIZE = %d\n", MAX_ROW_SIZE),
+		fmt.Sprintf("file length = %d bytes\n", HEADER_SIZE),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Unrecognized command") {
+		t.Errorf("+stats was not recognized\ngot:\n%s", got)
+	}
+}
+
+func TestIntegration_Schema(t *testing.T) {
+	var output bytes.Buffer
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	runREPL(strings.NewReader("+schema\n"), &output, table)
+	got := output.String()
+	for _, want := range []string{"id ", "username ", "email ", fmt.Sprintf("MAX_ROW_SIZE = %d\n", MAX_ROW_SIZE)} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Unrecognized command") {
+		t.Errorf("+schema was not recognized\ngot:\n%s", got)
+	}
+}
+
+func TestIntegration_InMemory(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+
+	var output bytes.Buffer
+	input := insertRows(1, rowsPerLeaf+2) + "delete 1\nupdate 2 renamed new@example.com\nselect\n"
+	runREPL(strings.NewReader(input), &output, table)
+	got := output.String()
+
+	for _, want := range []string{
+		"(2, renamed, new@example.com)",
+		fmt.Sprintf("(%d, user%d, person%d@example.com)", rowsPerLeaf+2, rowsPerLeaf+2, rowsPerLeaf+2),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, got)
+		}
+	}
+	if table.numRows != uint32(rowsPerLeaf+1) {
+		t.Errorf("table.numRows = %d, want %d", table.numRows, rowsPerLeaf+1)
+	}
+
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	if _, err := os.Stat(MEMORY_FILENAME); !os.IsNotExist(err) {
+		os.Remove(MEMORY_FILENAME)
+		t.Errorf("in-memory database created a %q file", MEMORY_FILENAME)
+	}
+}
+
+func TestIntegration_InMemorySnapshot(t *testing.T) {
+	snapshotName := filepath.Join(t.TempDir(), "snapshot.db")
+
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf+1)+"+snapshot "+snapshotName+"\n"), io.Discard, table)
+
+	snapshot := mustOpen(t, snapshotName)
+	defer dbClose(snapshot)
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader(fmt.Sprintf("select %d\n", rowsPerLeaf+1)), &output, snapshot)
+	if snapshot.numRows != uint32(rowsPerLeaf+1) {
+		t.Errorf("snapshot.numRows = %d, want %d", snapshot.numRows, rowsPerLeaf+1)
+	}
+	if want := fmt.Sprintf("user%d", rowsPerLeaf+1); !strings.Contains(output.String(), want) {
+		t.Errorf("snapshot output missing %q\ngot:\n%s", want, output.String())
+	}
+}
+
+func TestIntegration_IOErrorIsReported(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "insert", input: "insert 10 user10 person10@example.com\n"},
+		{name: "select", input: "select\n"},
+		{name: "update", input: "update 1 renamed new@example.com\n"},
+		{name: "delete", input: "delete 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := tempDBFile(t)
+			table := mustOpen(t, fileName)
+			runREPL(strings.NewReader(insertRows(1, 3)), io.Discard, table)
+			if err := dbClose(table); err != nil {
+				t.Fatalf("dbClose: %v", err)
+			}
+
+			// reopen, then pull the file out from under the pager so the next
+			// page fetch fails
+			table = mustOpen(t, fileName)
+			table.pager.file.Close()
+			pagerReset(table.pager, nil)
+
+			var output bytes.Buffer
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			if !strings.Contains(got, "Error: I/O failure.") {
+				t.Errorf("output missing I/O failure message\ngot:\n%s", got)
+			}
+			if strings.Contains(got, "Executed.") {
+				t.Errorf("failed statement reported success\ngot:\n%s", got)
+			}
+			if table.numRows != 3 {
+				t.Errorf("table.numRows = %d, want 3", table.numRows)
+			}
+		})
+	}
+}
+
+func TestIntegration_BatchMode(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	script := insertRows(1, 2) + "insert 1 dup dup@example.com\nselect\nbogus\n+json on\nselect 2\n"
+	runREPLWith(strings.NewReader(script), &output, table, true)
+
+	want := "Error: Duplicate key.\n" +
+		"(1, user1, person1@example.com)\n" +
+		"(2, user2, person2@example.com)\n" +
+		"Unrecognized keyword at start of bogus.\n" +
+		`{"id":2,"username":"user2","email":"person2@example.com"}` + "\n"
+	if got := output.String(); got != want {
+		t.Errorf("batch output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// unknownStmt is a statement executeStatement has no case for.
+type unknownStmt struct{}
+
+func (unknownStmt) statementNode() {}
+
+func TestExecuteStatement_UnknownType(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	writer := bufio.NewWriter(&output)
+	result := executeStatement(unknownStmt{}, table, writer)
+	writer.Flush()
+
+	if result != EXECUTE_UNKNOWN_STATEMENT {
+		t.Fatalf("executeStatement = %d, want EXECUTE_UNKNOWN_STATEMENT", result)
+	}
+	if got, want := executeResultMessage(result), "Error: unknown statement type."; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if output.Len() != 0 || table.numRows != 0 {
+		t.Errorf("unknown statement had an effect: output %q, numRows %d", output.String(), table.numRows)
+	}
+}
+
+func TestIntegration_JSONOutput(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	input := "insert 1 user1 \"a,b\"<c>@example.com\n+json on\nselect\n+json off\nselect\n"
+	runREPL(strings.NewReader(input), &output, table)
+
+	var jsonLines []string
+	for line := range strings.Lines(strings.ReplaceAll(output.String(), "simpledbgo > ", "")) {
+		if strings.HasPrefix(line, "{") {
+			jsonLines = append(jsonLines, line)
+		}
+	}
+	if len(jsonLines) != 1 {
+		t.Fatalf("got %d JSON rows, want 1\ngot:\n%s", len(jsonLines), output.String())
+	}
+
+	var got jsonRow
+	if err := json.Unmarshal([]byte(jsonLines[0]), &got); err != nil {
+		t.Fatalf("row is not valid JSON: %v\n%s", err, jsonLines[0])
+	}
+	want := jsonRow{ID: 1, Username: "user1", Email: `"a,b"<c>@example.com`}
+	if got != want {
+		t.Errorf("decoded row = %+v, want %+v", got, want)
+	}
+
+	if !strings.Contains(output.String(), `(1, user1, "a,b"<c>@example.com)`) {
+		t.Errorf("+json off did not restore the default format\ngot:\n%s", output.String())
+	}
+}
+
+func TestIntegration_JSONOutputToggledDuringSelects(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 5)), io.Discard, table)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runREPL(strings.NewReader(strings.Repeat("+json on\n+json off\n", 20)), io.Discard, table)
+	}()
+	// a second session reading while the first changes its format
+	go func() {
+		defer wg.Done()
+		writer := bufio.NewWriter(io.Discard)
+		for range 20 {
+			executeStatement(&SelectStmt{}, table, writer)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestOpen_UpgradesLegacyFiles(t *testing.T) {
+	user := func(id uint32) Row {
+		return Row{id: id, username: fmt.Sprintf("user%d", id), email: fmt.Sprintf("person%d@example.com", id)}
+	}
+	rows := make([]byte, 3*FIXED_ROW_SIZE)
+	putFixedRow(rows[:FIXED_ROW_SIZE], user(2))
+	putFixedRow(rows[FIXED_ROW_SIZE:2*FIXED_ROW_SIZE], user(1))
+	putFixedRow(rows[2*FIXED_ROW_SIZE:], user(3))
+
+	// version 1 stores the last page only up to its last row
+	v1 := encodeHeader(&Table{pager: &Pager{pageSize: DEFAULT_PAGE_SIZE, version: 1}, numRows: 3})
+	// version 2 stores whole pages ending in a checksum
+	v2Pager := &Pager{pageSize: DEFAULT_PAGE_SIZE, version: CHECKSUM_FORMAT_VERSION, checksums: true}
+	v2 := encodeHeader(&Table{pager: v2Pager, numRows: 3})
+	v2Page := make(Page, DEFAULT_PAGE_SIZE)
+	copy(v2Page, rows)
+	putPageChecksum(v2Pager, v2Page)
+	// versions 3 to 5 store leaves of fixed-size cells, chained from page 0
+	fixedLeaf := func(rows ...Row) Page {
+		page := make(Page, DEFAULT_PAGE_SIZE)
+		initializeLeafNode(page)
+		setLeafNodeNumCells(page, uint32(len(rows)))
+		for i, row := range rows {
+			cell := page[LEAF_NODE_HEADER_SIZE+i*FIXED_LEAF_NODE_CELL_SIZE:]
+			binary.LittleEndian.PutUint32(cell, row.id)
+			putFixedRow(cell[LEAF_NODE_KEY_SIZE:], row)
+		}
+		return page
+	}
+	v3Pager := &Pager{pageSize: DEFAULT_PAGE_SIZE, version: BTREE_FORMAT_VERSION, checksums: true}
+	v3 := encodeHeader(&Table{pager: v3Pager, numRows: 3})
+	v3Page := fixedLeaf(user(1), user(2), user(3))
+	putPageChecksum(v3Pager, v3Page)
+	// version 5 may keep an email on overflow pages, here row 2's on page 1
+	v5Pager := &Pager{pageSize: DEFAULT_PAGE_SIZE, version: OVERFLOW_FORMAT_VERSION, checksums: true}
+	v5 := encodeHeader(&Table{pager: v5Pager, numRows: 3})
+	v5Leaf := fixedLeaf(user(1), user(2), user(3))
+	email := v5Leaf[LEAF_NODE_HEADER_SIZE+FIXED_LEAF_NODE_CELL_SIZE+LEAF_NODE_KEY_SIZE+FIXED_EMAIL_OFFSET:]
+	clear(email[:COLUMN_EMAIL_SIZE])
+	email[1] = FIXED_OVERFLOW_MARKER
+	binary.LittleEndian.PutUint32(email[FIXED_OVERFLOW_LENGTH_OFFSET:], uint32(len(user(2).email)))
+	binary.LittleEndian.PutUint32(email[FIXED_OVERFLOW_PAGE_OFFSET:], 1)
+	putPageChecksum(v5Pager, v5Leaf)
+	v5Overflow := make(Page, DEFAULT_PAGE_SIZE)
+	setNodeType(v5Overflow, NODE_OVERFLOW)
+	copy(v5Overflow[OVERFLOW_NODE_HEADER_SIZE:], user(2).email)
+	putPageChecksum(v5Pager, v5Overflow)
+
+	tests := []struct {
+		name     string
+		contents []byte
+	}{
+		{name: "version 1", contents: append(v1[:], rows...)},
+		{name: "version 2", contents: append(v2[:], v2Page...)},
+		{name: "version 3", contents: append(v3[:], v3Page...)},
+		{name: "version 5", contents: slices.Concat(v5[:], v5Leaf, v5Overflow)},
+	}
+	for _, tt := range tests {
+		for _, readOnly := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/readOnly=%v", tt.name, readOnly), func(t *testing.T) {
+				fileName := tempDBFile(t)
+				if err := os.WriteFile(fileName, tt.contents, 0666); err != nil {
+					t.Fatalf("write database: %v", err)
+				}
+
+				table, err := dbOpenWith(fileName, OpenOptions{ReadOnly: readOnly})
+				if err != nil {
+					t.Fatalf("dbOpenWith: %v", err)
+				}
+				var output bytes.Buffer
+				runREPL(strings.NewReader("select\nselect 2\n"), &output, table)
+				want := "(1, user1, person1@example.com)\n(2, user2, person2@example.com)\n(3, user3, person3@example.com)\n" +
+					"Executed.\nsimpledbgo > (2, user2, person2@example.com)\n"
+				if !strings.Contains(output.String(), want) {
+					t.Errorf("output missing %q\ngot:\n%s", want, output.String())
+				}
+				if err := dbClose(table); err != nil {
+					t.Fatalf("dbClose: %v", err)
+				}
+
+				contents, err := os.ReadFile(fileName)
+				if err != nil {
+					t.Fatalf("read file: %v", err)
+				}
+				if readOnly {
+					if !bytes.Equal(contents, tt.contents) {
+						t.Errorf("read-only open modified the legacy file")
+					}
+					return
+				}
+				if version := binary.LittleEndian.Uint32(contents[HEADER_VERSION_OFFSET:]); version != FORMAT_VERSION {
+					t.Errorf("format version after upgrade = %d, want %d", version, FORMAT_VERSION)
+				}
+				if matches, _ := filepath.Glob(fileName + ".upgrade-*"); len(matches) != 0 {
+					t.Errorf("temporary files left behind: %v", matches)
+				}
+			})
+		}
+	}
+}
+
+func TestTable_ConcurrentInserts(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	const goroutines = 8
+	const perGoroutine = 50
+
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			writer := bufio.NewWriter(io.Discard)
+			for i := range perGoroutine {
+				id := uint32(g*perGoroutine + i)
+				statement := &InsertStmt{Row: Row{id: id, username: fmt.Sprintf("user%d", id), email: "x@example.com"}}
+				if result := executeStatement(statement, table, writer); result != EXECUTE_SUCCESS {
+					t.Errorf("insert %d: result = %d", id, result)
+				}
+			}
+		}()
+		// readers running alongside the writers
+		go func() {
+			defer wg.Done()
+			writer := bufio.NewWriter(io.Discard)
+			statement := &SelectStmt{}
+			for range perGoroutine / 10 {
+				executeStatement(statement, table, writer)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if table.numRows != goroutines*perGoroutine {
+		t.Fatalf("table.numRows = %d, want %d", table.numRows, goroutines*perGoroutine)
+	}
+
+	seen := make(map[uint32]bool)
+	var row Row
+	for cursor := tableStart(table); !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			t.Fatalf("cursorValue: %v", err)
+		}
+		deserializeRow(slot, &row)
+		if seen[row.id] {
+			t.Errorf("id %d stored twice", row.id)
+		}
+		seen[row.id] = true
+	}
+}
+
+func TestIntegration_SelectCount(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{name: "empty table", input: "select count\n", wantOutput: "count: 0\n"},
+		{name: "populated table", input: insertRows(1, rowsPerLeaf+3) + "select count\n", wantOutput: fmt.Sprintf("count: %d\n", rowsPerLeaf+3)},
+		{name: "count(*) spelling", input: insertRows(1, 2) + "select count(*)\n", wantOutput: "count: 2\n"},
+		{name: "after a delete", input: insertRows(1, 3) + "delete 2\nselect count\n", wantOutput: "count: 2\n"},
+		{name: "existing id", input: insertRows(1, 5) + "select count 3\n", wantOutput: "count: 1\n"},
+		{name: "missing id", input: insertRows(1, 5) + "select count 9\n", wantOutput: "count: 0\n"},
+		{name: "count with order", input: "select count order by id desc limit 1\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			got := output.String()
+			if !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+			if strings.Contains(got, "@example.com)") {
+				t.Errorf("count printed rows\ngot:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestIntegration_SelectAggregates(t *testing.T) {
+	rows := "insert 4 dan d@x.com\ninsert 9 ivy i@x.com\ninsert 1 al a@x.com\n"
+
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{name: "every function", input: rows + "select count(*), count(email), sum(id), min(username), max(id), avg(id)\n", wantOutput: "(3, 3, 14, al, 9, 4.666666666666667)\n"},
+		{name: "with where", input: rows + "select min(id), max(email) where id > 1\n", wantOutput: "(4, i@x.com)\n"},
+		{name: "no rows", input: "select count(id), sum(id), max(username)\n", wantOutput: "(0, NULL, NULL)\n"},
+		{name: "count(*) alone", input: rows + "select count(*) where id < 5\n", wantOutput: "count: 2\n"},
+		{name: "as JSON", input: rows + "+json on\nselect max(id), avg(id) 4\n", wantOutput: `{"max(id)":4,"avg(id)":4}` + "\n"},
+		{name: "sum of text", input: rows + "select sum(email)\n", wantOutput: "Error: value does not match the column's type.\n"},
+		{name: "unknown column", input: rows + "select max(name)\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+		{name: "with a column", input: rows + "select id, max(id)\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+		{name: "with order", input: rows + "select max(id) order by id\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			if got := output.String(); !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+		})
+	}
+}
+
+func TestIntegration_SelectGroupBy(t *testing.T) {
+	rows := "insert 1 bob b1@x.com\ninsert 2 al a@x.com\ninsert 3 bob b2@x.com\ninsert 4 cy c@x.com\ninsert 5 bob b3@x.com\ninsert 6 al a2@x.com\n"
+
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{name: "duplicate usernames", input: rows + "select username, count(*) group by username having count(*) > 1\n", wantOutput: "> (al, 2)\n(bob, 3)\nExecuted.\n"},
+		{name: "having an aggregate not printed", input: rows + "select username group by username having max(id) >= 5\n", wantOutput: "> (al)\n(bob)\nExecuted.\n"},
+		{name: "having a column grouped by", input: rows + "select max(id), username group by username having username != al and count(*) < 3\n", wantOutput: "> (4, cy)\nExecuted.\n"},
+		{name: "with where", input: rows + "select username, min(email) where id > 2 group by username\n", wantOutput: "> (al, a2@x.com)\n(bob, b2@x.com)\n(cy, c@x.com)\nExecuted.\n"},
+		{name: "by two columns", input: rows + "select count(*) group by username, id having id < 3\n", wantOutput: "> (1)\n(1)\nExecuted.\n"},
+		{name: "no groups", input: "select username, count(*) group by username\n", wantOutput: "> (no rows)\nExecuted.\n"},
+		{name: "having without group by", input: rows + "select count(*) having count(*) > 10\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+		{name: "a column not grouped by", input: rows + "select email, count(*) group by username\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+		{name: "having a column not grouped by", input: rows + "select count(*) group by username having id > 1\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+		{name: "having a value of another type", input: rows + "select count(*) group by username having count(*) > a\n", wantOutput: "Error: value does not match the column's type.\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			if got := output.String(); !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+		})
+	}
+}
+
+func TestIntegration_SelectOrderByID(t *testing.T) {
+	rows := "insert 3 user3 person3@example.com\ninsert 1 user1 person1@example.com\ninsert 2 user2 person2@example.com\n"
+
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{
+			name:       "ascending",
+			input:      rows + "select order id\n",
+			wantOutput: "(1, user1, person1@example.com)\n(2, user2, person2@example.com)\n(3, user3, person3@example.com)\n",
+		},
+		{
+			name:       "descending",
+			input:      rows + "select order id desc\n",
+			wantOutput: "(3, user3, person3@example.com)\n(2, user2, person2@example.com)\n(1, user1, person1@example.com)\n",
+		},
+		{
+			name:       "with by",
+			input:      rows + "select order by id asc\n",
+			wantOutput: "(1, user1, person1@example.com)\n(2, user2, person2@example.com)\n(3, user3, person3@example.com)\n",
+		},
+		{
+			name:       "by username, descending",
+			input:      "insert 1 bob b@x.com\ninsert 2 al c@x.com\ninsert 3 cy a@x.com\nselect order by username desc\n",
+			wantOutput: "(3, cy, a@x.com)\n(1, bob, b@x.com)\n(2, al, c@x.com)\n",
+		},
+		{
+			name:       "by email, with where and limit",
+			input:      "insert 1 bob b@x.com\ninsert 2 al c@x.com\ninsert 3 cy a@x.com\nselect username where id >= 2 order by email limit 1\n",
+			wantOutput: "(cy)\n",
+		},
+		{
+			name:       "by id, with where",
+			input:      rows + "select where id != 2 order by id desc\n",
+			wantOutput: "(3, user3, person3@example.com)\n(1, user1, person1@example.com)\n",
+		},
+		{
+			name:       "nothing matches",
+			input:      rows + "select 9 order by email\n",
+			wantOutput: "(no rows)\n",
+		},
+		{
+			name:       "unknown column",
+			input:      "select order name\n",
+			wantOutput: "Syntax error. Could not parse statement.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			if got := output.String(); !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+		})
+	}
+}
+
+func TestIntegration_SelectColumns(t *testing.T) {
+	rows := "insert 3 user3 person3@example.com\ninsert 1 user1 person1@example.com\ninsert 2 user2 person2@example.com\n"
+
+	tests := []struct {
+		name       string
+		input      string
+		wantOutput string
+	}{
+		{name: "every row", input: rows + "select id, email\n", wantOutput: "(1, person1@example.com)\n(2, person2@example.com)\n(3, person3@example.com)\n"},
+		{name: "reordered and repeated", input: rows + "select email, id, id 2\n", wantOutput: "(person2@example.com, 2, 2)\n"},
+		{name: "with where", input: rows + "select username where id > 1\n", wantOutput: "(user2)\n(user3)\n"},
+		{name: "with order", input: rows + "select id order by id desc limit 2\n", wantOutput: "(3)\n(2)\n"},
+		{name: "as JSON", input: rows + "+json on\nselect email, id 1\n", wantOutput: `{"email":"person1@example.com","id":1}` + "\n"},
+		{name: "unknown column", input: rows + "select id, name\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+		{name: "with count", input: rows + "select count id\n", wantOutput: "Syntax error. Could not parse statement.\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			if got := output.String(); !strings.Contains(got, tt.wantOutput) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantOutput, got)
+			}
+		})
+	}
+}
+
+func TestOpen_CustomPageSize(t *testing.T) {
+	fileName := tempDBFile(t)
+	pageSize := uint32(1024)
+	numRows := 100
+
+	table, err := dbOpenWith(fileName, OpenOptions{PageSize: pageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	runREPL(strings.NewReader(insertRows(1, numRows)+"delete 4\n"), io.Discard, table)
+	numPages := table.pager.numPages
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	// a hundred rows fill several leaves, under one root
+	if numPages < 4 {
+		t.Errorf("numPages = %d, want at least 4", numPages)
+	}
+	if want := int64(HEADER_SIZE) + int64(numPages)*int64(pageSize); info.Size() != want {
+		t.Errorf("file size = %d, want %d", info.Size(), want)
+	}
+
+	// the page size comes from the header when none is requested
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.pager.pageSize != pageSize {
+		t.Errorf("reopened pageSize = %d, want %d", table.pager.pageSize, pageSize)
+	}
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("select\n"), &output, table)
+	var want strings.Builder
+	for i := 1; i <= numRows; i++ {
+		if i != 4 {
+			fmt.Fprintf(&want, "(%d, user%d, person%d@example.com)\n", i, i, i)
+		}
+	}
+	if !strings.Contains(output.String(), want.String()) {
+		t.Errorf("output missing %q\ngot:\n%s", want.String(), output.String())
+	}
+}
+
+func TestOpen_InvalidPageSize(t *testing.T) {
+	for _, pageSize := range []uint32{MIN_PAGE_SIZE - 1, MAX_PAGE_SIZE + 1} {
+		if _, err := dbOpenWith(tempDBFile(t), OpenOptions{PageSize: pageSize}); err == nil || !strings.Contains(err.Error(), "outside the supported range") {
+			t.Errorf("dbOpenWith with a page size of %d: err = %v", pageSize, err)
+		}
+	}
+
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, 1)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	if _, err := dbOpenWith(fileName, OpenOptions{PageSize: 2 * DEFAULT_PAGE_SIZE}); err == nil || !strings.Contains(err.Error(), "page size") {
+		t.Errorf("dbOpenWith with a page size differing from the file's: err = %v", err)
+	}
+}
+
+func TestOpen_ReadOnly(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf+1)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	before, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+
+	table, err = dbOpenWith(fileName, OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("dbOpenWith read-only: %v", err)
+	}
+
+	var output bytes.Buffer
+	input := "select 1\ninsert 100 new new@example.com\nupdate 1 changed changed@example.com\ndelete 2\nselect count\n"
+	runREPL(strings.NewReader(input), &output, table)
+	got := output.String()
+
+	if !strings.Contains(got, "(1, user1, person1@example.com)") {
+		t.Errorf("select did not work on a read-only table\ngot:\n%s", got)
+	}
+	if n := strings.Count(got, "Error: database is read-only."); n != 3 {
+		t.Errorf("got %d read-only errors, want 3\ngot:\n%s", n, got)
+	}
+	if want := fmt.Sprintf("count: %d", rowsPerLeaf+1); !strings.Contains(got, want) {
+		t.Errorf("output missing %q\ngot:\n%s", want, got)
+	}
+
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose read-only: %v", err)
+	}
+	after, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("read-only session modified the database file")
+	}
+}
+
+func TestOpen_ReadOnlyMissingFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "missing.db")
+	if _, err := dbOpenWith(fileName, OpenOptions{ReadOnly: true}); err == nil {
+		t.Errorf("dbOpenWith read-only on a missing file succeeded")
+	}
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("read-only open created %s", fileName)
+	}
+}
+
+func TestOpen_MissingDirectory(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "no-such-dir", "test.db")
+	table, err := dbOpen(fileName)
+	if err == nil {
+		dbClose(table)
+		t.Fatalf("dbOpen(%q) succeeded, want an error", fileName)
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("dbOpen error = %v, want a not-exist error", err)
+	}
+}
+
+func TestOpen_SyncModes(t *testing.T) {
+	for _, mode := range []SyncMode{SYNC_NORMAL, SYNC_FULL, SYNC_OFF} {
+		if parsed, err := parseSyncMode(mode.String()); err != nil || parsed != mode {
+			t.Errorf("parseSyncMode(%q) = %v, %v", mode.String(), parsed, err)
+		}
+	}
+	if _, err := parseSyncMode("sometimes"); err == nil {
+		t.Errorf("parseSyncMode accepted an unknown mode")
+	}
+
+	// with SYNC_FULL every statement reaches the file as soon as it ends;
+	// otherwise the rows wait in the cache until +flush or close
+	for _, mode := range []SyncMode{SYNC_NORMAL, SYNC_FULL, SYNC_OFF} {
+		fileName := tempDBFile(t)
+		table, err := dbOpenWith(fileName, OpenOptions{Sync: mode})
+		if err != nil {
+			t.Fatalf("dbOpenWith: %v", err)
+		}
+		runREPL(strings.NewReader(insertRows(1, 3)), io.Discard, table)
+		written, err := dbOpenWith(fileName, OpenOptions{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("dbOpenWith read-only: %v", err)
+		}
+		want := uint32(0)
+		if mode == SYNC_FULL {
+			want = 3
+		}
+		if written.numRows != want {
+			t.Errorf("sync %s: file holds %d rows before close, want %d", mode, written.numRows, want)
+		}
+		dbClose(written)
+		dbClose(table)
+	}
+
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+	var output bytes.Buffer
+	runREPL(strings.NewReader("+sync full\n+sync\n+sync sometimes\n"), &output, table)
+	if got := output.String(); !strings.Contains(got, "sync = full") || !strings.Contains(got, "Usage: +sync") {
+		t.Errorf("+sync output:\n%s", got)
+	}
+}
+
+func TestIntegration_InsertIDRange(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantContains string
+		wantRows     int
+	}{
+		{name: "negative id", input: "insert -1 user person@example.com\n", wantContains: "Error: id must be between 0 and 4294967295.", wantRows: 0},
+		{name: "id above uint32", input: "insert 4294967296 user person@example.com\n", wantContains: "Error: id must be between 0 and 4294967295.", wantRows: 0},
+		{name: "largest id", input: "insert 4294967295 user person@example.com\nselect\n", wantContains: "(4294967295, user, person@example.com)", wantRows: 1},
+		{name: "smallest id", input: "insert 0 user person@example.com\nselect\n", wantContains: "(0, user, person@example.com)", wantRows: 1},
+		{name: "negative id on update", input: "update -5 user person@example.com\n", wantContains: "Error: id must be between 0 and 4294967295.", wantRows: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			table := mustOpen(t, tempDBFile(t))
+
+			runREPL(strings.NewReader(tt.input), &output, table)
+			if got := output.String(); !strings.Contains(got, tt.wantContains) {
+				t.Errorf("output missing %q\ngot:\n%s", tt.wantContains, got)
+			}
+			if table.numRows != uint32(tt.wantRows) {
+				t.Errorf("table.numRows = %d, want %d", table.numRows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestIntegration_InsertWithNextID(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("insert alice alice@example.com\n"+
+		"insert 10 bob bob@example.com\n"+
+		"insert carol carol@example.com\n"+
+		"delete 11\ndelete 10\n"+
+		"insert dave dave@example.com\n"+
+		"insert \"5\" five@example.com\n"+
+		"insert eve\n"+
+		"select\n"), &output, table, true)
+	want := "Syntax error. Could not parse statement.\n" +
+		"  insert eve\n" +
+		"            ^ expected a value for email, found end of statement\n" +
+		"(1, alice, alice@example.com)\n(12, dave, dave@example.com)\n(13, 5, five@example.com)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	runREPL(strings.NewReader("delete 13\ndelete 12\n"), io.Discard, table)
+	dbClose(table)
+
+	// the ids handed out are not handed out again once the database is
+	// reopened, nor after a vacuum
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if id, err := table.InsertWithNextID("frank", "frank@example.com"); err != nil || id != 14 {
+		t.Errorf("InsertWithNextID = %d, %v, want 14", id, err)
+	}
+	runREPL(strings.NewReader("delete 14\n"), io.Discard, table)
+	if _, err := dbVacuum(table); err != nil {
+		t.Fatalf("dbVacuum: %v", err)
+	}
+	if id, err := table.InsertWithNextID("grace", "grace@example.com"); err != nil || id != 15 {
+		t.Errorf("InsertWithNextID after vacuum = %d, %v, want 15", id, err)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+
+	runREPL(strings.NewReader("insert 4294967295 last last@example.com\n"), io.Discard, table)
+	if _, err := table.InsertWithNextID("none", "none@example.com"); !errors.Is(err, ErrTableFull) {
+		t.Errorf("InsertWithNextID past the last id: %v, want ErrTableFull", err)
+	}
+}
+
+func TestIntegration_InsertWithNextID_AfterExplicitID(t *testing.T) {
+	fileName := tempDBFile(t)
+	// the explicit id outlives its row and the database it was inserted in,
+	// however the row goes
+	for _, test := range []struct{ input, want string }{
+		{"insert 100 b b@x\ndelete 100\n", "(101, c, c@x)\n"},
+		{"insert 200 d d@x\ndelete from where id > 150\n", "(101, c, c@x)\n(201, c, c@x)\n"},
+		{"insert 300 e e@x\n+undo\n", "(101, c, c@x)\n(201, c, c@x)\n(301, c, c@x)\n"},
+	} {
+		table := mustOpen(t, fileName)
+		runREPL(strings.NewReader(test.input), io.Discard, table)
+		dbClose(table)
+
+		table = mustOpen(t, fileName)
+		var output bytes.Buffer
+		runREPLWith(strings.NewReader("insert c c@x\nselect\n"), &output, table, true)
+		dbClose(table)
+		if output.String() != test.want {
+			t.Errorf("after %q: output = %q, want %q", test.input, output.String(), test.want)
+		}
+	}
+}
+
+func TestPrepareStatement_QuotedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    PrepareResult
+		wantRow Row
+	}{
+		{name: "quoted username", input: `insert 1 "John Doe" a@b.com`, want: PREPARE_SUCCESS, wantRow: Row{id: 1, username: "John Doe", email: "a@b.com"}},
+		{name: "quoted email", input: `update 2 jd "john at example.com"`, want: PREPARE_SUCCESS, wantRow: Row{id: 2, username: "jd", email: "john at example.com"}},
+		{name: "quotes inside a value", input: `insert 3 jd "x"@example.com`, want: PREPARE_SUCCESS, wantRow: Row{id: 3, username: "jd", email: `"x"@example.com`}},
+		{name: "unterminated quote", input: `insert 1 "John Doe a@b.com`, want: PREPARE_SYNTAX_ERROR},
+		{name: "unquoted space", input: `insert 1 John Doe a@b.com`, want: PREPARE_SYNTAX_ERROR},
+		{name: "quoted too long", input: `insert 1 "` + strings.Repeat("a ", COLUMN_USERNAME_SIZE) + `" a@b.com`, want: PREPARE_STRING_TOO_LONG},
+		{name: "quoted just fits", input: `insert 1 "` + strings.Repeat("a", COLUMN_USERNAME_SIZE) + `" a@b.com`, want: PREPARE_SUCCESS, wantRow: Row{id: 1, username: strings.Repeat("a", COLUMN_USERNAME_SIZE), email: "a@b.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statement, err := prepareStatement(tt.input)
+			if got := prepareResult(err); got != tt.want {
+				t.Fatalf("prepareStatement(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+			if tt.want != PREPARE_SUCCESS {
+				return
+			}
+			var row Row
+			switch statement := statement.(type) {
+			case *InsertStmt:
+				row = statement.Row
+			case *UpdateStmt:
+				row.id = statement.ID
+				for _, assignment := range statement.Set {
+					if assignment.Column == "username" {
+						row.username = assignment.Value
+					} else {
+						row.email = assignment.Value
+					}
+				}
+			}
+			if row != tt.wantRow {
+				t.Errorf("row = %+v, want %+v", row, tt.wantRow)
+			}
+		})
+	}
+}
+
+func FuzzPrepareStatement(f *testing.F) {
+	for _, seed := range []string{
+		"insert 1 user1 person1@example.com",
+		"insert 1",
+		"insert -1 a b",
+		"insert 4294967296 a b",
+		"update 1 renamed new@example.com",
+		"delete 1",
+		"delete",
+		"select",
+		"select 7",
+		"select count(*)",
+		"select order by id desc limit 3",
+		"select order",
+		"insert " + strings.Repeat(" ", 1000),
+		"insert 1 " + strings.Repeat("a", COLUMN_USERNAME_SIZE+1) + " b",
+		"bogus",
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		statement, err := prepareStatement(input)
+		if (err != nil) == (statement != nil) {
+			t.Errorf("prepareStatement(%q) = %v with error %v", input, statement, err)
+		}
+		var prepareErr *prepareError
+		if err != nil && (!errors.As(err, &prepareErr) || prepareErr.pos > len(input)) {
+			t.Errorf("prepareStatement(%q): %v does not say where it stopped", input, err)
+		}
+		switch result := prepareResult(err); result {
+		case PREPARE_SUCCESS:
+			var row Row
+			switch statement := statement.(type) {
+			case *InsertStmt:
+				row = statement.Row
+			case *UpdateStmt:
+				for _, assignment := range statement.Set {
+					if assignment.Column == "username" {
+						row.username = assignment.Value
+					} else {
+						row.email = assignment.Value
+					}
+				}
+			}
+			if len(row.username) > COLUMN_USERNAME_SIZE || len(row.email) > COLUMN_EMAIL_MAX_SIZE {
+				t.Errorf("prepareStatement(%q) accepted a row that does not fit: %+v", input, row)
+			}
+		case PREPARE_UNRECOGNIZED_STATEMENT, PREPARE_SYNTAX_ERROR, PREPARE_STRING_TOO_LONG, PREPARE_NEGATIVE_ID:
+		default:
+			t.Errorf("prepareStatement(%q) = %d, not a defined PrepareResult", input, result)
+		}
+	})
+}
+
+func BenchmarkInsert(b *testing.B) {
+	// start over with a fresh table every batch so a long run does not hold
+	// gigabytes of rows
+	const batchSize = 100_000
+
+	for _, name := range []string{"memory", "file"} {
+		b.Run(name, func(b *testing.B) {
+			open := func() *Table {
+				if name == "memory" {
+					return mustOpen(b, MEMORY_FILENAME)
+				}
+				return mustOpen(b, tempDBFileB(b))
+			}
+			table := open()
+			defer func() { dbClose(table) }()
+
+			statement := InsertStmt{Row: Row{username: "user", email: "person@example.com"}}
+			writer := bufio.NewWriter(io.Discard)
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if table.numRows == batchSize {
+					b.StopTimer()
+					dbClose(table)
+					table = open()
+					b.StartTimer()
+				}
+				statement.Row.id = table.numRows
+				if result := executeInsert(&statement, table, writer); result != EXECUTE_SUCCESS {
+					b.Fatalf("executeInsert = %d", result)
+				}
 			}
 		})
 	}
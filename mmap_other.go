@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported on this platform")
+}
+
+func munmapFile(mapped []byte) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first length bytes of file for reading.
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(mapped []byte) error {
+	return syscall.Munmap(mapped)
+}
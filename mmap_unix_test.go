@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMmap_ReadsPagesFromTheMapping(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*3)), io.Discard, table)
+	want, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	if _, err := dbOpenWith(fileName, OpenOptions{Mmap: true}); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("dbOpenWith mapping a writable table: err = %v", err)
+	}
+
+	table, err = dbOpenWith(fileName, OpenOptions{ReadOnly: true, Mmap: true, MaxCachedPages: 2})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+	if int64(len(table.pager.mapped)) != table.pager.fileLength {
+		t.Fatalf("mapped %d bytes of a %d byte file", len(table.pager.mapped), table.pager.fileLength)
+	}
+	got, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// pages come straight out of the mapping rather than a copy
+	page, err := getPage(table.pager, 1)
+	if err != nil {
+		t.Fatalf("getPage: %v", err)
+	}
+	if &page[0] != &table.pager.mapped[HEADER_SIZE+DEFAULT_PAGE_SIZE] {
+		t.Errorf("page 1 was copied out of the mapping")
+	}
+}
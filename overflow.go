@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Starting with OVERFLOW_FORMAT_VERSION, an email longer than
+// COLUMN_EMAIL_SIZE bytes is stored on a chain of overflow pages, and the row
+// holds a reference to it instead.
+const OVERFLOW_FORMAT_VERSION = 5
+
+// NODE_OVERFLOW pages hold a slice of one value: the header is followed by
+// as many of its bytes as fit, and nextPage links the page holding the rest;
+// 0 ends the chain, since page 0 is always a leaf.
+const (
+	NODE_OVERFLOW NodeType = 2
+
+	OVERFLOW_NODE_NEXT_PAGE_SIZE   = 4
+	OVERFLOW_NODE_NEXT_PAGE_OFFSET = COMMON_NODE_HEADER_SIZE
+	OVERFLOW_NODE_HEADER_SIZE      = OVERFLOW_NODE_NEXT_PAGE_OFFSET + OVERFLOW_NODE_NEXT_PAGE_SIZE
+)
+
+// overflowPageCapacity returns how many bytes of a value fit on one overflow
+// page.
+func overflowPageCapacity(pager *Pager) uint32 {
+	return pageUsableSize(pager) - OVERFLOW_NODE_HEADER_SIZE
+}
+
+// writeRow serializes row, first moving an email too long to keep inline
+// onto new overflow pages. Callers hold table.mu for writing.
+func writeRow(pager *Pager, row *Row) ([]byte, error) {
+	if len(row.email) <= COLUMN_EMAIL_SIZE {
+		return serializeRow(row, overflowRef{}), nil
+	}
+	pageNum, err := writeOverflow(pager, row.email)
+	if err != nil {
+		return nil, err
+	}
+	return serializeRow(row, overflowRef{length: uint32(len(row.email)), pageNum: pageNum}), nil
+}
+
+// readRow deserializes source into destination, reading an email stored on
+// overflow pages back from them.
+func readRow(pager *Pager, source []byte, destination *Row) error {
+	ref, err := deserializeRow(source, destination)
+	if err != nil || ref.pageNum == 0 {
+		return err
+	}
+	email, err := readOverflow(pager, ref.pageNum, ref.length)
+	if err != nil {
+		return fmt.Errorf("row %d: %w", destination.id, err)
+	}
+	destination.email = email
+	return nil
+}
+
+// freeRowOverflow frees the overflow pages a serialized row refers to, if
+// any. The row itself is left as it is.
+func freeRowOverflow(pager *Pager, value []byte) error {
+	var row Row
+	ref, err := deserializeRow(value, &row)
+	if err != nil {
+		return err
+	}
+	pageNum := ref.pageNum
+	var chain []uint32
+	for pageNum != 0 {
+		page, err := getPage(pager, pageNum)
+		if err != nil {
+			return err
+		}
+		if nodeType(page) != NODE_OVERFLOW {
+			return fmt.Errorf("%w: page %d is not an overflow page", ErrCorrupt, pageNum)
+		}
+		chain = append(chain, pageNum)
+		if len(chain) > int(pager.numPages) {
+			return fmt.Errorf("%w: overflow chain starting at page %d has a cycle", ErrCorrupt, chain[0])
+		}
+		pageNum = binary.LittleEndian.Uint32(page[OVERFLOW_NODE_NEXT_PAGE_OFFSET:])
+	}
+	// the chain was allocated in ascending order, so freeing it from the end
+	// gives back all of it when it is at the end of the file
+	for _, pageNum := range slices.Backward(chain) {
+		if err := pagerFreePage(pager, pageNum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOverflow stores value on a chain of new overflow pages and returns
+// the first one.
+func writeOverflow(pager *Pager, value string) (uint32, error) {
+	capacity := overflowPageCapacity(pager)
+	pageNums := make([]uint32, (uint32(len(value))+capacity-1)/capacity)
+	for i := range pageNums {
+		pageNum, err := pagerAllocatePage(pager)
+		if err != nil {
+			return 0, err
+		}
+		pageNums[i] = pageNum
+	}
+
+	for i, pageNum := range pageNums {
+		page, err := getPageForWrite(pager, pageNum)
+		if err != nil {
+			return 0, err
+		}
+		clear(page)
+		setNodeType(page, NODE_OVERFLOW)
+		if i+1 < len(pageNums) {
+			binary.LittleEndian.PutUint32(page[OVERFLOW_NODE_NEXT_PAGE_OFFSET:], pageNums[i+1])
+		}
+		value = value[copy(page[OVERFLOW_NODE_HEADER_SIZE:OVERFLOW_NODE_HEADER_SIZE+capacity], value):]
+	}
+	return pageNums[0], nil
+}
+
+// readOverflow reads a value of length bytes from the overflow chain starting
+// at page pageNum.
+func readOverflow(pager *Pager, pageNum uint32, length uint32) (string, error) {
+	return readOverflowFrom(func(pageNum uint32) (Page, error) {
+		return getPage(pager, pageNum)
+	}, overflowPageCapacity(pager), pageNum, length)
+}
+
+// readOverflowFrom is readOverflow reading the pages through getPage, with
+// capacity bytes of the value on each.
+func readOverflowFrom(getPage func(uint32) (Page, error), capacity, pageNum, length uint32) (string, error) {
+	value := make([]byte, 0, length)
+	for uint32(len(value)) < length {
+		if pageNum == 0 {
+			return "", fmt.Errorf("%w: overflow chain ends after %d of %d bytes", ErrCorrupt, len(value), length)
+		}
+		page, err := getPage(pageNum)
+		if err != nil {
+			return "", err
+		}
+		if nodeType(page) != NODE_OVERFLOW {
+			return "", fmt.Errorf("%w: page %d is not an overflow page", ErrCorrupt, pageNum)
+		}
+		n := min(length-uint32(len(value)), capacity)
+		value = append(value, page[OVERFLOW_NODE_HEADER_SIZE:OVERFLOW_NODE_HEADER_SIZE+n]...)
+		pageNum = binary.LittleEndian.Uint32(page[OVERFLOW_NODE_NEXT_PAGE_OFFSET:])
+	}
+	return string(value), nil
+}
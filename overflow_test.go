@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// longEmail returns an email of exactly n bytes.
+func longEmail(n int, fill string) string {
+	const domain = "@example.com"
+	return strings.Repeat(fill, n-len(domain)) + domain
+}
+
+func TestOverflow_LongEmailsRoundTrip(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	emails := map[uint32]string{
+		1: "short@example.com",
+		2: longEmail(COLUMN_EMAIL_SIZE+1, "a"),
+		3: longEmail(3*DEFAULT_PAGE_SIZE, "b"), // spans several overflow pages
+		4: longEmail(COLUMN_EMAIL_SIZE, "c"),
+	}
+	for id := uint32(1); id <= 4; id++ {
+		if err := table.Insert(id, fmt.Sprintf("user%d", id), emails[id]); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(rows) != len(emails) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(emails))
+	}
+	for _, row := range rows {
+		if row.email != emails[row.id] {
+			t.Errorf("row %d has an email of %d bytes, want %d", row.id, len(row.email), len(emails[row.id]))
+		}
+	}
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("select count where email "+emails[3]+"\n"), &output, table)
+	if !strings.Contains(output.String(), "count: 1\n") {
+		t.Errorf("select count where email on an overflowed email\ngot:\n%s", output.String())
+	}
+}
+
+func TestOverflow_PagesAreFreed(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	runREPL(strings.NewReader(insertRows(1, 2)), io.Discard, table)
+	pagesBefore := table.pager.numPages
+
+	email := longEmail(2*DEFAULT_PAGE_SIZE, "x")
+	tests := []struct {
+		name      string
+		input     string
+		wantPages uint32
+	}{
+		{name: "insert", input: "insert 3 long " + email + "\n", wantPages: pagesBefore + 3},
+		{name: "update to a short email", input: "update 3 set email=short@example.com\n", wantPages: pagesBefore},
+		{name: "update to a long email", input: "update 3 set email=" + email + "\n", wantPages: pagesBefore + 3},
+		{name: "undo the update", input: "+undo\n", wantPages: pagesBefore},
+		{name: "redo it", input: "update 3 long " + email + "\n", wantPages: pagesBefore + 3},
+		{name: "delete", input: "delete 3\n", wantPages: pagesBefore},
+	}
+	for _, tt := range tests {
+		var output bytes.Buffer
+		runREPL(strings.NewReader(tt.input), &output, table)
+		if strings.Contains(output.String(), "Error") {
+			t.Fatalf("%s: %s", tt.name, output.String())
+		}
+		if table.pager.numPages != tt.wantPages {
+			t.Errorf("%s: numPages = %d, want %d", tt.name, table.pager.numPages, tt.wantPages)
+		}
+	}
+}
+
+func TestOverflow_RejectsEmailsOverTheLimit(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	if err := table.Insert(1, "user", longEmail(COLUMN_EMAIL_MAX_SIZE+1, "a")); err != ErrStringTooLong {
+		t.Errorf("Insert with an email of %d bytes = %v, want %v", COLUMN_EMAIL_MAX_SIZE+1, err, ErrStringTooLong)
+	}
+	if err := table.Insert(1, "user", longEmail(COLUMN_EMAIL_MAX_SIZE, "a")); err != nil {
+		t.Errorf("Insert with an email of %d bytes: %v", COLUMN_EMAIL_MAX_SIZE, err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Starting with RECORD_FORMAT_VERSION, a row takes only as many bytes as its
+// values need instead of the full width of every column. Files of older
+// versions store fixed-size rows and are upgraded when opened.
+const RECORD_FORMAT_VERSION = 6
+
+// A row is encoded as its id, then the username as a uvarint length and its
+// bytes, then the email. The email starts with a uvarint holding twice its
+// length, plus one if it is stored on overflow pages; the bytes follow, or
+// for an overflowed email the number of its first overflow page.
+const (
+	ROW_ID_SIZE           = ID_SIZE
+	ROW_OVERFLOW_REF_SIZE = 4
+
+	// MAX_ROW_SIZE is the longest encoding of a row, with both columns full
+	// and the email inline.
+	MAX_ROW_SIZE = ROW_ID_SIZE + 1 + COLUMN_USERNAME_SIZE + 2 + COLUMN_EMAIL_SIZE
+)
+
+// overflowRef locates an email stored on overflow pages. A zero pageNum means
+// the email is inline, since page 0 is always a leaf.
+type overflowRef struct {
+	length  uint32
+	pageNum uint32
+}
+
+// serializeRow encodes row. If overflow is set the email is stored as a
+// reference to it instead of inline.
+func serializeRow(row *Row, overflow overflowRef) []byte {
+	value := make([]byte, ROW_ID_SIZE, MAX_ROW_SIZE)
+	binary.LittleEndian.PutUint32(value, row.id)
+	value = binary.AppendUvarint(value, uint64(len(row.username)))
+	value = append(value, row.username...)
+	if overflow.pageNum != 0 {
+		value = binary.AppendUvarint(value, uint64(overflow.length)*2+1)
+		return binary.LittleEndian.AppendUint32(value, overflow.pageNum)
+	}
+	value = binary.AppendUvarint(value, uint64(len(row.email))*2)
+	return append(value, row.email...)
+}
+
+// rowID reads just the id of a serialized row.
+func rowID(source []byte) uint32 {
+	return binary.LittleEndian.Uint32(source[:ROW_ID_SIZE])
+}
+
+// deserializeRow decodes source into destination. An email stored on
+// overflow pages is left empty, and the reference to it returned.
+func deserializeRow(source []byte, destination *Row) (overflowRef, error) {
+	if len(source) < ROW_ID_SIZE {
+		return overflowRef{}, fmt.Errorf("%w: row of %d bytes is too short for an id", ErrCorrupt, len(source))
+	}
+	destination.id = rowID(source)
+	rest := source[ROW_ID_SIZE:]
+
+	username, rest, ok := cutLengthPrefixed(rest)
+	if !ok {
+		return overflowRef{}, fmt.Errorf("%w: row %d has a malformed username", ErrCorrupt, destination.id)
+	}
+	destination.username = string(username)
+
+	header, n := binary.Uvarint(rest)
+	if n <= 0 || header/2 > COLUMN_EMAIL_MAX_SIZE {
+		return overflowRef{}, fmt.Errorf("%w: row %d has a malformed email", ErrCorrupt, destination.id)
+	}
+	rest = rest[n:]
+	if header%2 == 1 {
+		if len(rest) != ROW_OVERFLOW_REF_SIZE {
+			return overflowRef{}, fmt.Errorf("%w: row %d has a malformed overflow reference", ErrCorrupt, destination.id)
+		}
+		destination.email = ""
+		return overflowRef{length: uint32(header / 2), pageNum: binary.LittleEndian.Uint32(rest)}, nil
+	}
+	if uint64(len(rest)) != header/2 {
+		return overflowRef{}, fmt.Errorf("%w: row %d has a malformed email", ErrCorrupt, destination.id)
+	}
+	destination.email = string(rest)
+	return overflowRef{}, nil
+}
+
+// cutLengthPrefixed splits a value prefixed by its uvarint length off the
+// front of source.
+func cutLengthPrefixed(source []byte) (value, rest []byte, ok bool) {
+	length, n := binary.Uvarint(source)
+	if n <= 0 || length > uint64(len(source)-n) {
+		return nil, nil, false
+	}
+	end := n + int(length)
+	return source[n:end], source[end:], true
+}
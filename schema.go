@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Besides the built-in table of ids, usernames and emails, a database holds
+// the tables made by "create table", each with the columns it was created
+// with. A column is an int (or integer), a signed 64-bit integer; a real, a
+// 64-bit floating point number; a boolean; a text of at most the size it was
+// declared with; a blob, bytes likewise limited; or a date or timestamp, a
+// point in time to the day or the second, in UTC. The first column is the key
+// the rows are stored by and must be an int; its values are ids, from 0 to
+// 4294967295, like those of the built-in table.
+type ColumnType uint8
+
+const (
+	COLUMN_INT       ColumnType = 0
+	COLUMN_TEXT      ColumnType = 1
+	COLUMN_REAL      ColumnType = 2
+	COLUMN_BOOLEAN   ColumnType = 3
+	COLUMN_BLOB      ColumnType = 4
+	COLUMN_DATE      ColumnType = 5
+	COLUMN_TIMESTAMP ColumnType = 6
+)
+
+// columnTypes maps the names a type is written with to it.
+var columnTypes = map[string]ColumnType{
+	"int": COLUMN_INT, "integer": COLUMN_INT, "text": COLUMN_TEXT,
+	"real": COLUMN_REAL, "boolean": COLUMN_BOOLEAN, "blob": COLUMN_BLOB,
+	"date": COLUMN_DATE, "timestamp": COLUMN_TIMESTAMP,
+}
+
+var columnTypeNames = [...]string{"int", "text", "real", "boolean", "blob", "date", "timestamp"}
+
+// The layouts dates and timestamps are written in, ISO 8601. A timestamp may
+// also be written with no time zone, meaning UTC, or as a date, meaning its
+// midnight.
+const (
+	DATE_LAYOUT      = time.DateOnly
+	TIMESTAMP_LAYOUT = time.RFC3339
+)
+
+// sized reports whether columns of the type are declared with the most
+// bytes they hold.
+func (columnType ColumnType) sized() bool {
+	return columnType == COLUMN_TEXT || columnType == COLUMN_BLOB
+}
+
+// Column is one column of a table made by "create table".
+type Column struct {
+	Name    string
+	Type    ColumnType
+	Size    uint32  // the most bytes a text or blob column holds
+	Default *string // the value, as written, an insert leaving it out gives it; nil for null
+	Unique  bool    // no two records hold the same value, null aside
+	NotNull bool    // no record holds null
+}
+
+// Schema is the definition of a table made by "create table".
+type Schema struct {
+	Name    string
+	Columns []Column
+	Checks  []string // conditions no record may make false, as written; see check.go
+}
+
+// Value is the value of a column in a record: an int64 for an int column, a
+// float64 for a real, a bool for a boolean, a string for a text, a []byte
+// for a blob and a time.Time, in UTC, for a date or a timestamp; nil for
+// null.
+type Value any
+
+func (column Column) String() string {
+	definition := column.Name + " " + column.typeName()
+	if column.Default != nil {
+		definition += " default " + quoteLiteral(*column.Default)
+	}
+	if column.NotNull {
+		definition += " not null"
+	}
+	if column.Unique {
+		definition += " unique"
+	}
+	return definition
+}
+
+// quoteLiteral writes text so that it reads back as the same value: as it
+// is, unless it would not be read as a single token, or would be read as
+// null.
+func quoteLiteral(text string) string {
+	tokens, err := tokenize(text)
+	if err != nil || len(tokens) != 2 || tokens[0].Type == TOKEN_KEYWORD || tokens[0].Type == TOKEN_OPERATOR || tokens[0].Text != text || isNull(tokens[0]) {
+		return `"` + text + `"`
+	}
+	return text
+}
+
+// typeName returns the type of column as it is written in "create table".
+func (column Column) typeName() string {
+	if column.Type.sized() {
+		return fmt.Sprintf("%s(%d)", columnTypeNames[column.Type], column.Size)
+	}
+	return columnTypeNames[column.Type]
+}
+
+// String returns the definition the way it is written in "create table".
+func (schema *Schema) String() string {
+	items := make([]string, 0, len(schema.Columns)+len(schema.Checks))
+	for _, column := range schema.Columns {
+		items = append(items, column.String())
+	}
+	for _, check := range schema.Checks {
+		items = append(items, "check ("+check+")")
+	}
+	return schema.Name + " (" + strings.Join(items, ", ") + ")"
+}
+
+// A record is encoded like a row of the built-in table: the key, 4 bytes as
+// a row id is, then a bitmap with a bit for every other column, set if it is
+// null, and then those columns that are not, in order: an int as a varint, a
+// real as the 8 bytes of its IEEE 754 bits, a boolean as a byte, 0 or 1, a
+// text or blob as a uvarint length and its bytes, and a date or timestamp as
+// the varint of its Unix time in seconds. The longest record must fit in
+// MAX_ROW_SIZE, so records never need overflow pages.
+
+// maxRecordSize is the length of the longest record of columns.
+func maxRecordSize(columns []Column) uint64 {
+	size := uint64(ROW_ID_SIZE + nullBitmapSize(len(columns)))
+	for _, column := range columns[1:] {
+		size += maxValueSize(column)
+	}
+	return size
+}
+
+// nullBitmapSize is the length of the bitmap of a record of numColumns
+// columns, which has no bit for the key.
+func nullBitmapSize(numColumns int) int {
+	return (numColumns - 1 + 7) / 8
+}
+
+// maxValueSize is the length of the longest encoding of a value of column.
+func maxValueSize(column Column) uint64 {
+	switch column.Type {
+	case COLUMN_REAL:
+		return 8
+	case COLUMN_BOOLEAN:
+		return 1
+	case COLUMN_TEXT, COLUMN_BLOB:
+		return uint64(uvarintSize(uint64(column.Size))) + uint64(column.Size)
+	}
+	return binary.MaxVarintLen64
+}
+
+func uvarintSize(n uint64) int {
+	return len(binary.AppendUvarint(nil, n))
+}
+
+// encodeRecord encodes values, which fit the columns of schema.
+func encodeRecord(schema *Schema, values []Value) []byte {
+	record := binary.LittleEndian.AppendUint32(nil, uint32(values[0].(int64)))
+	nulls := make([]byte, nullBitmapSize(len(values)))
+	for i, value := range values[1:] {
+		if value == nil {
+			nulls[i/8] |= 1 << (i % 8)
+		}
+	}
+	record = append(record, nulls...)
+	for i, column := range schema.Columns[1:] {
+		switch value := values[i+1].(type) {
+		case nil:
+		case int64:
+			record = binary.AppendVarint(record, value)
+		case float64:
+			record = binary.LittleEndian.AppendUint64(record, math.Float64bits(value))
+		case bool:
+			record = append(record, 0)
+			if value {
+				record[len(record)-1] = 1
+			}
+		case string:
+			record = binary.AppendUvarint(record, uint64(len(value)))
+			record = append(record, value...)
+		case []byte:
+			record = binary.AppendUvarint(record, uint64(len(value)))
+			record = append(record, value...)
+		case time.Time:
+			record = binary.AppendVarint(record, value.Unix())
+		default:
+			panic(fmt.Sprintf("column %s cannot hold %T", column.Name, value))
+		}
+	}
+	return record
+}
+
+// decodeRecord decodes a record of a table with the columns of schema.
+func decodeRecord(schema *Schema, source []byte) ([]Value, error) {
+	if len(source) < ROW_ID_SIZE {
+		return nil, fmt.Errorf("%w: record of %d bytes is too short for a key", ErrCorrupt, len(source))
+	}
+	key := rowID(source)
+	values := []Value{int64(key)}
+	bitmapEnd := ROW_ID_SIZE + nullBitmapSize(len(schema.Columns))
+	if len(source) < bitmapEnd {
+		return nil, fmt.Errorf("%w: record %d is too short for its null bitmap", ErrCorrupt, key)
+	}
+	nulls, rest := source[ROW_ID_SIZE:bitmapEnd], source[bitmapEnd:]
+	for i, column := range schema.Columns[1:] {
+		malformed := fmt.Errorf("%w: record %d has a malformed %s", ErrCorrupt, key, column.Name)
+		if nulls[i/8]&(1<<(i%8)) != 0 {
+			values = append(values, nil)
+			continue
+		}
+		switch column.Type {
+		case COLUMN_TEXT, COLUMN_BLOB:
+			data, after, ok := cutLengthPrefixed(rest)
+			if !ok || len(data) > int(column.Size) {
+				return nil, malformed
+			}
+			if column.Type == COLUMN_TEXT {
+				values = append(values, string(data))
+			} else {
+				values = append(values, bytes.Clone(data))
+			}
+			rest = after
+		case COLUMN_REAL:
+			if len(rest) < 8 {
+				return nil, malformed
+			}
+			values, rest = append(values, math.Float64frombits(binary.LittleEndian.Uint64(rest))), rest[8:]
+		case COLUMN_BOOLEAN:
+			if len(rest) < 1 || rest[0] > 1 {
+				return nil, malformed
+			}
+			values, rest = append(values, rest[0] == 1), rest[1:]
+		default:
+			value, n := binary.Varint(rest)
+			if n <= 0 {
+				return nil, malformed
+			}
+			if column.Type == COLUMN_DATE || column.Type == COLUMN_TIMESTAMP {
+				values, rest = append(values, time.Unix(value, 0).UTC()), rest[n:]
+				continue
+			}
+			values, rest = append(values, value), rest[n:]
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: record %d runs %d bytes past its last column", ErrCorrupt, key, len(rest))
+	}
+	return values, nil
+}
+
+// compareValues orders two values of the same column: numbers by value,
+// false before true, and texts and blobs byte by byte.
+func compareValues(a, b Value) int {
+	switch a := a.(type) {
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case a:
+			return 1
+		}
+		return -1
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("cannot compare %T", a))
+}
+
+// formatValue writes value, of column, the way a statement would: a blob as
+// x'<hex>', a date or timestamp in ISO 8601.
+func formatValue(column Column, value Value) string {
+	switch value := value.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case []byte:
+		return "x'" + hex.EncodeToString(value) + "'"
+	case time.Time:
+		if column.Type == COLUMN_DATE {
+			return value.Format(DATE_LAYOUT)
+		}
+		return value.Format(TIMESTAMP_LAYOUT)
+	}
+	return fmt.Sprint(value)
+}
+
+// createTable parses "create table <name> (<column> <type> [default
+// <value>] [not null] [unique] [check (<condition>)], ...)", where a type is
+// int, integer, real, boolean, text(<size>), blob(<size>), date or
+// timestamp. Checks may also stand among the columns, after the first.
+func (p *parser) createTable() (Statement, PrepareResult) {
+	if result := p.expect("table"); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	if result := p.expect("("); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+
+	statement := &CreateTableStmt{Schema: Schema{Name: name}}
+	for {
+		// the key comes first, so a check cannot
+		if len(statement.Schema.Columns) > 0 && p.isCheck() {
+			if result := p.check(&statement.Schema); result != PREPARE_SUCCESS {
+				return nil, result
+			}
+		} else {
+			column, result := p.column(&statement.Schema)
+			if result != PREPARE_SUCCESS {
+				return nil, result
+			}
+			statement.Schema.Columns = append(statement.Schema.Columns, column)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	if result := p.expect(")"); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	if result := p.checkChecks(&statement.Schema); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	return statement, p.end()
+}
+
+// dropTable parses "drop table [if exists] <name>".
+func (p *parser) dropTable() (Statement, PrepareResult) {
+	if result := p.expect("table"); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	statement := &DropTableStmt{}
+	if p.accept("if") {
+		if result := p.expect("exists"); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		statement.IfExists = true
+	}
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	statement.Name = name
+	return statement, p.end()
+}
+
+// column parses "<name> <type> [<attribute> ...]", the next column of
+// schema.
+func (p *parser) column(schema *Schema) (Column, PrepareResult) {
+	nameToken := p.peek()
+	name, result := p.name("a column name")
+	if result != PREPARE_SUCCESS {
+		return Column{}, result
+	}
+	for _, column := range schema.Columns {
+		if column.Name == name {
+			return Column{}, p.failAt(nameToken, PREPARE_SYNTAX_ERROR, fmt.Sprintf("column %s is defined twice", name))
+		}
+	}
+
+	typeToken := p.peek()
+	columnType, ok := columnTypes[typeToken.Text]
+	if typeToken.Type != TOKEN_IDENTIFIER || !ok {
+		return Column{}, p.fail("int, real, boolean, text(<size>), blob(<size>), date or timestamp")
+	}
+	p.advance()
+	column := Column{Name: name, Type: columnType}
+	if columnType.sized() {
+		if result := p.expect("("); result != PREPARE_SUCCESS {
+			return Column{}, result
+		}
+		sizeToken := p.peek()
+		size, err := strconv.ParseUint(sizeToken.Text, 10, 32)
+		if sizeToken.Type != TOKEN_NUMBER || err != nil || size == 0 {
+			return Column{}, p.fail("a size of at least 1")
+		}
+		p.advance()
+		column.Size = uint32(size)
+		if result := p.expect(")"); result != PREPARE_SUCCESS {
+			return Column{}, result
+		}
+	}
+
+	if len(schema.Columns) == 0 && column.Type != COLUMN_INT {
+		return Column{}, p.failAt(typeToken, PREPARE_SYNTAX_ERROR, "the first column is the key, and must be an int")
+	}
+	if result := p.columnAttributes(&column, schema); result != PREPARE_SUCCESS {
+		return Column{}, result
+	}
+	if size := maxRecordSize(append(schema.Columns, column)); size > MAX_ROW_SIZE {
+		return Column{}, p.failAt(nameToken, PREPARE_SYNTAX_ERROR, fmt.Sprintf("rows could take %d bytes with %s, more than the %d a row may", size, name, MAX_ROW_SIZE))
+	}
+	return column, PREPARE_SUCCESS
+}
+
+// columnAttributes parses what may follow the type of column, the next of
+// schema, in any order: "default <value>", "not null", "unique" and any
+// number of "check (<condition>)", which go to schema.
+func (p *parser) columnAttributes(column *Column, schema *Schema) PrepareResult {
+	isKey := len(schema.Columns) == 0
+	seen := map[string]bool{}
+	for {
+		if p.isCheck() {
+			if result := p.check(schema); result != PREPARE_SUCCESS {
+				return result
+			}
+			continue
+		}
+		token := p.peek()
+		if token.Type != TOKEN_IDENTIFIER || token.Text != "default" && token.Text != "not" && token.Text != "unique" {
+			return PREPARE_SUCCESS
+		}
+		if seen[token.Text] {
+			return p.failAt(token, PREPARE_SYNTAX_ERROR, fmt.Sprintf("column %s is %s twice", column.Name, token.Text))
+		}
+		seen[token.Text] = true
+		p.advance()
+
+		switch token.Text {
+		case "default":
+			if isKey {
+				return p.failAt(token, PREPARE_SYNTAX_ERROR, "the key cannot have a default")
+			}
+			value := p.peek()
+			if value.Type != TOKEN_NUMBER && value.Type != TOKEN_STRING && value.Type != TOKEN_IDENTIFIER {
+				return p.fail("a value")
+			}
+			if !isNull(value) {
+				if result := p.checkValue(value, *column); result != PREPARE_SUCCESS {
+					return result
+				}
+				column.Default = &value.Text
+			}
+			p.advance()
+		case "not":
+			if next := p.peek(); !isNull(next) {
+				return p.fail(`"null"`)
+			}
+			p.advance()
+			// the key is never null anyway
+			column.NotNull = !isKey
+		case "unique":
+			// nor is it ever held twice
+			column.Unique = !isKey
+		}
+	}
+}
+
+// name reads the name of a table or column: letters, digits and
+// underscores, not starting with a digit.
+func (p *parser) name(expected string) (string, PrepareResult) {
+	token := p.peek()
+	if token.Type != TOKEN_IDENTIFIER || !isName(token.Text) {
+		return "", p.fail(expected)
+	}
+	p.advance()
+	return token.Text, PREPARE_SUCCESS
+}
+
+// columnName reads the name of a column in a select, which may be qualified
+// by the name of its table, as products.name.
+func (p *parser) columnName() (string, PrepareResult) {
+	token := p.peek()
+	name := token.Text
+	if table, column, qualified := strings.Cut(name, "."); qualified && isName(table) {
+		name = column
+	}
+	if token.Type != TOKEN_IDENTIFIER || !isName(name) {
+		return "", p.fail("a column name")
+	}
+	p.advance()
+	return token.Text, PREPARE_SUCCESS
+}
+
+func isName(word string) bool {
+	for i, r := range word {
+		if r != '_' && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && (i == 0 || !('0' <= r && r <= '9')) {
+			return false
+		}
+	}
+	return word != ""
+}
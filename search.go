@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// tableFind returns a cursor at the row with the given id, or, if there is
+// none, at the position a row with that id would be inserted at.
+func tableFind(table *Table, id uint32) (cursor *Cursor, found bool, err error) {
+	_, leafPageNum, err := findLeaf(table, id)
+	if err != nil {
+		return nil, false, err
+	}
+	return leafNodeFind(table, leafPageNum, id)
+}
+
+// insertRow stores row at its position in the tree and records its id.
+// Callers hold table.mu for writing and have checked that the id is free in
+// table.ids; the tree is checked again, so that it never holds an id twice.
+func insertRow(table *Table, row *Row) (err error) {
+	cursor, found, err := tableFind(table, row.id)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("%w: id %d is stored but was missing from the ids", ErrDuplicateKey, row.id)
+	}
+
+	// a split may touch every node on the way up from the leaf
+	pagerHoldPages(table.pager)
+	defer func() {
+		if releaseErr := pagerReleasePages(table.pager); err == nil {
+			err = releaseErr
+		}
+	}()
+	value, err := writeRow(table.pager, row)
+	if err != nil {
+		return err
+	}
+	if err := leafNodeInsert(cursor, row.id, value); err != nil {
+		// don't leave the email's overflow pages behind
+		if freeErr := freeRowOverflow(table.pager, value); freeErr != nil {
+			return freeErr
+		}
+		return err
+	}
+	table.numRows++
+	table.ids[row.id] = struct{}{}
+	table.lastID = max(table.lastID, row.id)
+	return nil
+}
+
+// nextID returns the id for a row inserted without one: one more than the
+// largest id the built-in table has held. The catalog keeps the largest id
+// whenever it is saved, as it is after every such insert and by keepLastID,
+// so an id handed out or inserted is not handed out again once its row is
+// deleted, even after the database is reopened.
+func nextID(table *Table) (uint32, error) {
+	if table.lastID == math.MaxUint32 {
+		return 0, fmt.Errorf("%w: every id has been used", ErrTableFull)
+	}
+	return table.lastID + 1, nil
+}
+
+// keepLastID saves table.lastID in the catalog once no row holds it, as
+// after the row with the largest id is deleted. Until then there is no need:
+// reopening the database finds it again as the largest id stored, and a
+// table in memory is never reopened. Callers hold table.mu for writing, and
+// call it once a statement has removed its rows, since the catalog may have
+// to move a page to make room.
+func keepLastID(table *Table) error {
+	if _, held := table.ids[table.lastID]; held || table.lastID == 0 || table.pager.file == nil {
+		return nil
+	}
+	if err := ensureCatalog(table); err != nil {
+		return err
+	}
+	return saveCatalog(table)
+}
+
+// insertRecord stores an encoded record of a table made by create table at
+// its position in the tree and records its key. Callers hold table.mu for
+// writing and have checked that the key is free, as insertRow's have.
+func insertRecord(table *Table, record []byte) (err error) {
+	key := rowID(record)
+	cursor, found, err := tableFind(table, key)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("%w: key %d is stored but was missing from the ids", ErrDuplicateKey, key)
+	}
+
+	pagerHoldPages(table.pager)
+	defer func() {
+		if releaseErr := pagerReleasePages(table.pager); err == nil {
+			err = releaseErr
+		}
+	}()
+	if err := leafNodeInsert(cursor, key, record); err != nil {
+		return err
+	}
+	table.numRows++
+	table.ids[key] = struct{}{}
+	return nil
+}
+
+// deleteRecord removes the record of tree with key, and its key from
+// tree.ids. Callers hold table.mu for writing.
+func deleteRecord(tree *Table, key uint32) (err error) {
+	cursor, found, err := tableFind(tree, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: key %d is missing from its table", ErrCorrupt, key)
+	}
+	// rebalancing may touch every node on the way up from the leaf
+	pagerHoldPages(tree.pager)
+	defer func() {
+		if releaseErr := pagerReleasePages(tree.pager); err == nil {
+			err = releaseErr
+		}
+	}()
+	if err := leafNodeDelete(cursor); err != nil {
+		return err
+	}
+	tree.numRows--
+	delete(tree.ids, key)
+	return nil
+}
+
+// rewriteRow replaces the row under the cursor with row, which has the same
+// id, moving the email's overflow pages along with it. A row whose encoding
+// changes length is taken out of its leaf and inserted again. Callers hold
+// table.mu for writing.
+func rewriteRow(cursor *Cursor, row *Row) (err error) {
+	table := cursor.table
+	pager := table.pager
+	// the leaf must stay cached while overflow pages are written
+	pagerHoldPages(pager)
+	defer func() {
+		if releaseErr := pagerReleasePages(pager); err == nil {
+			err = releaseErr
+		}
+	}()
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		return err
+	}
+	if err := freeRowOverflow(pager, slot); err != nil {
+		return err
+	}
+	value, err := writeRow(pager, row)
+	if err != nil {
+		return err
+	}
+	if len(value) == len(slot) {
+		slot, err := cursorValueForWrite(cursor)
+		if err != nil {
+			return err
+		}
+		copy(slot, value)
+		return nil
+	}
+
+	if err := leafNodeDelete(cursor); err != nil {
+		return err
+	}
+	cursor, _, err = tableFind(table, row.id)
+	if err != nil {
+		return err
+	}
+	return leafNodeInsert(cursor, row.id, value)
+}
+
+// rewriteRecord replaces the record of tree with the key of record by
+// record. A record whose length changes is taken out of its leaf and
+// inserted again. Callers hold table.mu for writing.
+func rewriteRecord(tree *Table, record []byte) (err error) {
+	key := rowID(record)
+	cursor, found, err := tableFind(tree, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: key %d is missing from its table", ErrCorrupt, key)
+	}
+	pagerHoldPages(tree.pager)
+	defer func() {
+		if releaseErr := pagerReleasePages(tree.pager); err == nil {
+			err = releaseErr
+		}
+	}()
+	slot, err := cursorValue(cursor)
+	if err != nil {
+		return err
+	}
+	if len(slot) == len(record) {
+		slot, err := cursorValueForWrite(cursor)
+		if err != nil {
+			return err
+		}
+		copy(slot, record)
+		return nil
+	}
+
+	if err := leafNodeDelete(cursor); err != nil {
+		return err
+	}
+	cursor, _, err = tableFind(tree, key)
+	if err != nil {
+		return err
+	}
+	return leafNodeInsert(cursor, key, record)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestTableFind_MatchesStoredRows(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	var input strings.Builder
+	for range rowsPerLeaf * 3 {
+		id := rng.IntN(1000)
+		fmt.Fprintf(&input, "insert %d user%d person%d@example.com\n", id, id, id)
+	}
+	runREPL(strings.NewReader(input.String()), io.Discard, table)
+
+	for id := range uint32(1000) {
+		cursor, found, err := tableFind(table, id)
+		if err != nil {
+			t.Fatalf("tableFind(%d): %v", id, err)
+		}
+		if _, want := table.ids[id]; found != want {
+			t.Errorf("tableFind(%d) found = %v, want %v", id, found, want)
+		}
+		if !found {
+			continue
+		}
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			t.Fatalf("cursorValue: %v", err)
+		}
+		if got := rowID(slot); got != id {
+			t.Errorf("tableFind(%d) points at row %d", id, got)
+		}
+	}
+}
+
+func TestInsert_KeepsRowsOrderedByID(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	input := "insert 5 e e@example.com\ninsert 1 a a@example.com\ninsert 3 c c@example.com\n" +
+		"delete 1\nupdate 3 cc cc@example.com\nselect 3\nselect\n"
+	runREPL(strings.NewReader(input), &output, table)
+
+	want := "(3, cc, cc@example.com)\nExecuted.\nsimpledbgo > (3, cc, cc@example.com)\n(5, e, e@example.com)\n"
+	if !strings.Contains(output.String(), want) {
+		t.Errorf("output missing %q\ngot:\n%s", want, output.String())
+	}
+}
+
+func TestInsert_TreeRejectsDuplicateIDs(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*2)), io.Discard, table)
+
+	// even if the ids lost track of a row, the tree does not take its id
+	// twice
+	delete(table.ids, 7)
+	err := table.Insert(7, "again", "again@example.com")
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("Insert of a stored id: %v, want ErrDuplicateKey", err)
+	}
+	if table.numRows != uint32(rowsPerLeaf*2) {
+		t.Errorf("numRows = %d, want %d", table.numRows, rowsPerLeaf*2)
+	}
+}
+
+func TestInsert_SplitsFullLeaves(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	// random order splits leaves in the middle as well as at the end
+	ids := rng.Perm(rowsPerLeaf * 5)
+	for _, id := range ids {
+		if err := table.Insert(uint32(id), fmt.Sprintf("user%d", id), "x@example.com"); err != nil {
+			t.Fatalf("Insert(%d): %v", id, err)
+		}
+	}
+	if table.pager.numPages < 5 {
+		t.Errorf("numPages = %d, want at least 5", table.pager.numPages)
+	}
+
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(rows) != len(ids) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(ids))
+	}
+	for i, row := range rows {
+		if row.id != uint32(i) {
+			t.Fatalf("row %d has id %d, want %d", i, row.id, i)
+		}
+	}
+}
+
+func TestInsert_AppendingFillsLeaves(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*3)), io.Discard, table)
+	// every leaf but the last is filled to within a row of its space
+	space := leafNodeSpace(table.pager)
+	for pageNum := uint32(0); ; {
+		leaf, err := getPage(table.pager, pageNum)
+		if err != nil {
+			t.Fatalf("getPage(%d): %v", pageNum, err)
+		}
+		pageNum = leafNodeNextLeaf(leaf)
+		if pageNum == 0 {
+			break
+		}
+		if used := leafNodeUsedSpace(leaf); used+LEAF_NODE_CELL_POINTER_SIZE+LEAF_NODE_MAX_CELL_SIZE < space {
+			t.Errorf("leaf before page %d uses %d of %d bytes after appending rows", pageNum, used, space)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// A Snapshot is a view of the table as it was when Table.Snapshot took it,
+// which later statements do not change. Unlike +snapshot it copies nothing
+// up front: while snapshots are open, the first getPageForWrite of a page
+// after a snapshot was taken saves a copy of the page for it, and the
+// snapshot reads its saved copies first and the pages in the cache or the
+// file otherwise, since those have not changed. A scan through a snapshot
+// holds table.mu only while it fetches each page, so statements run in
+// between without the scan seeing them.
+//
+// Rolling back, +vacuum, compaction and closing the table replace pages
+// without going through getPageForWrite, or put back ones that were not
+// saved; they make the open snapshots fail with ErrSnapshotStale instead.
+type Snapshot struct {
+	table       *Table
+	rootPageNum uint32
+	numRows     uint32
+	numPages    uint32
+
+	// guarded by table.pager.mu
+	saved map[uint32]Page
+	stale bool
+}
+
+var errSnapshotClosed = errors.New("snapshot is closed")
+
+// snapshotOpen takes a snapshot of table.
+func snapshotOpen(table *Table) *Snapshot {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	pager := table.pager
+	snapshot := &Snapshot{
+		table:       table,
+		rootPageNum: table.rootPageNum,
+		numRows:     table.numRows,
+		numPages:    pager.numPages,
+		saved:       make(map[uint32]Page),
+	}
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.snapshots = append(pager.snapshots, snapshot)
+	return snapshot
+}
+
+// snapshotClose stops saving pages for snapshot and drops those it has.
+func snapshotClose(snapshot *Snapshot) {
+	pager := snapshot.table.pager
+	pager.mu.Lock()
+	defer pager.mu.Unlock()
+	pager.snapshots = slices.DeleteFunc(pager.snapshots, func(open *Snapshot) bool { return open == snapshot })
+	snapshot.saved = nil
+}
+
+// snapshotSavePage saves the page pageNum, about to be modified, for the
+// open snapshots that still need it. Callers hold pager.mu.
+func snapshotSavePage(pager *Pager, pageNum uint32) {
+	var saved Page
+	for _, snapshot := range pager.snapshots {
+		if pageNum >= snapshot.numPages {
+			continue // allocated after the snapshot, which never reads it
+		}
+		if _, ok := snapshot.saved[pageNum]; ok {
+			continue
+		}
+		if saved == nil {
+			saved = slices.Clone(pager.pages[pageNum])
+		}
+		snapshot.saved[pageNum] = saved
+	}
+}
+
+// invalidateSnapshots makes every open snapshot fail with ErrSnapshotStale.
+// Callers hold pager.mu.
+func invalidateSnapshots(pager *Pager) {
+	for _, snapshot := range pager.snapshots {
+		snapshot.stale = true
+		snapshot.saved = nil
+	}
+	pager.snapshots = nil
+}
+
+// snapshotPage returns page pageNum as it was when snapshot was taken. The
+// page is the snapshot's own copy, which no statement writes to.
+func snapshotPage(snapshot *Snapshot, pageNum uint32) (Page, error) {
+	table := snapshot.table
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	pager := table.pager
+	pager.mu.Lock()
+	stale, saved := snapshot.stale, snapshot.saved
+	page, ok := saved[pageNum]
+	pager.mu.Unlock()
+	switch {
+	case stale:
+		return nil, ErrSnapshotStale
+	case saved == nil:
+		return nil, errSnapshotClosed
+	case pageNum >= snapshot.numPages:
+		return nil, fmt.Errorf("%w: page %d is past the last page", ErrCorrupt, pageNum)
+	case ok:
+		return page, nil
+	}
+
+	page, err := getPage(pager, pageNum)
+	if err != nil {
+		return nil, err
+	}
+	// the cached page is written to in place once the lock is released
+	return slices.Clone(page), nil
+}
+
+// snapshotRows returns the rows of snapshot whose ids lie in [from, to],
+// ordered by id.
+func snapshotRows(snapshot *Snapshot, from, to uint32) ([]Row, error) {
+	// descend to the leaf whose keys cover from
+	node, err := snapshotPage(snapshot, snapshot.rootPageNum)
+	for err == nil && nodeType(node) == NODE_INTERNAL {
+		child := internalNodeChild(node, internalNodeFindChild(node, from))
+		node, err = snapshotPage(snapshot, child)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := overflowPageCapacity(snapshot.table.pager)
+	getPage := func(pageNum uint32) (Page, error) { return snapshotPage(snapshot, pageNum) }
+	var rows []Row
+	for {
+		for cellNum := range leafNodeNumCells(node) {
+			value := leafNodeValue(node, cellNum)
+			if id := rowID(value); id < from {
+				continue
+			} else if id > to {
+				return rows, nil
+			}
+			var row Row
+			ref, err := deserializeRow(value, &row)
+			if err != nil {
+				return nil, err
+			}
+			if ref.pageNum != 0 {
+				if row.email, err = readOverflowFrom(getPage, capacity, ref.pageNum, ref.length); err != nil {
+					return nil, fmt.Errorf("row %d: %w", row.id, err)
+				}
+			}
+			rows = append(rows, row)
+		}
+		next := leafNodeNextLeaf(node)
+		if next == 0 {
+			return rows, nil
+		}
+		if node, err = snapshotPage(snapshot, next); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot_IgnoresLaterStatements(t *testing.T) {
+	table, want := fragmentedTable(t, tempDBFile(t), OpenOptions{MaxCachedPages: 4})
+	defer dbClose(table)
+
+	snapshot := table.Snapshot()
+	defer snapshot.Close()
+
+	// another goroutine splits leaves, frees pages and rewrites overflow
+	// chains while the snapshot is read
+	var input strings.Builder
+	input.WriteString(insertRows(200, 400))
+	input.WriteString(deleteRows(1, 9))
+	for id := 160; id <= 170; id++ {
+		fmt.Fprintf(&input, "update %d set email = changed%d@example.com\n", id, id)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runREPL(strings.NewReader(input.String()), io.Discard, table)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		got, err := snapshot.SelectAll()
+		if err != nil {
+			t.Fatalf("SelectAll: %v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("snapshot holds %d rows, want the %d from before", len(got), len(want))
+		}
+	}
+
+	if now, err := table.SelectAll(); err != nil || slices.Equal(now, want) {
+		t.Fatalf("table.SelectAll() = %d rows, %v; want the changes", len(now), err)
+	}
+	got, err := snapshot.SelectRange(5, 165)
+	if err != nil {
+		t.Fatalf("SelectRange: %v", err)
+	}
+	if wantRange := want[4:]; !slices.Equal(got, wantRange[:len(wantRange)-5]) {
+		t.Errorf("SelectRange(5, 165) = %d rows, want %d", len(got), len(wantRange)-5)
+	}
+	if snapshot.NumRows() != uint32(len(want)) {
+		t.Errorf("NumRows() = %d, want %d", snapshot.NumRows(), len(want))
+	}
+}
+
+func TestSnapshot_StaleAfterRollback(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 10)), io.Discard, table)
+
+	snapshot := table.Snapshot()
+	runREPL(strings.NewReader("+begin\n"+insertRows(11, 20)+"+rollback\n"), io.Discard, table)
+	if _, err := snapshot.SelectAll(); !errors.Is(err, ErrSnapshotStale) {
+		t.Errorf("SelectAll after +rollback: %v, want ErrSnapshotStale", err)
+	}
+	snapshot.Close()
+
+	snapshot = table.Snapshot()
+	snapshot.Close()
+	if _, err := snapshot.SelectAll(); !errors.Is(err, errSnapshotClosed) {
+		t.Errorf("SelectAll after Close: %v, want errSnapshotClosed", err)
+	}
+	if len(table.pager.snapshots) != 0 {
+		t.Errorf("%d snapshots still open", len(table.pager.snapshots))
+	}
+}
@@ -0,0 +1,1065 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statements name the table made by create table they work on:
+//
+//	insert into <table> [(<column>, ...)] values (<value>, ...)
+//	update <table> set <column> = <value>, ... where <condition>
+//	delete from <table> [where <condition>]
+//	select [count] [* | <column>, ... | <aggregate>, ...] from <table>
+//		[[inner | left [outer]] join <table> on <condition>]
+//		[where <condition>] [group by <column>, ... [having <condition>]]
+//		[order [by] <column> [asc|desc]] [limit <n> [offset <m>]]
+//
+// and without a table name work on the built-in one, as they always did.
+// Values are single tokens, so a text holding whitespace, a comma or a
+// parenthesis needs quotes. A real is written as a decimal number, a boolean
+// as true or false, a blob in hex as x'<hex>', and a date or timestamp in ISO
+// 8601, as 2024-05-01 or 2024-05-01T12:30:00Z. Any column but the key may
+// hold null, written unquoted; a comparison with a null is never true. No two
+// records hold the same value in a unique column, though any number may hold
+// null there, none holds null in a column that is not null, and none makes a
+// check false; see check.go. An insert naming its columns gives those it
+// leaves out their default, or null if they have none. When the statement is
+// prepared against a catalog, the table, its columns and the values given
+// for them are checked then, pointing at the one in error; they are checked
+// again when the statement runs. Undo does not reach these tables.
+
+var (
+	errNoSuchColumn = errors.New("no such column")
+	errTypeMismatch = errors.New("value does not match the column's type")
+	errValueCount   = errors.New("wrong number of values")
+
+	errUniqueViolation  = errors.New("unique constraint failed")
+	errNotNullViolation = errors.New("not null constraint failed")
+)
+
+// insertInto parses "into <table> [(<column>, ...)] values (<value>, ...)".
+func (p *parser) insertInto() (Statement, PrepareResult) {
+	statement := &InsertStmt{}
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	statement.Table = name
+	if result := p.lookupTable(); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	var targets []Column // the columns the values are for, once looked up
+	if p.schema != nil {
+		targets = p.schema.Columns
+	}
+	if p.accept("(") {
+		statement.Columns, targets = []string{}, nil
+		for {
+			token := p.peek()
+			name, result := p.name("a column name")
+			if result != PREPARE_SUCCESS {
+				return nil, result
+			}
+			if slices.Contains(statement.Columns, name) {
+				return nil, p.failAt(token, PREPARE_SYNTAX_ERROR, fmt.Sprintf("column %s is given twice", name))
+			}
+			if p.schema != nil {
+				i := columnIndex(p.schema, name)
+				if i == -1 {
+					return nil, p.failAt(token, PREPARE_NO_SUCH_COLUMN, fmt.Sprintf("table %s has no column %s", p.schema.Name, name))
+				}
+				targets = append(targets, p.schema.Columns[i])
+			}
+			statement.Columns = append(statement.Columns, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if result := p.expect(")"); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		if p.schema != nil && !slices.Contains(statement.Columns, p.schema.Columns[0].Name) {
+			return nil, p.failAt(p.tokens[p.next-1], PREPARE_TYPE_MISMATCH, "the key "+p.schema.Columns[0].Name+" cannot be null")
+		}
+	}
+	if result := p.expect("values"); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	if result := p.expect("("); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	for {
+		value := p.peek()
+		if value.Type != TOKEN_NUMBER && value.Type != TOKEN_STRING && value.Type != TOKEN_IDENTIFIER {
+			return nil, p.fail("a value")
+		}
+		if result := p.checkInsertValue(value, targets, len(statement.Values)); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		p.advance()
+		statement.Values = append(statement.Values, Literal{Text: value.Text, Null: isNull(value)})
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.schema != nil && len(statement.Values) < len(targets) {
+		return nil, p.fail("a value for " + targets[len(statement.Values)].Name)
+	}
+	if result := p.expect(")"); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	return statement, p.end()
+}
+
+// updateTable parses "<table> set <column> = <value>, ... where
+// <condition>" into statement. The key cannot be assigned, and, as on the
+// built-in table, the where clause cannot be left out.
+func (p *parser) updateTable(statement *UpdateStmt) PrepareResult {
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.Table = name
+	if result := p.lookupTable(); result != PREPARE_SUCCESS {
+		return result
+	}
+	if result := p.expect("set"); result != PREPARE_SUCCESS {
+		return result
+	}
+	for {
+		token := p.peek()
+		name, result := p.name("a column name")
+		if result != PREPARE_SUCCESS {
+			return result
+		}
+		if slices.Contains(statement.Columns, name) {
+			return p.failAt(token, PREPARE_SYNTAX_ERROR, fmt.Sprintf("column %s is assigned twice", name))
+		}
+		var target Column
+		if p.schema != nil {
+			i := columnIndex(p.schema, name)
+			switch i {
+			case -1:
+				return p.failAt(token, PREPARE_NO_SUCH_COLUMN, fmt.Sprintf("table %s has no column %s", p.schema.Name, name))
+			case 0:
+				return p.failAt(token, PREPARE_SYNTAX_ERROR, fmt.Sprintf("the key %s cannot be assigned", name))
+			}
+			target = p.schema.Columns[i]
+		}
+		if result := p.expect("="); result != PREPARE_SUCCESS {
+			return result
+		}
+		value := p.peek()
+		if value.Type != TOKEN_NUMBER && value.Type != TOKEN_STRING && value.Type != TOKEN_IDENTIFIER {
+			return p.fail("a value")
+		}
+		if p.schema != nil && !isNull(value) {
+			if result := p.checkValue(value, target); result != PREPARE_SUCCESS {
+				return result
+			}
+		}
+		p.advance()
+		statement.Columns = append(statement.Columns, name)
+		statement.Values = append(statement.Values, Literal{Text: value.Text, Null: isNull(value)})
+		if !p.accept(",") {
+			break
+		}
+	}
+	if result := p.expect("where"); result != PREPARE_SUCCESS {
+		return result
+	}
+	p.anyColumn = true
+	where, result := p.where()
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.Where = where
+	return PREPARE_SUCCESS
+}
+
+// deleteTable parses "<table> [where <condition>]", after delete from, into
+// statement.
+func (p *parser) deleteTable(statement *DeleteStmt) PrepareResult {
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.Table = name
+	if result := p.lookupTable(); result != PREPARE_SUCCESS {
+		return result
+	}
+	if !p.accept("where") {
+		return PREPARE_SUCCESS
+	}
+	p.anyColumn = true
+	where, result := p.where()
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.Where = where
+	return PREPARE_SUCCESS
+}
+
+// lookupTable finds the table the statement names, the token before the
+// next, in the catalog, so the rest can be checked against its columns.
+// Without a catalog there is nothing to check.
+func (p *parser) lookupTable() PrepareResult {
+	if p.catalog == nil {
+		return PREPARE_SUCCESS
+	}
+	name := p.tokens[p.next-1]
+	p.catalog.mu.RLock()
+	tree := findTable(p.catalog, name.Text)
+	p.catalog.mu.RUnlock()
+	if tree == nil {
+		return p.failAt(name, PREPARE_NO_SUCH_TABLE, "no table named "+name.Text)
+	}
+	p.schema = tree.schema
+	return PREPARE_SUCCESS
+}
+
+// isNull reports whether token is the literal null, rather than a text.
+func isNull(token Token) bool {
+	return token.Type == TOKEN_IDENTIFIER && token.Text == "null"
+}
+
+// checkInsertValue checks value, the i-th of an insert, against its column
+// among targets.
+func (p *parser) checkInsertValue(value Token, targets []Column, i int) PrepareResult {
+	if p.schema == nil {
+		return PREPARE_SUCCESS
+	}
+	if i == len(targets) {
+		if len(targets) < len(p.schema.Columns) {
+			return p.failAt(value, PREPARE_SYNTAX_ERROR, fmt.Sprintf("%d columns are named, not more", i))
+		}
+		return p.failAt(value, PREPARE_SYNTAX_ERROR, fmt.Sprintf("table %s has only %d columns", p.schema.Name, i))
+	}
+	isKey := targets[i].Name == p.schema.Columns[0].Name
+	if isNull(value) {
+		if isKey {
+			return p.failAt(value, PREPARE_TYPE_MISMATCH, "the key "+targets[i].Name+" cannot be null")
+		}
+		return PREPARE_SUCCESS
+	}
+	if result := p.checkValue(value, targets[i]); result != PREPARE_SUCCESS || !isKey {
+		return result
+	}
+	if key, _ := strconv.ParseInt(value.Text, 10, 64); key < 0 || key > math.MaxUint32 {
+		return p.failAt(value, PREPARE_NEGATIVE_ID, fmt.Sprintf("id %s is out of range", value.Text))
+	}
+	return PREPARE_SUCCESS
+}
+
+// checkValue checks that the literal value is one column can hold.
+func (p *parser) checkValue(value Token, column Column) PrepareResult {
+	_, err := columnValue(column, value.Text)
+	if err == nil {
+		return PREPARE_SUCCESS
+	}
+	// the REPL says what kind of error it is; the message says the rest
+	_, message, _ := strings.Cut(err.Error(), ": ")
+	if errors.Is(err, ErrStringTooLong) {
+		return p.failAt(value, PREPARE_STRING_TOO_LONG, message)
+	}
+	return p.failAt(value, PREPARE_TYPE_MISMATCH, message)
+}
+
+// selectFrom parses "from <table> [<join>] [where <condition>] [<group> |
+// <order>] [<limit>]" into statement. The columns selected and aggregated,
+// and those of the condition and the order, may be any of the table's, or
+// of the tables joined.
+func (p *parser) selectFrom(statement *SelectStmt, selected selection) PrepareResult {
+	name, result := p.name("a table name")
+	if result != PREPARE_SUCCESS {
+		return result
+	}
+	statement.Table = name
+	if result := p.lookupTable(); result != PREPARE_SUCCESS {
+		return result
+	}
+	if token := p.peek(); token.Type == TOKEN_IDENTIFIER && (token.Text == "join" || token.Text == "inner" || token.Text == "left") {
+		if result := p.join(statement); result != PREPARE_SUCCESS {
+			return result
+		}
+	}
+	for _, column := range selected.columns {
+		if p.schema != nil && columnIndex(p.schema, column.Text) == -1 {
+			return p.failAt(column, PREPARE_NO_SUCH_COLUMN, fmt.Sprintf("table %s has no column %s", p.schema.Name, column.Text))
+		}
+	}
+	for i, aggregate := range statement.Aggregates {
+		if p.schema == nil {
+			break
+		}
+		if result := p.checkAggregate(aggregate, selected.arguments[i], p.schema); result != PREPARE_SUCCESS {
+			return result
+		}
+	}
+	if p.accept("where") {
+		p.anyColumn = true
+		where, result := p.where()
+		if result != PREPARE_SUCCESS {
+			return result
+		}
+		statement.Where = where
+	}
+	return p.selectEnd(statement, selected)
+}
+
+// recordValues converts the values of an insert into the columns of schema.
+// The insert gives values for names, or for every column if names is nil.
+func recordValues(schema *Schema, names []string, literals []Literal) ([]Value, error) {
+	if names == nil {
+		if len(literals) != len(schema.Columns) {
+			return nil, fmt.Errorf("%w: table %s has %d columns, not %d", errValueCount, schema.Name, len(schema.Columns), len(literals))
+		}
+	} else if len(literals) != len(names) {
+		return nil, fmt.Errorf("%w: %d columns are named, not %d", errValueCount, len(names), len(literals))
+	}
+
+	given := make([]*Literal, len(schema.Columns))
+	for i := range literals {
+		column := i
+		if names != nil {
+			if column = columnIndex(schema, names[i]); column == -1 {
+				return nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, names[i])
+			}
+			if given[column] != nil {
+				return nil, fmt.Errorf("%w: column %s is given twice", errValueCount, names[i])
+			}
+		}
+		given[column] = &literals[i]
+	}
+	values := make([]Value, len(schema.Columns))
+	for i, column := range schema.Columns {
+		literal := given[i]
+		if literal == nil && column.Default != nil {
+			literal = &Literal{Text: *column.Default}
+		}
+		if literal == nil || literal.Null {
+			if i == 0 {
+				return nil, fmt.Errorf("%w: the key %s cannot be null", errTypeMismatch, column.Name)
+			}
+			continue
+		}
+		value, err := columnValue(column, literal.Text)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	if key := values[0].(int64); key < 0 || key > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: the key %s must be between 0 and %d", errTypeMismatch, schema.Columns[0].Name, uint32(math.MaxUint32))
+	}
+	return values, nil
+}
+
+// columnValue converts text, as written in a statement, to a value of column.
+func columnValue(column Column, text string) (Value, error) {
+	mismatch := fmt.Errorf("%w: %s holds %s values, not %q", errTypeMismatch, column.Name, columnTypeNames[column.Type], text)
+	switch column.Type {
+	case COLUMN_TEXT:
+		if len(text) > int(column.Size) {
+			return nil, fmt.Errorf("%w: %s of %d bytes is longer than %d", ErrStringTooLong, column.Name, len(text), column.Size)
+		}
+		return text, nil
+	case COLUMN_REAL:
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+			return nil, mismatch
+		}
+		return number, nil
+	case COLUMN_BOOLEAN:
+		if text != "true" && text != "false" {
+			return nil, mismatch
+		}
+		return text == "true", nil
+	case COLUMN_BLOB:
+		digits, ok := strings.CutPrefix(text, "x'")
+		digits, closed := strings.CutSuffix(digits, "'")
+		data, err := hex.DecodeString(digits)
+		if !ok || !closed || err != nil {
+			return nil, mismatch
+		}
+		if len(data) > int(column.Size) {
+			return nil, fmt.Errorf("%w: %s of %d bytes is longer than %d", ErrStringTooLong, column.Name, len(data), column.Size)
+		}
+		return data, nil
+	case COLUMN_DATE:
+		date, err := time.Parse(DATE_LAYOUT, text)
+		if err != nil {
+			return nil, mismatch
+		}
+		return date, nil
+	case COLUMN_TIMESTAMP:
+		return parseTimestamp(text, mismatch)
+	}
+	number, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return nil, mismatch
+	}
+	return number, nil
+}
+
+// parseTimestamp reads a timestamp in any of the forms it may be written in,
+// returning mismatch if it is in none or is finer than a second.
+func parseTimestamp(text string, mismatch error) (Value, error) {
+	for _, layout := range []string{TIMESTAMP_LAYOUT, "2006-01-02T15:04:05", DATE_LAYOUT} {
+		timestamp, err := time.Parse(layout, text)
+		if err != nil {
+			continue
+		}
+		if timestamp.Nanosecond() != 0 {
+			return nil, mismatch
+		}
+		return timestamp.UTC(), nil
+	}
+	return nil, mismatch
+}
+
+// truth is the value of a condition in SQL's three-valued logic, where a
+// comparison with null is neither true nor false. A where clause only takes
+// the records its condition is true for, but a check only rejects those it
+// is false for.
+type truth uint8
+
+const (
+	TRUTH_FALSE   truth = 0
+	TRUTH_TRUE    truth = 1
+	TRUTH_UNKNOWN truth = 2
+)
+
+// compileCondition checks where against the columns of schema and returns a
+// function reporting whether a record meets it.
+func compileCondition(schema *Schema, where Condition) (func(values []Value) bool, error) {
+	evaluate, err := compileTruth(schema, where)
+	if err != nil {
+		return nil, err
+	}
+	return func(values []Value) bool { return evaluate(values) == TRUTH_TRUE }, nil
+}
+
+// compileTruth is compileCondition, for a function returning the truth of
+// where for a record.
+func compileTruth(schema *Schema, where Condition) (func(values []Value) truth, error) {
+	switch where := where.(type) {
+	case nil:
+		return func([]Value) truth { return TRUTH_TRUE }, nil
+	case *Logical:
+		left, err := compileTruth(schema, where.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileTruth(schema, where.Right)
+		if err != nil {
+			return nil, err
+		}
+		// false and anything is false, true or anything is true, and
+		// otherwise an unknown on either side leaves the result unknown
+		decisive := TRUTH_FALSE
+		if where.Operator == "or" {
+			decisive = TRUTH_TRUE
+		}
+		return func(values []Value) truth {
+			l := left(values)
+			if l == decisive {
+				return l
+			}
+			r := right(values)
+			if r == decisive {
+				return r
+			}
+			if l == TRUTH_UNKNOWN || r == TRUTH_UNKNOWN {
+				return TRUTH_UNKNOWN
+			}
+			return l
+		}, nil
+	case *Comparison:
+		i := columnIndex(schema, where.Column)
+		if i == -1 {
+			return nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, where.Column)
+		}
+		if where.Other != "" {
+			return compileColumns(schema, i, where)
+		}
+		if isLike(where.Operator) {
+			return compileLikeTruth(schema, i, where)
+		}
+		if where.Operator == "in" {
+			return compileIn(schema, i, where)
+		}
+		operand, err := columnValue(Column{Name: where.Column, Type: schema.Columns[i].Type, Size: math.MaxUint32}, where.Value)
+		if err != nil {
+			return nil, err
+		}
+		operator := where.Operator
+		return func(values []Value) truth {
+			switch {
+			case values[i] == nil:
+				return TRUTH_UNKNOWN
+			case compareOrder(compareValues(values[i], operand), operator):
+				return TRUTH_TRUE
+			}
+			return TRUTH_FALSE
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown condition %T", where)
+}
+
+// columnIndex returns the position of the column named name in schema, -1
+// if it has none. The name may be qualified by that of the table, as
+// products.name. The columns of a join are qualified, and may also be named
+// alone when only one of the tables has the column.
+func columnIndex(schema *Schema, name string) int {
+	found := -1
+	for i, column := range schema.Columns {
+		switch {
+		case column.Name == name, schema.Name+"."+column.Name == name:
+			return i
+		case strings.HasSuffix(column.Name, "."+name):
+			if found != -1 {
+				return -1
+			}
+			found = i
+		}
+	}
+	return found
+}
+
+// isGrouped reports whether statement groups by the column named name, one
+// of schema. Without a schema, the names are compared as written.
+func isGrouped(schema *Schema, statement *SelectStmt, name string) bool {
+	if schema == nil {
+		return slices.Contains(statement.GroupBy, name)
+	}
+	i := columnIndex(schema, name)
+	return i != -1 && slices.ContainsFunc(statement.GroupBy, func(grouped string) bool {
+		return columnIndex(schema, grouped) == i
+	})
+}
+
+// projection finds the columns of schema a select names, in the order it
+// names them, or returns nil when it names none and so selects them all.
+func projection(schema *Schema, names []string) ([]int, error) {
+	if names == nil {
+		return nil, nil
+	}
+	columns := make([]int, len(names))
+	for i, name := range names {
+		if columns[i] = columnIndex(schema, name); columns[i] == -1 {
+			return nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, name)
+		}
+	}
+	return columns, nil
+}
+
+// tableResult translates an error from a statement on a table made by create
+// table into a result for the REPL, printing errors from the file.
+func tableResult(err error, writer *bufio.Writer) ExecuteResult {
+	switch {
+	case err == nil:
+		return EXECUTE_SUCCESS
+	case errors.Is(err, errNoSuchTable):
+		return EXECUTE_NO_SUCH_TABLE
+	case errors.Is(err, errNoSuchColumn):
+		return EXECUTE_NO_SUCH_COLUMN
+	case errors.Is(err, errTypeMismatch):
+		return EXECUTE_TYPE_MISMATCH
+	case errors.Is(err, errValueCount):
+		return EXECUTE_VALUE_COUNT
+	case errors.Is(err, ErrStringTooLong):
+		return EXECUTE_STRING_TOO_LONG
+	case errors.Is(err, ErrDuplicateKey):
+		return EXECUTE_DUPLICATE_KEY
+	case errors.Is(err, ErrTableFull):
+		return EXECUTE_TABLE_FULL
+	case errors.Is(err, errUniqueViolation), errors.Is(err, errNotNullViolation), errors.Is(err, errCheckViolation):
+		// the result does not say which constraint, or which value, so
+		// this line stands in for its message
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_CONSTRAINT_FAILED
+	case errors.Is(err, errSumOverflow):
+		// as for constraints, the result does not name the column
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_SUM_OVERFLOW
+	default:
+		fmt.Fprintf(writer, "Error: %v\n", err)
+		return EXECUTE_IO_ERROR
+	}
+}
+
+// executeInsertInto adds a record to the table the insert names.
+func executeInsertInto(statement *InsertStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.readOnly {
+		return EXECUTE_READ_ONLY
+	}
+	tree := findTable(table, statement.Table)
+	if tree == nil {
+		return EXECUTE_NO_SUCH_TABLE
+	}
+	values, err := recordValues(tree.schema, statement.Columns, statement.Values)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	key := uint32(values[0].(int64))
+	if _, exists := tree.ids[key]; exists {
+		return EXECUTE_DUPLICATE_KEY
+	}
+	if tree.numRows >= tree.maxRows {
+		return EXECUTE_TABLE_FULL
+	}
+	if err := checkConstraints(tree, values); err != nil {
+		return tableResult(err, writer)
+	}
+
+	err = insertRecord(tree, encodeRecord(tree.schema, values))
+	if err == nil {
+		err = saveCatalog(table)
+	}
+	if err == nil {
+		err = commitStatement(table)
+	}
+	return tableResult(err, writer)
+}
+
+// checkConstraints fails if one of records, about to be stored in tree,
+// breaks a constraint on its columns, checking the cheapest first.
+func checkConstraints(tree *Table, records ...[]Value) error {
+	for _, values := range records {
+		for i, column := range tree.schema.Columns {
+			if column.NotNull && values[i] == nil {
+				return fmt.Errorf("%w: %s.%s", errNotNullViolation, tree.schema.Name, column.Name)
+			}
+		}
+		if err := checkRecord(tree.schema, values); err != nil {
+			return err
+		}
+	}
+	return checkUnique(tree, records)
+}
+
+// checkUnique fails if one of records, about to be stored in tree, holds in
+// a unique column a value another of them holds, or a record of tree does
+// other than the one it replaces. Nulls never conflict. There are no
+// indexes, so this scans the table.
+func checkUnique(tree *Table, records [][]Value) error {
+	var unique []int
+	for i, column := range tree.schema.Columns {
+		if column.Unique {
+			unique = append(unique, i)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	held := make(map[int]map[Value]bool) // the values of each unique column in records
+	replaced := make(map[int64]bool)
+	for _, i := range unique {
+		held[i] = make(map[Value]bool)
+	}
+	for _, values := range records {
+		replaced[values[0].(int64)] = true
+		for _, i := range unique {
+			if values[i] == nil {
+				continue
+			}
+			if key := setKey(values[i]); !held[i][key] {
+				held[i][key] = true
+				continue
+			}
+			column := tree.schema.Columns[i]
+			return fmt.Errorf("%w: %s.%s would hold %s twice", errUniqueViolation, tree.schema.Name, column.Name, quoteLiteral(formatValue(column, values[i])))
+		}
+	}
+
+	cursor := tableStart(tree)
+	defer cursorClose(cursor)
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		record, err := cursorValue(cursor)
+		if err != nil {
+			return err
+		}
+		stored, err := decodeRecord(tree.schema, record)
+		if err != nil {
+			return err
+		}
+		if replaced[stored[0].(int64)] {
+			continue
+		}
+		for _, i := range unique {
+			if stored[i] != nil && held[i][setKey(stored[i])] {
+				column := tree.schema.Columns[i]
+				return fmt.Errorf("%w: %s.%s already holds %s", errUniqueViolation, tree.schema.Name, column.Name, quoteLiteral(formatValue(column, stored[i])))
+			}
+		}
+	}
+	return nil
+}
+
+// executeUpdateTable assigns the values the update gives to the records of
+// the table it names that meet its where clause, and prints how many it
+// rewrote. Every record is rewritten and checked against the constraints
+// before any is stored, so a statement breaking one leaves the table as it
+// was. Callers hold table.mu for writing.
+func executeUpdateTable(statement *UpdateStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	tree := findTable(table, statement.Table)
+	if tree == nil {
+		return EXECUTE_NO_SUCH_TABLE
+	}
+	matches, err := compileCondition(tree.schema, statement.Where)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	columns, assigned, err := assignedValues(tree.schema, statement.Columns, statement.Values)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+
+	var records [][]Value
+	err = scanRecords(tree, keyRange(tree.schema, statement.Where), func(values []Value) bool {
+		if matches(values) {
+			for n, i := range columns {
+				values[i] = assigned[n]
+			}
+			records = append(records, values)
+		}
+		return true
+	})
+	if err == nil {
+		err = checkConstraints(tree, records...)
+	}
+	for _, values := range records {
+		if err != nil {
+			break
+		}
+		err = rewriteRecord(tree, encodeRecord(tree.schema, values))
+	}
+	if err == nil {
+		// a record that grew may have split a leaf, and moved the root
+		err = saveCatalog(table)
+	}
+	if err == nil {
+		err = commitStatement(table)
+	}
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	fmt.Fprintf(writer, "Updated %d rows.\n", len(records))
+	return EXECUTE_SUCCESS
+}
+
+// executeDeleteTable removes the records of the table the delete names that
+// meet its where clause, or all of them when it has none, and prints how
+// many it removed. Callers hold table.mu for writing.
+func executeDeleteTable(statement *DeleteStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	tree := findTable(table, statement.Table)
+	if tree == nil {
+		return EXECUTE_NO_SUCH_TABLE
+	}
+	matches, err := compileCondition(tree.schema, statement.Where)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+
+	// removing a record rebalances leaves, so the records are found before
+	// any of them goes
+	var keys []uint32
+	err = scanRecords(tree, keyRange(tree.schema, statement.Where), func(values []Value) bool {
+		if matches(values) {
+			keys = append(keys, uint32(values[0].(int64)))
+		}
+		return true
+	})
+	deleted := 0
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+		if err = deleteRecord(tree, key); err == nil {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		// the records removed before an error stay removed, and the catalog
+		// counts them; a leaf that emptied may have moved the root
+		if saveErr := saveCatalog(table); err == nil {
+			err = saveErr
+		}
+	}
+	if err == nil {
+		err = commitStatement(table)
+	}
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	fmt.Fprintf(writer, "Deleted %d rows.\n", deleted)
+	return EXECUTE_SUCCESS
+}
+
+// assignedValues converts the values of an update into the columns of
+// schema they are for, returning the positions of those columns.
+func assignedValues(schema *Schema, names []string, literals []Literal) ([]int, []Value, error) {
+	columns := make([]int, len(names))
+	values := make([]Value, len(names))
+	for n, name := range names {
+		i := columnIndex(schema, name)
+		switch {
+		case i == -1:
+			return nil, nil, fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, name)
+		case i == 0:
+			return nil, nil, fmt.Errorf("%w: the key %s cannot be assigned", errTypeMismatch, name)
+		}
+		columns[n] = i
+		if literals[n].Null {
+			continue
+		}
+		value, err := columnValue(schema.Columns[i], literals[n].Text)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[n] = value
+	}
+	return columns, values, nil
+}
+
+// executeSelectFrom prints the records of the table the select names that
+// meet its condition, or counts them. Unless they are ordered, the scan stops
+// once the limit is reached.
+func executeSelectFrom(statement *SelectStmt, table *Table, writer *bufio.Writer) ExecuteResult {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	schema, scan, err := selectSource(table, statement)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	matches, err := compileCondition(schema, statement.Where)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	columns, err := projection(schema, statement.Columns)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	grouping, err := newGrouping(schema, statement)
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	order := -1
+	if statement.OrderBy != "" {
+		if order = columnIndex(schema, statement.OrderBy); order == -1 {
+			return tableResult(fmt.Errorf("%w: table %s has no column %s", errNoSuchColumn, schema.Name, statement.OrderBy), writer)
+		}
+	}
+
+	var count uint32
+	var records [][]Value
+	window := newRowWindow(statement)
+	err = scan(func(values []Value) bool {
+		if !matches(values) {
+			return true
+		}
+		switch {
+		case statement.Count:
+			count++
+		case grouping != nil:
+			grouping.add(values)
+		case order != -1:
+			records = append(records, values)
+		case window.admit():
+			printRecord(schema, values, columns, table.jsonOutput, writer)
+			count++
+		}
+		return order != -1 || !window.done()
+	})
+	if err != nil {
+		return tableResult(err, writer)
+	}
+	if order != -1 {
+		records = orderRecords(records, order, statement)
+		count = uint32(len(records))
+	}
+	for _, values := range records {
+		printRecord(schema, values, columns, table.jsonOutput, writer)
+	}
+	switch {
+	case statement.Count:
+		fmt.Fprintf(writer, "count: %d\n", count)
+	case grouping != nil:
+		printed, err := grouping.print(table.jsonOutput, writer)
+		if err == nil && printed == 0 {
+			writer.WriteString("(no rows)\n")
+		}
+		return tableResult(err, writer)
+	case count == 0:
+		writer.WriteString("(no rows)\n")
+	}
+	return EXECUTE_SUCCESS
+}
+
+// selectSource returns the columns of the records a select reads and a
+// function scanning them, in key order, until visit returns false: the
+// records of the table it names, or those of the tables it joins.
+func selectSource(table *Table, statement *SelectStmt) (*Schema, func(visit func(values []Value) bool) error, error) {
+	tree := findTable(table, statement.Table)
+	if tree == nil {
+		return nil, nil, fmt.Errorf("%w: %s", errNoSuchTable, statement.Table)
+	}
+	if statement.Join != "" {
+		return joinSource(table, tree, statement)
+	}
+	return tree.schema, func(visit func(values []Value) bool) error {
+		return scanRecords(tree, keyRange(tree.schema, statement.Where), visit)
+	}, nil
+}
+
+// scanRecords calls visit with the values of each record of tree whose key
+// is within keys, in key order, until it returns false. It seeks to the
+// first such key and stops after the last.
+func scanRecords(tree *Table, keys idRange, visit func(values []Value) bool) error {
+	if keys.low > keys.high {
+		return nil
+	}
+	cursor := tableSeek(tree, uint32(max(keys.low, 0)))
+	defer cursorClose(cursor)
+	if cursor.err != nil {
+		return cursor.err
+	}
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		record, err := cursorValue(cursor)
+		if err != nil {
+			return err
+		}
+		values, err := decodeRecord(tree.schema, record)
+		if err != nil {
+			return err
+		}
+		if values[0].(int64) > keys.high {
+			break
+		}
+		if !visit(values) {
+			break
+		}
+	}
+	return nil
+}
+
+// keyRange returns the keys of every record of a table with the columns of
+// schema that where can match, and maybe more, as Condition.ids does for
+// the ids of the built-in table. Only comparisons of the key with a value
+// narrow it; where may be nil, and matches everything.
+func keyRange(schema *Schema, where Condition) idRange {
+	switch where := where.(type) {
+	case *Logical:
+		return logicalIDs(where.Operator, keyRange(schema, where.Left), keyRange(schema, where.Right))
+	case *Comparison:
+		if where.Other != "" || where.Aggregate != nil || columnIndex(schema, where.Column) != 0 {
+			return allIDs
+		}
+		if where.Operator == "in" {
+			return inIDs(where.Values)
+		}
+		key, err := strconv.ParseInt(where.Value, 10, 64)
+		if err != nil {
+			return allIDs
+		}
+		return operatorIDs(where.Operator, key)
+	}
+	return allIDs
+}
+
+// orderRecords sorts records by the column at index order, nulls first, in
+// the direction of statement, and keeps those its offset and limit allow.
+// The sort is stable, so records that tie stay in the order of their keys.
+func orderRecords(records [][]Value, order int, statement *SelectStmt) [][]Value {
+	slices.SortStableFunc(records, func(a, b []Value) int {
+		result := compareNullable(a[order], b[order])
+		if statement.OrderDesc {
+			return -result
+		}
+		return result
+	})
+	records = records[min(int(statement.Offset), len(records)):]
+	if statement.HasLimit && uint32(len(records)) > statement.Limit {
+		records = records[:statement.Limit]
+	}
+	return records
+}
+
+// compareNullable is compareValues for values that may be null, which comes
+// first.
+func compareNullable(a, b Value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return compareValues(a, b)
+}
+
+// printRecord prints the values of a record in the given columns of schema,
+// or in all of them when columns is nil: in parentheses, or as a JSON object
+// with a member for each column.
+func printRecord(schema *Schema, values []Value, columns []int, asJSON bool, writer *bufio.Writer) {
+	opening, separator, closing := "(", ", ", ")\n"
+	if asJSON {
+		opening, separator, closing = "{", ",", "}\n"
+	}
+	if columns == nil {
+		columns = make([]int, len(values))
+		for i := range columns {
+			columns[i] = i
+		}
+	}
+	writer.WriteString(opening)
+	for n, i := range columns {
+		value := values[i]
+		if n > 0 {
+			writer.WriteString(separator)
+		}
+		if !asJSON {
+			writer.WriteString(formatValue(schema.Columns[i], value))
+			continue
+		}
+		writeJSON(writer, schema.Columns[i].Name)
+		writer.WriteByte(':')
+		switch data := value.(type) {
+		case nil:
+			// null, as JSON has it
+		case []byte:
+			// a blob is written in hex, as in statements, not base64
+			value = hex.EncodeToString(data)
+		case time.Time:
+			value = formatValue(schema.Columns[i], data)
+		}
+		writeJSON(writer, value)
+	}
+	writer.WriteString(closing)
+}
+
+// writeJSON writes value as JSON, with no HTML escaping, as printRow does.
+func writeJSON(writer *bufio.Writer, value any) {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	writer.Write(bytes.TrimSuffix(encoded.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,960 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInsertIntoAndSelectFrom(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(createProducts+
+		"insert into products values (2, widget, 250)\n"+
+		`insert into products values (1, "big gadget", 1200)`+"\n"+
+		"insert into products values (2, again, 1)\n"+
+		"insert into products values (3, short)\n"+
+		"insert into products values (3, thing, cheap)\n"+
+		"insert into products values (-3, thing, 1)\n"+
+		"insert into products values (3, "+strings.Repeat("x", 65)+", 1)\n"+
+		"insert into nothing values (1)\n"+
+		"insert 1 user1 person1@example.com\n"+
+		"select from products\n"+
+		"select * from products where price > 300 or name = widget\n"+
+		"select count from products where price < 1000\n"+
+		"select from products where id > 5\n"+
+		"select from products where weight = 1\n"+
+		"select from products where price = cheap\n"+
+		"select from nothing\n"+
+		"select\n"), &output, table, true)
+	want := "Error: Duplicate key.\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  insert into products values (3, short)\n" +
+		"                                       ^ expected a value for price, found operator \")\"\n" +
+		"Error: value does not match the column's type.\n" +
+		"  insert into products values (3, thing, cheap)\n" +
+		"                                         ^ price holds int values, not \"cheap\"\n" +
+		"Error: id must be between 0 and 4294967295.\n" +
+		"  insert into products values (-3, thing, 1)\n" +
+		"                               ^ id -3 is out of range\n" +
+		"String is too long.\n" +
+		"  insert into products values (3, " + strings.Repeat("x", 65) + ", 1)\n" +
+		"                                  ^ name of 65 bytes is longer than 64\n" +
+		"Error: no such table.\n" +
+		"  insert into nothing values (1)\n" +
+		"              ^ no table named nothing\n" +
+		"(1, big gadget, 1200)\n(2, widget, 250)\n" +
+		"(1, big gadget, 1200)\n(2, widget, 250)\n" +
+		"count: 1\n" +
+		"(no rows)\n" +
+		"Error: no such column.\n" +
+		"  select from products where weight = 1\n" +
+		"                             ^ table products has no column weight\n" +
+		"Error: value does not match the column's type.\n" +
+		"  select from products where price = cheap\n" +
+		"                                     ^ price holds int values, not \"cheap\"\n" +
+		"Error: no such table.\n" +
+		"  select from nothing\n" +
+		"              ^ no table named nothing\n" +
+		"(1, user1, person1@example.com)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+json on\nselect from products where id = 1\n"), &output, table, true)
+	if want := `{"id":1,"name":"big gadget","price":1200}` + "\n"; !strings.HasSuffix(output.String(), want) {
+		t.Errorf("output:\n%s\nwant it to end with:\n%s", output.String(), want)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+}
+
+func TestInsertInto_ChecksWhenItRuns(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+
+	// prepared without the catalog, the statements are only checked by the
+	// executor
+	tests := []struct {
+		input string
+		want  ExecuteResult
+	}{
+		{"insert into products values (1, a, 1)", EXECUTE_SUCCESS},
+		{"insert into products values (2, a)", EXECUTE_VALUE_COUNT},
+		{"insert into products values (2, a, b)", EXECUTE_TYPE_MISMATCH},
+		{"insert into products values (4294967296, a, 1)", EXECUTE_TYPE_MISMATCH},
+		{"insert into products values (2, " + strings.Repeat("a", 65) + ", 1)", EXECUTE_STRING_TOO_LONG},
+		{"insert into nothing values (1)", EXECUTE_NO_SUCH_TABLE},
+		{"insert into products (name) values (a)", EXECUTE_TYPE_MISMATCH},
+		{"insert into products (id, size) values (2, 1)", EXECUTE_NO_SUCH_COLUMN},
+		{"insert into products (id, name) values (2, a)", EXECUTE_SUCCESS},
+		{"select from products where size = 1", EXECUTE_NO_SUCH_COLUMN},
+		{"select from products where price = 1.5", EXECUTE_TYPE_MISMATCH},
+	}
+	for _, tt := range tests {
+		statement, err := prepareStatement(tt.input)
+		if err != nil {
+			t.Fatalf("prepareStatement(%q): %v", tt.input, err)
+		}
+		writer := bufio.NewWriter(io.Discard)
+		if got := executeStatement(statement, table, writer); got != tt.want {
+			t.Errorf("%q: %s, want %s", tt.input, executeResultMessage(got), executeResultMessage(tt.want))
+		}
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table readings (id integer, value real, ok boolean, raw blob(4), note text(8))\n"+
+		"insert into readings values (1, 2.5, true, x'00ff', first)\n"+
+		"insert into readings values (2, -1e3, false, x'', second)\n"+
+		"insert into readings values (3, 7, true, x'0102', third)\n"+
+		"insert into readings values (4, 1, yes, x'', fourth)\n"+
+		"insert into readings values (4, 1, true, 00ff, fourth)\n"+
+		"insert into readings values (4, 1, true, x'0102030405', fourth)\n"+
+		"insert into readings values (4, nan, true, x'', fourth)\n"+
+		"select from readings where value > 2\n"+
+		"select from readings where ok = false or raw >= x'01'\n"+
+		"+schema readings\n"), &output, table, true)
+	want := "Error: value does not match the column's type.\n" +
+		"  insert into readings values (4, 1, yes, x'', fourth)\n" +
+		"                                     ^ ok holds boolean values, not \"yes\"\n" +
+		"Error: value does not match the column's type.\n" +
+		"  insert into readings values (4, 1, true, 00ff, fourth)\n" +
+		"                                           ^ raw holds blob values, not \"00ff\"\n" +
+		"String is too long.\n" +
+		"  insert into readings values (4, 1, true, x'0102030405', fourth)\n" +
+		"                                           ^ raw of 5 bytes is longer than 4\n" +
+		"Error: value does not match the column's type.\n" +
+		"  insert into readings values (4, nan, true, x'', fourth)\n" +
+		"                                  ^ value holds real values, not \"nan\"\n" +
+		"(1, 2.5, true, x'00ff', first)\n(3, 7, true, x'0102', third)\n" +
+		"(2, -1000, false, x'', second)\n(3, 7, true, x'0102', third)\n" +
+		"column     type       max size\n" +
+		"id         int (key)         4\n" +
+		"value      real              8\n" +
+		"ok         boolean           1\n" +
+		"raw        blob(4)           4\n" +
+		"note       text(8)           8\n" +
+		"max row size = 28\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+tables\n+json on\nselect from readings where id = 1\n"), &output, table, true)
+	want = "readings (id int, value real, ok boolean, raw blob(4), note text(8)), 3 rows\n"
+	if !strings.HasPrefix(output.String(), want) {
+		t.Errorf("output:\n%s\nwant it to start with:\n%s", output.String(), want)
+	}
+	if want := `{"id":1,"value":2.5,"ok":true,"raw":"00ff","note":"first"}` + "\n"; !strings.HasSuffix(output.String(), want) {
+		t.Errorf("output:\n%s\nwant it to end with:\n%s", output.String(), want)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+}
+
+func TestPrepareStatement_NamesATable(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Statement
+	}{
+		{`insert into t values (1, "a b", -2)`, &InsertStmt{Table: "t", Values: []Literal{{Text: "1"}, {Text: "a b"}, {Text: "-2"}}}},
+		{"select from t", &SelectStmt{Table: "t"}},
+		{"select count(*) from t", &SelectStmt{Table: "t", Count: true}},
+		{"select * from t where size >= 3", &SelectStmt{Table: "t", Where: &Comparison{Column: "size", Operator: ">=", Value: "3"}}},
+	}
+	for _, tt := range tests {
+		got, err := prepareStatement(tt.input)
+		if err != nil {
+			t.Errorf("prepareStatement(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("prepareStatement(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		"insert into t (1)",
+		"insert into t values 1",
+		"insert into t values (1,)",
+		"insert into t values (1) 2",
+		"select * t",
+		"select from",
+		"select from t order by",
+		"select from t where 1 = 1",
+	} {
+		if _, err := prepareStatement(input); prepareResult(err) != PREPARE_SYNTAX_ERROR {
+			t.Errorf("prepareStatement(%q): %v, want a syntax error", input, err)
+		}
+	}
+}
+
+func TestColumnTypes_DatesAndTimestamps(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table events (id int, day date, at timestamp)\n"+
+		"insert into events values (1, 2024-05-01, 2024-05-01T12:30:00Z)\n"+
+		"insert into events values (2, 1969-12-31, 2024-05-01T14:30:00+02:00)\n"+
+		"insert into events values (3, 2024-02-29, 2024-03-01)\n"+
+		"insert into events values (4, 2023-02-29, 2024-03-01)\n"+
+		"insert into events values (4, 2024-03-01, 2024-03-01T10:00:00.5Z)\n"+
+		"select from events where at = 2024-05-01T12:30:00\n"+
+		"select from events where day < 2024-03-01 or at > 2024-05-01T12:00:00Z\n"), &output, table, true)
+	want := "Error: value does not match the column's type.\n" +
+		"  insert into events values (4, 2023-02-29, 2024-03-01)\n" +
+		"                                ^ day holds date values, not \"2023-02-29\"\n" +
+		"Error: value does not match the column's type.\n" +
+		"  insert into events values (4, 2024-03-01, 2024-03-01T10:00:00.5Z)\n" +
+		"                                            ^ at holds timestamp values, not \"2024-03-01T10:00:00.5Z\"\n" +
+		"(1, 2024-05-01, 2024-05-01T12:30:00Z)\n(2, 1969-12-31, 2024-05-01T12:30:00Z)\n" +
+		"(1, 2024-05-01, 2024-05-01T12:30:00Z)\n(2, 1969-12-31, 2024-05-01T12:30:00Z)\n(3, 2024-02-29, 2024-03-01T00:00:00Z)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+json on\nselect from events where id = 2\n"), &output, table, true)
+	if want := `{"id":2,"day":"1969-12-31","at":"2024-05-01T12:30:00Z"}` + "\n"; !strings.HasSuffix(output.String(), want) {
+		t.Errorf("output:\n%s\nwant it to end with:\n%s", output.String(), want)
+	}
+}
+
+func TestNullValues(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(createProducts+
+		"insert into products values (1, null, 10)\n"+
+		`insert into products values (2, "null", null)`+"\n"+
+		"insert into products values (null, a, 1)\n"+
+		"select from products\n"+
+		"select from products where price < 100\n"+
+		"select count from products where price != 10\n"), &output, table, true)
+	want := "Error: value does not match the column's type.\n" +
+		"  insert into products values (null, a, 1)\n" +
+		"                               ^ the key id cannot be null\n" +
+		"(1, NULL, 10)\n(2, null, NULL)\n" +
+		"(1, NULL, 10)\n" +
+		"count: 0\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+json on\nselect from products\n"), &output, table, true)
+	if want := `{"id":1,"name":null,"price":10}` + "\n" + `{"id":2,"name":"null","price":null}` + "\n"; !strings.HasSuffix(output.String(), want) {
+		t.Errorf("output:\n%s\nwant it to end with:\n%s", output.String(), want)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+}
+
+func TestDefaultValues(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table posts (id int, title text(32) default \"no title\", views int default 0, draft boolean default true, note text(8))\n"+
+		"insert into posts (id) values (1)\n"+
+		"insert into posts (views, id, note) values (7, 2, hi)\n"+
+		"insert into posts values (3, t, 1, false, null)\n"+
+		"insert into posts (title) values (x)\n"+
+		"insert into posts (id, id) values (4, 4)\n"+
+		"insert into posts (id, size) values (4, 4)\n"+
+		"insert into posts (id, views) values (4)\n"+
+		"create table bad (id int, n int default many)\n"+
+		"create table bad (id int default 1)\n"+
+		"select from posts\n"+
+		"+tables\n"), &output, table, true)
+	want := "Error: value does not match the column's type.\n" +
+		"  insert into posts (title) values (x)\n" +
+		"                          ^ the key id cannot be null\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  insert into posts (id, id) values (4, 4)\n" +
+		"                         ^ column id is given twice\n" +
+		"Error: no such column.\n" +
+		"  insert into posts (id, size) values (4, 4)\n" +
+		"                         ^ table posts has no column size\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  insert into posts (id, views) values (4)\n" +
+		"                                         ^ expected a value for views, found operator \")\"\n" +
+		"Error: value does not match the column's type.\n" +
+		"  create table bad (id int, n int default many)\n" +
+		"                                          ^ n holds int values, not \"many\"\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  create table bad (id int default 1)\n" +
+		"                           ^ the key cannot have a default\n" +
+		"(1, no title, 0, true, NULL)\n(2, no title, 7, true, hi)\n(3, t, 1, false, NULL)\n" +
+		"posts (id int, title text(32) default \"no title\", views int default 0, draft boolean default true, note text(8)), 3 rows\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	// the defaults are kept in the catalog
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("insert into posts (id, note) values (4, \"a, b\")\nselect from posts where id = 4\n"), &output, table, true)
+	if want := "(4, no title, 0, true, a, b)\n"; output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestUpdateTable(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+	addProducts(t, table, 1, 300)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("update products set name = \"a much longer name than before\", price = 1 where id > 100 and id <= 200\n"+
+		"update products set price = null where name = product7\n"+
+		"update products set price = 5 where id > 300\n"+
+		"select count from products where price = 1\n"+
+		"select from products where id = 7 or id = 150\n"+
+		"update products set id = 1 where id = 2\n"+
+		"update products set size = 1 where id = 2\n"+
+		"update products set price = x where id = 2\n"+
+		"update products set price = 1, price = 2 where id = 2\n"+
+		"update products set price = 1\n"+
+		"update nothing set price = 1 where id = 2\n"), &output, table, true)
+	want := "Updated 100 rows.\n" +
+		"Updated 1 rows.\n" +
+		"Updated 0 rows.\n" +
+		"count: 100\n" +
+		"(7, product7, NULL)\n(150, a much longer name than before, 1)\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  update products set id = 1 where id = 2\n" +
+		"                      ^ the key id cannot be assigned\n" +
+		"Error: no such column.\n" +
+		"  update products set size = 1 where id = 2\n" +
+		"                      ^ table products has no column size\n" +
+		"Error: value does not match the column's type.\n" +
+		"  update products set price = x where id = 2\n" +
+		"                              ^ price holds int values, not \"x\"\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  update products set price = 1, price = 2 where id = 2\n" +
+		"                                 ^ column price is assigned twice\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  update products set price = 1\n" +
+		"                               ^ expected \"where\", found end of statement\n" +
+		"Error: no such table.\n" +
+		"  update nothing set price = 1 where id = 2\n" +
+		"         ^ no table named nothing\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+	dbClose(table)
+
+	// the records that grew moved within the tree, which stays whole
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("select count from products\nselect name from products where id = 200 or id = 201\n"), &output, table, true)
+	if want := "count: 300\n(a much longer name than before)\n(product201)\n"; output.String() != want {
+		t.Errorf("after reopening:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestDeleteFromTable(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader(createProducts+"create table tags (id int, name text(8))\n"+
+		"insert into tags values (1, a)\ninsert into tags values (2, b)\n"), io.Discard, table)
+	addProducts(t, table, 1, 300)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("delete from products where id between 101 and 250 or price = 20\n"+
+		"delete from products where id > 1000\n"+
+		"delete from tags\n"+
+		"select count from products\n"+
+		"select id from products where id <= 3 or id between 99 and 102 or id = 251\n"+
+		"select count from tags\n"+
+		"delete from products where size = 1\n"+
+		"delete from nothing\n"), &output, table, true)
+	want := "Deleted 151 rows.\n" +
+		"Deleted 0 rows.\n" +
+		"Deleted 2 rows.\n" +
+		"count: 149\n" +
+		"(1)\n(3)\n(99)\n(100)\n(251)\n" +
+		"count: 0\n" +
+		"Error: no such column.\n" +
+		"  delete from products where size = 1\n" +
+		"                             ^ table products has no column size\n" +
+		"Error: no such table.\n" +
+		"  delete from nothing\n" +
+		"              ^ no table named nothing\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	if problems, err := dbIntegrityCheck(table); err != nil || len(problems) > 0 {
+		t.Errorf("integrity check: %v %v", problems, err)
+	}
+	dbClose(table)
+
+	// the records stay deleted, and their keys may be used again
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("select count from products\n"+
+		"insert into products values (150, again, 1)\n"+
+		"select name from products where id = 150\n"), &output, table, true)
+	if want := "count: 149\n(again)\n"; output.String() != want {
+		t.Errorf("after reopening:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestUniqueColumns(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table people (id int unique, email text(64) unique default \"a b\", nick text(8) unique)\n"+
+		"insert into people values (1, a@example.com, null)\n"+
+		"insert into people values (2, b@example.com, null)\n"+
+		"insert into people values (3, a@example.com, x)\n"+
+		"insert into people (id, nick) values (4, y)\n"+
+		"insert into people (id, nick) values (5, z)\n"+
+		"create table bad (id int, n int unique unique)\n"+
+		"update people set nick = w where id = 1\n"+
+		"update people set nick = y where id = 1\n"+
+		"update people set email = b@example.com, nick = x where id = 2\n"+
+		"update people set nick = v where id < 3\n"+
+		"update people set email = null where id > 0\n"+
+		"select count from people\n"+
+		"+tables\n"), &output, table, true)
+	want := "Error: unique constraint failed: people.email already holds a@example.com\n" +
+		"Error: unique constraint failed: people.email already holds \"a b\"\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  create table bad (id int, n int unique unique)\n" +
+		"                                         ^ column n is unique twice\n" +
+		"Updated 1 rows.\n" +
+		"Error: unique constraint failed: people.nick already holds y\n" +
+		"Updated 1 rows.\n" +
+		"Error: unique constraint failed: people.nick would hold v twice\n" +
+		"Updated 3 rows.\n" +
+		"count: 3\n" +
+		"people (id int, email text(64) default \"a b\" unique, nick text(8) unique), 3 rows\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	// the constraint is kept in the catalog
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("insert into people values (6, c@example.com, y)\nselect from people\n"), &output, table, true)
+	if want := "Error: unique constraint failed: people.nick already holds y\n(1, NULL, w)\n(2, NULL, x)\n(4, NULL, y)\n"; output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestUniqueColumns_Email(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table users (id int, username text(32), email text(200) unique)\n"+
+		"insert into users values (1, ann, a@example.com)\n"+
+		"insert into users values (2, bob, a@example.com)\n"+
+		"insert into users values (3, cy, c@example.com)\n"+
+		"update users set email = a@example.com where id = 3\n"+
+		"update users set username = ann2, email = a@example.com where id = 1\n"+
+		"select from users\n"), &output, table, true)
+	want := "Error: unique constraint failed: users.email already holds a@example.com\n" +
+		"Error: unique constraint failed: users.email already holds a@example.com\n" +
+		"Updated 1 rows.\n" +
+		"(1, ann2, a@example.com)\n(3, cy, c@example.com)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestNotNullColumns(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table people (id int not null, name text(16) not null, nick text(8), born date not null default 2000-01-01)\n"+
+		"insert into people values (1, \"\", null, 1990-05-01)\n"+
+		"insert into people values (2, null, x, 1990-05-01)\n"+
+		"insert into people (id, nick) values (3, y)\n"+
+		"insert into people (id, name) values (4, d)\n"+
+		"insert into people values (5, e, z, null)\n"+
+		"create table bad (id int, n int not nul)\n"+
+		"update people set name = null where id = 4\n"+
+		"update people set nick = null, born = 1990-01-01 where name = d\n"+
+		"select from people\n"), &output, table, true)
+	want := "Error: not null constraint failed: people.name\n" +
+		"Error: not null constraint failed: people.name\n" +
+		"Error: not null constraint failed: people.born\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  create table bad (id int, n int not nul)\n" +
+		"                                      ^ expected \"null\", found identifier \"nul\"\n" +
+		"Error: not null constraint failed: people.name\n" +
+		"Updated 1 rows.\n" +
+		"(1, , NULL, 1990-05-01)\n(4, d, NULL, 1990-01-01)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+tables\n"), &output, table, true)
+	if want := "people (id int, name text(16) not null, nick text(8), born date default 2000-01-01 not null), 2 rows\n"; output.String() != want {
+		t.Errorf("+tables after reopening:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestNotNullColumns_Email(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table users (id int, username text(32), email text(200) not null)\n"+
+		"insert into users (id, username) values (1, ann)\n"+
+		"insert into users values (2, bob, null)\n"+
+		"insert into users values (3, cy, c@example.com)\n"+
+		"update users set email = null where id = 3\n"+
+		"select from users\n"), &output, table, true)
+	want := "Error: not null constraint failed: users.email\n" +
+		"Error: not null constraint failed: users.email\n" +
+		"Error: not null constraint failed: users.email\n" +
+		"(3, cy, c@example.com)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestCheckConstraints(t *testing.T) {
+	fileName := tempDBFile(t)
+	table := mustOpen(t, fileName)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table items (id int check (id > 0), price int check (price >= 0), stock int, check (stock > 0 or price = 0))\n"+
+		"insert into items values (1, 10, 5)\n"+
+		"insert into items values (2, -1, 5)\n"+
+		"insert into items values (3, 10, 0)\n"+
+		"insert into items values (4, 0, 0)\n"+
+		"insert into items (id) values (5)\n"+
+		"create table bad (id int, n int check (size > 0))\n"+
+		"create table bad (id int, n int, check (n = x))\n"+
+		"update items set price = -5 where id = 1\n"+
+		"update items set stock = 0 where id < 5\n"+
+		"update items set price = 0, stock = null where id = 1\n"+
+		"select from items\n"), &output, table, true)
+	want := "Error: check constraint failed: items: (price >= 0)\n" +
+		"Error: check constraint failed: items: (stock > 0 or price = 0)\n" +
+		"Error: no such column.\n" +
+		"  create table bad (id int, n int check (size > 0))\n" +
+		"                                  ^ table bad has no column size\n" +
+		"Error: value does not match the column's type.\n" +
+		"  create table bad (id int, n int, check (n = x))\n" +
+		"                                   ^ n holds int values, not \"x\"\n" +
+		"Error: check constraint failed: items: (price >= 0)\n" +
+		"Error: check constraint failed: items: (stock > 0 or price = 0)\n" +
+		"Updated 1 rows.\n" +
+		"(1, 0, NULL)\n(4, 0, 0)\n(5, NULL, NULL)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	dbClose(table)
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	output.Reset()
+	runREPLWith(strings.NewReader("+tables\ninsert into items values (0, 1, 1)\n"), &output, table, true)
+	want = "items (id int, price int, stock int, check (id > 0), check (price >= 0), check (stock > 0 or price = 0)), 3 rows\n" +
+		"Error: check constraint failed: items: (id > 0)\n"
+	if output.String() != want {
+		t.Errorf("after reopening:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(createProducts+
+		"insert into products values (1, pen, 3)\n"+
+		"insert into products values (2, ink, 12)\n"+
+		"select price, name from products where price > 5\n"+
+		"select name, name, id from products\n"+
+		"select id, size from products\n"+
+		"select id, * from products\n"+
+		"+json on\nselect name from products\n"), &output, table, true)
+	want := "(12, ink)\n" +
+		"(pen, pen, 1)\n(ink, ink, 2)\n" +
+		"Error: no such column.\n" +
+		"  select id, size from products\n" +
+		"             ^ table products has no column size\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  select id, * from products\n" +
+		"             ^ expected a column name, found operator \"*\"\n" +
+		"{\"name\":\"pen\"}\n{\"name\":\"ink\"}\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectFrom_OrderBy(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(createProducts+
+		"insert into products values (1, pen, 3)\n"+
+		"insert into products values (2, ink, 12)\n"+
+		"insert into products values (3, cap, null)\n"+
+		"insert into products values (4, box, 3)\n"+
+		"select from products order by price\n"+
+		"select name from products where id > 1 order by price desc limit 2\n"+
+		"select from products order by size\n"+
+		"select count from products order by price\n"), &output, table, true)
+	want := "(3, cap, NULL)\n(1, pen, 3)\n(4, box, 3)\n(2, ink, 12)\n" +
+		"(ink)\n(box)\n" +
+		"Error: no such column.\n" +
+		"  select from products order by size\n" +
+		"                                ^ table products has no column size\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  select count from products order by price\n" +
+		"                             ^ expected the end of the statement, found keyword \"order\"\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectFrom_LimitAndOffset(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+	addProducts(t, table, 1, 30)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("select id from products limit 3 offset 20\n"+
+		"select id from products where price > 100 order by price desc limit 2 offset 1\n"+
+		"select from products limit 0\n"+
+		"select from products limit 5 offset 40\n"+
+		"select count from products limit 1\n"), &output, table, true)
+	want := "(21)\n(22)\n(23)\n" +
+		"(29)\n(28)\n" +
+		"(no rows)\n" +
+		"(no rows)\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  select count from products limit 1\n" +
+		"                             ^ expected the end of the statement, found keyword \"limit\"\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectAggregates(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table readings (id int, place text(8), value real, taken date, ok boolean)\n"+
+		"insert into readings values (1, north, 2.5, 2024-05-01, true)\n"+
+		"insert into readings values (2, south, null, 2024-04-01, false)\n"+
+		"insert into readings values (3, north, -1, null, true)\n"+
+		"select count(*), count(value), sum(value), avg(value), min(taken), max(ok) from readings\n"+
+		"select max(place), min(value) from readings where place = south\n"+
+		"select sum(place) from readings\n"+
+		"select avg(size) from readings\n"+
+		"create table big (id int, n int)\n"+
+		"insert into big values (1, 9223372036854775807)\n"+
+		"insert into big values (2, 1)\n"+
+		"select sum(n) from big where id = 1\n"+
+		"select sum(n) from big\n"+
+		"insert into big values (3, -2)\n"+
+		"insert into big values (4, -9223372036854775807)\n"+
+		"select sum(n) from big where id < 4\n"+
+		"select sum(n) from big where id > 2\n"), &output, table, true)
+	want := "(3, 2, 1.5, 0.75, 2024-04-01, true)\n" +
+		"(south, NULL)\n" +
+		"Error: value does not match the column's type.\n" +
+		"  select sum(place) from readings\n" +
+		"             ^ sum adds up numbers, and place holds text values\n" +
+		"Error: no such column.\n" +
+		"  select avg(size) from readings\n" +
+		"             ^ table readings has no column size\n" +
+		"(9223372036854775807)\n" +
+		"Error: sum out of range: the sum of n is too large for an int\n" +
+		"(9223372036854775806)\n" +
+		"Error: sum out of range: the sum of n is too large for an int\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectGroupBy(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table sales (id int, region text(8), item text(8), amount real)\n"+
+		"insert into sales values (1, north, pen, 2.5)\n"+
+		"insert into sales values (2, south, pen, 1)\n"+
+		"insert into sales values (3, north, ink, 4)\n"+
+		"insert into sales values (4, null, pen, 3)\n"+
+		"insert into sales values (5, north, pen, null)\n"+
+		"select region, item, count(*), sum(amount) from sales group by region, item\n"+
+		"select region, avg(amount) from sales group by region having sum(amount) > 2\n"+
+		"select region from sales where id > 9 group by region\n"+
+		"select region, count(*) from sales group by size\n"+
+		"select item, count(*) from sales group by region\n"+
+		"select count(*) from sales group by region having avg(region) > 1\n"+
+		"+json on\nselect item, max(id) from sales group by item\n"), &output, table, true)
+	want := "(NULL, pen, 1, 3)\n(north, ink, 1, 4)\n(north, pen, 2, 2.5)\n(south, pen, 1, 1)\n" +
+		"(NULL, 3)\n(north, 3.25)\n" +
+		"(no rows)\n" +
+		"Error: no such column.\n" +
+		"  select region, count(*) from sales group by size\n" +
+		"                                              ^ table sales has no column size\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  select item, count(*) from sales group by region\n" +
+		"         ^ column item is not grouped by\n" +
+		"Error: value does not match the column's type.\n" +
+		"  select count(*) from sales group by region having avg(region) > 1\n" +
+		"                                                        ^ avg adds up numbers, and region holds text values\n" +
+		"{\"item\":\"ink\",\"max(id)\":3}\n{\"item\":\"pen\",\"max(id)\":5}\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectJoin(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table users (id int, name text(8))\n"+
+		"create table orders (id int, user_id int, total int)\n"+
+		"insert into users values (1, ann)\n"+
+		"insert into users values (2, bob)\n"+
+		"insert into users values (3, cy)\n"+
+		"insert into orders values (10, 2, 5)\n"+
+		"insert into orders values (11, 1, 7)\n"+
+		"insert into orders values (12, 2, 9)\n"+
+		"insert into orders values (13, null, 1)\n"+
+		"select * from users join orders on users.id = orders.user_id\n"+
+		"select name, orders.id, total from users inner join orders on users.id = user_id where total > 5\n"+
+		"select name, count(*), sum(total) from users join orders on users.id = user_id group by name\n"+
+		"select count from orders join users on user_id = users.id\n"+
+		"select name from users join orders on users.id = orders.user_id order by total desc limit 1\n"+
+		"select id from users join orders on users.id = user_id\n"+
+		"select name from users join orders on users.id = orders.owner\n"+
+		"select name from users join orders on name = orders.id\n"+
+		"select name from users join users on id = id\n"+
+		"+json on\nselect users.name, orders.total from users join orders on users.id = user_id where total < 6\n"), &output, table, true)
+	want := "(1, ann, 11, 1, 7)\n(2, bob, 10, 2, 5)\n(2, bob, 12, 2, 9)\n" +
+		"(ann, 11, 7)\n(bob, 12, 9)\n" +
+		"(ann, 1, 7)\n(bob, 2, 14)\n" +
+		"count: 3\n" +
+		"(bob)\n" +
+		"Error: no such column.\n" +
+		"  select id from users join orders on users.id = user_id\n" +
+		"         ^ table users join orders has no column id\n" +
+		"Error: no such column.\n" +
+		"  select name from users join orders on users.id = orders.owner\n" +
+		"                                                   ^ table users join orders has no column orders.owner\n" +
+		"Error: value does not match the column's type.\n" +
+		"  select name from users join orders on name = orders.id\n" +
+		"                                        ^ name and orders.id are of different types\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  select name from users join users on id = id\n" +
+		"                              ^ a table cannot be joined with itself\n" +
+		"{\"users.name\":\"bob\",\"orders.total\":5}\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectLeftJoin(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table users (id int, name text(8))\n"+
+		"create table orders (id int, user_id int, total int)\n"+
+		"insert into users values (1, ann)\n"+
+		"insert into users values (2, bob)\n"+
+		"insert into users values (3, cy)\n"+
+		"insert into orders values (10, 2, 5)\n"+
+		"insert into orders values (11, 2, 9)\n"+
+		"select * from users left join orders on users.id = user_id\n"+
+		"select name from users left outer join orders on users.id = user_id where total > 9\n"+
+		"select name, count(total) from users left join orders on users.id = user_id group by name\n"+
+		"select name, total from users left join orders on users.id = user_id order by total limit 2\n"+
+		"select count from orders left join users on user_id = users.id and name = users.name\n"+
+		"+json on\nselect name, orders.id from users left join orders on users.id = user_id where users.id = 3\n"), &output, table, true)
+	want := "(1, ann, NULL, NULL, NULL)\n(2, bob, 10, 2, 5)\n(2, bob, 11, 2, 9)\n(3, cy, NULL, NULL, NULL)\n" +
+		"(no rows)\n" +
+		"(ann, 0)\n(bob, 2)\n(cy, 0)\n" +
+		"(ann, NULL)\n(cy, NULL)\n" +
+		"count: 2\n" +
+		"{\"users.name\":\"cy\",\"orders.id\":null}\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectFrom_Like(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(createProducts+
+		"insert into products values (1, \"Blue pen\", 3)\n"+
+		"insert into products values (2, pencil, 1)\n"+
+		"insert into products values (3, null, 2)\n"+
+		"insert into products values (4, \"blue ink\", 8)\n"+
+		"select id from products where name like %pen%\n"+
+		"select id from products where name ilike blue%\n"+
+		"select count from products where name like %\n"+
+		"select id from products where price like 3\n"+
+		"create table t (k int, code text(8) check (code like A__))\n"+
+		"insert into t values (1, Abc)\n"+
+		"insert into t values (2, abc)\n"), &output, table, true)
+	want := "(1)\n(2)\n" +
+		"(1)\n(4)\n" +
+		"count: 3\n" +
+		"Error: value does not match the column's type.\n" +
+		"  select id from products where price like 3\n" +
+		"                                      ^ like matches text, and price does not hold text\n" +
+		"Error: check constraint failed: t: (code like A__)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectFrom_In(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table events (id int, kind text(8), day date, score real)\n"+
+		"insert into events values (1, open, 2024-05-01, 1.5)\n"+
+		"insert into events values (2, close, 2024-05-02, 2)\n"+
+		"insert into events values (3, null, 2024-05-01, null)\n"+
+		"insert into events values (4, open, 2024-05-03, 2.0)\n"+
+		"select id from events where kind in (open, \"shut down\")\n"+
+		"select id from events where day in (2024-05-01, 2024-05-03) and score in (2, 1.5)\n"+
+		"select count from events where id in (2, 3, 9)\n"+
+		"select kind, count(*) from events group by kind having kind in (close, open)\n"+
+		"select id from events where score in (1, high)\n"), &output, table, true)
+	want := "(1)\n(4)\n" +
+		"(1)\n(4)\n" +
+		"count: 2\n" +
+		"(close, 1)\n(open, 2)\n" +
+		"Error: value does not match the column's type.\n" +
+		"  select id from events where score in (1, high)\n" +
+		"                                           ^ score holds real values, not \"high\"\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestSelectFrom_KeyRange(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(createProducts), io.Discard, table)
+	addProducts(t, table, 1, 300)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("select id from products where id between 149 and 151\n"+
+		"select id from products where id in (300, 5, 4294967296)\n"+
+		"select id from products where id < 3 or id > 298\n"+
+		"select id from products where id >= -5 and id < 2\n"+
+		"select count from products where id between 20 and 10\n"+
+		"select count from products where id > 300\n"+
+		"select id from products where id > 100 and price < 1030 or price = 20\n"+
+		"update products set price = 0 where id between 1 and 150\n"+
+		"select count from products where price = 0\n"), &output, table, true)
+	want := "(149)\n(150)\n(151)\n" +
+		"(5)\n(300)\n" +
+		"(1)\n(2)\n(299)\n(300)\n" +
+		"(1)\n" +
+		"count: 0\n" +
+		"count: 0\n" +
+		"(2)\n(101)\n(102)\n" +
+		"Updated 150 rows.\n" +
+		"count: 150\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+
+	got, err := prepareStatement("select from products where id between 10 and 20 or id in (30, 25) and price > 5")
+	if err != nil {
+		t.Fatalf("prepareStatement: %v", err)
+	}
+	if keys := keyRange(findTable(table, "products").schema, got.(*SelectStmt).Where); keys != (idRange{10, 30}) {
+		t.Errorf("keyRange = %v, want [10, 30]", keys)
+	}
+}
+
+func TestSingleQuotedValues(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader("create table u (id int, email text(32), name text(16), d date)\n"+
+		"insert into u values (1, 'a@example.com', 'O''Brien', '2024-01-02')\n"+
+		"insert into u values (2, 'b@x.com', 'a, b', '2024-01-03')\n"+
+		"select from u where email = a@example.com\n"+
+		"select id from u where name = 'O''Brien' and d = '2024-01-02'\n"+
+		"select id from u where email like '%@x.com'\n"+
+		"select id from u where name in ('a, b', 'c')\n"+
+		"update u set email = 'c@example.com' where id = 2\n"+
+		"select email from u where id = 2\n"+
+		"insert 5 'al' 'al@example.com'\n"+
+		"select 5\n"), &output, table, true)
+	want := "(1, a@example.com, O'Brien, 2024-01-02)\n" +
+		"(1)\n" +
+		"(2)\n" +
+		"(2)\n" +
+		"Updated 1 rows.\n" +
+		"(c@example.com)\n" +
+		"(5, al, al@example.com)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
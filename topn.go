@@ -0,0 +1,85 @@
+package main
+
+import "container/heap"
+
+// rowHeap is a heap of rows keyed on id. When desc is set it is a min-heap,
+// so the root is the weakest of the largest ids seen so far; otherwise it is
+// a max-heap and the root is the weakest of the smallest ids.
+type rowHeap struct {
+	rows []Row
+	desc bool
+}
+
+func (h *rowHeap) Len() int { return len(h.rows) }
+
+func (h *rowHeap) Less(i, j int) bool {
+	if h.desc {
+		return h.rows[i].id < h.rows[j].id
+	}
+	return h.rows[i].id > h.rows[j].id
+}
+
+func (h *rowHeap) Swap(i, j int) { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+
+func (h *rowHeap) Push(x any) { h.rows = append(h.rows, x.(Row)) }
+
+func (h *rowHeap) Pop() any {
+	last := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return last
+}
+
+// topN collects the first limit rows of an ordered scan without sorting the
+// whole table: every row costs O(log limit) and memory stays O(limit).
+type topN struct {
+	heap  rowHeap
+	limit int
+}
+
+func newTopN(limit uint32, desc bool) *topN {
+	return &topN{
+		heap:  rowHeap{rows: make([]Row, 0, limit), desc: desc},
+		limit: int(limit),
+	}
+}
+
+// full reports whether limit rows have been kept.
+func (t *topN) full() bool { return t.heap.Len() >= t.limit }
+
+// accepts reports whether a row with this id would be kept by add.
+func (t *topN) accepts(id uint32) bool {
+	if t.limit == 0 {
+		return false
+	}
+	if t.heap.Len() < t.limit {
+		return true
+	}
+	// the root is the row that would be dropped first, so a new row is only
+	// kept when it sorts ahead of it
+	root := t.heap.rows[0]
+	if t.heap.desc {
+		return id > root.id
+	}
+	return id < root.id
+}
+
+func (t *topN) add(row Row) {
+	if !t.accepts(row.id) {
+		return
+	}
+	if t.heap.Len() < t.limit {
+		heap.Push(&t.heap, row)
+		return
+	}
+	t.heap.rows[0] = row
+	heap.Fix(&t.heap, 0)
+}
+
+// sorted drains the heap and returns the kept rows in the requested order.
+func (t *topN) sorted() []Row {
+	rows := make([]Row, t.heap.Len())
+	for i := len(rows) - 1; i >= 0; i-- {
+		rows[i] = heap.Pop(&t.heap).(Row)
+	}
+	return rows
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestTopN(t *testing.T) {
+	ids := []uint32{7, 3, 9, 1, 12, 5, 8}
+
+	tests := []struct {
+		name  string
+		limit uint32
+		desc  bool
+		want  []uint32
+	}{
+		{name: "largest ids descending", limit: 3, desc: true, want: []uint32{12, 9, 8}},
+		{name: "smallest ids ascending", limit: 3, desc: false, want: []uint32{1, 3, 5}},
+		{name: "limit larger than input", limit: 10, desc: true, want: []uint32{12, 9, 8, 7, 5, 3, 1}},
+		{name: "zero limit", limit: 0, desc: true, want: []uint32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			best := newTopN(tt.limit, tt.desc)
+			for _, id := range ids {
+				best.add(Row{id: id})
+			}
+			got := best.sorted()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].id != tt.want[i] {
+					t.Errorf("row %d: id = %d, want %d", i, got[i].id, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSelectTopN scans tables of different sizes with a fixed limit;
+// B/op should stay flat as the table grows.
+func BenchmarkSelectTopN(b *testing.B) {
+	for _, numRows := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("rows=%d", numRows), func(b *testing.B) {
+			table := mustOpen(b, tempDBFileB(b))
+			defer dbClose(table)
+
+			var input strings.Builder
+			for _, i := range rand.Perm(numRows) {
+				fmt.Fprintf(&input, "insert %d user%d person%d@example.com\n", i, i, i)
+			}
+			runREPL(strings.NewReader(input.String()), io.Discard, table)
+
+			statement := SelectStmt{OrderBy: "id", OrderDesc: true, HasLimit: true, Limit: 5}
+			writer := bufio.NewWriter(io.Discard)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for b.Loop() {
+				executeSelectTopN(&statement, nil, table, writer)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"slices"
+)
+
+var (
+	errTransactionOpen   = errors.New("transaction already open")
+	errNoTransaction     = errors.New("no transaction open")
+	errInsideTransaction = errors.New("not allowed inside a transaction")
+)
+
+// beginTransaction marks the start of a transaction. Everything before it is
+// flushed first, so the file holds exactly the state a rollback returns to.
+// In-memory tables have no file to return to and keep copies of their pages
+// instead.
+func beginTransaction(table *Table) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if table.inTransaction {
+		return errTransactionOpen
+	}
+	if err := flushAll(table); err != nil {
+		return err
+	}
+
+	if table.pager.file == nil {
+		table.savedPages = make([]Page, len(table.pager.pages))
+		for i, page := range table.pager.pages {
+			table.savedPages[i] = slices.Clone(page)
+		}
+	}
+	table.savedNumRows = table.numRows
+	table.savedNumPages = table.pager.numPages
+	table.savedRootPage = table.rootPageNum
+	table.savedFreePage = table.pager.freePage
+	table.savedNumFree = table.pager.numFreePages
+	table.inTransaction = true
+	table.pager.keepDirty = true
+	return nil
+}
+
+// commitTransaction makes the changes since beginTransaction durable.
+func commitTransaction(table *Table) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if !table.inTransaction {
+		return errNoTransaction
+	}
+	table.inTransaction = false
+	table.savedPages = nil
+	table.pager.keepDirty = false
+	return flushAll(table)
+}
+
+// commitStatement writes back a statement that changed the table, when it
+// runs outside a transaction and either the table keeps a write-ahead log or
+// a rollback journal, or the sync mode is SYNC_FULL; otherwise it counts the
+// statement towards OpenOptions.FlushEvery. Callers hold table.mu for
+// writing.
+func commitStatement(table *Table) error {
+	pager := table.pager
+	if table.inTransaction || (pager.wal == nil && !pager.journaling && pager.syncMode != SYNC_FULL) {
+		return autoFlushStatement(table)
+	}
+	return flushAll(table)
+}
+
+// rollbackTransaction discards the changes since beginTransaction by dropping
+// the cached pages, so they are read back from the file on next use.
+func rollbackTransaction(table *Table) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if !table.inTransaction {
+		return errNoTransaction
+	}
+	return discardTransaction(table)
+}
+
+// discardTransaction does the work of rollbackTransaction with table.mu held.
+func discardTransaction(table *Table) error {
+	pagerReset(table.pager, table.savedPages)
+	table.pager.keepDirty = false
+
+	table.numRows = table.savedNumRows
+	table.pager.numPages = table.savedNumPages
+	table.rootPageNum = table.savedRootPage
+	table.pager.freePage = table.savedFreePage
+	table.pager.numFreePages = table.savedNumFree
+	table.inTransaction = false
+	table.savedPages = nil
+	table.undo = nil // the recorded changes may no longer apply
+	if err := loadIDs(table); err != nil {
+		return err
+	}
+	return loadCatalog(table)
+}
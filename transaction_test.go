@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTransaction_Rollback(t *testing.T) {
+	for _, fileName := range []string{"", MEMORY_FILENAME} {
+		name := "file"
+		if fileName == MEMORY_FILENAME {
+			name = "memory"
+		}
+		t.Run(name, func(t *testing.T) {
+			if fileName == "" {
+				fileName = tempDBFile(t)
+			}
+			table := mustOpen(t, fileName)
+			defer dbClose(table)
+
+			var output bytes.Buffer
+			input := insertRows(1, 2) +
+				"+begin\n" +
+				insertRows(3, rowsPerLeaf+2) +
+				"update 1 changed changed@example.com\ndelete 2\n" +
+				"+rollback\nselect\n"
+			runREPL(strings.NewReader(input), &output, table)
+			got := output.String()
+
+			want := "(1, user1, person1@example.com)\n(2, user2, person2@example.com)\nExecuted."
+			if !strings.Contains(got, want) {
+				t.Errorf("output missing %q\ngot:\n%s", want, got)
+			}
+			if strings.Contains(got, "user3") || strings.Contains(got, "changed") {
+				t.Errorf("rolled back changes are still visible\ngot:\n%s", got)
+			}
+			if table.numRows != 2 {
+				t.Errorf("table.numRows = %d, want 2", table.numRows)
+			}
+
+			// the rolled back ids are free again
+			output.Reset()
+			runREPL(strings.NewReader("insert 3 again again@example.com\n"), &output, table)
+			if strings.Contains(output.String(), "Duplicate key") {
+				t.Errorf("id from a rolled back insert is still taken\ngot:\n%s", output.String())
+			}
+		})
+	}
+}
+
+func TestTransaction_CommitSurvivesReopen(t *testing.T) {
+	fileName := tempDBFile(t)
+
+	table := mustOpen(t, fileName)
+	runREPL(strings.NewReader("+begin\n"+insertRows(1, 3)+"+commit\n+begin\ninsert 4 uncommitted x@example.com\n"), io.Discard, table)
+	// closing with a transaction open discards it
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.numRows != 3 {
+		t.Errorf("table.numRows after reopen = %d, want 3", table.numRows)
+	}
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("select\n"), &output, table)
+	if !strings.Contains(output.String(), "(3, user3, person3@example.com)") {
+		t.Errorf("committed row missing after reopen\ngot:\n%s", output.String())
+	}
+	if strings.Contains(output.String(), "uncommitted") {
+		t.Errorf("uncommitted row persisted\ngot:\n%s", output.String())
+	}
+}
+
+func TestTransaction_Errors(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader("+commit\n+rollback\n+begin\n+begin\n+snapshot "+tempDBFile(t)+"\n"), &output, table)
+	got := output.String()
+	for _, want := range []string{
+		"Error: no transaction open\n",
+		"Error: transaction already open\n",
+		"Error: not allowed inside a transaction",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\ngot:\n%s", want, got)
+		}
+	}
+}
+
+func TestTransaction_RollbackWithSmallCache(t *testing.T) {
+	fileName := tempDBFile(t)
+	table, err := dbOpenWith(fileName, OpenOptions{MaxCachedPages: 2})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+
+	// the transaction dirties more pages than the cache holds, and none of
+	// them may be evicted to the file before the rollback
+	var output bytes.Buffer
+	input := insertRows(1, 2) +
+		"+begin\n" +
+		insertRows(3, rowsPerLeaf*4) +
+		"+rollback\nselect\n"
+	runREPL(strings.NewReader(input), &output, table)
+
+	if table.numRows != 2 {
+		t.Errorf("table.numRows = %d, want 2", table.numRows)
+	}
+	if strings.Contains(output.String(), "user3") {
+		t.Errorf("rolled back rows are still visible\ngot:\n%s", output.String())
+	}
+	if info, err := os.Stat(fileName); err != nil {
+		t.Fatalf("Stat: %v", err)
+	} else if want := int64(HEADER_SIZE + DEFAULT_PAGE_SIZE); info.Size() != want {
+		t.Errorf("file is %d bytes, want %d", info.Size(), want)
+	}
+}
+
+func TestTransaction_RollbackRestoresRoot(t *testing.T) {
+	table, err := dbOpenWith(tempDBFile(t), OpenOptions{PageSize: smallPageSize})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	defer dbClose(table)
+
+	// the transaction splits the single leaf, moving the root to a new page
+	var output bytes.Buffer
+	runREPL(strings.NewReader(insertRows(1, 2)+"+begin\n"+insertRows(3, 20)+"+rollback\nselect\n"), &output, table)
+
+	if table.rootPageNum != 0 || table.pager.numPages != 1 {
+		t.Errorf("after rollback rootPageNum = %d, numPages = %d, want 0 and 1", table.rootPageNum, table.pager.numPages)
+	}
+	want := "(1, user1, person1@example.com)\n(2, user2, person2@example.com)\nExecuted."
+	if !strings.Contains(output.String(), want) || strings.Contains(output.String(), "user3") {
+		t.Errorf("output after rollback does not show just rows 1 and 2\ngot:\n%s", output.String())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import "errors"
+
+// UNDO_LIMIT is how many changes +undo can step back through.
+const UNDO_LIMIT = 16
+
+var errNothingToUndo = errors.New("nothing to undo")
+
+// mutation records one statement's change to the table, with what is needed
+// to revert it: the inserted row, or the deleted or overwritten rows. Rows
+// are found again by id, since other changes may have moved them since.
+type mutation struct {
+	kind StatementType
+	rows []Row
+}
+
+// recordMutation pushes m onto the undo stack, dropping the oldest entry once
+// the stack is full. Callers hold table.mu for writing.
+func recordMutation(table *Table, m mutation) {
+	if len(table.undo) == UNDO_LIMIT {
+		table.undo = append(table.undo[:0], table.undo[1:]...)
+	}
+	table.undo = append(table.undo, m)
+}
+
+// undoLastMutation reverts the most recent recorded change.
+func undoLastMutation(table *Table) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if len(table.undo) == 0 {
+		return errNothingToUndo
+	}
+	if table.readOnly {
+		return ErrReadOnly
+	}
+	m := table.undo[len(table.undo)-1]
+
+	for i := len(m.rows) - 1; i >= 0; i-- {
+		if err := undoRow(table, m.kind, &m.rows[i]); err != nil {
+			return err
+		}
+	}
+
+	table.undo = table.undo[:len(table.undo)-1]
+	// an insert undone leaves its id used, as a delete would
+	if err := keepLastID(table); err != nil {
+		return err
+	}
+	return commitStatement(table)
+}
+
+// undoRow reverts the change of a statement of kind to one row. Callers hold
+// table.mu for writing.
+func undoRow(table *Table, kind StatementType, row *Row) error {
+	switch kind {
+	case STATEMENT_INSERT:
+		cursor, found, err := tableFind(table, row.id)
+		if err != nil {
+			return err
+		}
+		if found {
+			var removed Row
+			if err := removeRow(cursor, &removed); err != nil {
+				return err
+			}
+		}
+	case STATEMENT_DELETE:
+		if err := insertRow(table, row); err != nil {
+			return err
+		}
+	case STATEMENT_UPDATE:
+		cursor, found, err := tableFind(table, row.id)
+		if err != nil {
+			return err
+		}
+		if found {
+			if err := rewriteRow(cursor, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUndo_LastInsert(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	runREPL(strings.NewReader(insertRows(1, 2)+"+undo\nselect\n"), &output, table)
+
+	got := strings.ReplaceAll(output.String(), "simpledbgo > ", "")
+	if !strings.Contains(got, "(1, user1, person1@example.com)\nExecuted.\n") || strings.Contains(got, "user2") {
+		t.Errorf("select after +undo should show only row 1\ngot:\n%s", got)
+	}
+	if table.numRows != 1 {
+		t.Errorf("table.numRows = %d, want 1", table.numRows)
+	}
+	if _, exists := table.ids[2]; exists {
+		t.Errorf("id 2 is still taken after undoing its insert")
+	}
+}
+
+func TestUndo_UpdateAndDelete(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+
+	input := insertRows(1, rowsPerLeaf+2) +
+		"update 2 changed changed@example.com\ndelete 1\n+undo\n+undo\n"
+	runREPL(strings.NewReader(input), io.Discard, table)
+
+	rows, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(rows) != rowsPerLeaf+2 {
+		t.Fatalf("got %d rows, want %d", len(rows), rowsPerLeaf+2)
+	}
+	for i, row := range rows {
+		want := Row{id: uint32(i + 1), username: fmt.Sprintf("user%d", i+1), email: fmt.Sprintf("person%d@example.com", i+1)}
+		if row != want {
+			t.Errorf("row %d = %+v, want %+v", i, row, want)
+		}
+	}
+}
+
+func TestUndo_NothingToUndo(t *testing.T) {
+	table := mustOpen(t, MEMORY_FILENAME)
+	defer dbClose(table)
+
+	var output bytes.Buffer
+	input := insertRows(1, UNDO_LIMIT+2) + strings.Repeat("+undo\n", UNDO_LIMIT+1)
+	runREPL(strings.NewReader(input), &output, table)
+
+	// only the newest UNDO_LIMIT inserts can be undone
+	if n := strings.Count(output.String(), "Nothing to undo."); n != 1 {
+		t.Errorf("got %d \"Nothing to undo.\" lines, want 1\ngot:\n%s", n, output.String())
+	}
+	if table.numRows != 2 {
+		t.Errorf("table.numRows = %d, want 2", table.numRows)
+	}
+}
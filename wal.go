@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+)
+
+// With OpenOptions.WAL, changes reach the database file only through a
+// write-ahead log next to it. Every statement outside a transaction, and
+// every +commit, appends the pages it changed to the log, followed by a commit
+// frame holding the new database header. With SYNC_FULL the log is synced
+// then, so a crash loses at most the statement in progress. Pages evicted in
+// between go to the log as well and are read back from there. Once the log
+// holds WAL_CHECKPOINT_FRAMES pages, and when the table is closed, the log is
+// synced and its pages copied into the database file, which is synced before
+// the log is emptied.
+//
+// The log starts with WAL_MAGIC and the page size. Each frame is the page
+// number, the number of pages in the database for a commit frame or 0 for a
+// page, and a CRC32 of the frame, followed by the page or, for a commit, the
+// database header. Opening a database replays the committed frames of a log
+// left behind by a crash, whether or not the log is used from then on.
+const (
+	WAL_SUFFIX            = "-wal"
+	WAL_MAGIC             = "SIMPLWAL"
+	WAL_PAGE_SIZE_OFFSET  = len(WAL_MAGIC)
+	WAL_HEADER_SIZE       = WAL_PAGE_SIZE_OFFSET + 4
+	WAL_CHECKPOINT_FRAMES = 1000
+
+	WAL_FRAME_PAGE_NUM_OFFSET  = 0
+	WAL_FRAME_NUM_PAGES_OFFSET = WAL_FRAME_PAGE_NUM_OFFSET + 4
+	WAL_FRAME_CHECKSUM_OFFSET  = WAL_FRAME_NUM_PAGES_OFFSET + 4
+	WAL_FRAME_HEADER_SIZE      = WAL_FRAME_CHECKSUM_OFFSET + 4
+)
+
+func walPath(filename string) string {
+	return filename + WAL_SUFFIX
+}
+
+// walOpen starts an empty log for the pager's file.
+func walOpen(pager *Pager) error {
+	wal, err := os.OpenFile(walPath(pager.file.Name()), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("open write-ahead log: %w", err)
+	}
+	var header [WAL_HEADER_SIZE]byte
+	copy(header[:], WAL_MAGIC)
+	binary.LittleEndian.PutUint32(header[WAL_PAGE_SIZE_OFFSET:], pager.pageSize)
+	if _, err := wal.WriteAt(header[:], 0); err != nil {
+		wal.Close()
+		return fmt.Errorf("write write-ahead log header: %w", err)
+	}
+	if err := wal.Sync(); err != nil {
+		wal.Close()
+		return fmt.Errorf("sync write-ahead log: %w", err)
+	}
+	pager.wal = wal
+	pager.walLength = int64(WAL_HEADER_SIZE)
+	pager.walIndex = make(map[uint32]int64)
+	return nil
+}
+
+// walClose empties the log into the database file and removes it. Callers
+// have flushed the table.
+func walClose(table *Table) error {
+	pager := table.pager
+	if pager.wal == nil {
+		return nil
+	}
+	if err := walCheckpoint(table); err != nil {
+		return err
+	}
+	if err := pager.wal.Close(); err != nil {
+		return err
+	}
+	pager.wal = nil
+	pager.walIndex = nil
+	return os.Remove(walPath(pager.file.Name()))
+}
+
+// walAppend adds a frame to the log and returns where its payload starts.
+func walAppend(pager *Pager, pageNum uint32, numPages uint32, payload []byte) (int64, error) {
+	frame := make([]byte, WAL_FRAME_HEADER_SIZE, WAL_FRAME_HEADER_SIZE+len(payload))
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_PAGE_NUM_OFFSET:], pageNum)
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_NUM_PAGES_OFFSET:], numPages)
+	frame = append(frame, payload...)
+	binary.LittleEndian.PutUint32(frame[WAL_FRAME_CHECKSUM_OFFSET:], walFrameChecksum(frame))
+
+	if _, err := pager.wal.WriteAt(frame, pager.walLength); err != nil {
+		return 0, fmt.Errorf("write to write-ahead log: %w", err)
+	}
+	offset := pager.walLength + WAL_FRAME_HEADER_SIZE
+	pager.walLength += int64(len(frame))
+	return offset, nil
+}
+
+// walFrameChecksum covers a whole frame but its checksum field.
+func walFrameChecksum(frame []byte) uint32 {
+	checksum := crc32.ChecksumIEEE(frame[:WAL_FRAME_CHECKSUM_OFFSET])
+	return crc32.Update(checksum, crc32.IEEETable, frame[WAL_FRAME_HEADER_SIZE:])
+}
+
+// walWritePage appends page, as it would be written to the database file,
+// to the log in place of writing it there. Callers hold pager.mu or table.mu
+// for writing.
+func walWritePage(pager *Pager, pageNum uint32, page Page) error {
+	offset, err := walAppend(pager, pageNum, 0, page)
+	if err != nil {
+		return err
+	}
+	pager.walIndex[pageNum] = offset
+	pager.walFrames++
+	pager.dirty[pageNum] = false
+	return nil
+}
+
+// walCommit appends every dirty page and a commit frame to the log, syncing
+// it with SYNC_FULL, and checkpoints once the log has grown long enough.
+func walCommit(table *Table) error {
+	pager := table.pager
+	for pageNum, dirty := range pager.dirty {
+		// pages past the end of the tree are dropped at the next checkpoint
+		if !dirty || uint32(pageNum) >= pager.numPages {
+			continue
+		}
+		if err := pagerFlush(pager, uint32(pageNum)); err != nil {
+			return err
+		}
+	}
+	header := encodeHeader(table)
+	if _, err := walAppend(pager, 0, pager.numPages, header[:]); err != nil {
+		return err
+	}
+	if pager.syncMode == SYNC_FULL {
+		if err := pager.wal.Sync(); err != nil {
+			return fmt.Errorf("sync write-ahead log: %w", err)
+		}
+	}
+	if pager.walFrames < WAL_CHECKPOINT_FRAMES {
+		return nil
+	}
+	return walCheckpoint(table)
+}
+
+// walCheckpoint copies the pages in the log into the database file, syncs it
+// and empties the log. Callers have flushed the table, so the log ends in a
+// commit.
+func walCheckpoint(table *Table) error {
+	pager := table.pager
+	if pager.wal == nil {
+		return nil
+	}
+	pages := make(map[uint32][]byte, len(pager.walIndex))
+	for pageNum, offset := range pager.walIndex {
+		page := make([]byte, pager.pageSize)
+		if _, err := pager.wal.ReadAt(page, offset); err != nil {
+			return fmt.Errorf("read write-ahead log: %w", err)
+		}
+		pages[pageNum] = page
+	}
+	// the file must not get ahead of a log that could still be lost
+	if pager.syncMode != SYNC_OFF {
+		if err := pager.wal.Sync(); err != nil {
+			return fmt.Errorf("sync write-ahead log: %w", err)
+		}
+	}
+	header := encodeHeader(table)
+	if err := applyPages(pager, pager.pageSize, pages, header[:], pager.numPages); err != nil {
+		return err
+	}
+
+	if err := pager.wal.Truncate(int64(WAL_HEADER_SIZE)); err != nil {
+		return fmt.Errorf("truncate write-ahead log: %w", err)
+	}
+	pager.walLength = int64(WAL_HEADER_SIZE)
+	pager.walFrames = 0
+	clear(pager.walIndex)
+	return nil
+}
+
+// applyPages writes pages of pageSize bytes and the database header to the
+// database file, trims it to numPages pages and syncs it. Recovery runs
+// before the header is read, so the page size is the log's or the journal's.
+func applyPages(pager *Pager, pageSize uint32, pages map[uint32][]byte, header []byte, numPages uint32) error {
+	for _, pageNum := range slices.Sorted(maps.Keys(pages)) {
+		page := pages[pageNum]
+		if pageNum >= numPages {
+			continue
+		}
+		if _, err := pager.file.WriteAt(page, HEADER_SIZE+int64(pageNum)*int64(pageSize)); err != nil {
+			return fmt.Errorf("checkpoint write failed: %w", err)
+		}
+	}
+	if _, err := pager.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("write header failed: %w", err)
+	}
+	fileLength := HEADER_SIZE + int64(numPages)*int64(pageSize)
+	if err := pager.file.Truncate(fileLength); err != nil {
+		return fmt.Errorf("truncate failed: %w", err)
+	}
+	if err := pagerSync(pager); err != nil {
+		return err
+	}
+	pager.fileLength = fileLength
+	return nil
+}
+
+// walRecover replays the committed frames of a log left next to the pager's
+// file and removes the log. Frames after the last commit, or from the first
+// damaged one on, belong to a statement that never finished and are dropped.
+// A read-only open cannot replay anything and fails if there is something to
+// replay.
+func walRecover(pager *Pager, readOnly bool) error {
+	path := walPath(pager.file.Name())
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read write-ahead log: %w", err)
+	}
+
+	pageSize, pages, header, numPages := walCommittedFrames(contents)
+	if header == nil {
+		if readOnly {
+			return nil
+		}
+		return os.Remove(path)
+	}
+	if readOnly {
+		return fmt.Errorf("write-ahead log %s holds committed changes; open the database for writing once to apply them", path)
+	}
+	if err := applyPages(pager, pageSize, pages, header, numPages); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// walCommittedFrames reads a log, returning its page size, the latest
+// committed version of each page in it and the header and size of the
+// database as of the last commit, or a nil header if nothing was committed.
+func walCommittedFrames(contents []byte) (pageSize uint32, pages map[uint32][]byte, header []byte, numPages uint32) {
+	if len(contents) < WAL_HEADER_SIZE || string(contents[:len(WAL_MAGIC)]) != WAL_MAGIC {
+		return 0, nil, nil, 0
+	}
+	pageSize = binary.LittleEndian.Uint32(contents[WAL_PAGE_SIZE_OFFSET:])
+	if pageSize < MIN_PAGE_SIZE || pageSize > MAX_PAGE_SIZE {
+		return 0, nil, nil, 0
+	}
+
+	pages = make(map[uint32][]byte)
+	pending := make(map[uint32][]byte)
+	rest := contents[WAL_HEADER_SIZE:]
+	for len(rest) >= WAL_FRAME_HEADER_SIZE {
+		frameNumPages := binary.LittleEndian.Uint32(rest[WAL_FRAME_NUM_PAGES_OFFSET:])
+		payloadSize := int(pageSize)
+		if frameNumPages != 0 {
+			payloadSize = HEADER_SIZE
+		}
+		if len(rest) < WAL_FRAME_HEADER_SIZE+payloadSize {
+			break
+		}
+		frame := rest[:WAL_FRAME_HEADER_SIZE+payloadSize]
+		rest = rest[len(frame):]
+		if binary.LittleEndian.Uint32(frame[WAL_FRAME_CHECKSUM_OFFSET:]) != walFrameChecksum(frame) {
+			break
+		}
+
+		payload := frame[WAL_FRAME_HEADER_SIZE:]
+		if frameNumPages == 0 {
+			pending[binary.LittleEndian.Uint32(frame[WAL_FRAME_PAGE_NUM_OFFSET:])] = payload
+			continue
+		}
+		maps.Copy(pages, pending)
+		clear(pending)
+		header, numPages = payload, frameNumPages
+	}
+	return pageSize, pages, header, numPages
+}
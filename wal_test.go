@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// crashCopy copies a database and its write-ahead log or rollback journal as
+// they are on disk, as if the process had died, and returns the path of the
+// copy.
+func crashCopy(t *testing.T, fileName string) string {
+	t.Helper()
+	copyName := filepath.Join(t.TempDir(), "crashed.db")
+	for _, suffix := range []string{"", WAL_SUFFIX, JOURNAL_SUFFIX} {
+		contents, err := os.ReadFile(fileName + suffix)
+		if suffix != "" && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if err := os.WriteFile(copyName+suffix, contents, 0666); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	return copyName
+}
+
+func openWAL(t *testing.T, fileName string) *Table {
+	t.Helper()
+	table, err := dbOpenWith(fileName, OpenOptions{WAL: true, MaxCachedPages: 2})
+	if err != nil {
+		t.Fatalf("dbOpenWith: %v", err)
+	}
+	return table
+}
+
+func TestWAL_CommittedStatementsSurviveACrash(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "wal.db")
+	table := openWAL(t, fileName)
+	defer dbClose(table)
+
+	// the small cache makes pages spill into the log between commits
+	input := insertRows(1, rowsPerLeaf*3) + deleteRows(10, 20) + "update 5 set email = changed@example.com\n"
+	runREPL(strings.NewReader(input), io.Discard, table)
+	want, err := table.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if info, err := os.Stat(fileName); err != nil || info.Size() != HEADER_SIZE {
+		t.Fatalf("database file before a checkpoint: %v, %v; want just the header", info, err)
+	}
+
+	copyName := crashCopy(t, fileName)
+	recovered := mustOpen(t, copyName)
+	defer dbClose(recovered)
+	if _, err := os.Stat(copyName + WAL_SUFFIX); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("log still there after recovery: %v", err)
+	}
+	got, err := recovered.SelectAll()
+	if err != nil {
+		t.Fatalf("SelectAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recovered %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	checkTree(t, recovered)
+}
+
+func TestWAL_DropsUnfinishedFrames(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "wal.db")
+	table := openWAL(t, fileName)
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 5)), io.Discard, table)
+
+	// a page written without a commit after it, then half a frame
+	committed := table.pager.walLength
+	if _, err := walAppend(table.pager, 0, 0, make([]byte, table.pager.pageSize)); err != nil {
+		t.Fatalf("walAppend: %v", err)
+	}
+	if _, err := table.pager.wal.WriteAt([]byte{1, 2, 3}, table.pager.walLength); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	uncommitted := crashCopy(t, fileName)
+
+	// a commit whose frame was torn
+	if err := table.pager.wal.Truncate(committed); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	table.pager.walLength = committed
+	runREPL(strings.NewReader(insertRows(6, 6)), io.Discard, table)
+	torn := crashCopy(t, fileName)
+	contents, err := os.ReadFile(torn + WAL_SUFFIX)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	contents[len(contents)-1] ^= 0xFF
+	if err := os.WriteFile(torn+WAL_SUFFIX, contents, 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, copyName := range []string{uncommitted, torn} {
+		recovered := mustOpen(t, copyName)
+		rows, err := recovered.SelectAll()
+		if err != nil {
+			t.Fatalf("SelectAll: %v", err)
+		}
+		if len(rows) != 5 || rows[4].id != 5 {
+			t.Errorf("recovered %+v, want rows 1 to 5", rows)
+		}
+		dbClose(recovered)
+	}
+}
+
+func TestWAL_CheckpointCopiesPagesIntoTheFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "wal.db")
+	table := openWAL(t, fileName)
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, rowsPerLeaf*2)), io.Discard, table)
+
+	// the next statement fills the log up to the limit
+	table.pager.walFrames = WAL_CHECKPOINT_FRAMES - 1
+	runREPL(strings.NewReader(insertRows(rowsPerLeaf*2+1, rowsPerLeaf*2+1)), io.Discard, table)
+	if table.pager.walLength != int64(WAL_HEADER_SIZE) || len(table.pager.walIndex) != 0 {
+		t.Fatalf("log holds %d bytes and %d pages after a checkpoint, want it empty", table.pager.walLength, len(table.pager.walIndex))
+	}
+	if info, err := os.Stat(fileName); err != nil || info.Size() != table.pager.fileLength {
+		t.Fatalf("database file after a checkpoint: %v, %v; want %d bytes", info, err, table.pager.fileLength)
+	}
+
+	// the file alone now holds everything
+	copyName := crashCopyWithoutLog(t, fileName)
+	recovered := mustOpen(t, copyName)
+	defer dbClose(recovered)
+	if _, stored := checkTree(t, recovered); stored != uint32(rowsPerLeaf*2+1) {
+		t.Errorf("file holds %d rows, want %d", stored, rowsPerLeaf*2+1)
+	}
+}
+
+// crashCopyWithoutLog copies just the database file.
+func crashCopyWithoutLog(t *testing.T, fileName string) string {
+	t.Helper()
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	copyName := filepath.Join(t.TempDir(), "copy.db")
+	if err := os.WriteFile(copyName, contents, 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return copyName
+}
+
+func TestWAL_CloseRemovesTheLog(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "wal.db")
+	if _, err := dbOpenWith(fileName, OpenOptions{WAL: true, ReadOnly: true}); err == nil {
+		t.Errorf("dbOpenWith a read-only table with a log succeeded")
+	}
+
+	table := openWAL(t, fileName)
+	runREPL(strings.NewReader(insertRows(1, 10)), io.Discard, table)
+	if err := dbClose(table); err != nil {
+		t.Fatalf("dbClose: %v", err)
+	}
+	if _, err := os.Stat(fileName + WAL_SUFFIX); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("log still there after dbClose: %v", err)
+	}
+	table = mustOpen(t, fileName)
+	defer dbClose(table)
+	if table.numRows != 10 {
+		t.Errorf("numRows = %d, want 10", table.numRows)
+	}
+}
+
+func TestWAL_ReadOnlyOpenRefusesAPendingLog(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "wal.db")
+	table := openWAL(t, fileName)
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 3)), io.Discard, table)
+
+	copyName := crashCopy(t, fileName)
+	if _, err := dbOpenWith(copyName, OpenOptions{ReadOnly: true}); err == nil || !strings.Contains(err.Error(), "write-ahead log") {
+		t.Errorf("dbOpenWith read-only over a pending log: err = %v", err)
+	}
+	recovered := mustOpen(t, copyName)
+	defer dbClose(recovered)
+	if recovered.numRows != 3 {
+		t.Errorf("numRows = %d, want 3", recovered.numRows)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A where clause is a condition on the columns of a row: comparisons of a
+// column with a value, "id = 5" or "email >= m", joined by and and or, where
+// and binds tighter than or and parentheses group. Ids compare as numbers,
+// usernames and emails byte by byte, as strings. The older form
+// "where email <email>", with no operator, still means email = <email>.
+// A text may also be matched against a pattern, "email like %@example.com";
+// see like.go. "id in (1, 5, 9)" is true of the values listed; see in.go.
+// "id between 10 and 20" is id >= 10 and id <= 20, and so a range of ids.
+//
+// A select reads only the rows whose ids the condition allows, so a
+// condition on id seeks to the first id it can match and stops after the
+// last, instead of scanning the whole table. The same holds for the key of
+// a table made by create table; see keyRange.
+type Condition interface {
+	// matches reports whether row meets the condition.
+	matches(row *Row) bool
+	// ids returns the ids of every row the condition can match, and
+	// maybe more.
+	ids() idRange
+}
+
+// Comparison is the condition "<column> <operator> <value>". "select <id>"
+// is the comparison id = <id>. In a having, the column may be an aggregate,
+// which Column then names as it is written; in the on of a join, the column
+// is compared with another instead of a value.
+type Comparison struct {
+	Column    string
+	Operator  string
+	Value     string
+	ID        uint32         // Value parsed, when Column is id
+	Aggregate *Aggregate     // what Column names, when it is an aggregate
+	Other     string         // the column compared with, in the on of a join
+	like      *likePattern   // Value compiled, when Operator is like or ilike
+	Values    []string       // the list of an in, which leaves Value empty
+	in        map[Value]bool // Values as a set, on the built-in table
+}
+
+// Logical is "<left> and <right>" or "<left> or <right>".
+type Logical struct {
+	Operator    string
+	Left, Right Condition
+}
+
+// comparisonOperators are the operators a comparison may use.
+var comparisonOperators = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// idRange is the ids [low, high], empty when low > high. The bounds are
+// wider than ids so that neither end wraps around.
+type idRange struct {
+	low, high int64
+}
+
+var allIDs = idRange{0, math.MaxUint32}
+
+func (comparison *Comparison) matches(row *Row) bool {
+	if comparison.in != nil {
+		switch comparison.Column {
+		case "id":
+			return comparison.in[int64(row.id)]
+		case "username":
+			return comparison.in[row.username]
+		}
+		return comparison.in[row.email]
+	}
+	if comparison.like != nil {
+		if comparison.Column == "username" {
+			return comparison.like.match(row.username)
+		}
+		return comparison.like.match(row.email)
+	}
+	var order int
+	switch comparison.Column {
+	case "id":
+		order = cmp.Compare(row.id, comparison.ID)
+	case "username":
+		order = strings.Compare(row.username, comparison.Value)
+	case "email":
+		order = strings.Compare(row.email, comparison.Value)
+	}
+	return compareOrder(order, comparison.Operator)
+}
+
+// compareOrder reports whether two values, which cmp.Compare or
+// strings.Compare put in order, meet a comparison operator.
+func compareOrder(order int, operator string) bool {
+	switch operator {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
+
+func (comparison *Comparison) ids() idRange {
+	if comparison.Column != "id" {
+		return allIDs
+	}
+	if comparison.Operator == "in" {
+		return inIDs(comparison.Values)
+	}
+	return operatorIDs(comparison.Operator, int64(comparison.ID))
+}
+
+// operatorIDs returns the ids a comparison of id with operator can match.
+func operatorIDs(operator string, id int64) idRange {
+	switch operator {
+	case "=":
+		return idRange{id, id}
+	case "<":
+		return idRange{0, id - 1}
+	case "<=":
+		return idRange{0, id}
+	case ">":
+		return idRange{id + 1, math.MaxUint32}
+	case ">=":
+		return idRange{id, math.MaxUint32}
+	}
+	return allIDs
+}
+
+func (logical *Logical) matches(row *Row) bool {
+	if logical.Operator == "and" {
+		return logical.Left.matches(row) && logical.Right.matches(row)
+	}
+	return logical.Left.matches(row) || logical.Right.matches(row)
+}
+
+func (logical *Logical) ids() idRange {
+	return logicalIDs(logical.Operator, logical.Left.ids(), logical.Right.ids())
+}
+
+// logicalIDs returns the ids a condition joining conditions that match left
+// and right with operator can match.
+func logicalIDs(operator string, left, right idRange) idRange {
+	switch {
+	case operator == "and":
+		return idRange{max(left.low, right.low), min(left.high, right.high)}
+	case left.low > left.high:
+		return right
+	case right.low > right.high:
+		return left
+	}
+	// the ids between two ranges are read for nothing, which is still
+	// fewer than the whole table
+	return idRange{min(left.low, right.low), max(left.high, right.high)}
+}
+
+// scanRows calls visit with every row that meets where, or every row when
+// where is nil, in id order, until visit returns false. Callers hold
+// table.mu.
+func scanRows(table *Table, where Condition, visit func(row *Row) bool) error {
+	ids := allIDs
+	if where != nil {
+		ids = where.ids()
+	}
+	if ids.low > ids.high {
+		return nil
+	}
+
+	var row Row
+	cursor := tableSeek(table, uint32(ids.low))
+	defer cursorClose(cursor)
+	if cursor.err != nil {
+		return cursor.err
+	}
+	for ; !cursor.endOfTable; cursorAdvance(cursor) {
+		slot, err := cursorValue(cursor)
+		if err != nil {
+			return fmt.Errorf("reading page %d: %w", cursor.pageNum, err)
+		}
+		if int64(rowID(slot)) > ids.high {
+			break
+		}
+		if err := readRow(table.pager, slot, &row); err != nil {
+			return err
+		}
+		if (where == nil || where.matches(&row)) && !visit(&row) {
+			break
+		}
+	}
+	return nil
+}
+
+// where parses a condition, the or of one or more ands.
+func (p *parser) where() (Condition, PrepareResult) {
+	left, result := p.and()
+	for result == PREPARE_SUCCESS && p.accept("or") {
+		right, rightResult := p.and()
+		left, result = &Logical{"or", left, right}, rightResult
+	}
+	return left, result
+}
+
+// and parses the and of one or more comparisons.
+func (p *parser) and() (Condition, PrepareResult) {
+	left, result := p.comparison()
+	for result == PREPARE_SUCCESS && p.accept("and") {
+		right, rightResult := p.comparison()
+		left, result = &Logical{"and", left, right}, rightResult
+	}
+	return left, result
+}
+
+// comparison parses "<column> <operator> <value>" or a condition in
+// parentheses. Values are single tokens here, so that operators end them;
+// one that holds an operator or whitespace needs quotes.
+func (p *parser) comparison() (Condition, PrepareResult) {
+	if p.accept("(") {
+		condition, result := p.where()
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		return condition, p.expect(")")
+	}
+
+	column := p.peek()
+	name := column.Text
+	var aggregate *Aggregate
+	var operand *Column // what the value is compared with, once known
+	switch {
+	case p.having != nil && p.isAggregate():
+		parsed, argument, result := p.aggregate()
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		aggregate, name = &parsed, parsed.String()
+		if p.schema != nil {
+			if result := p.checkAggregate(parsed, argument, p.schema); result != PREPARE_SUCCESS {
+				return nil, result
+			}
+			a, _ := newAggregator(p.schema, parsed)
+			result := a.aggregateColumn(p.schema)
+			operand = &result
+		}
+	case p.anyColumn:
+		if _, result := p.columnName(); result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		if p.having != nil && !isGrouped(p.schema, p.having, name) {
+			return nil, p.failAt(column, PREPARE_SYNTAX_ERROR, fmt.Sprintf("column %s is not grouped by", name))
+		}
+		if p.schema != nil {
+			index := columnIndex(p.schema, name)
+			if index == -1 {
+				return nil, p.failAt(column, PREPARE_NO_SUCH_COLUMN, fmt.Sprintf("table %s has no column %s", p.schema.Name, name))
+			}
+			operand = &p.schema.Columns[index]
+		}
+	case column.Type != TOKEN_IDENTIFIER || name != "id" && name != "username" && name != "email":
+		return nil, p.fail("id, username or email")
+	default:
+		p.advance()
+	}
+	operator := p.peek()
+	word := operator.Type == TOKEN_IDENTIFIER && !p.joining
+	like, in := word && isLike(operator.Text), word && operator.Text == "in"
+	between := word && operator.Text == "between"
+	if !like && !in && !between && (operator.Type != TOKEN_OPERATOR || !comparisonOperators[operator.Text]) {
+		if p.joining {
+			return nil, p.fail("=, !=, <, <=, > or >=")
+		}
+		return nil, p.fail("=, !=, <, <=, >, >=, like, ilike, in or between")
+	}
+	p.advance()
+	if p.joining {
+		return p.columnComparison(column, name, operator.Text, operand)
+	}
+	if between {
+		return p.between(name, operand, aggregate)
+	}
+
+	value := p.peek()
+	comparison := &Comparison{Column: name, Operator: operator.Text, Value: value.Text, Aggregate: aggregate}
+	if in {
+		return comparison, p.inList(comparison, operand)
+	}
+	if like {
+		if operand != nil && operand.Type != COLUMN_TEXT || operand == nil && name == "id" && !p.anyColumn {
+			return nil, p.failAt(operator, PREPARE_TYPE_MISMATCH, operator.Text+" matches text, and "+name+" does not hold text")
+		}
+		if value.Type != TOKEN_STRING && value.Type != TOKEN_IDENTIFIER && value.Type != TOKEN_NUMBER {
+			return nil, p.fail("a pattern")
+		}
+		comparison.like = compileLike(value.Text, operator.Text)
+	} else {
+		id, result := p.operandValue(name, operand)
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		comparison.ID = id
+	}
+	p.advance()
+	return comparison, PREPARE_SUCCESS
+}
+
+// between parses "<low> and <high>", the bounds of a between of the column
+// name, into the comparisons it stands for: <column> >= <low> and <column>
+// <= <high>.
+func (p *parser) between(name string, operand *Column, aggregate *Aggregate) (Condition, PrepareResult) {
+	bounds := make([]*Comparison, 2)
+	for i, operator := range []string{">=", "<="} {
+		if i == 1 {
+			if result := p.expect("and"); result != PREPARE_SUCCESS {
+				return nil, result
+			}
+		}
+		value := p.peek()
+		id, result := p.operandValue(name, operand)
+		if result != PREPARE_SUCCESS {
+			return nil, result
+		}
+		p.advance()
+		bounds[i] = &Comparison{Column: name, Operator: operator, Value: value.Text, ID: id, Aggregate: aggregate}
+	}
+	return &Logical{"and", bounds[0], bounds[1]}, PREPARE_SUCCESS
+}
+
+// operandValue checks the next token, a value compared with the column
+// name, of type operand when the table is known, and returns the id it is
+// when the column is the id of the built-in table.
+func (p *parser) operandValue(name string, operand *Column) (uint32, PrepareResult) {
+	value := p.peek()
+	switch {
+	case name == "id" && !p.anyColumn:
+		if value.Type != TOKEN_NUMBER {
+			return 0, p.fail("an id")
+		}
+		id, result := parseID(value.Text)
+		if result != PREPARE_SUCCESS {
+			return 0, p.failAt(value, result, fmt.Sprintf("id %s is out of range", value.Text))
+		}
+		return id, PREPARE_SUCCESS
+	case value.Type != TOKEN_STRING && value.Type != TOKEN_IDENTIFIER && value.Type != TOKEN_NUMBER:
+		return 0, p.fail("a value for " + name)
+	case operand != nil:
+		operandColumn := *operand
+		operandColumn.Size = math.MaxUint32
+		return 0, p.checkValue(value, operandColumn)
+	}
+	return 0, PREPARE_SUCCESS
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectWhere(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 300)), io.Discard, table)
+
+	tests := []struct {
+		where string
+		want  []uint32
+	}{
+		{"id = 5", []uint32{5}},
+		{"id=5", []uint32{5}},
+		{"id < 3", []uint32{1, 2}},
+		{"id <= 2 or id >= 299", []uint32{1, 2, 299, 300}},
+		{"id > 297", []uint32{298, 299, 300}},
+		{"id > 10 and id < 13", []uint32{11, 12}},
+		{"id < 0", nil},
+		{"id >= 4294967295", nil},
+		{"id > 150 and id < 100", nil},
+		{"username = user7", []uint32{7}},
+		{`email = "person8@example.com"`, []uint32{8}},
+		{"email < person2 and id < 25", []uint32{1, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}},
+		{"id != 1 and id <= 3", []uint32{2, 3}},
+		{"username = user1 or username = user2 and id > 2", []uint32{1}},
+		{"(username = user1 or username = user2) and id <= 2", []uint32{1, 2}},
+		{"username like user29_", []uint32{290, 291, 292, 293, 294, 295, 296, 297, 298, 299}},
+		{`email like "%8@example.com" and id < 30`, []uint32{8, 18, 28}},
+		{"email like person1_@example.com or username ilike USER3", []uint32{3, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}},
+		{"username like user", nil},
+		{"username like User7", nil},
+		{"username ilike %R2_0", []uint32{200, 210, 220, 230, 240, 250, 260, 270, 280, 290}},
+		{"id in (9, 5, 1)", []uint32{1, 5, 9}},
+		{"id in (300, 301) or id in (2)", []uint32{2, 300}},
+		{"username in (user7, user70, nobody) and id != 70", []uint32{7}},
+		{`email in ("person4@example.com")`, []uint32{4}},
+		{"id in (4) and id in (5)", nil},
+		{"id between 10 and 13", []uint32{10, 11, 12, 13}},
+		{"id between 299 and 400 or id between 0 and 1", []uint32{1, 299, 300}},
+		{"id between 5 and 4", nil},
+		{"username between user297 and user3 and id > 290", []uint32{297, 298, 299}},
+		{"email between person5 and person6 and id between 40 and 60", []uint32{50, 51, 52, 53, 54, 55, 56, 57, 58, 59}},
+	}
+	for _, tt := range tests {
+		var output bytes.Buffer
+		runREPLWith(strings.NewReader("select where "+tt.where+"\nselect count where "+tt.where+"\n"), &output, table, true)
+
+		var want strings.Builder
+		for _, id := range tt.want {
+			fmt.Fprintf(&want, "(%d, user%d, person%d@example.com)\n", id, id, id)
+		}
+		if len(tt.want) == 0 {
+			want.WriteString("(no rows)\n")
+		}
+		fmt.Fprintf(&want, "count: %d\n", len(tt.want))
+		if output.String() != want.String() {
+			t.Errorf("where %s:\n%s\nwant:\n%s", tt.where, output.String(), want.String())
+		}
+	}
+}
+
+func TestPrepareStatement_Where(t *testing.T) {
+	got, err := prepareStatement("select where id > 1 and (email = a or username != b)")
+	if err != nil {
+		t.Fatalf("prepareStatement: %v", err)
+	}
+	want := &SelectStmt{Where: &Logical{"and",
+		&Comparison{Column: "id", Operator: ">", Value: "1", ID: 1},
+		&Logical{"or",
+			&Comparison{Column: "email", Operator: "=", Value: "a"},
+			&Comparison{Column: "username", Operator: "!=", Value: "b"},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prepareStatement = %+v, want %+v", got, want)
+	}
+
+	for _, input := range []string{
+		"select where",
+		"select where id",
+		"select where id = x",
+		"select where size = 1",
+		"select where username user1",
+		"select where id = 1 and",
+		"select where (id = 1",
+		"select where id = 1 id = 2",
+		"select where email = a b",
+		"select where username like",
+		"select where id in ()",
+		"select where id in (1, x)",
+		"select where username in (a, b",
+		"select where id between 1",
+		"select where id between 1 or 2",
+		"select where id between 1 and x",
+	} {
+		if _, err := prepareStatement(input); prepareResult(err) != PREPARE_SYNTAX_ERROR {
+			t.Errorf("prepareStatement(%q): %v, want a syntax error", input, err)
+		}
+	}
+	if _, err := prepareStatement("select where id > -1"); prepareResult(err) != PREPARE_NEGATIVE_ID {
+		t.Errorf("prepareStatement with a negative id: %v", err)
+	}
+	if _, err := prepareStatement("select where id like 1"); prepareResult(err) != PREPARE_TYPE_MISMATCH {
+		t.Errorf("prepareStatement with a like of id: %v", err)
+	}
+}
+
+func TestPrepareStatement_Between(t *testing.T) {
+	got, err := prepareStatement("select where id between 10 and 20 and username = a")
+	if err != nil {
+		t.Fatalf("prepareStatement: %v", err)
+	}
+	between := &Logical{"and",
+		&Comparison{Column: "id", Operator: ">=", Value: "10", ID: 10},
+		&Comparison{Column: "id", Operator: "<=", Value: "20", ID: 20},
+	}
+	want := &SelectStmt{Where: &Logical{"and", between, &Comparison{Column: "username", Operator: "=", Value: "a"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prepareStatement = %+v, want %+v", got, want)
+	}
+	if ids := got.(*SelectStmt).Where.ids(); ids != (idRange{10, 20}) {
+		t.Errorf("ids = %v, want [10, 20]", ids)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		pattern, operator, text string
+		want                    bool
+	}{
+		{"abc", "like", "abc", true},
+		{"abc", "like", "abcd", false},
+		{"a_c", "like", "abc", true},
+		{"a_c", "like", "ac", false},
+		{"%", "like", "", true},
+		{"%c", "like", "abcabc", true},
+		{"a%b%c", "like", "axxbyyc", true},
+		{"a%b%c", "like", "axxcyyb", false},
+		{"%aab", "like", "aaaab", true},
+		{"_é%", "like", "xéz", true},
+		{"ABC", "like", "abc", false},
+		{"ABC", "ilike", "abc", true},
+		{"%É", "ilike", "café", true},
+	}
+	for _, tt := range tests {
+		if got := compileLike(tt.pattern, tt.operator).match(tt.text); got != tt.want {
+			t.Errorf("%q %s %q = %v, want %v", tt.text, tt.operator, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateWhere(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 10)), io.Discard, table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(
+		"update set email = moved@example.com username = moved where id > 7 or id = 2\n"+
+			"select where email = moved@example.com\n"+
+			"update set username = nobody where id > 100\n"+
+			"update set username = everyone\n"), &output, table, true)
+	want := "Updated 4 rows.\n" +
+		"(2, moved, moved@example.com)\n(8, moved, moved@example.com)\n(9, moved, moved@example.com)\n(10, moved, moved@example.com)\n" +
+		"Updated 0 rows.\n" +
+		"Syntax error. Could not parse statement.\n" +
+		"  update set username = everyone\n" +
+		"                                ^ expected \"where\", found end of statement\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+
+	// one +undo puts back every row the update rewrote
+	output.Reset()
+	runREPLWith(strings.NewReader("+undo\nselect count where username = moved\nselect 9\n"), &output, table, true)
+	if want := "count: 0\n(9, user9, person9@example.com)\n"; !strings.HasSuffix(output.String(), want) {
+		t.Errorf("after +undo:\n%s\nwant it to end with:\n%s", output.String(), want)
+	}
+	checkTree(t, table)
+}
+
+func TestDeleteWhere(t *testing.T) {
+	table := mustOpen(t, tempDBFile(t))
+	defer dbClose(table)
+	runREPL(strings.NewReader(insertRows(1, 200)), io.Discard, table)
+
+	var output bytes.Buffer
+	runREPLWith(strings.NewReader(
+		"delete from where id > 50 and id <= 150 or username = user3\n"+
+			"select count\n"+
+			"select where id >= 50 and id <= 151\n"), &output, table, true)
+	want := "Deleted 101 rows.\ncount: 99\n" +
+		"(50, user50, person50@example.com)\n(151, user151, person151@example.com)\n"
+	if output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	checkTree(t, table)
+
+	output.Reset()
+	runREPLWith(strings.NewReader("+undo\nselect count\ndelete from\nselect count\n"), &output, table, true)
+	if want := "count: 200\nDeleted 200 rows.\ncount: 0\n"; output.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output.String(), want)
+	}
+	checkTree(t, table)
+}